@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
@@ -8,16 +9,28 @@ import (
 	"github.com/blazskufca/dns_server_in_go/internal/Message"
 	"github.com/blazskufca/dns_server_in_go/internal/RR"
 	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"github.com/blazskufca/dns_server_in_go/internal/clock"
+	"github.com/blazskufca/dns_server_in_go/internal/edns"
 	"github.com/blazskufca/dns_server_in_go/internal/header"
-	"github.com/blazskufca/dns_server_in_go/internal/question"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"github.com/blazskufca/dns_server_in_go/internal/signing"
+	"github.com/blazskufca/dns_server_in_go/internal/tsig"
+	"github.com/blazskufca/dns_server_in_go/internal/zone"
 	"log/slog"
 	"net"
 	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// udpResponseMaxSize is the largest response sent over UDP to clients which did not advertise a larger payload size
+// in an OPT record, larger responses are truncated.
+const udpResponseMaxSize int = 512
+
+// maxMessageSize is the largest DNS message, bounded by the two byte length prefix of TCP.
+const maxMessageSize int = 65535
+
 // RootServer represents a DNS root server
 type RootServer struct {
 	Name string
@@ -30,19 +43,168 @@ type DNSServer struct { //nolint:govet
 	resolverHost string
 	udpConn      *net.UDPConn
 	resolverAddr *net.UDPAddr
+	lookupIPAddr func(ctx context.Context, host string) ([]net.IPAddr, error)
 	logger       *slog.Logger
 	cache        *cache.DNSCache
 	wg           sync.WaitGroup
 	recursive    bool
+	// minimalResponses drops non-essential Additional records from recursive responses.
+	minimalResponses bool
+	// bestEffortQuestions answers queries from the parsable questions when not all questions QDCOUNT announces parse.
+	bestEffortQuestions bool
+	// normalizeNames lowercases the names of recursive queries and nameserver responses before they are resolved and
+	// cached.
+	normalizeNames bool
+	// synthesizePTR answers reverse lookups of addresses from cached A and AAAA answers.
+	synthesizePTR bool
+	// stripAdditionalRecords drops every Additional record except OPT from forwarded and recursive responses.
+	stripAdditionalRecords bool
+	// signingKey, if set, is used to sign every response sent to clients.
+	signingKey []byte
+	// zone, if set, is served authoritatively and receives dynamic updates.
+	zone      *zone.Zone
+	updateKey *tsig.Key
+	updateACL []*net.IPNet
+	// recursionACL, if not empty, limits recursion and forwarding to clients inside its networks.
+	recursionACL []*net.IPNet
+	// specialNames answers RFC 6761 special-use names (localhost, invalid) locally instead of resolving them.
+	specialNames bool
+	// ednsOptions decides which EDNS options are relayed between clients and the upstream resolver.
+	ednsOptions *edns.Registry
+	// exchanger, if set, replaces the UDP and TCP transport to nameservers and the upstream resolver.
+	exchanger Exchanger
+	// cookieSecret, if set, is the key server cookies are derived from.
+	cookieSecret []byte
+	// ttlFloor is the minimum TTL, in seconds, of answers in forwarded and recursive responses.
+	ttlFloor uint32
+	// outbound is a semaphore limiting the number of concurrent queries to upstream resolvers and nameservers.
+	outbound chan struct{}
+	// queryBudget bounds the total time spent resolving a single client query, across all delegation hops.
+	queryBudget time.Duration
+	// nameserverPort is the port nameservers are queried on during recursive resolution.
+	nameserverPort int
+	// latency tracks the response times of upstream resolvers and nameservers, to prefer the faster ones.
+	latency *latencyTracker
+	// backoff skips upstream resolvers which failed repeatedly.
+	backoff *upstreamBackoff
+	// upstreamAddrCache holds the resolved addresses of a hostname upstream resolver.
+	upstreamAddrCache upstreamAddrCache
+	// ready is closed by Start once the server is serving queries.
+	ready chan struct{}
+	// rootHints are used as root servers if bootstrapping them from the upstream resolver fails.
+	rootHints []RootServer
+	// localRoot, if set, answers root zone NS and SOA queries locally.
+	localRoot *RootZone
+	// bootstrapAttempts is how many times bootstrapping the root servers is tried, bootstrapBackoff the first delay
+	// between attempts, doubled after every attempt.
+	bootstrapAttempts int
+	bootstrapBackoff  time.Duration
+	// bootstrapTimeout limits every bootstrap attempt, including the TCP retry of a truncated response.
+	bootstrapTimeout time.Duration
+	// ednsUDPSize is the UDP payload size advertised to clients in the OPT record of responses.
+	ednsUDPSize uint16
+	// clock tells the time cache entries, upstream backoff, upstream addresses and signatures are based on, the system
+	// clock if nil.
+	clock clock.Clock
+	// cacheMaxTTL caps how long responses and RRsets are cached, zero disables the cap.
+	cacheMaxTTL time.Duration
+	// cnameResponseLimit bounds the size of responses assembled from CNAME chains, maxMessageSize if zero.
+	cnameResponseLimit int
+	// responsePolicy, if set, filters responses with answers pointing into blocked networks.
+	responsePolicy *ResponsePolicy
+	// rebinding, if set, rejects responses resolving public names to private addresses.
+	rebinding *rebindingFilter
+	// hosts and blocklist are answered locally, with the listed addresses and the block response respectively.
+	hosts     *nameTrie[[]net.IP]
+	blocklist *nameTrie[struct{}]
+	// staticAnswers, if set, answers the names it has records for, see WithStaticAnswers.
+	staticAnswers *zone.Zone
+	// useSharedUpstream forwards UDP queries over the single sharedUpstream socket, see WithSharedUpstreamSocket.
+	useSharedUpstream bool
+	sharedUpstream    *sharedUpstream
+	sharedUpstreamMu  sync.Mutex
+	// upstreamProtocol is the transport queries are forwarded over, see WithUpstreamProtocol.
+	upstreamProtocol UpstreamProtocol
+	// familyFilter removes address answers of the other family from responses, see WithAddressFamilyFilter.
+	familyFilter FamilyFilter
+	// noCache holds the names set with WithNoCacheNames, which are resolved on every query.
+	noCache *nameTrie[struct{}]
+	// blockResponse is how blocked names are answered.
+	blockResponse BlockResponse
+	// negativeSOA, if set, is added to the locally synthesized negative responses.
+	negativeSOA *NegativeSOA
+	// logConfig configures the logger created by New when it is not given one.
+	logConfig logConfig
+	// successLogSampling logs only one in that many per-query success messages, successLogs counts them.
+	successLogSampling uint64
+	successLogs        atomic.Uint64
+	// truncatedResponses counts the responses truncated to fit into UDP or TCP framing, see Stats.
+	truncatedResponses atomic.Uint64
+	// badCookies counts the queries answered with BADCOOKIE, see Stats.
+	badCookies atomic.Uint64
+	// udpBufferSize is the size of the UDP socket receive and send buffers set by WithUDPBufferSize, 0 keeps the
+	// operating system defaults.
+	udpBufferSize int
+	// queryTypes counts the received queries by question type, see Stats.
+	queryTypes queryTypeCounter
+	// stubZones maps lowercase zone names, without the trailing dot, to the nameservers recursive resolution of names
+	// inside the zone starts from, instead of the root servers.
+	stubZones map[string][]RootServer
 }
 
 // New creates a new DNSServer with initialized UDP, TCP listener and a forwarder.
-func New(address string, resolverAddr string, recursive bool, logger *slog.Logger) (*DNSServer, func(), error) {
+// Optional behaviour can be enabled by passing any number of Option values.
+// If logger is nil, a logger is created as configured with WithLogFormat, WithLogLevel and WithLogOutput.
+func New(address string, resolverAddr string, recursive bool, logger *slog.Logger, opts ...Option) (*DNSServer, func(), error) {
+	resolver, err := net.ResolveUDPAddr("udp", resolverAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve resolver address: %w", err)
+	}
+
+	server := &DNSServer{
+		resolverAddr: resolver,
+		resolverHost: resolverAddr,
+		recursive:    recursive,
+		specialNames: true,
+		ednsOptions:  edns.NewRegistry(),
+		outbound:     make(chan struct{}, defaultMaxOutboundQueries),
+		queryBudget:  defaultQueryBudget,
+		ednsUDPSize:  defaultEDNSUDPSize,
+		ready:        make(chan struct{}),
+		latency:      newLatencyTracker(),
+		backoff:      newUpstreamBackoff(),
+		rootHints:    defaultRootHints,
+
+		bootstrapAttempts: defaultBootstrapAttempts,
+		bootstrapBackoff:  defaultBootstrapBackoff,
+		bootstrapTimeout:  defaultBootstrapTimeout,
+		cacheMaxTTL:       cache.DefaultMaxTTL,
+
+		logConfig: logConfig{output: os.Stdout, level: slog.LevelInfo, format: LogFormatText},
+	}
+
+	for _, opt := range opts {
+		opt(server)
+	}
+	if server.clock == nil {
+		server.clock = clock.Real{}
+	}
+
+	if logger == nil {
+		logger = server.logConfig.newLogger()
+	}
+	server.logger = logger
+	server.cache = cache.NewDNSCache(logger, cache.WithClock(server.clock), cache.WithMaxTTL(server.cacheMaxTTL))
+	server.backoff.now = server.now
+	if server.synthesizePTR {
+		server.cache.EnableReverseIndex()
+	}
+
 	udpAddr, err := net.ResolveUDPAddr("udp", address)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to resolve UDP address: %w", err)
 	}
-	udpConn, err := net.ListenUDP("udp", udpAddr)
+	udpConn, err := server.listenUDP(udpAddr)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to listen UDP address: %w", err)
 	}
@@ -52,54 +214,60 @@ func New(address string, resolverAddr string, recursive bool, logger *slog.Logge
 		_ = udpConn.Close()
 		return nil, nil, fmt.Errorf("failed to resolve TCP address: %w", err)
 	}
+	if tcpAddr.Port == 0 { // Clients retry truncated responses over TCP on the same port, so use the one UDP got
+		tcpAddr.Port = udpConn.LocalAddr().(*net.UDPAddr).Port
+	}
 
 	tcpListener, err := net.ListenTCP("tcp", tcpAddr)
 	if err != nil {
 		_ = udpConn.Close()
 		return nil, nil, fmt.Errorf("failed to listen on TCP address: %w", err)
 	}
+	server.udpConn = udpConn
+	server.tcpListener = tcpListener
 
-	resolver, err := net.ResolveUDPAddr("udp", resolverAddr)
-	if err != nil {
+	cleanup := func() {
 		_ = udpConn.Close()
 		_ = tcpListener.Close()
-		return nil, nil, fmt.Errorf("failed to resolve resolver address: %w", err)
+		server.wg.Wait()
+		server.closeSharedUpstream()
 	}
 
-	if logger == nil {
-		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-			AddSource:   false,
-			Level:       slog.LevelInfo,
-			ReplaceAttr: nil,
-		}))
-	}
+	return server, cleanup, nil
+}
 
-	server := &DNSServer{
-		udpConn:      udpConn,
-		tcpListener:  tcpListener,
-		resolverAddr: resolver,
-		resolverHost: resolverAddr,
-		logger:       logger,
-		cache:        cache.NewDNSCache(logger),
-		recursive:    recursive,
+// now returns the current time of the server's clock.
+func (s *DNSServer) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
 	}
+	return s.clock.Now()
+}
 
-	cleanup := func() {
-		server.wg.Wait()
-		_ = udpConn.Close()
-		_ = tcpListener.Close()
-	}
+// UDPAddr returns the address the UDP listener is bound to, including the port chosen by the system when New was
+// called with port 0.
+func (s *DNSServer) UDPAddr() net.Addr {
+	return s.udpConn.LocalAddr()
+}
 
-	return server, cleanup, nil
+// TCPAddr returns the address the TCP listener is bound to, including the port chosen by the system when New was
+// called with port 0.
+func (s *DNSServer) TCPAddr() net.Addr {
+	return s.tcpListener.Addr()
+}
+
+// Ready returns a channel which is closed once Start has the server serving queries, that is after the root servers
+// were bootstrapped in recursive mode.
+func (s *DNSServer) Ready() <-chan struct{} {
+	return s.ready
 }
 
 // Start starts the TCP and the UDP servers and starts listening on them for incoming DNS queries.
+// It blocks until the listeners are closed by the cleanup function returned from New.
 func (s *DNSServer) Start() {
-	const udpDNSMessageMaxSize uint16 = 512
-
 	s.logger.Info("Starting DNS server with resolver", slog.Any("resolver", *s.resolverAddr), slog.Any("listener", s.udpConn.LocalAddr()))
 	if s.recursive {
-		err := s.bootstrapRootServers()
+		err := s.initRootServers(context.Background())
 		if err != nil {
 			s.logger.Error("Failed to bootstrap root servers, recursive resolution may not work properly",
 				slog.Any("error", err))
@@ -108,12 +276,18 @@ func (s *DNSServer) Start() {
 
 	s.logger.Info("TCP listener started", slog.Any("listener", s.tcpListener.Addr()))
 
+	s.wg.Add(1)
 	go s.startTCPServer()
 
-	buf := make([]byte, udpDNSMessageMaxSize, udpDNSMessageMaxSize) //nolint:gosimple
+	close(s.ready)
+
+	buf := make([]byte, maxMessageSize)
 
 	for {
 		n, addr, err := s.udpConn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			s.logger.Error("failed to read from UDP connection", slog.Any("error", err))
 			continue
@@ -121,8 +295,49 @@ func (s *DNSServer) Start() {
 
 		s.wg.Add(1)
 
-		go s.handleDNSRequest(buf[:n], addr)
+		// Every handler gets its own copy, as the next read overwrites buf while the handler may still be running.
+		go s.handleDNSRequest(append([]byte(nil), buf[:n]...), addr)
+	}
+}
+
+// errResponseReceived is returned for a packet with the QR bit set arriving on the listening sockets.
+var errResponseReceived = errors.New("received a response instead of a query")
+
+// isResponsePacket reports whether data has the QR bit set. Such a packet arriving on the listening sockets is most
+// likely spoofed or looped back to us. It is dropped rather than answered or forwarded, which could bounce it between
+// servers indefinitely.
+func isResponsePacket(data []byte) bool {
+	const headerSize int = 12
+
+	if len(data) < headerSize {
+		return false
+	}
+	h, err := header.Unmarshal(data[:headerSize])
+	return err == nil && h.IsResponse()
+}
+
+// parseQuery unmarshals a query received from a client. A query whose questions don't match QDCOUNT is rejected, unless
+// best effort parsing was enabled with WithBestEffortQuestions, in which case it is answered from the questions which
+// could be parsed. The discrepancy is logged either way.
+func (s *DNSServer) parseQuery(data []byte) (Message.Message, error) {
+	if !s.bestEffortQuestions {
+		msg, err := Message.New(data)
+		if errors.Is(err, Message.ErrQuestionCount) {
+			s.logger.Warn("Rejected query with questions not matching QDCOUNT", slog.Any("error", err))
+		}
+		return msg, err
+	}
+
+	msg, unparsed, err := Message.NewBestEffort(data)
+	if err != nil {
+		return Message.Message{}, err
 	}
+	if unparsed > 0 {
+		s.logger.Warn("Parsed query with questions not matching QDCOUNT",
+			slog.Int("parsed", len(msg.Questions)),
+			slog.Int("unparsed", unparsed))
+	}
+	return msg, nil
 }
 
 // handleDNSRequest processes a single DNS request and sends a response
@@ -131,16 +346,18 @@ func (s *DNSServer) handleDNSRequest(data []byte, addr *net.UDPAddr) {
 	const theRestOfQuestions uint8 = 1
 
 	defer s.wg.Done()
-	msg, err := Message.New(data)
+	if isResponsePacket(data) {
+		s.logger.Debug("Dropped response received on the UDP listener", slog.Any("from", addr.String()))
+		return
+	}
+
+	msg, err := s.parseQuery(data)
 	if err != nil {
 		s.logger.Error("failed to unmarshal DNS request", slog.Any("error", err))
 		s.sendErrorResponse(data, addr, header.FormatError)
 		return
 	}
-
-	s.logger.Debug("Received DNS query from", slog.Any("from", addr.String()),
-		slog.String("question", msg.Questions[firstQuestion].Name),
-		slog.Any("type", msg.Questions[firstQuestion].Type))
+	limit := s.udpResponseLimit(&msg)
 
 	if len(msg.Questions) == 0 || msg.Header.GetQDCOUNT() == 0 {
 		s.logger.Error("DNS request contains no questions")
@@ -148,6 +365,10 @@ func (s *DNSServer) handleDNSRequest(data []byte, addr *net.UDPAddr) {
 		return
 	}
 
+	s.logger.Debug("Received DNS query from", slog.Any("from", addr.String()),
+		slog.String("question", msg.Questions[firstQuestion].Name),
+		slog.Any("type", msg.Questions[firstQuestion].Type))
+
 	if len(msg.Questions) > 1 || msg.Header.GetQDCOUNT() > 1 {
 		s.logger.Warn("Multiple questions in request, only processing the first one",
 			slog.Int("question_count", len(msg.Questions)))
@@ -158,9 +379,88 @@ func (s *DNSServer) handleDNSRequest(data []byte, addr *net.UDPAddr) {
 			s.logger.Error("Failed to update question count", slog.Any("error", err))
 		}
 	}
+	s.queryTypes.add(msg.Questions[firstQuestion].Type)
+
+	if msg.Header.GetOpcode() == header.Update {
+		s.logger.Warn("Dynamic updates are only accepted over TCP", slog.Any("from", addr.String()))
+		s.sendErrorResponse(data, addr, header.NotImplemented)
+		return
+	}
+
+	if resp, ok := badVersionResponse(&msg, s.advertisedUDPSize()); ok {
+		s.logger.Warn("Unsupported EDNS version in request", slog.Any("from", addr.String()))
+		s.sendResponse(&msg, resp, nil, data, addr, limit)
+		return
+	}
+
+	cookie, err := s.responseCookie(&msg, addr)
+	if errors.Is(err, errBadServerCookie) {
+		s.logger.Debug("Client sent an invalid server cookie", slog.Any("from", addr.String()))
+		s.badCookies.Add(1)
+		resp, err := badCookieResponse(&msg, s.advertisedUDPSize())
+		if err != nil {
+			s.logger.Error("Failed to create BADCOOKIE response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
+		}
+		s.sendResponse(&msg, resp, cookie, data, addr, limit)
+		return
+	}
+	if err != nil {
+		s.logger.Warn("Malformed EDNS cookie in request", slog.Any("from", addr.String()), slog.Any("error", err))
+		s.sendErrorResponse(data, addr, header.FormatError)
+		return
+	}
+
+	if s.specialNames {
+		if resp, ok := specialNameResponse(&msg); ok {
+			if err := s.addNegativeSOA(resp); err != nil {
+				s.logger.Error("Failed to add negative SOA", slog.Any("error", err))
+			}
+			s.sendResponse(&msg, resp, cookie, data, addr, limit)
+			return
+		}
+	}
+
+	if resp, ok := s.localRootResponse(&msg); ok {
+		s.sendResponse(&msg, resp, cookie, data, addr, limit)
+		return
+	}
+
+	if resp, ok := s.hostsResponse(&msg); ok {
+		s.sendResponse(&msg, resp, cookie, data, addr, limit)
+		return
+	}
+
+	if resp, ok := s.staticResponse(&msg); ok {
+		s.sendResponse(&msg, resp, cookie, data, addr, limit)
+		return
+	}
+
+	if s.zone != nil {
+		if resp, ok := s.zone.Answer(&msg); ok {
+			s.sendResponse(&msg, resp, cookie, data, addr, limit)
+			return
+		}
+	}
+
+	if !s.recursionAllowedFrom(addr) {
+		s.logger.Warn("Refused recursion to client outside the allowlist", slog.Any("from", addr.String()))
+		resp, err := refusedResponse(&msg)
+		if err != nil {
+			s.sendErrorResponse(data, addr, header.Refused)
+			return
+		}
+		resp.Header.SetRA(false)
+		s.sendResponse(&msg, resp, cookie, data, addr, limit)
+		return
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
 	if msg.Header.IsRD() && s.recursive {
-		resp, err := s.resolveRecursively(&msg)
+		resp, err := s.resolveRecursively(ctx, &msg)
 		if err != nil {
 			s.logger.Error("Recursive resolution failed",
 				slog.String("question", msg.Questions[firstQuestion].Name),
@@ -181,21 +481,29 @@ func (s *DNSServer) handleDNSRequest(data []byte, addr *net.UDPAddr) {
 
 		resp.Header.ID = msg.Header.ID
 
-		respData, err := resp.MarshalBinary()
+		resp, err = s.applyResponsePolicy(resp)
 		if err != nil {
-			s.logger.Error("Failed to marshal recursive response", slog.Any("error", err))
+			s.logger.Error("Failed to apply response policy to recursive response", slog.Any("error", err))
 			s.sendErrorResponse(data, addr, header.ServerFailure)
 			return
 		}
-
-		if len(respData) > 512 {
-			resp.Header.SetTC(true)
-			respData, err = resp.MarshalBinary()
-			if err != nil {
-				s.logger.Error("Failed to marshal recursive response with TC flag", slog.Any("error", err))
-				s.sendErrorResponse(data, addr, header.ServerFailure)
-				return
-			}
+		resp, err = s.filterAddressFamilies(resp, addr)
+		if err != nil {
+			s.logger.Error("Failed to filter address families of recursive response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
+		}
+		resp, err = s.stripAdditional(resp)
+		if err != nil {
+			s.logger.Error("Failed to strip Additional records from recursive response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
+		}
+		respData, err := s.marshalUDPResponse(&msg, resp, cookie, limit)
+		if err != nil {
+			s.logger.Error("Failed to marshal recursive response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
 		}
 
 		_, err = s.udpConn.WriteToUDP(respData, addr)
@@ -205,11 +513,16 @@ func (s *DNSServer) handleDNSRequest(data []byte, addr *net.UDPAddr) {
 				slog.Any("error", err))
 		}
 
-		s.logger.Info("Sent recursive response",
+		s.logSuccess("Sent recursive response",
 			slog.Any("to_address", addr.String()),
 			slog.Int("answer_count", len(resp.Answers)))
 	} else {
 		msg.Header.SetQRFlag(false)
+		if err := s.ednsOptions.Apply(&msg); err != nil {
+			s.logger.Error("Error filtering EDNS options", slog.Any("error", err), slog.Any("to_address", addr.String()))
+			s.sendErrorResponse(data, addr, header.FormatError)
+			return
+		}
 		queryData, err := msg.MarshalBinary()
 		if err != nil {
 			s.logger.Error("Error marshalling query", slog.Any("error", err), slog.Any("to_address", addr.String()))
@@ -217,7 +530,7 @@ func (s *DNSServer) handleDNSRequest(data []byte, addr *net.UDPAddr) {
 			return
 		}
 
-		responseData, err := s.forwardToResolver(queryData)
+		responseData, err := s.forwardToResolver(ctx, queryData)
 		if err != nil {
 			s.logger.Error("Error forwarding request", slog.Any("error", err))
 			s.sendErrorResponse(data, addr, header.ServerFailure)
@@ -228,89 +541,139 @@ func (s *DNSServer) handleDNSRequest(data []byte, addr *net.UDPAddr) {
 			return
 		}
 
-		if len(responseData.Answers) > 0 && responseData.Header.GetANCOUNT() != 0 {
-			marshalledData, err := responseData.MarshalBinary()
-			if err != nil {
-				s.logger.Error("Error marshalling response", slog.Any("error", err))
-				s.sendErrorResponse(data, addr, header.ServerFailure)
-				return
-			}
-
-			if len(marshalledData) > 512 {
-				responseData.Header.SetTC(true)
-				marshalledData, err = responseData.MarshalBinary()
-				if err != nil {
-					s.logger.Error("Error marshalling response with TC flag", slog.Any("error", err))
-					s.sendErrorResponse(data, addr, header.ServerFailure)
-					return
-				}
-			}
-
-			_, err = s.udpConn.WriteToUDP(marshalledData, addr)
-			if err != nil {
-				s.logger.Error("Error sending response", slog.Any("to_address", addr.String()), slog.Any("error", err))
-			}
+		if err := s.ednsOptions.Apply(responseData); err != nil {
+			s.logger.Error("Error filtering EDNS options of forwarded response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
+		}
+		s.applyTTLFloor(responseData)
+		responseData, err = s.applyResponsePolicy(responseData)
+		if err != nil {
+			s.logger.Error("Failed to apply response policy to forwarded response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
+		}
+		responseData, err = s.filterAddressFamilies(responseData, addr)
+		if err != nil {
+			s.logger.Error("Failed to filter address families of forwarded response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
+		}
+		responseData, err = s.stripAdditional(responseData)
+		if err != nil {
+			s.logger.Error("Failed to strip Additional records from forwarded response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
+		}
+		marshalledData, err := s.marshalUDPResponse(&msg, responseData, cookie, limit)
+		if err != nil {
+			s.logger.Error("Error marshalling response", slog.Any("error", err))
+			s.sendErrorResponse(data, addr, header.ServerFailure)
+			return
+		}
 
-			s.logger.Info("Sent forwarded response",
-				slog.Any("to_address", addr.String()),
-				slog.Int("answer_count", len(responseData.Answers)))
+		_, err = s.udpConn.WriteToUDP(marshalledData, addr)
+		if err != nil {
+			s.logger.Error("Error sending response", slog.Any("to_address", addr.String()), slog.Any("error", err))
 		}
+
+		s.logSuccess("Sent forwarded response",
+			slog.Any("to_address", addr.String()),
+			slog.Int("answer_count", len(responseData.Answers)))
 	}
 }
 
-func (s *DNSServer) sendErrorResponse(data []byte, addr *net.UDPAddr, errorCode header.ResponseCode) {
-	const headerSize int = 12
-
-	var h header.Header
+// sendResponse finishes, signs, marshals and sends resp to query over UDP, truncating it and setting the TC flag if it
+// does not fit into limit bytes.
+func (s *DNSServer) sendResponse(query, resp *Message.Message, cookie *edns.Option, data []byte, addr *net.UDPAddr,
+	limit int) {
+	respData, err := s.marshalUDPResponse(query, resp, cookie, limit)
+	if err != nil {
+		s.logger.Error("Failed to marshal response", slog.Any("error", err))
+		s.sendErrorResponse(data, addr, header.ServerFailure)
+		return
+	}
 
-	if len(data) >= headerSize {
-		originalHeader, err := header.Unmarshal(data[:headerSize])
-		if err == nil && originalHeader != nil {
-			h = *originalHeader
-		} else {
-			h = header.Header{}
-		}
-	} else {
-		h = header.Header{}
+	_, err = s.udpConn.WriteToUDP(respData, addr)
+	if err != nil {
+		s.logger.Error("Failed to send response", slog.Any("to_address", addr.String()), slog.Any("error", err))
+		return
 	}
+	s.logSuccess("Sent local response",
+		slog.Any("to_address", addr.String()),
+		slog.Int("answer_count", len(resp.Answers)))
+}
 
-	h.SetQRFlag(true)
-	h.SetRCODE(errorCode)
+// marshalUDPResponse finishes resp to query with cookie, see finishResponse, signs it and marshals it to fit into limit
+// bytes, counting it in the statistics if it had to be truncated. The signature covers the whole message, so it is
+// added after truncation, with room reserved for it. resp itself is left unmodified.
+func (s *DNSServer) marshalUDPResponse(query, resp *Message.Message, cookie *edns.Option, limit int) ([]byte, error) {
+	resp, err := s.finishResponse(query, resp, cookie)
+	if err != nil {
+		return nil, err
+	}
 
-	var questions []question.Question
-	if len(data) >= headerSize {
-		msg, err := Message.New(data)
-		if err == nil {
-			questions = msg.Questions
+	reserved := 0
+	if len(s.signingKey) != 0 {
+		signed, err := s.signResponse(resp)
+		if err != nil {
+			return nil, err
 		}
+		sig, err := signed.Additional[len(signed.Additional)-1].MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		reserved = len(sig)
 	}
 
-	errorMsg := Message.Message{
-		Header:    h,
-		Questions: questions,
-		Answers:   []RR.RR{},
+	truncated := *resp
+	data, err := truncated.MarshalBinaryWithLimit(limit - reserved)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Header.IsTC() && truncated.Header.IsTC() {
+		s.truncatedResponses.Add(1)
+	}
+	if len(s.signingKey) == 0 {
+		return data, nil
 	}
 
-	err := h.SetQDCOUNT(len(questions))
+	signed, err := s.signResponse(&truncated)
 	if err != nil {
-		s.logger.Error("failed to set QDCOUNT", slog.Any("error", err))
-		return
+		return nil, err
 	}
-	err = h.SetANCOUNT(0)
-	if err != nil {
-		s.logger.Error("failed to set ANCOUNT", slog.Any("error", err))
-		return
+	return signed.MarshalBinary()
+}
+
+// signResponse returns a signed copy of resp if a signing key was configured with WithSigningKey.
+// Otherwise, resp is returned as is. The copy keeps cached messages from being modified.
+func (s *DNSServer) signResponse(resp *Message.Message) (*Message.Message, error) {
+	if len(s.signingKey) == 0 {
+		return resp, nil
 	}
-	err = h.SetNSCOUNT(0)
+
+	signed, err := Message.Copy(resp)
 	if err != nil {
-		s.logger.Error("failed to set NSCOUNT", slog.Any("error", err))
-		return
+		return nil, fmt.Errorf("failed to copy response for signing: %w", err)
 	}
-	err = h.SetARCOUNT(0)
-	if err != nil {
-		s.logger.Error("failed to set ARCOUNT", slog.Any("error", err))
-		return
+	if err := signing.Sign(&signed, s.signingKey, s.now()); err != nil {
+		return nil, fmt.Errorf("failed to sign response: %w", err)
+	}
+	return &signed, nil
+}
+
+func (s *DNSServer) sendErrorResponse(data []byte, addr *net.UDPAddr, errorCode header.ResponseCode) {
+	const headerSize int = 12
+
+	var query Message.Message
+	if msg, err := Message.New(data); err == nil {
+		query = msg
+	} else if len(data) >= headerSize {
+		if originalHeader, err := header.Unmarshal(data[:headerSize]); err == nil && originalHeader != nil {
+			query.Header = *originalHeader
+		}
 	}
+	errorMsg := Message.MakeError(&query, errorCode)
 
 	responseData, err := errorMsg.MarshalBinary()
 	if err != nil {
@@ -332,11 +695,77 @@ func (s *DNSServer) sendErrorResponse(data []byte, addr *net.UDPAddr, errorCode
 	}
 }
 
-func (s *DNSServer) forwardToResolver(query []byte) (*Message.Message, error) {
-	const udpMaxSize uint16 = 512
+// forwardToResolver sends a DNS Message to the upstream resolver via UDP.
+// If the upstream resolves to both IPv6 and IPv4 addresses the two are raced and the first response wins.
+// A truncated response is transparently retried over TCP, so the caller always gets the complete answer.
+// With UpstreamTCP configured the query is sent over TCP right away.
+// A response which fails Message.ValidateResponse counts as a failed attempt, and the next address is tried.
+func (s *DNSServer) forwardToResolver(ctx context.Context, query []byte) (*Message.Message, error) {
+	if s.upstreamProtocol == UpstreamTCP {
+		return s.forwardToResolverTCP(ctx, query)
+	}
+	queryMsg, err := Message.New(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal forwarded query: %w", err)
+	}
+	addrs, err := s.availableUpstreamAddrs()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := raceUpstreams(ctx, s.latency.sortAddrs(addrs),
+		func(ctx context.Context, addr string) (*Message.Message, error) {
+			start := time.Now()
+			msg, err := s.forwardToResolverAddr(ctx, addr, query)
+			// Truncated responses are validated once they are retried over TCP.
+			if err == nil && (msg == nil || !msg.Header.IsTC()) {
+				err = Message.ValidateResponse(&queryMsg, msg)
+			}
+			s.observeUpstream(ctx, addr, start, err)
+			if err != nil {
+				return nil, err
+			}
+			return msg, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Header.IsTC() {
+		s.logger.Debug("Upstream response truncated, retrying over TCP")
+		return s.forwardToResolverTCP(ctx, query)
+	}
+	return msg, nil
+}
+
+// forwardToResolverAddr sends a DNS Message to a single upstream resolver address via UDP.
+// The response is read into a buffer sized to the UDP payload size the query advertised via EDNS.
+func (s *DNSServer) forwardToResolverAddr(ctx context.Context, addr string, query []byte) (*Message.Message, error) {
 	const dialTimeout time.Duration = time.Second * 5
 
-	conn, err := net.DialTimeout("udp", s.resolverAddr.String(), dialTimeout)
+	if s.exchanger != nil {
+		return s.exchangeUpstream(ctx, addr, query)
+	}
+
+	udpMaxSize := uint16(512)
+	if queryMsg, err := Message.New(query); err == nil {
+		udpMaxSize = queryMsg.EDNSUDPSize()
+	}
+
+	release, err := s.acquireOutbound(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if s.useSharedUpstream {
+		shared, err := s.sharedUpstreamSocket()
+		if err != nil {
+			return nil, err
+		}
+		return shared.exchange(ctx, addr, query)
+	}
+
+	conn, err := dialUpstream(ctx, "udp", addr, dialTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to resolver: %w", err)
 	}
@@ -363,8 +792,29 @@ func (s *DNSServer) forwardToResolver(query []byte) (*Message.Message, error) {
 	return &msg, nil
 }
 
-// resolveRecursively performs recursive DNS resolution starting from root servers
-func (s *DNSServer) resolveRecursively(query *Message.Message) (*Message.Message, error) {
+// resolveRecursively performs recursive DNS resolution starting from root servers. With name normalization enabled the
+// query is resolved with its names lowercased and the response echoes the question as the client sent it.
+func (s *DNSServer) resolveRecursively(ctx context.Context, query *Message.Message) (*Message.Message, error) {
+	if !s.normalizeNames || query == nil {
+		return s.resolveQuery(ctx, query)
+	}
+
+	normalized := *query
+	normalized.LowercaseNames()
+	resp, err := s.resolveQuery(ctx, &normalized)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	echo, err := Message.Copy(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy a response: %w", err)
+	}
+	echo.Questions = query.Questions
+	return &echo, nil
+}
+
+// resolveQuery performs recursive DNS resolution of query starting from root servers, see resolveRecursively.
+func (s *DNSServer) resolveQuery(ctx context.Context, query *Message.Message) (*Message.Message, error) {
 	const startDelegationCount int = 0
 	const maxAcceptableQuestionsCount int = 1
 	const maxAcceptableQuestionsCountUint16 uint16 = uint16(maxAcceptableQuestionsCount)
@@ -379,44 +829,81 @@ func (s *DNSServer) resolveRecursively(query *Message.Message) (*Message.Message
 
 	questionType := query.Questions[firstQuestion].Type
 	domain := query.Questions[firstQuestion].Name
-	cacheKey := fmt.Sprintf("%s:%d", domain, questionType)
+	cacheKey := recursiveCacheKey(domain, questionType)
+
+	// The root servers and everything below them only serve the Internet class, other classes can't be resolved.
+	if questionClass := query.Questions[firstQuestion].Class; questionClass != DNS_Class.IN {
+		s.logger.Warn("Refusing recursive query of unsupported class",
+			slog.String("domain", domain),
+			slog.Any("class", questionClass))
+		return refusedResponse(query)
+	}
+
+	if isRootName(domain) && (questionType == DNS_Type.NS || questionType == DNS_Type.SOA) {
+		return s.resolveRootQuery(ctx, query)
+	}
 
-	if che := s.cache.Get(cacheKey); che != nil {
-		s.logger.Info("Cache hit", slog.String("domain", domain), slog.Any("type", questionType))
-		che.Header.ID = query.Header.ID
-		return che, nil
+	if s.synthesizePTR && questionType == DNS_Type.PTR {
+		if resp, ok := s.synthesizedPTRResponse(query); ok {
+			s.logger.Info("Answered from reverse index", slog.String("domain", domain))
+			return resp, nil
+		}
+	}
+
+	cacheable := s.cacheable(domain)
+	if cacheable {
+		if che := s.cache.Get(cacheKey); che != nil {
+			s.logger.Info("Cache hit", slog.String("domain", domain), slog.Any("type", questionType))
+			che.Header.ID = query.Header.ID
+			// Cached data is never authoritative (https://datatracker.ietf.org/doc/html/rfc1035#section-6.1.3).
+			che.Header.SetAA(false)
+			return che, nil
+		}
+		if s.cache.HasFailure(cacheKey) {
+			s.logger.Info("Failure cache hit", slog.String("domain", domain), slog.Any("type", questionType))
+			return nil, fmt.Errorf("resolution of %s recently failed", domain)
+		}
 	}
 
 	s.logger.Info("Starting recursive resolution",
 		slog.String("domain", domain),
 		slog.Any("type", questionType))
 
-	var nameservers []RootServer
-	nameservers = append(nameservers, s.rootServers...)
-
-	result, err := s.resolveWithNameservers(domain, questionType, nameservers, startDelegationCount,
-		make(map[string]struct{}))
-	if err != nil {
-		s.logger.Error("Recursive resolution failed, falling back to upstream resolver",
-			slog.String("domain", domain), slog.Any("error", err))
-
-		query.Header.SetQRFlag(false)
-		queryData, errMarshal := query.MarshalBinary()
-		if errMarshal != nil {
-			return nil, fmt.Errorf("failed to marshal fallback query: %w", err)
+	var result *Message.Message
+	var err error
+	if cnameResult := s.cachedCNAMEResponse(ctx, query, questionType); cnameResult != nil {
+		s.logger.Info("Answered from cached CNAME", slog.String("domain", domain), slog.Any("type", questionType))
+		result = cnameResult
+	} else {
+		zone, nameservers := s.startingNameservers(domain)
+		result, err = s.resolveWithNameservers(ctx, domain, questionType, zone, s.orderNameservers(nameservers),
+			startDelegationCount, make(map[string]struct{}))
+	}
+	if err != nil && ctx.Err() != nil {
+		if cacheable {
+			s.cache.PutFailure(cacheKey, serverFailureTTL)
 		}
-
-		return s.forwardToResolver(queryData)
+		return nil, err
 	}
-	if result == nil {
-		s.logger.Error("resolveRecursively got nil result from resolveWithNameservers")
+	if err != nil || result == nil {
+		if err != nil {
+			s.logger.Error("Recursive resolution failed, falling back to upstream resolver",
+				slog.String("domain", domain), slog.Any("error", err))
+		} else {
+			s.logger.Error("resolveRecursively got nil result from resolveWithNameservers")
+		}
+
 		query.Header.SetQRFlag(false)
 		queryData, errMarshal := query.MarshalBinary()
 		if errMarshal != nil {
-			return nil, fmt.Errorf("failed to marshal fallback query: %w", err)
+			return nil, fmt.Errorf("failed to marshal fallback query: %w", errMarshal)
 		}
 
-		return s.forwardToResolver(queryData)
+		fallback, errForward := s.forwardToResolver(ctx, queryData)
+		if cacheable && (errForward != nil || fallback.Header.GetRCODE() == header.ServerFailure) {
+			s.cache.PutFailure(cacheKey, serverFailureTTL)
+		}
+		return fallback, errForward
 	}
 
 	response, err := Message.Copy(result)
@@ -426,7 +913,13 @@ func (s *DNSServer) resolveRecursively(query *Message.Message) (*Message.Message
 	response.Header.ID = query.Header.ID
 	response.Header.SetQRFlag(true)
 	response.Header.SetRA(true)
-	response.Header.SetAA(result.Header.IsAA())
+	response.Header.SetAA(isAuthoritativeAnswer(result, domain))
+
+	if s.minimalResponses {
+		if err := dropNonEssentialAdditional(&response); err != nil {
+			s.logger.Error("Failed to drop non-essential additional records", slog.Any("error", err))
+		}
+	}
 
 	if err := response.Header.SetANCOUNT(len(response.Answers)); err != nil {
 		s.logger.Error("Failed to set ANCOUNT", slog.Any("error", err))
@@ -438,18 +931,175 @@ func (s *DNSServer) resolveRecursively(query *Message.Message) (*Message.Message
 		s.logger.Error("Failed to set ARCOUNT", slog.Any("error", err))
 	}
 
-	s.cache.Put(cacheKey, &response)
+	s.applyTTLFloor(&response)
+
+	if !cacheable {
+		return &response, nil
+	}
+	// The caller adapts the response to its client, the cache keeps its own header.
+	cached := response
+	cached.Header = response.Header.Clone()
+	if ttl, negative := negativeCacheTTL(&cached); negative {
+		s.cache.PutNegative(cacheKey, &cached, ttl)
+	} else {
+		s.cache.Put(cacheKey, &cached)
+	}
 	return &response, nil
 }
 
-// resolveWithNameservers recursively resolves a domain by querying nameservers
-func (s *DNSServer) resolveWithNameservers(domain string, questionType DNS_Type.Type, nameservers []RootServer,
-	delegationCount int, cnameChain map[string]struct{}) (*Message.Message, error) {
+// isAuthoritativeAnswer reports whether result may be passed on with the AA flag: it came straight from a server
+// authoritative for domain, and its answers all belong to domain rather than to the targets of followed CNAMEs.
+func isAuthoritativeAnswer(result *Message.Message, domain string) bool {
+	if !result.Header.IsAA() {
+		return false
+	}
+	for _, answer := range result.Answers {
+		if !name.Equal(answer.GetName(), domain) {
+			return false
+		}
+	}
+	return true
+}
+
+// recursiveCacheKey returns the key responses to recursive queries for domain and questionType are cached under.
+func recursiveCacheKey(domain string, questionType DNS_Type.Type) string {
+	return fmt.Sprintf("%s:%d", name.Canonicalize(domain), questionType)
+}
+
+// applyTTLFloor raises the TTL of every answer in msg which is below the floor configured with WithTTLFloor.
+func (s *DNSServer) applyTTLFloor(msg *Message.Message) {
+	if s.ttlFloor == 0 {
+		return
+	}
+	for i := range msg.Answers {
+		if msg.Answers[i].GetTTL() < s.ttlFloor {
+			msg.Answers[i].TTL = s.ttlFloor
+		}
+	}
+}
+
+// advertiseEDNS returns a copy of response whose OPT record advertises the UDP payload size of the server. A response
+// to a query with an OPT record gets one added if it has none, responses to other queries are returned as is.
+// The copy keeps cached messages from being modified.
+func (s *DNSServer) advertiseEDNS(query, response *Message.Message) (*Message.Message, error) {
+	opt, hasOPT := response.GetOPT()
+	if !hasOPT {
+		if _, queryHasOPT := query.GetOPT(); !queryHasOPT {
+			return response, nil
+		}
+		opt = &Message.OPTRecord{Version: edns.Version}
+	}
+	opt.UDPSize = s.advertisedUDPSize()
+
+	advertised := *response
+	if err := advertised.SetOPT(opt); err != nil {
+		return nil, fmt.Errorf("failed to set OPT record: %w", err)
+	}
+	return &advertised, nil
+}
+
+// udpResponseLimit returns the largest UDP response to query: the payload size it advertised in its OPT record, capped
+// at the size the server advertises itself (https://datatracker.ietf.org/doc/html/rfc6891#section-6.2.5). Queries
+// without an OPT record are limited to udpResponseMaxSize.
+func (s *DNSServer) udpResponseLimit(query *Message.Message) int {
+	if _, ok := query.GetOPT(); !ok {
+		return udpResponseMaxSize
+	}
+	return max(udpResponseMaxSize, int(min(query.EDNSUDPSize(), s.advertisedUDPSize())))
+}
+
+// advertisedUDPSize returns the UDP payload size the server advertises in OPT records of its responses.
+func (s *DNSServer) advertisedUDPSize() uint16 {
+	if s.ednsUDPSize == 0 {
+		return defaultEDNSUDPSize
+	}
+	return s.ednsUDPSize
+}
+
+// recursionAllowedFrom reports whether the client at from may use recursion and forwarding, which is everyone unless
+// limited with WithRecursionACL.
+func (s *DNSServer) recursionAllowedFrom(from net.Addr) bool {
+	if len(s.recursionACL) == 0 {
+		return true
+	}
+	ip := clientIP(from)
+	for _, network := range s.recursionACL {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// refusedResponse builds a REFUSED response to query, echoing its question.
+func refusedResponse(query *Message.Message) (*Message.Message, error) {
+	response := Message.MakeError(query, header.Refused)
+	response.Header.SetRA(true)
+	return response, nil
+}
+
+// badVersionResponse returns a BADVERS response advertising udpSize if query advertises an EDNS version newer than the
+// server implements, as required by https://datatracker.ietf.org/doc/html/rfc6891#section-6.1.3
+func badVersionResponse(query *Message.Message, udpSize uint16) (*Message.Message, bool) {
+	version, hasOPT := query.EDNSVersion()
+	if !hasOPT || version <= edns.Version {
+		return nil, false
+	}
+
+	response := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+	}
+	response.Header.SetQRFlag(true)
+	if err := response.Header.SetANCOUNT(0); err != nil {
+		return nil, false
+	}
+	if err := response.Header.SetNSCOUNT(0); err != nil {
+		return nil, false
+	}
+	if err := edns.SetExtendedRCODE(response, edns.BadVersion, udpSize); err != nil {
+		return nil, false
+	}
+	return response, true
+}
+
+// dropNonEssentialAdditional removes every record except the OPT pseudo record from the Additional section.
+// Glue and other optional records are not needed by a stub resolver which got its answer from us.
+func dropNonEssentialAdditional(msg *Message.Message) error {
+	opt, hasOPT := msg.GetOPT()
+	msg.Additional = nil
+	if !hasOPT {
+		return msg.Header.SetARCOUNT(0)
+	}
+	return msg.SetOPT(opt)
+}
+
+// stripAdditional returns a copy of response without Additional records other than OPT, if the server is configured to
+// with WithStripAdditional. Otherwise it returns response unchanged.
+func (s *DNSServer) stripAdditional(response *Message.Message) (*Message.Message, error) {
+	if !s.stripAdditionalRecords {
+		return response, nil
+	}
+	stripped := *response
+	if err := dropNonEssentialAdditional(&stripped); err != nil {
+		return nil, fmt.Errorf("failed to drop Additional records: %w", err)
+	}
+	return &stripped, nil
+}
+
+// resolveWithNameservers recursively resolves a domain by querying nameservers, which are authoritative for zone.
+// Records outside zone are discarded from their responses.
+func (s *DNSServer) resolveWithNameservers(ctx context.Context, domain string, questionType DNS_Type.Type, zone string,
+	nameservers []RootServer, delegationCount int, cnameChain map[string]struct{}) (*Message.Message, error) {
 
 	const maxDelegations int = 10
 	const firstNameServer uint8 = 0
 	const restOfAvailableNameServers uint8 = 1
 
+	if err := ctx.Err(); err != nil { // Base case: the budget of the client query is used up
+		return nil, fmt.Errorf("resolution budget exceeded: %w", err)
+	}
+
 	if delegationCount >= maxDelegations { // Base case: delegation limit reached
 		return nil, fmt.Errorf("exceeded maximum delegation count (%d)", maxDelegations)
 	}
@@ -470,25 +1120,48 @@ func (s *DNSServer) resolveWithNameservers(domain string, questionType DNS_Type.
 	nsQuery, err := Message.CreateDNSQuery(domain, questionType, DNS_Class.IN, false)
 	if err != nil {
 		s.logger.Error("Failed to create nameserver query", slog.Any("error", err))
-		return s.resolveWithNameservers(domain, questionType, remainingServers, delegationCount, cnameChain)
+		return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
 	}
 
 	err = nsQuery.Header.SetRandomID()
 	if err != nil {
 		s.logger.Error("Failed to set random query ID", slog.Any("error", err))
-		return s.resolveWithNameservers(domain, questionType, remainingServers, delegationCount, cnameChain)
+		return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
 	}
 
-	nsResp, err := s.queryNameserver(server.IP, &nsQuery)
+	start := time.Now()
+	nsResp, err := s.queryNameserver(ctx, server.IP, &nsQuery)
+	s.latency.observeResult(s.nameserverAddr(server.IP), start, err)
 	if err != nil {
 		s.logger.Debug("Failed to query nameserver",
 			slog.String("nameserver", server.Name),
 			slog.Any("error", err))
-		return s.resolveWithNameservers(domain, questionType, remainingServers, delegationCount, cnameChain)
+		return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
 	}
 
-	if !nsResp.IsNoErrWithMatchingID(nsQuery.Header.GetMessageID()) {
-		return nil, fmt.Errorf("resolveNameserver got invalid response from nameserver")
+	if err := Message.ValidateResponse(&nsQuery, nsResp); err != nil {
+		s.logger.Debug("Invalid response from nameserver",
+			slog.String("nameserver", server.Name),
+			slog.Any("error", err))
+		return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
+	}
+
+	discarded, err := discardOutOfBailiwick(nsResp, zone)
+	if err != nil {
+		s.logger.Error("Failed to discard out-of-bailiwick records", slog.Any("error", err))
+		return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
+	}
+	if discarded > 0 {
+		s.logger.Warn("Discarded out-of-bailiwick records",
+			slog.String("nameserver", server.Name),
+			slog.String("zone", zone),
+			slog.Int("count", discarded))
+	}
+	if s.normalizeNames {
+		nsResp.LowercaseNames()
+	}
+	if s.cacheable(domain) {
+		s.cache.PutRRSets(nsResp)
 	}
 
 	// Check for CNAME records when not specifically looking for CNAMEs
@@ -497,10 +1170,10 @@ func (s *DNSServer) resolveWithNameservers(domain string, questionType DNS_Type.
 			s.logger.Error("Mismatch between ANCOUNT flag and actual answers",
 				slog.Any("ANCOUNT_flag", nsResp.Header.GetANCOUNT()),
 				slog.Any("actual answers", len(nsResp.Answers)))
-			return s.resolveWithNameservers(domain, questionType, remainingServers, delegationCount, cnameChain)
+			return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
 		}
 
-		cnameResult := s.handleCNAMEs(domain, questionType, nsResp, cnameChain)
+		cnameResult := s.handleCNAMEs(ctx, domain, questionType, nsResp, cnameChain)
 		if cnameResult != nil {
 			return cnameResult, nil
 		}
@@ -510,7 +1183,14 @@ func (s *DNSServer) resolveWithNameservers(domain string, questionType DNS_Type.
 		if len(nsResp.Answers) != int(nsResp.Header.GetANCOUNT()) {
 			s.logger.Error("Mismatch between ANCOUNT flag and actual answers", slog.Any("ANCOUNT_flag", nsResp.Header.GetANCOUNT()),
 				slog.Any("actual answers", len(nsResp.Answers)))
-			return s.resolveWithNameservers(domain, questionType, remainingServers, delegationCount, cnameChain)
+			return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
+		}
+		if !answersQuestion(nsResp, domain, questionType) {
+			s.logger.Warn("Authoritative answer does not match the query",
+				slog.String("nameserver", server.Name),
+				slog.String("domain", domain),
+				slog.String("type", questionType.String()))
+			return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
 		}
 		s.logger.Info("Found authoritative answer",
 			slog.String("domain", domain),
@@ -532,19 +1212,36 @@ func (s *DNSServer) resolveWithNameservers(domain string, questionType DNS_Type.
 		return nsResp, nil
 	}
 
-	nextNameservers, hasAuthority := s.extractAuthorityNameservers(domain, nsResp) // Recursive case: try new authority nameservers
+	nextNameservers, hasAuthority := s.extractAuthorityNameservers(ctx, domain, nsResp) // Recursive case: try new authority nameservers
 	if hasAuthority {
-		return s.resolveWithNameservers(domain, questionType, nextNameservers, delegationCount+1, cnameChain)
+		nextZone, _ := delegationZone(nsResp)
+		return s.resolveWithNameservers(ctx, domain, questionType, nextZone, s.orderNameservers(nextNameservers),
+			delegationCount+1, cnameChain)
 	}
 
 	if len(remainingServers) > 0 { // If no authority records found, try next nameserver at current level
-		return s.resolveWithNameservers(domain, questionType, remainingServers, delegationCount, cnameChain)
+		return s.resolveWithNameservers(ctx, domain, questionType, zone, remainingServers, delegationCount, cnameChain)
 	}
 	return nil, fmt.Errorf("all nameservers exhausted without finding an answer")
 }
 
+// answersQuestion reports whether resp has at least one answer owned by domain, either of questionType or a CNAME to
+// follow. Domain is the name currently resolved, which is the CNAME target while following a chain. A response whose
+// answers are all for other names does not answer the query.
+func answersQuestion(resp *Message.Message, domain string, questionType DNS_Type.Type) bool {
+	for _, answer := range resp.Answers {
+		if !name.Equal(answer.GetName(), domain) {
+			continue
+		}
+		if answer.Type == questionType || answer.Type == DNS_Type.CNAME || questionType == DNS_Type.ANY {
+			return true
+		}
+	}
+	return false
+}
+
 // handleCNAMEs should hande the CNAME chains...Except when it does not everything breaks... (This caused me a lot of issues)
-func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsResp *Message.Message, cnameChain map[string]struct{}) *Message.Message {
+func (s *DNSServer) handleCNAMEs(ctx context.Context, domain string, questionType DNS_Type.Type, nsResp *Message.Message, cnameChain map[string]struct{}) *Message.Message {
 	if nsResp == nil {
 		return nil
 	}
@@ -557,9 +1254,17 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 		Header:    nsResp.Header,
 		Questions: nsResp.Questions,
 	}
+	// The answer is assembled from several responses, so no single server vouches for all of it.
+	response.Header.SetAA(false)
+	size := messageBaseSize(response)
+	limit := s.cnameResponseLimit
+	if limit == 0 {
+		limit = maxMessageSize
+	}
 
+chain:
 	for _, answer := range nsResp.Answers {
-		if answer.Type != DNS_Type.CNAME || answer.GetName() != domain {
+		if answer.Type != DNS_Type.CNAME || !name.Equal(answer.GetName(), domain) {
 			continue
 		}
 
@@ -569,13 +1274,13 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 			continue
 		}
 
-		if _, ok := cnameChain[cname]; ok {
+		if _, ok := cnameChain[name.Canonicalize(cname)]; ok {
 			s.logger.Warn("Detected CNAME loop",
 				slog.String("domain", domain),
 				slog.String("cname", cname))
 			return nil
 		}
-		cnameChain[cname] = struct{}{}
+		cnameChain[name.Canonicalize(cname)] = struct{}{}
 
 		s.logger.Debug("Following CNAME",
 			slog.String("from", domain),
@@ -593,7 +1298,10 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 			s.logger.Warn("Failed to set CNAME record", slog.Any("error", err))
 			return nil
 		}
-		response.Answers = append(response.Answers, ra)
+		if !appendWithinLimit(&response.Answers, ra, &size, limit) {
+			response.Header.SetTC(true)
+			break
+		}
 
 		cnameQuery, err := Message.CreateDNSQuery(cname, questionType, DNS_Class.IN, false)
 		if err != nil {
@@ -601,7 +1309,7 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 			return nil
 		}
 
-		cnameResp, err := s.resolveRecursively(&cnameQuery)
+		cnameResp, err := s.resolveRecursively(ctx, &cnameQuery)
 		if err != nil || cnameResp == nil {
 			s.logger.Error("Failed to resolve CNAME target",
 				slog.String("cname", cname),
@@ -609,11 +1317,8 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 			return nil
 		}
 
-		if !cnameResp.IsNoErrWithMatchingID(cnameQuery.Header.GetMessageID()) {
-			s.logger.Error("Invalid CNAME response",
-				slog.Any("rcode", cnameResp.Header.GetRCODE()),
-				slog.Any("sent_id", cnameQuery.Header.GetMessageID()),
-				slog.Any("got_id", cnameResp.Header.GetMessageID()))
+		if err := Message.ValidateResponse(&cnameQuery, cnameResp); err != nil {
+			s.logger.Error("Invalid CNAME response", slog.Any("error", err))
 			return nil
 		}
 
@@ -623,7 +1328,17 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 				s.logger.Warn("Failed to deep copy Answer RR", slog.Any("error", err))
 				continue
 			}
-			response.Answers = append(response.Answers, deepCopyRR)
+			if !appendWithinLimit(&response.Answers, deepCopyRR, &size, limit) {
+				s.logger.Warn("CNAME chain response exceeds size limit, truncating",
+					slog.String("domain", domain),
+					slog.Int("limit", limit))
+				response.Header.SetTC(true)
+				break chain
+			}
+		}
+		if cnameResp.Header.IsTC() {
+			response.Header.SetTC(true)
+			break
 		}
 		for _, auth := range cnameResp.Authority {
 			deepCopyRR, err := RR.CopyRR(auth)
@@ -631,7 +1346,7 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 				s.logger.Warn("Failed to deep copy Authority RR", slog.Any("error", err))
 				continue
 			}
-			response.Authority = append(response.Authority, deepCopyRR)
+			appendWithinLimit(&response.Authority, deepCopyRR, &size, limit)
 		}
 		for _, add := range cnameResp.Additional {
 			deepCopyRR, err := RR.CopyRR(add)
@@ -639,7 +1354,7 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 				s.logger.Warn("Failed to deep copy Authority RR", slog.Any("error", err))
 				continue
 			}
-			response.Additional = append(response.Additional, deepCopyRR)
+			appendWithinLimit(&response.Additional, deepCopyRR, &size, limit)
 		}
 	}
 
@@ -662,8 +1377,32 @@ func (s *DNSServer) handleCNAMEs(domain string, questionType DNS_Type.Type, nsRe
 	return nil
 }
 
+// messageBaseSize returns the wire size of msg without any records, its header and questions.
+func messageBaseSize(msg *Message.Message) int {
+	const headerSize int = 12
+
+	size := headerSize
+	for _, q := range msg.Questions {
+		if data, err := q.MarshalBinary(); err == nil {
+			size += len(data)
+		}
+	}
+	return size
+}
+
+// appendWithinLimit appends rr to section if it still fits into limit bytes, updating the running size. The size of a
+// record is taken without name compression, which overestimates it. Records that do not fit are reported with false.
+func appendWithinLimit(section *[]RR.RR, rr RR.RR, size *int, limit int) bool {
+	if *size+rr.WireSize() > limit {
+		return false
+	}
+	*size += rr.WireSize()
+	*section = append(*section, rr)
+	return true
+}
+
 // extractAuthorityNameservers extracts NS records from the Authority section and resolves their IP addresses
-func (s *DNSServer) extractAuthorityNameservers(domain string, nsResp *Message.Message) ([]RootServer, bool) {
+func (s *DNSServer) extractAuthorityNameservers(ctx context.Context, domain string, nsResp *Message.Message) ([]RootServer, bool) {
 	if nsResp == nil {
 		return nil, false
 	}
@@ -674,15 +1413,13 @@ func (s *DNSServer) extractAuthorityNameservers(domain string, nsResp *Message.M
 			s.logger.Error("Failed to extract authority nameservers", slog.String("domain", domain))
 			return nil, false
 		}
-		for _, auth := range nsResp.Authority {
-			if auth.Type == DNS_Type.NS {
-				nsName, err := auth.GetRDATAAsNSRecord()
-				if err != nil {
-					s.logger.Warn("Failed to parse NS record", slog.Any("error", err))
-					continue
-				}
-				authority = append(authority, nsName)
+		for _, auth := range nsResp.RecordsOfType(Message.AuthoritySection, DNS_Type.NS) {
+			nsName, err := auth.GetRDATAAsNSRecord()
+			if err != nil {
+				s.logger.Warn("Failed to parse NS record", slog.Any("error", err))
+				continue
 			}
+			authority = append(authority, nsName)
 		}
 	}
 
@@ -700,20 +1437,18 @@ func (s *DNSServer) extractAuthorityNameservers(domain string, nsResp *Message.M
 			return nil, false
 		}
 
-		for _, add := range nsResp.Additional { // Glue records
-			if add.Type == DNS_Type.A {
-				for _, auth := range authority {
-					if add.GetName() == auth {
-						ip, err := add.GetRDATAAsARecord()
-						if err != nil {
-							continue
-						}
-						nameservers = append(nameservers, RootServer{
-							Name: auth,
-							IP:   ip,
-						})
-						foundGlue = true
+		for _, add := range nsResp.RecordsOfType(Message.AdditionalSection, DNS_Type.A) { // Glue records
+			for _, auth := range authority {
+				if name.Equal(add.GetName(), auth) {
+					ip, err := add.GetRDATAAsARecord()
+					if err != nil {
+						continue
 					}
+					nameservers = append(nameservers, RootServer{
+						Name: auth,
+						IP:   ip,
+					})
+					foundGlue = true
 				}
 			}
 		}
@@ -722,14 +1457,14 @@ func (s *DNSServer) extractAuthorityNameservers(domain string, nsResp *Message.M
 	if !foundGlue {
 		for _, auth := range authority {
 			// Avoid resolving the domain we're already trying to resolve (loop prevention)
-			if strings.HasSuffix(domain, auth) {
+			if name.IsSubdomain(domain, auth) {
 				s.logger.Warn("Skipping nameserver resolution to avoid loop",
 					slog.String("domain", domain),
 					slog.String("nameserver", auth))
 				continue
 			}
 
-			ips, err := s.resolveNameserverRecursively(auth)
+			ips, err := s.resolveNameserverRecursively(ctx, auth)
 			if err != nil {
 				s.logger.Debug("Failed to resolve nameserver",
 					slog.String("nameserver", auth),
@@ -750,20 +1485,20 @@ func (s *DNSServer) extractAuthorityNameservers(domain string, nsResp *Message.M
 }
 
 // resolveNameserverRecursively resolves a nameserver using recursive resolution
-func (s *DNSServer) resolveNameserverRecursively(nameserver string) ([]net.IP, error) {
+func (s *DNSServer) resolveNameserverRecursively(ctx context.Context, nameserver string) ([]net.IP, error) {
 	query, err := Message.CreateDNSQuery(nameserver, DNS_Type.A, DNS_Class.IN, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create nameserver query: %w", err)
 	}
 
-	resp, err := s.resolveRecursively(&query)
+	resp, err := s.resolveRecursively(ctx, &query)
 	if err != nil {
 		s.logger.Warn("Failed to resolve nameserver recursively", slog.Any("error", err))
-		return s.resolveNameserver(nameserver)
+		return s.resolveNameserver(ctx, nameserver)
 	}
 
-	if !resp.IsNoErrWithMatchingID(query.Header.GetMessageID()) {
-		return nil, fmt.Errorf("resolveNameserver got invalid response from forwardToResolver")
+	if err := Message.ValidateResponse(&query, resp); err != nil {
+		return nil, fmt.Errorf("resolveNameserver got invalid response from forwardToResolver: %w", err)
 	}
 
 	var ips []net.IP
@@ -772,14 +1507,12 @@ func (s *DNSServer) resolveNameserverRecursively(nameserver string) ([]net.IP, e
 			return nil, fmt.Errorf("failed to query nameserver with unexpected ANCOUNT (%d) answers, expected %d",
 				resp.Header.GetANCOUNT(), len(resp.Answers))
 		}
-		for _, answer := range resp.Answers {
-			if answer.Type == DNS_Type.A {
-				ip, err := answer.GetRDATAAsARecord()
-				if err != nil {
-					continue
-				}
-				ips = append(ips, ip)
+		for _, answer := range resp.RecordsOfType(Message.AnswerSection, DNS_Type.A) {
+			ip, err := answer.GetRDATAAsARecord()
+			if err != nil {
+				continue
 			}
+			ips = append(ips, ip)
 		}
 	}
 
@@ -791,9 +1524,9 @@ func (s *DNSServer) resolveNameserverRecursively(nameserver string) ([]net.IP, e
 }
 
 // queryNameserver sends a query to a specific nameserver and returns the response
-func (s *DNSServer) queryNameserver(serverIP net.IP, query *Message.Message) (*Message.Message, error) {
+// A truncated response is retried over UDP with EDNS if the query had none, and over TCP if that does not help.
+func (s *DNSServer) queryNameserver(ctx context.Context, serverIP net.IP, query *Message.Message) (*Message.Message, error) {
 	const maxUDPPacketSize uint16 = 512
-	const timeout = 3 * time.Second
 
 	if query == nil {
 		return nil, errors.New("query name server got nil query")
@@ -802,17 +1535,71 @@ func (s *DNSServer) queryNameserver(serverIP net.IP, query *Message.Message) (*M
 	if err != nil {
 		return nil, err
 	}
+	if s.exchanger != nil {
+		return s.exchangeNameserver(ctx, serverIP, query)
+	}
+
+	response, err := s.queryNameserverUDP(ctx, serverIP, query, maxUDPPacketSize)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Header.IsTC() {
+		return response, nil
+	}
+
+	if _, hasOPT := query.GetOPT(); !hasOPT {
+		response, err := s.queryNameserverEDNS(ctx, serverIP, query)
+		if err == nil {
+			return response, nil
+		}
+		s.logger.Debug("EDNS retry of truncated nameserver response failed, retrying over TCP",
+			slog.String("ip", serverIP.String()),
+			slog.Any("error", err))
+	}
+	return s.queryNameserverTCP(ctx, serverIP, query)
+}
+
+// queryNameserverEDNS retries query, which got a truncated response without EDNS, once over UDP advertising the
+// default EDNS payload size. Many responses fit into that, saving the TCP connection. The OPT record is removed from
+// the response, so it looks like a response to query. An error is returned if the response is still truncated.
+func (s *DNSServer) queryNameserverEDNS(ctx context.Context, serverIP net.IP, query *Message.Message) (*Message.Message, error) {
+	ednsQuery, err := Message.Copy(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy query: %w", err)
+	}
+	if err := ednsQuery.SetOPT(&Message.OPTRecord{UDPSize: defaultEDNSUDPSize}); err != nil {
+		return nil, fmt.Errorf("failed to add OPT record to query: %w", err)
+	}
+
+	response, err := s.queryNameserverUDP(ctx, serverIP, &ednsQuery, defaultEDNSUDPSize)
+	if err != nil {
+		return nil, err
+	}
+	if response.Header.IsTC() {
+		return nil, fmt.Errorf("response from nameserver %s is truncated at %d bytes", serverIP.String(), defaultEDNSUDPSize)
+	}
+	if err := response.SetOPT(nil); err != nil {
+		return nil, fmt.Errorf("failed to remove OPT record from response: %w", err)
+	}
+	return response, nil
+}
+
+// queryNameserverUDP sends query to the nameserver at serverIP over UDP, reading a response of up to bufferSize bytes.
+func (s *DNSServer) queryNameserverUDP(ctx context.Context, serverIP net.IP, query *Message.Message, bufferSize uint16) (*Message.Message, error) {
+	const timeout = 3 * time.Second
+
 	queryData, err := query.MarshalBinary()
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal query: %w", err)
 	}
 
-	serverAddr := net.UDPAddr{
-		IP:   serverIP,
-		Port: 53,
+	release, err := s.acquireOutbound(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
-	conn, err := net.DialUDP("udp", nil, &serverAddr)
+	conn, err := dialUpstream(ctx, "udp", s.nameserverAddr(serverIP), timeout)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to nameserver %s: %w", serverIP.String(), err)
 	}
@@ -820,17 +1607,12 @@ func (s *DNSServer) queryNameserver(serverIP net.IP, query *Message.Message) (*M
 		_ = conn.Close()
 	}()
 
-	err = conn.SetDeadline(time.Now().Add(timeout))
-	if err != nil {
-		return nil, fmt.Errorf("failed to set connection deadline: %w", err)
-	}
-
 	_, err = conn.Write(queryData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send query to nameserver %s: %w", serverIP.String(), err)
 	}
 
-	responseData := make([]byte, maxUDPPacketSize, maxUDPPacketSize) // nolint:gosimple
+	responseData := make([]byte, bufferSize, bufferSize) // nolint:gosimple
 	n, err := conn.Read(responseData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to receive response from nameserver %s: %w", serverIP.String(), err)
@@ -840,12 +1622,8 @@ func (s *DNSServer) queryNameserver(serverIP net.IP, query *Message.Message) (*M
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response from nameserver %s: %w", serverIP.String(), err)
 	}
-	if !response.IsNoErrWithMatchingID(query.Header.GetMessageID()) {
-		return nil, fmt.Errorf("resolveNameserver got invalid response from forwardToResolver")
+	if err := Message.ValidateResponse(query, &response); err != nil {
+		return nil, fmt.Errorf("queryNameserver got invalid response from nameserver %s: %w", serverIP.String(), err)
 	}
-	if response.Header.IsTC() {
-		return s.queryNameserverTCP(serverIP, query)
-	}
-
 	return &response, nil
 }
@@ -0,0 +1,1027 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"github.com/blazskufca/dns_server_in_go/internal/clock"
+	"github.com/blazskufca/dns_server_in_go/internal/edns"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/signing"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newUDPTestServer creates a test server forwarding to resolverHost with a bound UDP connection to answer clients on.
+func newUDPTestServer(t testing.TB, resolverHost string) *DNSServer {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	s := newTestServer(resolverHost)
+	s.udpConn = conn
+	return s
+}
+
+// runServer starts s in the background and shuts it down when the test finishes.
+func runServer(t *testing.T, s *DNSServer, cleanup func()) {
+	t.Helper()
+	stopped := make(chan struct{})
+	go func() {
+		s.Start()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cleanup()
+		<-stopped
+	})
+}
+
+// exchangeUDP hands query to handleDNSRequest as if it was received from a client and returns the response sent back.
+func exchangeUDP(t *testing.T, s *DNSServer, query Message.Message) Message.Message {
+	t.Helper()
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	s.wg.Add(1)
+	go s.handleDNSRequest(data, client.LocalAddr().(*net.UDPAddr))
+
+	if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	buf := make([]byte, 65535)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	resp, err := Message.New(buf[:n])
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return resp
+}
+
+func TestDropNonEssentialAdditional(t *testing.T) {
+	glue := RR.RR{}
+	glue.SetName("ns1.example.com")
+	glue.SetClass(DNS_Class.IN)
+	if err := glue.SetRDATAToARecord(net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+
+	opt := RR.RR{}
+	opt.SetName(".")
+	opt.SetType(DNS_Type.OPT)
+	opt.SetClass(DNS_Class.Class(1232))
+
+	msg := Message.Message{Additional: []RR.RR{glue, opt, glue}}
+	if err := msg.Header.SetARCOUNT(len(msg.Additional)); err != nil {
+		t.Fatalf("failed to set ARCOUNT: %v", err)
+	}
+
+	if err := dropNonEssentialAdditional(&msg); err != nil {
+		t.Fatalf("dropNonEssentialAdditional returned error: %v", err)
+	}
+
+	if len(msg.Additional) != 1 || msg.Additional[0].Type != DNS_Type.OPT {
+		t.Fatalf("expected only the OPT record to remain, got %v", msg.Additional)
+	}
+	if msg.Header.GetARCOUNT() != 1 {
+		t.Fatalf("expected ARCOUNT 1, got %d", msg.Header.GetARCOUNT())
+	}
+}
+
+func TestWithStripAdditional_ForwardedResponse(t *testing.T) {
+	withGlue := func(query Message.Message) Message.Message {
+		resp := answerA(t, "192.0.2.1", 300)(query)
+		glue := RR.RR{}
+		glue.SetName("ns1.example.com")
+		glue.SetClass(DNS_Class.IN)
+		if err := glue.SetRDATAToARecord(net.ParseIP("192.0.2.53")); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+		resp.Additional = append([]RR.RR{glue}, resp.Additional...)
+		if err := resp.Header.SetARCOUNT(len(resp.Additional)); err != nil {
+			t.Errorf("failed to set ARCOUNT: %v", err)
+		}
+		return resp
+	}
+	stub := startUDPStub(t, withGlue)
+
+	for _, strip := range []bool{true, false} {
+		s := newUDPTestServer(t, stub.String())
+		WithStripAdditional(strip)(s)
+
+		query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, false)
+		if err != nil {
+			t.Fatalf("failed to create query: %v", err)
+		}
+		if err := query.SetOPT(&Message.OPTRecord{UDPSize: 1232}); err != nil {
+			t.Fatalf("failed to set OPT record: %v", err)
+		}
+
+		resp := exchangeUDP(t, s, query)
+
+		if len(resp.Answers) != 1 {
+			t.Fatalf("strip=%v: expected the answer to be kept, got %d answers", strip, len(resp.Answers))
+		}
+		if _, hasOPT := resp.GetOPT(); !hasOPT {
+			t.Fatalf("strip=%v: expected the OPT record to be kept", strip)
+		}
+		wantAdditional := 2
+		if strip {
+			wantAdditional = 1
+		}
+		if len(resp.Additional) != wantAdditional || int(resp.Header.GetARCOUNT()) != wantAdditional {
+			t.Fatalf("strip=%v: expected %d Additional records, got %d with ARCOUNT %d",
+				strip, wantAdditional, len(resp.Additional), resp.Header.GetARCOUNT())
+		}
+	}
+}
+
+// queryWithEDNSOption creates an A query for name carrying a single EDNS option.
+func queryWithEDNSOption(t *testing.T, name string, option edns.Option) Message.Message {
+	t.Helper()
+	query, err := Message.CreateDNSQuery(name, DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	rdata, err := edns.PackOptions([]edns.Option{option})
+	if err != nil {
+		t.Fatalf("failed to pack EDNS options: %v", err)
+	}
+	if err := query.SetOPT(&Message.OPTRecord{UDPSize: 1232, Data: rdata}); err != nil {
+		t.Fatalf("failed to set OPT record: %v", err)
+	}
+	return query
+}
+
+// ednsOptions returns the options of the first OPT record in msg.
+func ednsOptions(t *testing.T, msg Message.Message) []edns.Option {
+	t.Helper()
+	opt, ok := msg.GetOPT()
+	if !ok {
+		return nil
+	}
+	opts, err := edns.ParseOptions(opt.Data)
+	if err != nil {
+		t.Fatalf("failed to parse EDNS options: %v", err)
+	}
+	return opts
+}
+
+func TestForward_PassesThroughUnknownEDNSOptions(t *testing.T) {
+	custom := edns.Option{Code: edns.OptionCode(65001), Data: []byte("opaque")}
+
+	seen := make(chan []edns.Option, 1)
+	stub := startUDPStub(t, func(query Message.Message) Message.Message {
+		seen <- ednsOptions(t, query)
+		return answerA(t, "192.0.2.1", 300)(query) // Echoes the OPT record of the query back
+	})
+
+	s := newUDPTestServer(t, stub.String())
+	resp := exchangeUDP(t, s, queryWithEDNSOption(t, "example.com", custom))
+
+	upstreamOpts := <-seen
+	if len(upstreamOpts) != 1 || upstreamOpts[0].Code != custom.Code || !bytes.Equal(upstreamOpts[0].Data, custom.Data) {
+		t.Fatalf("expected the upstream to receive the custom option, got %v", upstreamOpts)
+	}
+	respOpts := ednsOptions(t, resp)
+	if len(respOpts) != 1 || respOpts[0].Code != custom.Code || !bytes.Equal(respOpts[0].Data, custom.Data) {
+		t.Fatalf("expected the client to receive the custom option, got %v", respOpts)
+	}
+}
+
+func TestForward_RelaysChainOption(t *testing.T) {
+	// The closest trust point of a CHAIN query is a name in wire format, here "example.com.".
+	chain := edns.Option{Code: edns.Chain, Data: []byte("\x07example\x03com\x00")}
+
+	for _, enabled := range []bool{true, false} {
+		seen := make(chan []edns.Option, 1)
+		stub := startUDPStub(t, func(query Message.Message) Message.Message {
+			seen <- ednsOptions(t, query)
+			return answerA(t, "192.0.2.1", 300)(query) // Echoes the OPT record of the query back
+		})
+
+		s := newUDPTestServer(t, stub.String())
+		s.ednsOptions = edns.NewRegistry()
+		WithChainQueries(enabled)(s)
+		resp := exchangeUDP(t, s, queryWithEDNSOption(t, "www.example.com", chain))
+
+		wantOpts := 0
+		if enabled {
+			wantOpts = 1
+		}
+		upstreamOpts := <-seen
+		if len(upstreamOpts) != wantOpts {
+			t.Fatalf("enabled=%v: expected the upstream to receive %d options, got %v", enabled, wantOpts, upstreamOpts)
+		}
+		respOpts := ednsOptions(t, resp)
+		if len(respOpts) != wantOpts {
+			t.Fatalf("enabled=%v: expected the client to receive %d options, got %v", enabled, wantOpts, respOpts)
+		}
+		if enabled && (respOpts[0].Code != edns.Chain || !bytes.Equal(respOpts[0].Data, chain.Data)) {
+			t.Fatalf("expected the CHAIN option to survive the round trip, got %v", respOpts)
+		}
+	}
+}
+
+func TestForward_StripsEDNSOptionsByPolicy(t *testing.T) {
+	seen := make(chan []edns.Option, 1)
+	stub := startUDPStub(t, func(query Message.Message) Message.Message {
+		seen <- ednsOptions(t, query)
+		return answerA(t, "192.0.2.1", 300)(query)
+	})
+
+	s := newUDPTestServer(t, stub.String())
+	s.ednsOptions = edns.NewRegistry()
+	WithEDNSOptionPolicy(edns.Cookie, edns.Strip)(s)
+
+	exchangeUDP(t, s, queryWithEDNSOption(t, "example.com", edns.Option{Code: edns.Cookie, Data: []byte("12345678")}))
+
+	if upstreamOpts := <-seen; len(upstreamOpts) != 0 {
+		t.Fatalf("expected the cookie option to be stripped, got %v", upstreamOpts)
+	}
+}
+
+func TestForward_AppliesTTLFloor(t *testing.T) {
+	const floor = 60
+
+	stub := startUDPStub(t, answerA(t, "192.0.2.1", 5))
+	s := newUDPTestServer(t, stub.String())
+	WithTTLFloor(floor)(s)
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	if ttl := resp.Answers[0].GetTTL(); ttl != floor {
+		t.Fatalf("expected the upstream TTL of 5 to be raised to %d, got %d", floor, ttl)
+	}
+}
+
+func TestForward_RelaysNXDOMAIN(t *testing.T) {
+	nxdomain := func(query Message.Message) Message.Message {
+		resp := query
+		resp.Header.SetQRFlag(true)
+		resp.Header.SetRCODE(header.NameError)
+		return resp
+	}
+	stub := startUDPStub(t, nxdomain)
+	s := newUDPTestServer(t, stub.String())
+
+	query, err := Message.CreateDNSQuery("missing.example.com", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+
+	if resp.Header.GetRCODE() != header.NameError {
+		t.Fatalf("expected NXDOMAIN, got %s", resp.Header.GetRCODE())
+	}
+	if len(resp.Answers) != 0 {
+		t.Fatalf("expected no answers, got %d", len(resp.Answers))
+	}
+}
+
+func TestRecursion_BoundedByQueryBudget(t *testing.T) {
+	const hopDelay = 300 * time.Millisecond
+	const budget = time.Second
+
+	// Every hop is slow and refers the resolver to another nameserver, which is the same stub again.
+	slowReferral := func(query Message.Message) Message.Message {
+		time.Sleep(hopDelay)
+		resp := query
+		resp.Header.SetQRFlag(true)
+
+		ns := RR.RR{}
+		ns.SetName("test")
+		ns.SetClass(DNS_Class.IN)
+		if err := ns.SetRDATAToNSRecord("ns.slow.test"); err != nil {
+			t.Errorf("failed to set NS record: %v", err)
+		}
+		glue := RR.RR{}
+		glue.SetName("ns.slow.test")
+		glue.SetClass(DNS_Class.IN)
+		if err := glue.SetRDATAToARecord(net.IPv4(127, 0, 0, 1)); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+
+		resp.Authority = []RR.RR{ns}
+		resp.Additional = []RR.RR{glue}
+		if err := resp.Header.SetNSCOUNT(len(resp.Authority)); err != nil {
+			t.Errorf("failed to set NSCOUNT: %v", err)
+		}
+		if err := resp.Header.SetARCOUNT(len(resp.Additional)); err != nil {
+			t.Errorf("failed to set ARCOUNT: %v", err)
+		}
+		return resp
+	}
+
+	stub := startUDPStub(t, slowReferral)
+	s := newUDPTestServer(t, stub.String())
+	s.recursive = true
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "root.test", IP: net.IPv4(127, 0, 0, 1)}}
+	s.nameserverPort = stub.Port
+	WithQueryBudget(budget)(s)
+
+	query, err := Message.CreateDNSQuery("www.example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+
+	start := time.Now()
+	resp := exchangeUDP(t, s, query)
+	elapsed := time.Since(start)
+
+	if resp.Header.GetRCODE() != header.ServerFailure {
+		t.Fatalf("expected SERVFAIL once the budget is used up, got %s", resp.Header.GetRCODE())
+	}
+	if elapsed > budget+hopDelay {
+		t.Fatalf("expected the resolution to be bounded by the %v budget, took %v", budget, elapsed)
+	}
+}
+
+func TestRecursiveQueryClass(t *testing.T) {
+	var queried atomic.Int32
+	stub := startUDPStub(t, func(query Message.Message) Message.Message {
+		queried.Add(1)
+		return answerA(t, "192.0.2.1", 300)(query)
+	})
+	s := newUDPTestServer(t, stub.String())
+	s.recursive = true
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "root.test", IP: net.IPv4(127, 0, 0, 1)}}
+	s.nameserverPort = stub.Port
+	WithHost("nas.lan.example", net.IPv4(10, 0, 0, 2))(s)
+
+	for _, name := range []string{"nas.lan.example", "www.example.test", "localhost"} {
+		query, err := Message.CreateDNSQuery(name, DNS_Type.A, DNS_Class.CH, true)
+		if err != nil {
+			t.Fatalf("failed to create query: %v", err)
+		}
+
+		resp := exchangeUDP(t, s, query)
+
+		if resp.Header.GetRCODE() != header.Refused {
+			t.Fatalf("expected CH query for %s to be refused, got %s", name, resp.Header.GetRCODE())
+		}
+		if len(resp.Answers) != 0 {
+			t.Fatalf("expected no answers to CH query for %s, got %d", name, len(resp.Answers))
+		}
+	}
+	if n := queried.Load(); n != 0 {
+		t.Fatalf("expected CH queries not to reach nameservers, got %d queries", n)
+	}
+
+	query, err := Message.CreateDNSQuery("www.example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+	if resp.Header.GetRCODE() != header.NoError || len(resp.Answers) != 1 {
+		t.Fatalf("expected IN query to be answered, got %s with %d answers", resp.Header.GetRCODE(), len(resp.Answers))
+	}
+	if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("expected answer 192.0.2.1, got %v (%v)", ip, err)
+	}
+}
+
+func TestExtractAuthorityNameservers_LoopPreventionUsesLabelBoundaries(t *testing.T) {
+	stub := startUDPStub(t, answerA(t, "192.0.2.53", 300))
+	s := newUDPTestServer(t, stub.String())
+	s.recursive = true
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "root.test", IP: net.IPv4(127, 0, 0, 1)}}
+	s.nameserverPort = stub.Port
+
+	// referral delegates zone to a nameserver named example.com, without glue.
+	referral := func(zone string) *Message.Message {
+		ns := RR.RR{}
+		ns.SetName(zone)
+		ns.SetClass(DNS_Class.IN)
+		if err := ns.SetRDATAToNSRecord("example.com"); err != nil {
+			t.Fatalf("failed to set NS record: %v", err)
+		}
+		msg := &Message.Message{Authority: []RR.RR{ns}}
+		if err := msg.Header.SetNSCOUNT(len(msg.Authority)); err != nil {
+			t.Fatalf("failed to set NSCOUNT: %v", err)
+		}
+		return msg
+	}
+
+	// notexample.com merely ends with the nameserver name, resolving the nameserver can't loop back to it.
+	nameservers, ok := s.extractAuthorityNameservers(context.Background(), "notexample.com", referral("notexample.com"))
+	if !ok || len(nameservers) != 1 || !nameservers[0].IP.Equal(net.IPv4(192, 0, 2, 53)) {
+		t.Fatalf("expected the nameserver of notexample.com to be resolved, got %v", nameservers)
+	}
+
+	// www.example.com is below the nameserver name, resolving it could loop.
+	if nameservers, ok := s.extractAuthorityNameservers(context.Background(), "www.example.com", referral("example.com")); ok {
+		t.Fatalf("expected resolving the nameserver of www.example.com to be skipped, got %v", nameservers)
+	}
+}
+
+func TestRecursionACL(t *testing.T) {
+	stub := startUDPStub(t, answerA(t, "192.0.2.1", 300))
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, elsewhere, _ := net.ParseCIDR("192.0.2.0/24")
+
+	query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+
+	allowed := newUDPTestServer(t, stub.String())
+	WithRecursionACL(loopback)(allowed)
+	resp := exchangeUDP(t, allowed, query)
+	if resp.Header.GetRCODE() != header.NoError || len(resp.Answers) != 1 {
+		t.Fatalf("expected allowlisted client to be served, got %s with %d answers",
+			resp.Header.GetRCODE(), len(resp.Answers))
+	}
+
+	refused := newUDPTestServer(t, stub.String())
+	WithRecursionACL(elsewhere)(refused)
+	WithHost("nas.lan.example", net.IPv4(10, 0, 0, 2))(refused)
+	resp = exchangeUDP(t, refused, query)
+	if resp.Header.GetRCODE() != header.Refused || resp.Header.IsRA() || len(resp.Answers) != 0 {
+		t.Fatalf("expected client outside the allowlist to be refused without RA, got %s with RA %v and %d answers",
+			resp.Header.GetRCODE(), resp.Header.IsRA(), len(resp.Answers))
+	}
+
+	local, err := Message.CreateDNSQuery("nas.lan.example", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp = exchangeUDP(t, refused, local)
+	if resp.Header.GetRCODE() != header.NoError || len(resp.Answers) != 1 {
+		t.Fatalf("expected local data to be served to every client, got %s with %d answers",
+			resp.Header.GetRCODE(), len(resp.Answers))
+	}
+}
+
+func TestUnsupportedEDNSVersionGetsBADVERS(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:0")
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	if err := query.SetOPT(&Message.OPTRecord{UDPSize: 1232, Version: 1}); err != nil {
+		t.Fatalf("failed to set OPT record: %v", err)
+	}
+
+	resp := exchangeUDP(t, s, query)
+
+	if rcode := edns.ExtendedRCODE(&resp); rcode != edns.BadVersion {
+		t.Fatalf("expected extended RCODE BADVERS (%d), got %d", edns.BadVersion, rcode)
+	}
+	if version, ok := resp.EDNSVersion(); !ok || version != edns.Version {
+		t.Fatalf("expected the response to advertise EDNS version %d, got %d (OPT present: %v)", edns.Version, version, ok)
+	}
+}
+
+func TestNew_ReportsEphemeralListenerAddresses(t *testing.T) {
+	stub := startUDPStub(t, answerA(t, "192.0.2.1", 300))
+	startTCPStub(t, stub.Port, answerA(t, "192.0.2.1", 300))
+
+	s, cleanup, err := New("127.0.0.1:0", stub.String(), false, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	runServer(t, s, cleanup)
+
+	udpAddr, ok := s.UDPAddr().(*net.UDPAddr)
+	if !ok || udpAddr.Port == 0 {
+		t.Fatalf("expected a concrete UDP address, got %v", s.UDPAddr())
+	}
+	tcpAddr, ok := s.TCPAddr().(*net.TCPAddr)
+	if !ok || tcpAddr.Port == 0 {
+		t.Fatalf("expected a concrete TCP address, got %v", s.TCPAddr())
+	}
+	if tcpAddr.Port != udpAddr.Port {
+		t.Fatalf("expected UDP and TCP to share a port, got %d and %d", udpAddr.Port, tcpAddr.Port)
+	}
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		t.Fatalf("failed to dial UDP: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("failed to send query: %v", err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read UDP response: %v", err)
+	}
+	resp, err := Message.New(buf[:n])
+	if err != nil {
+		t.Fatalf("failed to unmarshal UDP response: %v", err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer over UDP, got %d", len(resp.Answers))
+	}
+
+	tcpConn, err := net.DialTCP("tcp", nil, tcpAddr)
+	if err != nil {
+		t.Fatalf("failed to dial TCP: %v", err)
+	}
+	defer func() { _ = tcpConn.Close() }()
+	if err := tcpConn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	if _, err := tcpConn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...)); err != nil {
+		t.Fatalf("failed to send TCP query: %v", err)
+	}
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(tcpConn, lenBuf); err != nil {
+		t.Fatalf("failed to read TCP response length: %v", err)
+	}
+	tcpBuf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(tcpConn, tcpBuf); err != nil {
+		t.Fatalf("failed to read TCP response: %v", err)
+	}
+	tcpResp, err := Message.New(tcpBuf)
+	if err != nil {
+		t.Fatalf("failed to unmarshal TCP response: %v", err)
+	}
+	if len(tcpResp.Answers) != 1 {
+		t.Fatalf("expected 1 answer over TCP, got %d", len(tcpResp.Answers))
+	}
+}
+
+func TestStart_ReadsQueriesLargerThan512Bytes(t *testing.T) {
+	s, cleanup, err := New("127.0.0.1:0", "127.0.0.1:1", false, slog.New(slog.DiscardHandler),
+		WithHost("host.example", net.IPv4(192, 0, 2, 1)))
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	runServer(t, s, cleanup)
+	<-s.Ready()
+
+	query := queryWithEDNSOption(t, "host.example", edns.PaddingOption(600))
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+	if len(data) <= 512 {
+		t.Fatalf("expected a query larger than 512 bytes, got %d", len(data))
+	}
+
+	conn, err := net.DialUDP("udp", nil, s.UDPAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("failed to dial UDP: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("failed to send query: %v", err)
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read UDP response: %v", err)
+	}
+	resp, err := Message.New(buf[:n])
+	if err != nil {
+		t.Fatalf("failed to unmarshal UDP response: %v", err)
+	}
+	if resp.Header.GetRCODE() != header.NoError || len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got RCODE %s and %d answers", resp.Header.GetRCODE(), len(resp.Answers))
+	}
+}
+
+func TestReady_ClosedAfterBootstrap(t *testing.T) {
+	var bootstrapped atomic.Bool
+	stub := startUDPStub(t, func(query Message.Message) Message.Message {
+		time.Sleep(100 * time.Millisecond)
+		defer bootstrapped.Store(true)
+		return answerA(t, "192.0.2.1", 300)(query)
+	})
+
+	s, cleanup, err := New("127.0.0.1:0", stub.String(), true, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	s.bootstrapAttempts = 1 // The stub cannot actually bootstrap root servers, don't wait for retries
+	runServer(t, s, cleanup)
+
+	select {
+	case <-s.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not become ready")
+	}
+	if !bootstrapped.Load() {
+		t.Fatalf("server became ready before the root server bootstrap finished")
+	}
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+	conn, err := net.DialUDP("udp", nil, s.UDPAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("failed to dial UDP: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("failed to send query: %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 512)); err != nil {
+		t.Fatalf("expected a response once ready, got %v", err)
+	}
+}
+
+func TestRecursiveServerFailureIsCached(t *testing.T) {
+	var queries atomic.Int32
+	serverFailure := func(query Message.Message) Message.Message {
+		queries.Add(1)
+		resp := query
+		resp.Header.SetQRFlag(true)
+		resp.Header.SetRCODE(header.ServerFailure)
+		return resp
+	}
+
+	stub := startUDPStub(t, serverFailure)
+	s := newUDPTestServer(t, stub.String())
+	s.recursive = true
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "root.test", IP: net.IPv4(127, 0, 0, 1)}}
+	s.nameserverPort = stub.Port
+
+	query, err := Message.CreateDNSQuery("broken.example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+
+	resp := exchangeUDP(t, s, query)
+	if resp.Header.GetRCODE() != header.ServerFailure {
+		t.Fatalf("expected SERVFAIL, got %s", resp.Header.GetRCODE())
+	}
+	resolved := queries.Load()
+	if resolved == 0 {
+		t.Fatalf("expected the first query to be resolved upstream")
+	}
+
+	resp = exchangeUDP(t, s, query)
+	if resp.Header.GetRCODE() != header.ServerFailure {
+		t.Fatalf("expected cached SERVFAIL, got %s", resp.Header.GetRCODE())
+	}
+	if got := queries.Load(); got != resolved {
+		t.Fatalf("expected the second query to be answered from the failure cache, upstream saw %d more queries",
+			got-resolved)
+	}
+}
+
+func TestMarshalUDPResponse_SignsTruncatedResponse(t *testing.T) {
+	key := []byte("truncation-test-key")
+	s := &DNSServer{signingKey: key}
+
+	resp := Message.Message{}
+	resp.Header.SetQRFlag(true)
+	for i := 1; i <= 64; i++ {
+		rr := RR.RR{}
+		rr.SetName("big.example")
+		rr.SetClass(DNS_Class.IN)
+		rr.SetRDATAToARecord(net.IPv4(192, 0, 2, byte(i)))
+		resp.Answers = append(resp.Answers, rr)
+	}
+	if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+		t.Fatalf("failed to set ANCOUNT: %v", err)
+	}
+
+	data, err := s.marshalUDPResponse(&Message.Message{}, &resp, nil, udpResponseMaxSize)
+	if err != nil {
+		t.Fatalf("marshalUDPResponse returned error: %v", err)
+	}
+	if len(data) > udpResponseMaxSize {
+		t.Fatalf("expected the signed response to fit into %d bytes, got %d", udpResponseMaxSize, len(data))
+	}
+	if resp.Header.IsTC() || len(resp.Answers) != 64 {
+		t.Fatal("expected the response passed in to be left unmodified")
+	}
+
+	truncated, err := Message.New(data)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !truncated.Header.IsTC() {
+		t.Fatal("expected a truncated response")
+	}
+	if err := signing.Verify(&truncated, key, time.Now(), time.Minute); err != nil {
+		t.Fatalf("expected a valid signature on the truncated response, got %v", err)
+	}
+}
+
+func TestResponseAdvertisesConfiguredEDNSUDPSize(t *testing.T) {
+	const advertised uint16 = 1400
+
+	stub := startUDPStub(t, answerA(t, "192.0.2.1", 300))
+	s := newUDPTestServer(t, stub.String())
+	WithEDNSUDPSize(advertised)(s)
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	if err := query.SetOPT(&Message.OPTRecord{UDPSize: 4096}); err != nil {
+		t.Fatalf("failed to set OPT record: %v", err)
+	}
+
+	resp := exchangeUDP(t, s, query)
+
+	opt, ok := resp.GetOPT()
+	if !ok {
+		t.Fatalf("expected the response to carry an OPT record")
+	}
+	if opt.UDPSize != advertised {
+		t.Fatalf("expected the response to advertise a UDP payload size of %d, got %d", advertised, opt.UDPSize)
+	}
+}
+
+func TestQuestionCountMismatch(t *testing.T) {
+	query, err := Message.CreateDNSQuery("host.example", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+	// Announce a second question which is not there.
+	data[5] = 2
+
+	tests := []struct {
+		name       string
+		bestEffort bool
+		wantRCODE  header.ResponseCode
+		wantAnswer bool
+	}{
+		{name: "reject", bestEffort: false, wantRCODE: header.FormatError},
+		{name: "best effort", bestEffort: true, wantRCODE: header.NoError, wantAnswer: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUDPTestServer(t, "127.0.0.1:1")
+			WithHost("host.example", net.IPv4(192, 0, 2, 1))(s)
+			WithBestEffortQuestions(tt.bestEffort)(s)
+
+			client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatalf("failed to listen on UDP: %v", err)
+			}
+			defer func() { _ = client.Close() }()
+
+			s.wg.Add(1)
+			go s.handleDNSRequest(data, client.LocalAddr().(*net.UDPAddr))
+
+			if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+				t.Fatalf("failed to set deadline: %v", err)
+			}
+			buf := make([]byte, 65535)
+			n, err := client.Read(buf)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			h, err := header.Unmarshal(buf[:n])
+			if err != nil {
+				t.Fatalf("failed to unmarshal response header: %v", err)
+			}
+			if h.GetRCODE() != tt.wantRCODE {
+				t.Fatalf("expected RCODE %s, got %s", tt.wantRCODE, h.GetRCODE())
+			}
+			if !tt.wantAnswer {
+				return
+			}
+			resp, err := Message.New(buf[:n])
+			if err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if len(resp.Questions) != 1 || len(resp.Answers) != 1 {
+				t.Fatalf("expected 1 question and 1 answer, got %d and %d", len(resp.Questions), len(resp.Answers))
+			}
+		})
+	}
+}
+
+func TestHeaderOnlyQueryGetsFORMERR(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:1")
+
+	query := Message.Message{}
+	query.Header.ID = [2]byte{0x12, 0x34}
+	resp := exchangeUDP(t, s, query)
+
+	if resp.Header.GetRCODE() != header.FormatError {
+		t.Fatalf("expected FORMERR, got %s", resp.Header.GetRCODE())
+	}
+	if resp.Header.ID != query.Header.ID {
+		t.Fatalf("expected ID %d to be echoed, got %d", query.Header.GetMessageID(), resp.Header.GetMessageID())
+	}
+}
+
+func TestResponsePacketIsDropped(t *testing.T) {
+	query, err := Message.CreateDNSQuery("host.example", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	query.Header.SetQRFlag(true)
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	WithHost("host.example", net.IPv4(192, 0, 2, 1))(s)
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	s.wg.Add(1)
+	s.handleDNSRequest(data, client.LocalAddr().(*net.UDPAddr))
+
+	if err := client.SetDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	if n, err := client.Read(make([]byte, 65535)); err == nil {
+		t.Fatalf("expected the packet to be dropped, got a %d byte reply", n)
+	}
+
+	if _, err := s.processDNSRequestTCP(data, client.LocalAddr()); !errors.Is(err, errResponseReceived) {
+		t.Fatalf("expected errResponseReceived over TCP, got %v", err)
+	}
+}
+
+func TestNew_WithClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	s, cleanup, err := New("127.0.0.1:0", "127.0.0.1:53", true, slog.New(slog.DiscardHandler), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	defer cleanup()
+
+	answer := RR.RR{Name: "www.example.com", Class: DNS_Class.IN, TTL: 30}
+	if err := answer.SetRDATAToARecord(net.IPv4(192, 0, 2, 1)); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	s.cache.PutRRSets(&Message.Message{Answers: []RR.RR{answer}})
+
+	if got := s.cache.GetRRSet("www.example.com", DNS_Type.A, DNS_Class.IN); len(got) != 1 {
+		t.Fatalf("expected the RRset to be cached, got %v", got)
+	}
+	clk.Advance(30 * time.Second)
+	if got := s.cache.GetRRSet("www.example.com", DNS_Type.A, DNS_Class.IN); got != nil {
+		t.Fatalf("expected the RRset to expire with the server clock, got %v", got)
+	}
+	if !s.backoff.now().Equal(clk.Now()) {
+		t.Fatalf("expected the upstream backoff to use the server clock")
+	}
+}
+
+func TestQueryNameserver_RetriesTruncatedResponseWithEDNS(t *testing.T) {
+	var plain, withEDNS atomic.Int32
+	truncateWithoutEDNS := func(query Message.Message) Message.Message {
+		if _, ok := query.GetOPT(); !ok {
+			plain.Add(1)
+			resp := query
+			resp.Header.SetQRFlag(true)
+			resp.Header.SetTC(true)
+			return resp
+		}
+		withEDNS.Add(1)
+		return answerA(t, "192.0.2.1", 300)(query)
+	}
+	// No TCP listener on the stub port, so falling back to TCP fails the query.
+	stub := startUDPStub(t, truncateWithoutEDNS)
+	s := newTestServer(stub.String())
+	s.nameserverPort = stub.Port
+
+	query, err := Message.CreateDNSQuery("www.example.test", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp, err := s.queryNameserver(context.Background(), net.IPv4(127, 0, 0, 1), &query)
+	if err != nil {
+		t.Fatalf("queryNameserver returned error: %v", err)
+	}
+
+	if resp.Header.IsTC() || len(resp.Answers) != 1 {
+		t.Fatalf("expected the complete answer, got TC %v and %d answers", resp.Header.IsTC(), len(resp.Answers))
+	}
+	if _, ok := resp.GetOPT(); ok || resp.Header.GetARCOUNT() != 0 {
+		t.Errorf("expected the OPT record of the retry to be removed from the response")
+	}
+	if plain.Load() != 1 || withEDNS.Load() != 1 {
+		t.Fatalf("expected one plain query and one EDNS retry, got %d and %d", plain.Load(), withEDNS.Load())
+	}
+}
+
+// BenchmarkHandleDNSRequest measures a UDP query from parsing to sending the response, with upstream and nameserver
+// queries answered in memory.
+func BenchmarkHandleDNSRequest(b *testing.B) {
+	authoritative := func(query Message.Message) Message.Message {
+		resp := answerA(b, "192.0.2.1", 3600)(query)
+		resp.Header.SetAA(true)
+		return resp
+	}
+	benchmarks := []struct {
+		name      string
+		recursive bool
+	}{
+		{name: "forward", recursive: false},
+		{name: "recursive cached", recursive: true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			s := newUDPTestServer(b, "192.0.2.53:53")
+			s.recursive = bm.recursive
+			s.cache = cache.NewDNSCache(s.logger)
+			s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+			WithExchanger(&scriptedExchanger{servers: map[string]stubHandler{
+				"192.0.2.53": authoritative,
+				"198.41.0.4": authoritative,
+			}})(s)
+
+			client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatalf("failed to listen on UDP: %v", err)
+			}
+			defer func() { _ = client.Close() }()
+			query, err := Message.CreateDNSQuery("www.example.test", DNS_Type.A, DNS_Class.IN, true)
+			if err != nil {
+				b.Fatalf("failed to create query: %v", err)
+			}
+			data, err := query.MarshalBinary()
+			if err != nil {
+				b.Fatalf("failed to marshal query: %v", err)
+			}
+
+			buf := make([]byte, 65535)
+			b.ReportAllocs()
+			for b.Loop() {
+				s.wg.Add(1)
+				s.handleDNSRequest(data, client.LocalAddr().(*net.UDPAddr))
+				n, err := client.Read(buf)
+				if err != nil {
+					b.Fatalf("failed to read response: %v", err)
+				}
+				if resp, err := Message.New(buf[:n]); err != nil || len(resp.Answers) != 1 {
+					b.Fatalf("expected one answer, got %v (%v)", resp.Answers, err)
+				}
+			}
+		})
+	}
+}
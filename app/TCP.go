@@ -1,11 +1,13 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/edns"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
 	"github.com/blazskufca/dns_server_in_go/internal/utils"
-	"io"
 	"log/slog"
 	"math"
 	"net"
@@ -13,11 +15,14 @@ import (
 )
 
 // startTCPServer starts a TCP server on which a client usually calls if DNS Message is truncated.
+// The caller must add the server to the wait group. It returns once the listener is closed.
 func (s *DNSServer) startTCPServer() {
-	s.wg.Add(1)
 	defer s.wg.Done()
 	for {
 		conn, err := s.tcpListener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			s.logger.Error("failed to accept TCP connection", slog.Any("error", err))
 			continue
@@ -36,7 +41,6 @@ func (s *DNSServer) handleTCPConnection(conn net.Conn) {
 	}()
 	defer s.wg.Done()
 
-	const lenPrefix uint8 = 2
 	const timeout = 5 * time.Second
 
 	err := conn.SetDeadline(time.Now().Add(timeout))
@@ -45,65 +49,76 @@ func (s *DNSServer) handleTCPConnection(conn net.Conn) {
 		return
 	}
 
-	lenBuf := make([]byte, lenPrefix, lenPrefix) //nolint:gosimple
-	_, err = io.ReadFull(conn, lenBuf)
-	if err != nil {
-		s.logger.Error("failed to read message length", slog.Any("error", err))
-		return
-	}
-
-	msgLen := binary.BigEndian.Uint16(lenBuf)
-	if msgLen == 0 {
-		s.logger.Error("received empty message or message length is missing", slog.Any("message_len", msgLen))
-		return
-	}
-
-	msgBuf := make([]byte, msgLen, msgLen) //nolint:gosimple
-	_, err = io.ReadFull(conn, msgBuf)
+	msgBuf, err := Message.ReadFrame(conn)
 	if err != nil {
 		s.logger.Error("failed to read message", slog.Any("error", err))
 		return
 	}
 
-	response, err := s.processDNSRequestTCP(msgBuf)
+	response, err := s.processDNSRequestTCP(msgBuf, conn.RemoteAddr())
 	if err != nil {
 		s.logger.Error("failed to process TCP DNS request", slog.Any("error", err))
 		return
 	}
 
 	if utils.WouldOverflowUint16(len(response)) {
-		s.logger.Error("response too large", slog.Any("response_size", len(response)),
+		s.logger.Warn("response too large for TCP framing, truncating", slog.Any("response_size", len(response)),
 			slog.Any("uint16_max", math.MaxUint16))
+		response, err = truncateResponse(response, math.MaxUint16)
+		if err != nil {
+			s.logger.Error("failed to truncate TCP response", slog.Any("error", err))
+			return
+		}
+		s.truncatedResponses.Add(1)
+	}
+	if _, err := Message.WriteFrame(conn, response); err != nil {
+		s.logger.Error("failed to write TCP response", slog.Any("error", err))
 		return
 	}
-	lenBytes := make([]byte, lenPrefix, lenPrefix) //nolint:gosimple
-	binary.BigEndian.PutUint16(lenBytes, uint16(len(response)))
+}
 
-	_, err = conn.Write(append(lenBytes, response...))
+// truncateResponse trims the marshalled response to at most limit bytes and sets the TC flag. Additional records are
+// dropped first, then Authority records and finally Answers from the end, so the client keeps as much of the answer
+// as fits.
+func truncateResponse(response []byte, limit int) ([]byte, error) {
+	msg, err := Message.New(response)
 	if err != nil {
-		s.logger.Error("failed to write TCP response", slog.Any("error", err))
-		return
+		return nil, fmt.Errorf("failed to unmarshal response for truncation: %w", err)
+	}
+
+	if response, err = msg.MarshalBinaryWithLimit(limit); err != nil {
+		return nil, fmt.Errorf("failed to marshal truncated response: %w", err)
 	}
+	return response, nil
 }
 
 // processDNSRequestTCP takes care of incoming DNS request on TCP connection
-func (s *DNSServer) processDNSRequestTCP(data []byte) ([]byte, error) {
+func (s *DNSServer) processDNSRequestTCP(data []byte, from net.Addr) ([]byte, error) {
 	const firstQuestion uint8 = 0
 	const theRestOfQuestions uint8 = 1
 
-	msg, err := Message.New(data)
+	if isResponsePacket(data) {
+		return nil, errResponseReceived
+	}
+
+	msg, err := s.parseQuery(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal DNS request: %w", err)
 	}
 
-	s.logger.Debug("Received TCP DNS query",
-		slog.String("question", msg.Questions[firstQuestion].Name),
-		slog.Any("type", msg.Questions[firstQuestion].Type))
+	if msg.Header.GetOpcode() == header.Update {
+		return s.processUpdateTCP(data, &msg, from)
+	}
 
 	if len(msg.Questions) == 0 {
-		return nil, fmt.Errorf("DNS request contains no questions")
+		s.logger.Error("TCP DNS request contains no questions", slog.Any("from", from.String()))
+		return Message.MakeError(&msg, header.FormatError).MarshalBinary()
 	}
 
+	s.logger.Debug("Received TCP DNS query",
+		slog.String("question", msg.Questions[firstQuestion].Name),
+		slog.Any("type", msg.Questions[firstQuestion].Type))
+
 	if len(msg.Questions) > 1 {
 		s.logger.Warn("Multiple questions in TCP request, only processing the first one",
 			slog.Int("question_count", len(msg.Questions)))
@@ -114,109 +129,215 @@ func (s *DNSServer) processDNSRequestTCP(data []byte) ([]byte, error) {
 			s.logger.Error("Failed to update question count", slog.Any("error", err))
 		}
 	}
+	s.queryTypes.add(msg.Questions[firstQuestion].Type)
+
+	if response, ok := badVersionResponse(&msg, s.advertisedUDPSize()); ok {
+		s.logger.Warn("Unsupported EDNS version in TCP request", slog.Any("from", from.String()))
+		return s.marshalTCPResponse(&msg, response, nil)
+	}
+
+	cookie, err := s.responseCookie(&msg, from)
+	if errors.Is(err, errBadServerCookie) {
+		s.logger.Debug("TCP client sent an invalid server cookie", slog.Any("from", from.String()))
+		s.badCookies.Add(1)
+		response, err := badCookieResponse(&msg, s.advertisedUDPSize())
+		if err != nil {
+			return nil, err
+		}
+		return s.marshalTCPResponse(&msg, response, cookie)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed EDNS cookie: %w", err)
+	}
+
+	if s.specialNames {
+		if response, ok := specialNameResponse(&msg); ok {
+			if err := s.addNegativeSOA(response); err != nil {
+				return nil, err
+			}
+			return s.marshalTCPResponse(&msg, response, cookie)
+		}
+	}
+
+	if response, ok := s.localRootResponse(&msg); ok {
+		return s.marshalTCPResponse(&msg, response, cookie)
+	}
+
+	if response, ok := s.hostsResponse(&msg); ok {
+		return s.marshalTCPResponse(&msg, response, cookie)
+	}
+
+	if response, ok := s.staticResponse(&msg); ok {
+		return s.marshalTCPResponse(&msg, response, cookie)
+	}
+
+	if s.zone != nil {
+		if response, ok := s.zone.Answer(&msg); ok {
+			return s.marshalTCPResponse(&msg, response, cookie)
+		}
+	}
+
+	if !s.recursionAllowedFrom(from) {
+		s.logger.Warn("Refused recursion to TCP client outside the allowlist", slog.Any("from", from.String()))
+		response, err := refusedResponse(&msg)
+		if err != nil {
+			return nil, err
+		}
+		response.Header.SetRA(false)
+		return s.marshalTCPResponse(&msg, response, cookie)
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
 
 	if msg.Header.IsRD() && s.recursive {
-		response, err := s.resolveRecursively(&msg)
+		response, err := s.resolveRecursively(ctx, &msg)
 		if err != nil {
 			return nil, fmt.Errorf("recursive resolution failed: %w", err)
 		}
 		response.Header.SetTC(false)
-		return response.MarshalBinary()
+		response, err = s.applyResponsePolicy(response)
+		if err != nil {
+			return nil, err
+		}
+		response, err = s.filterAddressFamilies(response, from)
+		if err != nil {
+			return nil, err
+		}
+		response, err = s.stripAdditional(response)
+		if err != nil {
+			return nil, err
+		}
+		return s.marshalTCPResponse(&msg, response, cookie)
 	} else {
 		msg.Header.SetQRFlag(false)
+		if err := s.ednsOptions.Apply(&msg); err != nil {
+			return nil, fmt.Errorf("error filtering EDNS options: %w", err)
+		}
 		queryData, err := msg.MarshalBinary()
 		if err != nil {
 			return nil, fmt.Errorf("error marshalling query: %w", err)
 		}
 
-		msgData, err := s.forwardToResolverTCP(queryData)
+		msgData, err := s.forwardToResolverTCP(ctx, queryData)
 		if err != nil {
 			return nil, fmt.Errorf("error forwarding question via TCP: %w", err)
 		}
 		if msgData == nil {
 			return nil, fmt.Errorf("error forwarding question via TCP: message is nil")
 		}
-		if !msg.IsNoErrWithMatchingID(msgData.Header.GetMessageID()) {
-			return nil, fmt.Errorf("error forwarding question via TCP: message is not a valid response")
-		}
 		msgData.Header.SetTC(false)
-		return msgData.MarshalBinary()
+		if err := s.ednsOptions.Apply(msgData); err != nil {
+			return nil, fmt.Errorf("error filtering EDNS options of forwarded response: %w", err)
+		}
+		s.applyTTLFloor(msgData)
+		msgData, err = s.applyResponsePolicy(msgData)
+		if err != nil {
+			return nil, err
+		}
+		msgData, err = s.filterAddressFamilies(msgData, from)
+		if err != nil {
+			return nil, err
+		}
+		msgData, err = s.stripAdditional(msgData)
+		if err != nil {
+			return nil, err
+		}
+		return s.marshalTCPResponse(&msg, msgData, cookie)
+	}
+}
+
+// marshalTCPResponse finishes resp to query with cookie, see finishResponse, then signs and marshals it for a TCP
+// client, the TCP counterpart of sendResponse.
+func (s *DNSServer) marshalTCPResponse(query, resp *Message.Message, cookie *edns.Option) ([]byte, error) {
+	resp, err := s.finishResponse(query, resp, cookie)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = s.signResponse(resp)
+	if err != nil {
+		return nil, err
 	}
+	return resp.MarshalBinary()
 }
 
 // forwardToResolverTCP sends a DNS Message to upstream resolver via a TCP connection.
 // As with reading from TCP socket, DNS messages are prefixed with uint16 message length
-func (s *DNSServer) forwardToResolverTCP(query []byte) (*Message.Message, error) {
-	const timeout time.Duration = time.Second * 5
-	const lengthPrefixBytes uint8 = 2
-
-	conn, err := net.DialTimeout("tcp", s.resolverHost, timeout)
+// If the upstream resolves to both IPv6 and IPv4 addresses the two are raced and the first valid response wins, a
+// response which fails Message.ValidateResponse counts as a failed attempt.
+func (s *DNSServer) forwardToResolverTCP(ctx context.Context, query []byte) (*Message.Message, error) {
+	queryMsg, err := Message.New(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to resolver via TCP: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal forwarded query: %w", err)
 	}
-	defer func() {
-		_ = conn.Close()
-	}()
-
-	err = conn.SetDeadline(time.Now().Add(timeout))
+	addrs, err := s.availableUpstreamAddrs()
 	if err != nil {
-		return nil, fmt.Errorf("failed to set connection deadline: %w", err)
+		return nil, err
 	}
+	return raceUpstreams(ctx, s.latency.sortAddrs(addrs),
+		func(ctx context.Context, addr string) (*Message.Message, error) {
+			start := time.Now()
+			msg, err := s.forwardToResolverTCPAddr(ctx, addr, query)
+			if err == nil {
+				err = Message.ValidateResponse(&queryMsg, msg)
+			}
+			s.observeUpstream(ctx, addr, start, err)
+			if err != nil {
+				return nil, err
+			}
+			return msg, nil
+		})
+}
 
-	lenBuf := make([]byte, lengthPrefixBytes, lengthPrefixBytes) //nolint:gosimple
-	queryLen := len(query)
+// forwardToResolverTCPAddr sends a DNS Message to a single upstream resolver address via a TCP connection.
+func (s *DNSServer) forwardToResolverTCPAddr(ctx context.Context, addr string, query []byte) (*Message.Message, error) {
+	const timeout time.Duration = time.Second * 5
 
-	if utils.WouldOverflowUint32(queryLen) {
-		return nil, fmt.Errorf("query length overflow")
+	if s.exchanger != nil {
+		return s.exchangeUpstream(ctx, addr, query)
 	}
 
-	binary.BigEndian.PutUint16(lenBuf, uint16(queryLen))
-
-	_, err = conn.Write(append(lenBuf, query...))
+	release, err := s.acquireOutbound(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send query to resolver via TCP: %w", err)
+		return nil, err
 	}
+	defer release()
 
-	lenBuf = make([]byte, lengthPrefixBytes)
-	_, err = io.ReadFull(conn, lenBuf)
+	conn, err := dialUpstream(ctx, "tcp", addr, timeout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response length from resolver: %w", err)
+		return nil, fmt.Errorf("failed to connect to resolver via TCP: %w", err)
 	}
-	responseLen := binary.BigEndian.Uint16(lenBuf)
-	response := make([]byte, responseLen)
-	_, err = io.ReadFull(conn, response)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response from resolver: %w", err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err := Message.WriteFrame(conn, query); err != nil {
+		return nil, fmt.Errorf("failed to send query to resolver via TCP: %w", err)
 	}
+
 	responseMsg := Message.Message{}
-	err = responseMsg.UnmarshalBinary(response)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response from resolver: %w", err)
+	if _, err := responseMsg.ReadFrom(conn); err != nil {
+		return nil, fmt.Errorf("failed to read response from resolver: %w", err)
 	}
 
 	return &responseMsg, nil
 }
 
 // queryNameserverTCP sends a query to a specific nameserver using TCP and returns the response
-func (s *DNSServer) queryNameserverTCP(serverIP net.IP, query *Message.Message) (*Message.Message, error) {
+func (s *DNSServer) queryNameserverTCP(ctx context.Context, serverIP net.IP, query *Message.Message) (*Message.Message, error) {
 	const timeout time.Duration = time.Second * 5
-	const standardUDPServerPort = 53
-	const lengthPrefixBytes uint8 = 2
 
 	if query == nil {
 		return nil, fmt.Errorf("queryNameServerTCP got nil query")
 	}
-	queryData, err := query.MarshalBinary()
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal TCP query: %w", err)
-	}
 
-	serverAddr := net.TCPAddr{
-		IP:   serverIP,
-		Port: standardUDPServerPort,
+	release, err := s.acquireOutbound(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
-	conn, err := net.DialTCP("tcp", nil, &serverAddr)
+	conn, err := dialUpstream(ctx, "tcp", s.nameserverAddr(serverIP), timeout)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to nameserver %s via TCP: %w", serverIP.String(), err)
 	}
@@ -224,42 +345,16 @@ func (s *DNSServer) queryNameserverTCP(serverIP net.IP, query *Message.Message)
 		_ = conn.Close()
 	}()
 
-	err = conn.SetDeadline(time.Now().Add(timeout))
-	if err != nil {
-		return nil, fmt.Errorf("failed to set TCP connection deadline: %w", err)
-	}
-
-	lenBuf := make([]byte, lengthPrefixBytes, lengthPrefixBytes) //nolint:gosimple
-	binary.BigEndian.PutUint16(lenBuf, uint16(len(queryData)))
-
-	_, err = conn.Write(append(lenBuf, queryData...))
-	if err != nil {
+	if _, err := query.WriteTo(conn); err != nil {
 		return nil, fmt.Errorf("failed to send TCP query to nameserver %s: %w", serverIP.String(), err)
 	}
 
-	respLenBuf := make([]byte, lengthPrefixBytes, lengthPrefixBytes) //nolint:gosimple
-	_, err = io.ReadFull(conn, respLenBuf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read TCP response length from nameserver %s: %w", serverIP.String(), err)
-	}
-
-	respLen := binary.BigEndian.Uint16(respLenBuf)
-	if respLen == 0 {
-		return nil, fmt.Errorf("received empty TCP response from nameserver %s", serverIP.String())
-	}
-
-	responseData := make([]byte, respLen)
-	_, err = io.ReadFull(conn, responseData)
-	if err != nil {
+	response := Message.Message{}
+	if _, err := response.ReadFrom(conn); err != nil {
 		return nil, fmt.Errorf("failed to read TCP response from nameserver %s: %w", serverIP.String(), err)
 	}
-
-	response, err := Message.New(responseData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal TCP response from nameserver %s: %w", serverIP.String(), err)
-	}
-	if !response.IsNoErrWithMatchingID(query.Header.GetMessageID()) {
-		return nil, fmt.Errorf("resolveNameserver got invalid response from nameserver")
+	if err := Message.ValidateResponse(query, &response); err != nil {
+		return nil, fmt.Errorf("queryNameserverTCP got invalid response from nameserver %s: %w", serverIP.String(), err)
 	}
 	return &response, nil
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/zone"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exchangeTCP hands query to handleTCPConnection over an in-memory connection and returns the response.
+func exchangeTCP(t *testing.T, s *DNSServer, query Message.Message) Message.Message {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	s.wg.Add(1)
+	go s.handleTCPConnection(server)
+
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+	if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	if _, err := client.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...)); err != nil {
+		t.Fatalf("failed to send query: %v", err)
+	}
+
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(client, lenBuf); err != nil {
+		t.Fatalf("failed to read response length: %v", err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	resp, err := Message.New(buf)
+	if err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return resp
+}
+
+func TestTCP_TruncatesResponsesExceedingFraming(t *testing.T) {
+	const records = 300
+
+	z := zone.New("example.com")
+	for i := range records {
+		rr := RR.RR{}
+		rr.SetName("big.example.com")
+		rr.SetClass(DNS_Class.IN)
+		if err := rr.SetTTL(300); err != nil {
+			t.Fatalf("failed to set TTL: %v", err)
+		}
+		rr.SetRDATAToTXTRecord(strings.Repeat(string(rune('a'+i%26)), 200) + strings.Repeat("x", i%50))
+		if err := z.Add(rr); err != nil {
+			t.Fatalf("failed to add record: %v", err)
+		}
+	}
+
+	s := newTestServer("127.0.0.1:0")
+	WithZone(z)(s)
+
+	query, err := Message.CreateDNSQuery("big.example.com", DNS_Type.TXT, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeTCP(t, s, query)
+
+	if !resp.Header.IsTC() {
+		t.Fatalf("expected the TC flag on the trimmed response")
+	}
+	if len(resp.Answers) == 0 || len(resp.Answers) >= records {
+		t.Fatalf("expected a trimmed, non-empty answer section, got %d answers", len(resp.Answers))
+	}
+	if int(resp.Header.GetANCOUNT()) != len(resp.Answers) {
+		t.Fatalf("ANCOUNT %d does not match %d answers", resp.Header.GetANCOUNT(), len(resp.Answers))
+	}
+}
+
+func TestTCP_HeaderOnlyQueryGetsFORMERR(t *testing.T) {
+	s := newTestServer("127.0.0.1:0")
+
+	query := Message.Message{}
+	query.Header.ID = [2]byte{0x12, 0x34}
+	resp := exchangeTCP(t, s, query)
+
+	if resp.Header.GetRCODE() != header.FormatError {
+		t.Fatalf("expected FORMERR, got %s", resp.Header.GetRCODE())
+	}
+	if resp.Header.ID != query.Header.ID {
+		t.Fatalf("expected ID %d to be echoed, got %d", query.Header.GetMessageID(), resp.Header.GetMessageID())
+	}
+}
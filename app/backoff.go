@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// upstreamBackoffThreshold is the number of consecutive failures after which an upstream is skipped.
+	upstreamBackoffThreshold = 3
+	// upstreamBackoffBase is the backoff window after reaching the threshold, it doubles with every further failure.
+	upstreamBackoffBase = time.Second
+	// upstreamBackoffMax caps the backoff window.
+	upstreamBackoffMax = time.Minute
+)
+
+// errUpstreamsBackingOff is returned when every upstream address is skipped because of recent failures.
+var errUpstreamsBackingOff = errors.New("all upstream resolvers are backing off after repeated failures")
+
+// upstreamState is the failure history of a single upstream address.
+type upstreamState struct {
+	failures int
+	until    time.Time
+}
+
+// upstreamBackoff skips upstream resolvers which failed repeatedly, keyed by "host:port" address. After
+// upstreamBackoffThreshold consecutive failures an upstream is skipped for a jittered, exponentially growing window,
+// after which it gets another try. A success clears its history. A nil *upstreamBackoff never skips anything.
+type upstreamBackoff struct {
+	state map[string]*upstreamState
+	now   func() time.Time
+	mu    sync.Mutex
+}
+
+func newUpstreamBackoff() *upstreamBackoff {
+	return &upstreamBackoff{state: make(map[string]*upstreamState), now: time.Now}
+}
+
+// available returns the addresses which are not backing off, keeping their order.
+func (b *upstreamBackoff) available(addrs []string) ([]string, error) {
+	if b == nil {
+		return addrs, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	available := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if st, ok := b.state[addr]; ok && now.Before(st.until) {
+			continue
+		}
+		available = append(available, addr)
+	}
+	if len(available) == 0 {
+		return nil, errUpstreamsBackingOff
+	}
+	return available, nil
+}
+
+// observe records the outcome of a query to addr.
+func (b *upstreamBackoff) observe(addr string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		delete(b.state, addr)
+		return
+	}
+
+	st, ok := b.state[addr]
+	if !ok {
+		st = &upstreamState{}
+		b.state[addr] = st
+	}
+	st.failures++
+	if st.failures < upstreamBackoffThreshold {
+		return
+	}
+
+	window := upstreamBackoffMax
+	if shift := st.failures - upstreamBackoffThreshold; shift < 6 {
+		window = min(upstreamBackoffBase<<shift, upstreamBackoffMax)
+	}
+	// Jitter the window between half and all of it, so that upstreams failing together don't come back together.
+	window = window/2 + rand.N(window/2) //nolint:gosec
+	st.until = b.now().Add(window)
+}
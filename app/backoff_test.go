@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUpstreamBackoff_SkipsFailingUpstream(t *testing.T) {
+	// Queries to a closed UDP port fail right away with "connection refused".
+	closed, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	addr := closed.LocalAddr().(*net.UDPAddr)
+	_ = closed.Close()
+
+	now := time.Now()
+	s := newTestServer(addr.String())
+	s.backoff = newUpstreamBackoff()
+	s.backoff.now = func() time.Time { return now }
+
+	query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	for i := 0; i < upstreamBackoffThreshold; i++ {
+		if _, err := s.forwardToResolver(context.Background(), data); err == nil || errors.Is(err, errUpstreamsBackingOff) {
+			t.Fatalf("attempt %d: expected the upstream to be queried and fail, got %v", i+1, err)
+		}
+	}
+
+	// The upstream comes back, but is skipped until its backoff window passes.
+	startUDPStubAt(t, addr, answerA(t, "192.0.2.1", 300))
+	if _, err := s.forwardToResolver(context.Background(), data); !errors.Is(err, errUpstreamsBackingOff) {
+		t.Fatalf("expected the upstream to be skipped during the backoff window, got %v", err)
+	}
+
+	now = now.Add(upstreamBackoffBase)
+	resp, err := s.forwardToResolver(context.Background(), data)
+	if err != nil {
+		t.Fatalf("expected the upstream to be retried after the backoff window, got %v", err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	if available, err := s.backoff.available([]string{addr.String()}); err != nil || len(available) != 1 {
+		t.Fatalf("expected a success to clear the backoff, got %v, %v", available, err)
+	}
+}
+
+func TestUpstreamBackoff_WindowGrowsWithFailures(t *testing.T) {
+	now := time.Now()
+	b := newUpstreamBackoff()
+	b.now = func() time.Time { return now }
+	failure := errors.New("timeout")
+
+	for i := 0; i < upstreamBackoffThreshold-1; i++ {
+		b.observe("192.0.2.1:53", failure)
+	}
+	if _, err := b.available([]string{"192.0.2.1:53"}); err != nil {
+		t.Fatalf("expected the upstream to be used below the failure threshold, got %v", err)
+	}
+
+	var previous time.Duration
+	for i := 0; i < 10; i++ {
+		b.observe("192.0.2.1:53", failure)
+		window := b.state["192.0.2.1:53"].until.Sub(now)
+		if window > upstreamBackoffMax {
+			t.Fatalf("expected the window to be capped at %v, got %v", upstreamBackoffMax, window)
+		}
+		if i < 5 && window < previous {
+			t.Fatalf("expected the window to grow, got %v after %v", window, previous)
+		}
+		previous = window
+	}
+
+	available, err := b.available([]string{"192.0.2.1:53", "192.0.2.2:53"})
+	if err != nil || len(available) != 1 || available[0] != "192.0.2.2:53" {
+		t.Fatalf("expected only the healthy upstream to be available, got %v, %v", available, err)
+	}
+}
+
+func TestUpstreamBackoff_CountsQueryBudgetTimeouts(t *testing.T) {
+	// A blackholed upstream reads queries but never answers, so every query runs out of the query budget.
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	t.Cleanup(func() { _ = silent.Close() })
+
+	now := time.Now()
+	s := newTestServer(silent.LocalAddr().String())
+	s.queryBudget = 100 * time.Millisecond
+	s.backoff = newUpstreamBackoff()
+	s.backoff.now = func() time.Time { return now }
+
+	query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	for i := 0; i < upstreamBackoffThreshold; i++ {
+		ctx, cancel := s.queryContext()
+		_, err := s.forwardToResolver(ctx, data)
+		cancel()
+		if err == nil || errors.Is(err, errUpstreamsBackingOff) {
+			t.Fatalf("attempt %d: expected the upstream to be queried and time out, got %v", i+1, err)
+		}
+	}
+
+	ctx, cancel := s.queryContext()
+	defer cancel()
+	if _, err := s.forwardToResolver(ctx, data); !errors.Is(err, errUpstreamsBackingOff) {
+		t.Fatalf("expected the silent upstream to back off, got %v", err)
+	}
+}
+
+func TestObserveUpstream_IgnoresCancelledQueries(t *testing.T) {
+	s := newTestServer("192.0.2.53:53")
+	s.backoff = newUpstreamBackoff()
+	s.latency = newLatencyTracker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < upstreamBackoffThreshold; i++ {
+		s.observeUpstream(ctx, "192.0.2.53:53", time.Now(), ctx.Err())
+	}
+
+	if latency := s.latency.latency("192.0.2.53:53"); latency != 0 {
+		t.Fatalf("expected no latency sample for cancelled queries, got %v", latency)
+	}
+	if available, err := s.backoff.available([]string{"192.0.2.53:53"}); err != nil || len(available) != 1 {
+		t.Fatalf("expected cancelled queries not to back off the upstream, got %v, %v", available, err)
+	}
+}
+
+func TestUpstreamBackoff_SkipsSilentAddressFamily(t *testing.T) {
+	// The IPv6 address is tried first and never answers, the IPv4 address outpaces it on every query.
+	v4 := startUDPStub(t, answerA(t, "192.0.2.1", 300))
+	silent, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback, Port: v4.Port})
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	t.Cleanup(func() { _ = silent.Close() })
+
+	now := time.Now()
+	s := newTestServer(net.JoinHostPort("dual.test", strconv.Itoa(v4.Port)))
+	s.lookupIPAddr = dualStackLookup
+	s.backoff = newUpstreamBackoff()
+	s.backoff.now = func() time.Time { return now }
+
+	query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	for i := 0; i < upstreamBackoffThreshold; i++ {
+		if _, err := s.forwardToResolver(context.Background(), data); err != nil {
+			t.Fatalf("attempt %d: expected the IPv4 address to answer, got %v", i+1, err)
+		}
+	}
+
+	// The outpaced attempts are observed once they return, after the race is over.
+	v6Addr := net.JoinHostPort(net.IPv6loopback.String(), strconv.Itoa(v4.Port))
+	deadline := time.Now().Add(time.Second)
+	for {
+		available, err := s.backoff.available([]string{v6Addr, v4.String()})
+		if err == nil && len(available) == 1 && available[0] == v4.String() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the silent IPv6 address to back off, got %v, %v", available, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if _, err := s.forwardToResolver(context.Background(), data); err != nil {
+		t.Fatalf("expected the IPv4 address to answer, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= happyEyeballsDelay {
+		t.Fatalf("expected the IPv4 address to be queried right away, took %v", elapsed)
+	}
+}
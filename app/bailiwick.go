@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+)
+
+// inBailiwick reports whether owner is at or below zone, comparing case-insensitively. Every name is inside the root
+// zone.
+func inBailiwick(owner, zone string) bool {
+	return name.IsSubdomain(owner, zone)
+}
+
+// delegationZone returns the zone a referral delegates to, the owner of its first NS record in the Authority section.
+func delegationZone(msg *Message.Message) (string, bool) {
+	for _, auth := range msg.Authority {
+		if auth.Type == DNS_Type.NS {
+			return auth.GetName(), true
+		}
+	}
+	return "", false
+}
+
+// discardOutOfBailiwick removes every record whose owner is outside zone, the zone the nameserver which sent msg is
+// authoritative for, so that a nameserver cannot poison the cache with data for names it is not responsible for.
+// The OPT pseudo record is kept. It returns the number of discarded records.
+func discardOutOfBailiwick(msg *Message.Message, zone string) (int, error) {
+	discarded := 0
+	keep := func(records []RR.RR) []RR.RR {
+		var kept []RR.RR
+		for _, record := range records {
+			if record.Type == DNS_Type.OPT || inBailiwick(record.GetName(), zone) {
+				kept = append(kept, record)
+				continue
+			}
+			discarded++
+		}
+		return kept
+	}
+
+	msg.Answers = keep(msg.Answers)
+	msg.Authority = keep(msg.Authority)
+	msg.Additional = keep(msg.Additional)
+	if discarded == 0 {
+		return 0, nil
+	}
+
+	if err := msg.Header.SetANCOUNT(len(msg.Answers)); err != nil {
+		return discarded, err
+	}
+	if err := msg.Header.SetNSCOUNT(len(msg.Authority)); err != nil {
+		return discarded, err
+	}
+	return discarded, msg.Header.SetARCOUNT(len(msg.Additional))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"net"
+	"testing"
+)
+
+func TestInBailiwick(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		want bool
+	}{
+		{"www.example.com", "example.com", true},
+		{"example.com", "Example.COM.", true},
+		{"www.example.org", "example.com", false},
+		{"badexample.com", "example.com", false},
+		{"www.example.org", ".", true},
+	}
+	for _, tt := range tests {
+		if got := inBailiwick(tt.name, tt.zone); got != tt.want {
+			t.Errorf("inBailiwick(%q, %q) = %v, want %v", tt.name, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestRecursion_DiscardsOutOfBailiwickRecords(t *testing.T) {
+	poisoning := func(query Message.Message) Message.Message {
+		resp := answerA(t, "192.0.2.1", 300)(query)
+		resp.Header.SetAA(true)
+
+		unrelated := RR.RR{}
+		unrelated.SetName("www.bank.test")
+		unrelated.SetType(DNS_Type.A)
+		unrelated.SetClass(DNS_Class.IN)
+		if err := unrelated.SetTTL(300); err != nil {
+			t.Errorf("failed to set TTL: %v", err)
+		}
+		if err := unrelated.SetRDATAToARecord(net.IPv4(203, 0, 113, 66)); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+		resp.Answers = append(resp.Answers, unrelated)
+		resp.Additional = append(resp.Additional, unrelated)
+		if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+		if err := resp.Header.SetARCOUNT(len(resp.Additional)); err != nil {
+			t.Errorf("failed to set ARCOUNT: %v", err)
+		}
+		return resp
+	}
+
+	stub := startUDPStub(t, poisoning)
+	s := newTestServer("127.0.0.1:0")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.nameserverPort = stub.Port
+	WithStubZone("example.test", stub.IP)(s)
+
+	query, err := Message.CreateDNSQuery("www.example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp, err := s.resolveRecursively(context.Background(), &query)
+	if err != nil {
+		t.Fatalf("resolveRecursively returned error: %v", err)
+	}
+
+	if len(resp.Answers) != 1 || resp.Answers[0].GetName() != "www.example.test" {
+		t.Fatalf("expected only the in-bailiwick answer, got %v", resp.Answers)
+	}
+	for _, add := range resp.Additional {
+		if add.GetName() == "www.bank.test" {
+			t.Fatalf("expected the out-of-bailiwick additional record to be discarded")
+		}
+	}
+}
@@ -1,99 +1,160 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
 	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
 	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
 	"log/slog"
 	"net"
+	"time"
 )
 
-// bootstrapRootServers queries the upstream resolver for root server information
-func (s *DNSServer) bootstrapRootServers() error {
+const (
+	defaultBootstrapAttempts = 3
+	defaultBootstrapBackoff  = time.Second
+	defaultBootstrapTimeout  = 10 * time.Second
+)
+
+// defaultRootHints are the IPv4 addresses of the root servers (https://www.iana.org/domains/root/servers).
+// They are used when the root servers cannot be bootstrapped from the upstream resolver.
+var defaultRootHints = []RootServer{
+	{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)},
+	{Name: "b.root-servers.net", IP: net.IPv4(170, 247, 170, 2)},
+	{Name: "c.root-servers.net", IP: net.IPv4(192, 33, 4, 12)},
+	{Name: "d.root-servers.net", IP: net.IPv4(199, 7, 91, 13)},
+	{Name: "e.root-servers.net", IP: net.IPv4(192, 203, 230, 10)},
+	{Name: "f.root-servers.net", IP: net.IPv4(192, 5, 5, 241)},
+	{Name: "g.root-servers.net", IP: net.IPv4(192, 112, 36, 4)},
+	{Name: "h.root-servers.net", IP: net.IPv4(198, 97, 190, 53)},
+	{Name: "i.root-servers.net", IP: net.IPv4(192, 36, 148, 17)},
+	{Name: "j.root-servers.net", IP: net.IPv4(192, 58, 128, 30)},
+	{Name: "k.root-servers.net", IP: net.IPv4(193, 0, 14, 129)},
+	{Name: "l.root-servers.net", IP: net.IPv4(199, 7, 83, 42)},
+	{Name: "m.root-servers.net", IP: net.IPv4(202, 12, 27, 33)},
+}
+
+// initRootServers bootstraps the root servers from the upstream resolver, retrying with exponential backoff.
+// If every attempt fails the root hints are used instead, so recursion keeps working without the upstream.
+func (s *DNSServer) initRootServers(ctx context.Context) error {
+	attempts := max(s.bootstrapAttempts, 1)
+	backoff := s.bootstrapBackoff
+	var errs []error
+
+retry:
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err := s.bootstrapAttempt(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+		s.logger.Warn("Failed to bootstrap root servers",
+			slog.Int("attempt", attempt),
+			slog.Any("error", err))
+
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			break retry
+		}
+	}
+
+	if len(s.rootHints) == 0 {
+		return fmt.Errorf("could not bootstrap root servers and no root hints are configured: %w", errors.Join(errs...))
+	}
+	s.rootServers = append([]RootServer(nil), s.rootHints...)
+	s.logger.Warn("Using root hints after failing to bootstrap root servers", slog.Int("count", len(s.rootServers)))
+	return nil
+}
+
+// bootstrapAttempt runs bootstrapRootServers limited by the bootstrap timeout configured with WithBootstrapTimeout.
+func (s *DNSServer) bootstrapAttempt(ctx context.Context) error {
+	if s.bootstrapTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.bootstrapTimeout)
+		defer cancel()
+	}
+	return s.bootstrapRootServers(ctx)
+}
+
+// bootstrapRootServers queries the upstream resolver for root server information. The query advertises EDNS, as the
+// root NS set with its glue does not fit into 512 bytes, and a truncated response is retried over TCP.
+func (s *DNSServer) bootstrapRootServers(ctx context.Context) error {
 	s.logger.Info("Bootstrapping root servers from upstream resolver")
 
 	query, err := Message.CreateDNSQuery(".", DNS_Type.NS, DNS_Class.IN, true)
 	if err != nil {
 		return fmt.Errorf("failed to create root servers query: %w", err)
 	}
+	if err := query.SetOPT(&Message.OPTRecord{UDPSize: s.advertisedUDPSize()}); err != nil {
+		return fmt.Errorf("failed to add OPT record to root servers query: %w", err)
+	}
 
 	queryData, err := query.MarshalBinary()
 	if err != nil {
 		return fmt.Errorf("failed to marshal root servers query: %w", err)
 	}
 
-	response, err := s.forwardToResolver(queryData)
+	response, err := s.forwardToResolver(ctx, queryData)
 	if err != nil {
 		return fmt.Errorf("failed to get root servers from upstream: %w", err)
 	}
 	if response == nil {
 		return fmt.Errorf("bootstrapRootServers get nil response *Message from forwardToResolver")
 	}
-	if !response.IsNoErrWithMatchingID(query.Header.GetMessageID()) {
-		return errors.New("bootstrapRootServers got invalid response from forwardToResolver")
+	if err := Message.ValidateResponse(&query, response); err != nil {
+		return fmt.Errorf("bootstrapRootServers got invalid response from forwardToResolver: %w", err)
 	}
 
 	var rootServers []RootServer
 	var nsNames []string
 
-	if response.Header.GetANCOUNT() != 0 {
-		for _, ns := range response.Answers {
-			if ns.Type == DNS_Type.NS {
-				nsName, err := ns.GetRDATAAsNSRecord()
-				if err != nil {
-					s.logger.Warn("Failed to parse NS record for root server", slog.Any("error", err))
-					continue
-				}
-				nsNames = append(nsNames, nsName)
-			}
+	// The root NS set may come in the Answer or, from some resolvers, the Authority section.
+	nsRecords := append(response.RecordsOfType(Message.AnswerSection, DNS_Type.NS),
+		response.RecordsOfType(Message.AuthoritySection, DNS_Type.NS)...)
+	for _, ns := range nsRecords {
+		nsName, err := ns.GetRDATAAsNSRecord()
+		if err != nil {
+			s.logger.Warn("Failed to parse NS record for root server", slog.Any("error", err))
+			continue
 		}
+		nsNames = append(nsNames, nsName)
 	}
 
-	if response.Header.GetNSCOUNT() != 0 {
-		for _, ns := range response.Authority {
-			if ns.Type == DNS_Type.NS {
-				nsName, err := ns.GetRDATAAsNSRecord()
+	for _, add := range response.RecordsOfType(Message.AdditionalSection, DNS_Type.A) {
+		for _, nsName := range nsNames {
+			if name.Equal(add.GetName(), nsName) {
+				ip, err := add.GetRDATAAsARecord()
 				if err != nil {
-					s.logger.Warn("Failed to parse NS record for root server", slog.Any("error", err))
+					s.logger.Warn("Failed to parse A record for root server",
+						slog.String("name", nsName),
+						slog.Any("error", err))
 					continue
 				}
-				nsNames = append(nsNames, nsName)
-			}
-		}
-	}
 
-	if response.Header.GetARCOUNT() != 0 {
-		for _, add := range response.Additional {
-			if add.Type == DNS_Type.A {
-				for _, nsName := range nsNames {
-					if add.GetName() == nsName {
-						ip, err := add.GetRDATAAsARecord()
-						if err != nil {
-							s.logger.Warn("Failed to parse A record for root server",
-								slog.String("name", nsName),
-								slog.Any("error", err))
-							continue
-						}
-
-						rootServers = append(rootServers, RootServer{
-							Name: nsName,
-							IP:   ip,
-						})
-
-						s.logger.Debug("Found root server",
-							slog.String("name", nsName),
-							slog.String("ip", ip.String()))
-					}
-				}
+				rootServers = append(rootServers, RootServer{
+					Name: nsName,
+					IP:   ip,
+				})
+
+				s.logger.Debug("Found root server",
+					slog.String("name", nsName),
+					slog.String("ip", ip.String()))
 			}
 		}
 	}
 
 	if len(rootServers) == 0 {
 		for _, nsName := range nsNames {
-			ips, err := s.resolveNameserver(nsName)
+			ips, err := s.resolveNameserver(ctx, nsName)
 			if err != nil {
 				s.logger.Warn("Failed to resolve root server IP",
 					slog.String("name", nsName),
@@ -125,7 +186,7 @@ func (s *DNSServer) bootstrapRootServers() error {
 }
 
 // resolveNameserver resolves a nameserver hostname to IP addresses using the upstream resolver
-func (s *DNSServer) resolveNameserver(name string) ([]net.IP, error) {
+func (s *DNSServer) resolveNameserver(ctx context.Context, name string) ([]net.IP, error) {
 	query, err := Message.CreateDNSQuery(name, DNS_Type.A, DNS_Class.IN, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create nameserver query: %w", err)
@@ -136,7 +197,7 @@ func (s *DNSServer) resolveNameserver(name string) ([]net.IP, error) {
 		return nil, fmt.Errorf("failed to marshal nameserver query: %w", err)
 	}
 
-	response, err := s.forwardToResolver(queryData)
+	response, err := s.forwardToResolver(ctx, queryData)
 	if err != nil {
 		return nil, err
 	}
@@ -144,8 +205,8 @@ func (s *DNSServer) resolveNameserver(name string) ([]net.IP, error) {
 		return nil, fmt.Errorf("resolveNameserver got nil response from forwardToResolver")
 	}
 
-	if !response.IsNoErrWithMatchingID(query.Header.GetMessageID()) {
-		return nil, fmt.Errorf("resolveNameserver got invalid response from forwardToResolver")
+	if err := Message.ValidateResponse(&query, response); err != nil {
+		return nil, fmt.Errorf("resolveNameserver got invalid response from forwardToResolver: %w", err)
 	}
 
 	var ips []net.IP
@@ -173,3 +234,25 @@ func (s *DNSServer) resolveNameserver(name string) ([]net.IP, error) {
 
 	return ips, nil
 }
+
+// isRootName reports whether name is the root domain.
+func isRootName(name string) bool {
+	return name == "." || name == ""
+}
+
+// resolveRootQuery answers NS and SOA queries for the root zone. The NS set is answered from the known root servers,
+// with their addresses as glue. Everything else, including the SOA which is not kept locally, is forwarded to the
+// upstream resolver.
+func (s *DNSServer) resolveRootQuery(ctx context.Context, query *Message.Message) (*Message.Message, error) {
+	const firstQuestion uint8 = 0
+
+	if query.Questions[firstQuestion].Type != DNS_Type.NS || len(s.rootServers) == 0 {
+		query.Header.SetQRFlag(false)
+		queryData, err := query.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal root query: %w", err)
+		}
+		return s.forwardToResolver(ctx, queryData)
+	}
+	return rootNSResponse(query, s.rootServers)
+}
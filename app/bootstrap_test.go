@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRecursion_UsesRootHintsWhenBootstrapFails(t *testing.T) {
+	// A closed port makes the upstream resolver unreachable for the bootstrap and the forwarding fallback.
+	down, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	downAddr := down.LocalAddr().String()
+	_ = down.Close()
+
+	root := startUDPStub(t, func(query Message.Message) Message.Message {
+		resp := answerA(t, "192.0.2.53", 300)(query)
+		resp.Header.SetAA(true)
+		return resp
+	})
+
+	s := newUDPTestServer(t, downAddr)
+	s.recursive = true
+	s.cache = cache.NewDNSCache(s.logger)
+	s.nameserverPort = root.Port
+	s.bootstrapAttempts = 2
+	s.bootstrapBackoff = 10 * time.Millisecond
+	WithRootHints(RootServer{Name: "hint.test", IP: net.IPv4(127, 0, 0, 1)})(s)
+
+	if err := s.initRootServers(context.Background()); err != nil {
+		t.Fatalf("initRootServers returned error: %v", err)
+	}
+	if len(s.rootServers) != 1 || s.rootServers[0].Name != "hint.test" {
+		t.Fatalf("expected the root hints to be used, got %v", s.rootServers)
+	}
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+
+	if resp.Header.GetRCODE() != header.NoError || len(resp.Answers) != 1 {
+		t.Fatalf("expected an answer resolved via the root hints, got %s with %d answers",
+			resp.Header.GetRCODE(), len(resp.Answers))
+	}
+	ip, err := resp.Answers[0].GetRDATAAsARecord()
+	if err != nil || !ip.Equal(net.ParseIP("192.0.2.53")) {
+		t.Fatalf("expected answer 192.0.2.53, got %v (err: %v)", ip, err)
+	}
+}
+
+func TestRecursion_AnswersRootNSFromRootServers(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:0")
+	s.recursive = true
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{
+		{Name: "a.root.test", IP: net.IPv4(192, 0, 2, 1)},
+		{Name: "b.root.test", IP: net.IPv4(192, 0, 2, 2)},
+	}
+
+	query, err := Message.CreateDNSQuery(".", DNS_Type.NS, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+
+	if resp.Header.GetRCODE() != header.NoError {
+		t.Fatalf("expected NoError, got %s", resp.Header.GetRCODE())
+	}
+	if len(resp.Answers) != len(s.rootServers) {
+		t.Fatalf("expected %d root NS records, got %d", len(s.rootServers), len(resp.Answers))
+	}
+	for i, answer := range resp.Answers {
+		ns, err := answer.GetRDATAAsNSRecord()
+		if err != nil || ns != s.rootServers[i].Name {
+			t.Fatalf("expected NS %s, got %q (err: %v)", s.rootServers[i].Name, ns, err)
+		}
+	}
+	if len(resp.Additional) != len(s.rootServers) {
+		t.Fatalf("expected glue for every root server, got %d records", len(resp.Additional))
+	}
+	for i, glue := range resp.Additional {
+		ip, err := glue.GetRDATAAsARecord()
+		if err != nil || !ip.Equal(s.rootServers[i].IP) || glue.GetName() != s.rootServers[i].Name {
+			t.Fatalf("expected glue %s %v, got %s %v (err: %v)", s.rootServers[i].Name, s.rootServers[i].IP,
+				glue.GetName(), ip, err)
+		}
+	}
+}
+
+func TestBootstrapRootServers_LargeResponse(t *testing.T) {
+	log := &queryLog{}
+	upstream := startUDPStub(t, func(query Message.Message) Message.Message {
+		log.record(query)
+		resp := query
+		resp.Header.SetQRFlag(true)
+		resp.Header.SetRA(true)
+		if query.Questions[0].Type != DNS_Type.NS {
+			return answerA(t, "192.0.2.1", 300)(query)
+		}
+
+		resp.Additional = nil
+		for i, hint := range defaultRootHints {
+			ns := RR.RR{Name: ".", Class: DNS_Class.IN, TTL: 518400}
+			if err := ns.SetRDATAToNSRecord(hint.Name); err != nil {
+				t.Errorf("failed to set NS record: %v", err)
+			}
+			resp.Answers = append(resp.Answers, ns)
+			glue := RR.RR{Name: hint.Name, Class: DNS_Class.IN, TTL: 518400}
+			if err := glue.SetRDATAToARecord(net.IPv4(192, 0, 2, byte(i+1))); err != nil {
+				t.Errorf("failed to set A record: %v", err)
+			}
+			resp.Additional = append(resp.Additional, glue)
+		}
+		if err := resp.SetOPT(&Message.OPTRecord{UDPSize: 1232}); err != nil {
+			t.Errorf("failed to set OPT record: %v", err)
+		}
+		if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+
+		// Like resolvers sending minimal responses, drop the glue if it does not fit the client's buffer.
+		data, err := resp.MarshalBinary()
+		if err != nil {
+			t.Errorf("failed to marshal response: %v", err)
+		}
+		if len(data) > int(query.EDNSUDPSize()) {
+			resp.Additional = nil
+			if err := resp.Header.SetARCOUNT(0); err != nil {
+				t.Errorf("failed to set ARCOUNT: %v", err)
+			}
+		}
+		return resp
+	})
+
+	s := newTestServer(upstream.String())
+	s.bootstrapTimeout = time.Second
+
+	if err := s.bootstrapAttempt(context.Background()); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+	if len(s.rootServers) != len(defaultRootHints) {
+		t.Fatalf("expected %d root servers from the glue, got %d", len(defaultRootHints), len(s.rootServers))
+	}
+	if !s.rootServers[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("expected the first root server at 192.0.2.1, got %v", s.rootServers[0].IP)
+	}
+	if log.count() != 1 {
+		t.Fatalf("expected a single bootstrap query without resolving nameservers, upstream saw %v", log.snapshot())
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
+	"github.com/blazskufca/dns_server_in_go/internal/zone"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// ConfigFiles are the paths of the optional configuration files loaded at startup. Empty paths are skipped.
+type ConfigFiles struct {
+	// Zone is a zone file for ZoneOrigin, see zone.Parse.
+	Zone       string
+	ZoneOrigin string
+	// Hosts is a hosts file with an address followed by one or more names on every line.
+	Hosts string
+	// Blocklist has one name to block per line. Names may be wildcards like "*.example.com".
+	Blocklist string
+	// Answers is a JSON object mapping names to the records they are answered with, see parseStaticAnswers.
+	Answers string
+}
+
+// Load reads and validates every configured file and returns the options applying them. Errors in all files are
+// reported together, each as a *utils.ParseError with the file and line it was found at.
+func (c ConfigFiles) Load() ([]Option, error) {
+	var opts []Option
+	var errs []error
+
+	if c.Zone != "" && c.ZoneOrigin == "" {
+		errs = append(errs, errors.New("zone file requires a zone origin"))
+	} else if c.Zone != "" {
+		err := readConfigFile(c.Zone, func(r io.Reader) error {
+			z, err := zone.Parse(r, c.ZoneOrigin)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, WithZone(z))
+			return nil
+		})
+		errs = append(errs, err)
+	}
+	if c.Hosts != "" {
+		err := readConfigFile(c.Hosts, func(r io.Reader) error {
+			hosts, err := parseHosts(r)
+			for _, name := range hosts.names {
+				opts = append(opts, WithHost(name, hosts.ips[name]...))
+			}
+			return err
+		})
+		errs = append(errs, err)
+	}
+	if c.Blocklist != "" {
+		err := readConfigFile(c.Blocklist, func(r io.Reader) error {
+			names, err := parseBlocklist(r)
+			opts = append(opts, WithBlockedNames(names...))
+			return err
+		})
+		errs = append(errs, err)
+	}
+
+	if c.Answers != "" {
+		err := readConfigFile(c.Answers, func(r io.Reader) error {
+			answers, err := parseStaticAnswers(r)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, WithStaticAnswers(answers))
+			return nil
+		})
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// readConfigFile opens path and parses it with parse, filling in the file name of the parse errors.
+func readConfigFile(path string, parse func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	err = parse(f)
+	if err == nil {
+		return nil
+	}
+
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else {
+		errs = []error{err}
+	}
+	for _, e := range errs {
+		var parseErr *utils.ParseError
+		if errors.As(e, &parseErr) {
+			parseErr.File = path
+		}
+	}
+	return err
+}
+
+// hostsTable holds the addresses of every name of a hosts file, names in the order they first appear.
+type hostsTable struct {
+	ips   map[string][]net.IP
+	names []string
+}
+
+// parseHosts parses a hosts file: an address followed by one or more names on every line, "#" starts a comment.
+func parseHosts(r io.Reader) (hostsTable, error) {
+	hosts := hostsTable{ips: make(map[string][]net.IP)}
+
+	var errs []error
+	err := scanConfigLines(r, func(fields []string) error {
+		if len(fields) < 2 {
+			return errors.New("expected an address followed by at least one name")
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return fmt.Errorf("invalid address %q", fields[0])
+		}
+		for _, name := range fields[1:] {
+			if err := validateTableName(name); err != nil {
+				return err
+			}
+			if _, seen := hosts.ips[name]; !seen {
+				hosts.names = append(hosts.names, name)
+			}
+			hosts.ips[name] = append(hosts.ips[name], ip)
+		}
+		return nil
+	}, &errs)
+	if err != nil {
+		return hosts, err
+	}
+	return hosts, errors.Join(errs...)
+}
+
+// parseBlocklist parses a blocklist file: one name per line, "#" starts a comment.
+func parseBlocklist(r io.Reader) ([]string, error) {
+	var names []string
+
+	var errs []error
+	err := scanConfigLines(r, func(fields []string) error {
+		if len(fields) != 1 {
+			return errors.New("expected exactly one name")
+		}
+		if err := validateTableName(fields[0]); err != nil {
+			return err
+		}
+		names = append(names, fields[0])
+		return nil
+	}, &errs)
+	if err != nil {
+		return names, err
+	}
+	return names, errors.Join(errs...)
+}
+
+// scanConfigLines calls parse with the fields of every line of r which is not empty or a comment. Errors returned by
+// parse are collected into errs as *utils.ParseError with the line number, reading errors are returned.
+func scanConfigLines(r io.Reader, parse func(fields []string) error, errs *[]error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := parse(fields); err != nil {
+			*errs = append(*errs, &utils.ParseError{Line: line, Err: err})
+		}
+	}
+	return scanner.Err()
+}
+
+// validateTableName validates a name of the hosts or blocklist tables, which may be a wildcard. Internationalized names
+// are validated in their A-label form.
+func validateTableName(tableName string) error {
+	ascii, err := name.ToASCII(strings.TrimPrefix(tableName, "*."))
+	if err != nil {
+		return fmt.Errorf("invalid name %q: %w", tableName, err)
+	}
+	if err := utils.ValidateName(ascii); err != nil {
+		return fmt.Errorf("invalid name %q: %w", tableName, err)
+	}
+	if strings.Contains(strings.TrimPrefix(tableName, "*."), "*") {
+		return fmt.Errorf("invalid name %q: wildcards are only allowed as the first label", tableName)
+	}
+	return nil
+}
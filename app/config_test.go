@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestConfigFilesLoad(t *testing.T) {
+	files := ConfigFiles{
+		Zone:       writeConfigFile(t, "zone", "@ IN SOA ns1 admin 1 3600 600 86400 300\nns1 IN A 192.0.2.1\n"),
+		ZoneOrigin: "example.com.",
+		Hosts:      writeConfigFile(t, "hosts", "# local names\n192.0.2.10 router router.lan\n2001:db8::10 router\n"),
+		Blocklist:  writeConfigFile(t, "blocklist", "ads.example.net\n*.tracker.example # and its subdomains\n"),
+		Answers:    writeConfigFile(t, "answers.json", `{"printer.lan": [{"type": "A", "data": "192.0.2.20"}]}`),
+	}
+
+	opts, err := files.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	s := &DNSServer{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.zone == nil {
+		t.Error("expected the zone to be loaded")
+	}
+	if ips, ok := s.hosts.lookup("router"); !ok || len(ips) != 2 {
+		t.Errorf("expected two addresses for router, got %v", ips)
+	}
+	if _, ok := s.hosts.lookup("router.lan"); !ok {
+		t.Error("expected the router.lan alias to be loaded")
+	}
+	if _, ok := s.blocklist.lookup("www.tracker.example"); !ok {
+		t.Error("expected the wildcard blocklist entry to be loaded")
+	}
+	if s.staticAnswers == nil {
+		t.Error("expected the static answers to be loaded")
+	}
+}
+
+func TestConfigFilesLoad_ReportsFileAndLine(t *testing.T) {
+	hosts := writeConfigFile(t, "hosts", "192.0.2.10 router\n192.0.2.300 broken\n")
+	blocklist := writeConfigFile(t, "blocklist", "ads.example.net\n\nads.example.org tracker.example.org\n")
+	zoneFile := writeConfigFile(t, "zone", "@ IN SOA ns1 admin 1 3600 600 86400 300\nns1 IN A\n")
+
+	_, err := ConfigFiles{Zone: zoneFile, ZoneOrigin: "example.com.", Hosts: hosts, Blocklist: blocklist}.Load()
+	if err == nil {
+		t.Fatal("expected errors for the malformed files")
+	}
+
+	var parseErr *utils.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a *utils.ParseError, got %T", err)
+	}
+	for _, want := range []string{zoneFile + ":2:", hosts + ":2:", blocklist + ":3:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error %q to contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/edns"
+	"net"
+)
+
+// serverCookieLen is the length of the server cookies the server hands out.
+const serverCookieLen = 16
+
+// errBadServerCookie is returned by responseCookie for queries carrying a server cookie the server did not hand out.
+var errBadServerCookie = errors.New("invalid server cookie")
+
+// responseCookie returns the COOKIE option for the response to query, sent by the client at from. The server cookie is
+// a keyed hash of the client cookie and the client address, so a client keeps getting the same server cookie and a
+// returning client can be validated without keeping any state. It returns nil if cookies are not enabled with
+// WithCookieSecret or the query carries no cookie, and an error if the cookie is malformed. A query with a server
+// cookie other than the one of the client gets errBadServerCookie along with the right cookie to answer BADCOOKIE with.
+func (s *DNSServer) responseCookie(query *Message.Message, from net.Addr) (*edns.Option, error) {
+	if len(s.cookieSecret) == 0 {
+		return nil, nil
+	}
+	opt, ok := query.GetOPT()
+	if !ok {
+		return nil, nil
+	}
+	opts, err := edns.ParseOptions(opt.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	option, ok := edns.FindOption(opts, edns.Cookie)
+	if !ok {
+		return nil, nil
+	}
+	client, server, err := edns.ParseCookie(option.Data)
+	if err != nil {
+		return nil, err
+	}
+	expected := s.serverCookie(client, clientIP(from))
+	cookie := edns.CookieOption(client, expected)
+	if server != nil && !hmac.Equal(server, expected) {
+		return &cookie, errBadServerCookie
+	}
+	return &cookie, nil
+}
+
+// badCookieResponse returns a BADCOOKIE response to query advertising udpSize, for a query with a server cookie the
+// server did not hand out. The response carries the right cookie once finished, so the client can retry with it
+// (https://datatracker.ietf.org/doc/html/rfc7873#section-5.2.3).
+func badCookieResponse(query *Message.Message, udpSize uint16) (*Message.Message, error) {
+	response := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+	}
+	response.Header.SetQRFlag(true)
+	if err := response.Header.SetANCOUNT(0); err != nil {
+		return nil, err
+	}
+	if err := response.Header.SetNSCOUNT(0); err != nil {
+		return nil, err
+	}
+	if err := edns.SetExtendedRCODE(response, edns.BadCookie, udpSize); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// finishResponse returns response to query with the steps every response to a client takes before it is signed: the
+// OPT record advertising the server's UDP payload size, see advertiseEDNS, and cookie, see addCookie.
+func (s *DNSServer) finishResponse(query, response *Message.Message, cookie *edns.Option) (*Message.Message, error) {
+	response, err := s.advertiseEDNS(query, response)
+	if err != nil {
+		return nil, err
+	}
+	return addCookie(response, cookie)
+}
+
+// serverCookie computes the server cookie of the client at ip using client cookie client.
+func (s *DNSServer) serverCookie(client []byte, ip net.IP) []byte {
+	mac := hmac.New(sha256.New, s.cookieSecret)
+	mac.Write(client)
+	mac.Write(ip.To16())
+	return mac.Sum(nil)[:serverCookieLen]
+}
+
+// addCookie returns a copy of response carrying cookie in its OPT record, in place of any cookie the upstream sent.
+// The response is returned unchanged if cookie is nil or it has no OPT record.
+func addCookie(response *Message.Message, cookie *edns.Option) (*Message.Message, error) {
+	if cookie == nil {
+		return response, nil
+	}
+	opt, ok := response.GetOPT()
+	if !ok {
+		return response, nil
+	}
+	opts, err := edns.ParseOptions(opt.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	opt.Data, err = edns.PackOptions(edns.SetOption(opts, *cookie))
+	if err != nil {
+		return nil, err
+	}
+
+	withCookie := *response
+	if err := withCookie.SetOPT(opt); err != nil {
+		return nil, fmt.Errorf("failed to set OPT record: %w", err)
+	}
+	return &withCookie, nil
+}
+
+// clientIP returns the IP address of the client at from, or nil if from is not a UDP or TCP address.
+func clientIP(from net.Addr) net.IP {
+	switch addr := from.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	default:
+		return nil
+	}
+}
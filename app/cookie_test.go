@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/edns"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+	"testing"
+)
+
+// queryWithCookie creates an A query for name carrying a COOKIE option with the client and server cookie.
+func queryWithCookie(t *testing.T, name string, client, server []byte) Message.Message {
+	t.Helper()
+	return queryWithEDNSOption(t, name, edns.CookieOption(client, server))
+}
+
+// responseServerCookie returns the server cookie of the COOKIE option of resp.
+func responseServerCookie(t *testing.T, resp Message.Message) []byte {
+	t.Helper()
+	opt, ok := resp.GetOPT()
+	if !ok {
+		t.Fatalf("expected the response to have an OPT record")
+	}
+	opts, err := edns.ParseOptions(opt.Data)
+	if err != nil {
+		t.Fatalf("failed to parse EDNS options: %v", err)
+	}
+	var cookies [][]byte
+	for _, option := range opts {
+		if option.Code == edns.Cookie {
+			cookies = append(cookies, option.Data)
+		}
+	}
+	if len(cookies) != 1 {
+		t.Fatalf("expected exactly one COOKIE option, got %d", len(cookies))
+	}
+	_, server, err := edns.ParseCookie(cookies[0])
+	if err != nil {
+		t.Fatalf("failed to parse cookie: %v", err)
+	}
+	if len(server) != serverCookieLen {
+		t.Fatalf("expected a %d byte server cookie, got %d bytes", serverCookieLen, len(server))
+	}
+	return server
+}
+
+func TestCookie_StableServerCookiePerClient(t *testing.T) {
+	stub := startUDPStub(t, answerA(t, "192.0.2.1", 300))
+	s := newUDPTestServer(t, stub.String())
+	WithCookieSecret([]byte("0123456789abcdef"))(s)
+	client := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	first := responseServerCookie(t, exchangeUDP(t, s, queryWithCookie(t, "www.example.com", client, nil)))
+
+	// A returning client presents the server cookie and keeps getting the same one.
+	for i := 0; i < 3; i++ {
+		resp := exchangeUDP(t, s, queryWithCookie(t, "www.example.com", client, first))
+		if resp.Header.GetRCODE() != header.NoError {
+			t.Fatalf("expected NOERROR, got %s", resp.Header.GetRCODE())
+		}
+		if got := responseServerCookie(t, resp); !bytes.Equal(got, first) {
+			t.Fatalf("query %d: expected the server cookie %x to be stable, got %x", i, first, got)
+		}
+	}
+
+	if bytes.Equal(s.serverCookie(client, net.IPv4(127, 0, 0, 1)), s.serverCookie(client, net.IPv4(127, 0, 0, 2))) {
+		t.Fatalf("expected different clients to get different server cookies")
+	}
+}
+
+func TestCookie_InvalidServerCookieIsBadCookie(t *testing.T) {
+	stub := startUDPStub(t, answerA(t, "192.0.2.1", 300))
+	s := newUDPTestServer(t, stub.String())
+	WithCookieSecret([]byte("0123456789abcdef"))(s)
+	client := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	stale := bytes.Repeat([]byte{0xff}, serverCookieLen)
+
+	resp := exchangeUDP(t, s, queryWithCookie(t, "www.example.com", client, stale))
+	if rcode := edns.ExtendedRCODE(&resp); rcode != edns.BadCookie {
+		t.Fatalf("expected BADCOOKIE for an invalid server cookie, got RCODE %d", rcode)
+	}
+	if len(resp.Answers) != 0 {
+		t.Fatalf("expected no answers with BADCOOKIE, got %d", len(resp.Answers))
+	}
+	if got := s.Stats().BadCookies; got != 1 {
+		t.Fatalf("expected 1 bad cookie counted, got %d", got)
+	}
+
+	// The client retries with the server cookie of the BADCOOKIE response and gets its answer.
+	server := responseServerCookie(t, resp)
+	resp = exchangeUDP(t, s, queryWithCookie(t, "www.example.com", client, server))
+	if rcode := edns.ExtendedRCODE(&resp); rcode != uint16(header.NoError) {
+		t.Fatalf("expected NOERROR after retrying with the new server cookie, got RCODE %d", rcode)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	if got := s.Stats().BadCookies; got != 1 {
+		t.Fatalf("expected the valid cookie not to be counted, got %d bad cookies", got)
+	}
+}
+
+func TestCookie_LocalAnswersOverTCP(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	WithCookieSecret([]byte("0123456789abcdef"))(s)
+	WithHost("host.example", net.IPv4(192, 0, 2, 1))(s)
+	client := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	resp := exchangeTCP(t, s, queryWithCookie(t, "host.example", client, nil))
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected the local answer, got %d answers", len(resp.Answers))
+	}
+	responseServerCookie(t, resp)
+}
+
+func TestCookie_MalformedCookieIsFormatError(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	WithCookieSecret([]byte("0123456789abcdef"))(s)
+
+	resp := exchangeUDP(t, s, queryWithEDNSOption(t, "www.example.com", edns.Option{Code: edns.Cookie, Data: []byte{1, 2, 3}}))
+
+	if resp.Header.GetRCODE() != header.FormatError {
+		t.Fatalf("expected FORMERR for a malformed cookie, got %s", resp.Header.GetRCODE())
+	}
+}
+
+func TestCookie_DisabledWithoutSecret(t *testing.T) {
+	query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	s := newUDPTestServer(t, "127.0.0.1:1")
+
+	cookie, err := s.responseCookie(&query, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil || cookie != nil {
+		t.Fatalf("expected no cookie without a secret, got %v, %v", cookie, err)
+	}
+}
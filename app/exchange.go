@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"net"
+)
+
+// Exchanger sends query to the DNS server at server and returns its response. It replaces the UDP and TCP transport
+// the server uses to talk to nameservers and the upstream resolver, see WithExchanger.
+type Exchanger interface {
+	Exchange(ctx context.Context, query *Message.Message, server net.IP) (*Message.Message, error)
+}
+
+// exchangeUpstream sends the marshalled query to the upstream resolver address addr through the configured Exchanger.
+func (s *DNSServer) exchangeUpstream(ctx context.Context, addr string, query []byte) (*Message.Message, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolver address %q: %w", addr, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("resolver address %q is not an IP address", addr)
+	}
+	msg, err := Message.New(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal query: %w", err)
+	}
+
+	release, err := s.acquireOutbound(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return s.exchanger.Exchange(ctx, &msg, ip)
+}
+
+// exchangeNameserver sends query to the nameserver at serverIP through the configured Exchanger and validates the
+// response.
+func (s *DNSServer) exchangeNameserver(ctx context.Context, serverIP net.IP, query *Message.Message) (*Message.Message, error) {
+	release, err := s.acquireOutbound(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	response, err := s.exchanger.Exchange(ctx, query, serverIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query nameserver %s: %w", serverIP.String(), err)
+	}
+	if err := Message.ValidateResponse(query, response); err != nil {
+		return nil, fmt.Errorf("queryNameserver got invalid response from nameserver %s: %w", serverIP.String(), err)
+	}
+	return response, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"net"
+	"sync"
+	"testing"
+)
+
+// scriptedExchanger answers queries from in-memory nameservers, keyed by their address, and records which servers
+// were queried.
+type scriptedExchanger struct {
+	servers map[string]stubHandler
+	mu      sync.Mutex
+	queried []string
+}
+
+func (e *scriptedExchanger) Exchange(_ context.Context, query *Message.Message, server net.IP) (*Message.Message, error) {
+	e.mu.Lock()
+	e.queried = append(e.queried, server.String())
+	e.mu.Unlock()
+
+	handler, ok := e.servers[server.String()]
+	if !ok {
+		return nil, fmt.Errorf("no scripted nameserver at %s", server)
+	}
+	response := handler(*query)
+	return &response, nil
+}
+
+// scriptedReferral returns a stubHandler delegating every query to zone, served by nameserver at ip.
+func scriptedReferral(t *testing.T, zone, nameserver string, ip net.IP) stubHandler {
+	t.Helper()
+	return func(query Message.Message) Message.Message {
+		resp := query
+		resp.Header.SetQRFlag(true)
+
+		ns := RR.RR{Name: zone, Class: DNS_Class.IN, TTL: 300}
+		if err := ns.SetRDATAToNSRecord(nameserver); err != nil {
+			t.Errorf("failed to set NS record: %v", err)
+		}
+		glue := RR.RR{Name: nameserver, Class: DNS_Class.IN, TTL: 300}
+		if err := glue.SetRDATAToARecord(ip); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+		resp.Authority = []RR.RR{ns}
+		resp.Additional = []RR.RR{glue}
+		if err := resp.Header.SetNSCOUNT(len(resp.Authority)); err != nil {
+			t.Errorf("failed to set NSCOUNT: %v", err)
+		}
+		if err := resp.Header.SetARCOUNT(len(resp.Additional)); err != nil {
+			t.Errorf("failed to set ARCOUNT: %v", err)
+		}
+		return resp
+	}
+}
+
+func TestExchanger_DrivesRecursiveResolution(t *testing.T) {
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{
+		"198.41.0.4": scriptedReferral(t, "test", "ns.nic.test", net.IPv4(192, 0, 2, 10)),
+		"192.0.2.10": scriptedReferral(t, "example.test", "ns1.example.test", net.IPv4(192, 0, 2, 20)),
+		"192.0.2.20": func(query Message.Message) Message.Message {
+			resp := answerA(t, "192.0.2.80", 300)(query)
+			resp.Header.SetAA(true)
+			return resp
+		},
+	}}
+
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+
+	resp := resolveForTest(t, s, "www.example.test", DNS_Type.A)
+
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 80)) {
+		t.Fatalf("expected answer 192.0.2.80, got %v (%v)", ip, err)
+	}
+	want := []string{"198.41.0.4", "192.0.2.10", "192.0.2.20"}
+	if fmt.Sprint(exchanger.queried) != fmt.Sprint(want) {
+		t.Fatalf("expected the root, TLD and authoritative servers to be queried in order %v, got %v",
+			want, exchanger.queried)
+	}
+}
+
+func TestExchanger_DrivesForwarding(t *testing.T) {
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{
+		"192.0.2.53": answerA(t, "192.0.2.1", 300),
+	}}
+	s := newTestServer("192.0.2.53:53")
+	WithExchanger(exchanger)(s)
+
+	query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	resp, err := s.forwardToResolver(context.Background(), data)
+	if err != nil {
+		t.Fatalf("forwardToResolver returned error: %v", err)
+	}
+	if len(resp.Answers) != 1 || resp.Header.ID != query.Header.ID {
+		t.Fatalf("expected the scripted answer to the query, got %d answers with ID %d", len(resp.Answers), resp.Header.ID)
+	}
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"github.com/blazskufca/dns_server_in_go/internal/clock"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDelegation delegates zone to nameserver, with glue as its address.
+type fakeDelegation struct {
+	zone       string
+	nameserver string
+	glue       net.IP
+}
+
+// fakeAuthority is a scripted authoritative nameserver. Queries below one of its delegations get a referral to the
+// most specific one, other queries are answered from its records, following the rules of an authoritative server:
+// a CNAME owned by the name is returned instead of other types, and names without records get NXDOMAIN.
+type fakeAuthority struct {
+	t           *testing.T
+	records     []RR.RR
+	delegations []fakeDelegation
+	// negativeSOA, if set, is added to the Authority section of negative answers.
+	negativeSOA *RR.RR
+}
+
+// newFakeAuthority creates a fakeAuthority without records or delegations.
+func newFakeAuthority(t *testing.T) *fakeAuthority {
+	return &fakeAuthority{t: t}
+}
+
+// delegate adds a delegation of zone to nameserver at glue.
+func (a *fakeAuthority) delegate(zone, nameserver string, glue net.IP) *fakeAuthority {
+	a.delegations = append(a.delegations, fakeDelegation{zone: zone, nameserver: nameserver, glue: glue})
+	return a
+}
+
+// a adds an A record.
+func (a *fakeAuthority) a(owner, ip string) *fakeAuthority {
+	rr := RR.RR{Name: owner, Class: DNS_Class.IN, TTL: 300}
+	if err := rr.SetRDATAToARecord(net.ParseIP(ip)); err != nil {
+		a.t.Errorf("failed to set A record: %v", err)
+	}
+	a.records = append(a.records, rr)
+	return a
+}
+
+// cname adds a CNAME record.
+func (a *fakeAuthority) cname(owner, target string) *fakeAuthority {
+	rr := RR.RR{Name: owner, Class: DNS_Class.IN, TTL: 300}
+	if err := rr.SetRDATAToCNAMERecord(target); err != nil {
+		a.t.Fatalf("failed to set CNAME record: %v", err)
+	}
+	rr.SetType(DNS_Type.CNAME)
+	a.records = append(a.records, rr)
+	return a
+}
+
+// soa sets the SOA record added to negative answers, owned by zone.
+func (a *fakeAuthority) soa(zone string, ttl, minimum uint32) *fakeAuthority {
+	rr := RR.RR{Name: zone, Class: DNS_Class.IN, TTL: ttl}
+	if err := rr.SetRDATAToSOARecord("ns."+zone, "hostmaster."+zone, 1, 3600, 600, 86400, minimum); err != nil {
+		a.t.Fatalf("failed to set SOA record: %v", err)
+	}
+	a.negativeSOA = &rr
+	return a
+}
+
+// handle answers query, it is a stubHandler.
+func (a *fakeAuthority) handle(query Message.Message) Message.Message {
+	resp := query
+	resp.Header.SetQRFlag(true)
+	q := query.Questions[0]
+
+	var referral *fakeDelegation
+	for i, d := range a.delegations {
+		if name.IsSubdomain(q.Name, d.zone) && (referral == nil || len(d.zone) > len(referral.zone)) {
+			referral = &a.delegations[i]
+		}
+	}
+	if referral != nil {
+		ns := RR.RR{Name: referral.zone, Class: DNS_Class.IN, TTL: 300}
+		if err := ns.SetRDATAToNSRecord(referral.nameserver); err != nil {
+			a.t.Errorf("failed to set NS record: %v", err)
+		}
+		glue := RR.RR{Name: referral.nameserver, Class: DNS_Class.IN, TTL: 300}
+		if err := glue.SetRDATAToARecord(referral.glue); err != nil {
+			a.t.Errorf("failed to set A record: %v", err)
+		}
+		resp.Authority = []RR.RR{ns}
+		resp.Additional = []RR.RR{glue}
+	} else {
+		resp.Header.SetAA(true)
+		exists := false
+		var answers, cnames []RR.RR
+		for _, rr := range a.records {
+			if !name.Equal(rr.Name, q.Name) {
+				continue
+			}
+			exists = true
+			switch rr.Type {
+			case q.Type:
+				answers = append(answers, rr)
+			case DNS_Type.CNAME:
+				cnames = append(cnames, rr)
+			}
+		}
+		if len(answers) == 0 {
+			answers = cnames
+		}
+		resp.Answers = answers
+		if !exists {
+			resp.Header.SetRCODE(header.NameError)
+		}
+		if len(answers) == 0 && a.negativeSOA != nil {
+			resp.Authority = []RR.RR{*a.negativeSOA}
+		}
+	}
+
+	if !finishLocalResponse(&resp) {
+		a.t.Errorf("failed to set section counts")
+	}
+	return resp
+}
+
+func TestFakeAuthority_TwoLevelDelegationWithCNAMEAtLeaf(t *testing.T) {
+	root := newFakeAuthority(t).delegate("test", "ns.nic.test", net.IPv4(192, 0, 2, 10))
+	tld := newFakeAuthority(t).delegate("example.test", "ns1.example.test", net.IPv4(192, 0, 2, 20))
+	leaf := newFakeAuthority(t).
+		cname("www.example.test", "web.example.test").
+		a("web.example.test", "192.0.2.80")
+
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{
+		"198.41.0.4": root.handle,
+		"192.0.2.10": tld.handle,
+		"192.0.2.20": leaf.handle,
+	}}
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+
+	resp := resolveForTest(t, s, "www.example.test", DNS_Type.A)
+
+	if resp.Header.GetRCODE() != header.NoError {
+		t.Fatalf("expected NOERROR, got %s", resp.Header.GetRCODE())
+	}
+	if len(resp.Answers) != 2 || resp.Answers[0].Type != DNS_Type.CNAME || resp.Answers[1].Type != DNS_Type.A {
+		t.Fatalf("expected the CNAME followed by the A record of its target, got %v", resp.Answers)
+	}
+	if target, err := resp.Answers[0].GetRDATAAsCNAMERecord(); err != nil || !name.Equal(target, "web.example.test") {
+		t.Fatalf("expected the CNAME to point at web.example.test, got %q (%v)", target, err)
+	}
+	if ip, err := resp.Answers[1].GetRDATAAsARecord(); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 80)) {
+		t.Fatalf("expected answer 192.0.2.80, got %v (%v)", ip, err)
+	}
+	if fmt.Sprint(exchanger.queried[:3]) != fmt.Sprint([]string{"198.41.0.4", "192.0.2.10", "192.0.2.20"}) {
+		t.Fatalf("expected the delegation to be followed from the root, queried %v", exchanger.queried)
+	}
+}
+
+func TestFakeAuthority_LongCNAMEChainStaysWithinLimit(t *testing.T) {
+	const chainLength int = 40
+	const limit int = 512
+
+	root := newFakeAuthority(t)
+	for i := range chainLength {
+		root.cname(fmt.Sprintf("hop%02d.a-rather-long-label-to-grow-the-response.test", i),
+			fmt.Sprintf("hop%02d.a-rather-long-label-to-grow-the-response.test", i+1))
+	}
+	root.a(fmt.Sprintf("hop%02d.a-rather-long-label-to-grow-the-response.test", chainLength), "192.0.2.80")
+
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": root.handle}}
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	s.cnameResponseLimit = limit
+	WithExchanger(exchanger)(s)
+
+	resp := resolveForTest(t, s, "hop00.a-rather-long-label-to-grow-the-response.test", DNS_Type.A)
+
+	if !resp.Header.IsTC() {
+		t.Errorf("expected the truncated chain to set TC")
+	}
+	if len(resp.Answers) == 0 || len(resp.Answers) > chainLength {
+		t.Fatalf("expected a partial chain, got %d answers", len(resp.Answers))
+	}
+	data, err := resp.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+	if len(data) > limit {
+		t.Errorf("expected the assembled response to stay within %d bytes, got %d", limit, len(data))
+	}
+}
+
+func TestFakeAuthority_OversizedUDPResponseIsTrimmed(t *testing.T) {
+	const records int = 60
+
+	root := newFakeAuthority(t)
+	for i := range records {
+		root.a("big.example.test", fmt.Sprintf("192.0.2.%d", i+1))
+	}
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": root.handle}}
+	s := newUDPTestServer(t, "192.0.2.53:53")
+	s.recursive = true
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+
+	query, err := Message.CreateDNSQuery("big.example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+
+	if !resp.Header.IsTC() {
+		t.Errorf("expected the trimmed response to set TC")
+	}
+	if len(resp.Answers) == 0 || len(resp.Answers) >= records {
+		t.Fatalf("expected a partial answer, got %d records", len(resp.Answers))
+	}
+	data, err := resp.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+	if len(data) > udpResponseMaxSize {
+		t.Errorf("expected the UDP response to stay within %d bytes, got %d", udpResponseMaxSize, len(data))
+	}
+}
+
+func TestFakeAuthority_UDPResponseUsesAdvertisedEDNSSize(t *testing.T) {
+	const records int = 40
+	const advertised uint16 = 1232
+
+	root := newFakeAuthority(t)
+	for i := range records {
+		root.a("big.example.test", fmt.Sprintf("192.0.2.%d", i+1))
+	}
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": root.handle}}
+	s := newUDPTestServer(t, "192.0.2.53:53")
+	s.recursive = true
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+
+	query, err := Message.CreateDNSQuery("big.example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	if err := query.SetOPT(&Message.OPTRecord{UDPSize: advertised}); err != nil {
+		t.Fatalf("failed to set OPT record: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+
+	if resp.Header.IsTC() || len(resp.Answers) != records {
+		t.Fatalf("expected all %d records without TC, got %d (TC: %v)", records, len(resp.Answers), resp.Header.IsTC())
+	}
+	data, err := resp.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+	if len(data) <= udpResponseMaxSize || len(data) > int(advertised) {
+		t.Fatalf("expected a response between %d and %d bytes, got %d", udpResponseMaxSize, advertised, len(data))
+	}
+	if opt, ok := resp.GetOPT(); !ok || opt.UDPSize != s.advertisedUDPSize() {
+		t.Fatalf("expected the response to advertise %d bytes, got %+v", s.advertisedUDPSize(), opt)
+	}
+}
+
+func TestFakeAuthority_MinimalResponsesDropAdditional(t *testing.T) {
+	root := newFakeAuthority(t).a("www.example.test", "192.0.2.80")
+	// The authority answers with an optional address record for its nameserver in the Additional section.
+	withAdditional := func(query Message.Message) Message.Message {
+		resp := root.handle(query)
+		extra := RR.RR{Name: "ns.example.test", Class: DNS_Class.IN, TTL: 300}
+		if err := extra.SetRDATAToARecord(net.IPv4(192, 0, 2, 53)); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+		resp.Additional = append(resp.Additional, extra)
+		if err := resp.Header.SetARCOUNT(len(resp.Additional)); err != nil {
+			t.Errorf("failed to set ARCOUNT: %v", err)
+		}
+		return resp
+	}
+
+	for _, minimal := range []bool{false, true} {
+		exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": withAdditional}}
+		s := newTestServer("192.0.2.53:53")
+		s.cache = cache.NewDNSCache(s.logger)
+		s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+		WithExchanger(exchanger)(s)
+		WithMinimalResponses(minimal)(s)
+
+		resp := resolveForTest(t, s, "www.example.test", DNS_Type.A)
+
+		if len(resp.Answers) != 1 {
+			t.Fatalf("minimal %v: expected 1 answer, got %v", minimal, resp.Answers)
+		}
+		want := 1
+		if minimal {
+			want = 0
+		}
+		if len(resp.Additional) != want || int(resp.Header.GetARCOUNT()) != want {
+			t.Fatalf("minimal %v: expected %d Additional records and ARCOUNT %d, got %d and %d", minimal, want, want,
+				len(resp.Additional), resp.Header.GetARCOUNT())
+		}
+	}
+}
+
+func TestFakeAuthority_RejectsAnswersForOtherNames(t *testing.T) {
+	// The first server answers authoritatively with a consistent ANCOUNT, but for a name that was not asked.
+	unrelated := func(query Message.Message) Message.Message {
+		resp := answerA(t, "192.0.2.66", 300)(query)
+		resp.Answers[0].SetName("unrelated.test")
+		resp.Header.SetAA(true)
+		return resp
+	}
+	good := newFakeAuthority(t).a("www.example.test", "192.0.2.80")
+
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{
+		"198.41.0.4":   unrelated,
+		"199.9.14.201": good.handle,
+	}}
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{
+		{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)},
+		{Name: "b.root-servers.net", IP: net.IPv4(199, 9, 14, 201)},
+	}
+	WithExchanger(exchanger)(s)
+
+	resp := resolveForTest(t, s, "www.example.test", DNS_Type.A)
+
+	if len(resp.Answers) != 1 || !name.Equal(resp.Answers[0].GetName(), "www.example.test") {
+		t.Fatalf("expected the answer of the second server, got %v", resp.Answers)
+	}
+	if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 80)) {
+		t.Fatalf("expected answer 192.0.2.80, got %v (%v)", ip, err)
+	}
+	if fmt.Sprint(exchanger.queried) != fmt.Sprint([]string{"198.41.0.4", "199.9.14.201"}) {
+		t.Fatalf("expected both servers to be queried in order, queried %v", exchanger.queried)
+	}
+}
+
+func TestFakeAuthority_InvalidResponseTriesNextServer(t *testing.T) {
+	// The first server answers with a header claiming more answers than the message carries.
+	invalid := func(query Message.Message) Message.Message {
+		resp := answerA(t, "192.0.2.66", 300)(query)
+		if err := resp.Header.SetANCOUNT(2); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+		return resp
+	}
+	good := newFakeAuthority(t).a("www.example.test", "192.0.2.80")
+
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{
+		"198.41.0.4":   invalid,
+		"199.9.14.201": good.handle,
+	}}
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{
+		{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)},
+		{Name: "b.root-servers.net", IP: net.IPv4(199, 9, 14, 201)},
+	}
+	WithExchanger(exchanger)(s)
+
+	resp := resolveForTest(t, s, "www.example.test", DNS_Type.A)
+
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected the answer of the second server, got %v", resp.Answers)
+	}
+	if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 80)) {
+		t.Fatalf("expected answer 192.0.2.80, got %v (%v)", ip, err)
+	}
+	if fmt.Sprint(exchanger.queried) != fmt.Sprint([]string{"198.41.0.4", "199.9.14.201"}) {
+		t.Fatalf("expected both servers to be queried in order, queried %v", exchanger.queried)
+	}
+}
+
+func TestFakeAuthority_CachesNXDOMAINForSOAMinimum(t *testing.T) {
+	const soaMinimum = 60
+
+	root := newFakeAuthority(t).a("www.example.test", "192.0.2.80").soa("test", 3600, soaMinimum)
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": root.handle}}
+	clk := clock.NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(slog.New(slog.DiscardHandler), cache.WithClock(clk))
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+
+	resp := resolveForTest(t, s, "missing.example.test", DNS_Type.A)
+	if resp.Header.GetRCODE() != header.NameError {
+		t.Fatalf("expected NXDOMAIN, got %s", resp.Header.GetRCODE())
+	}
+	if len(exchanger.queried) != 1 {
+		t.Fatalf("expected 1 nameserver query, got %v", exchanger.queried)
+	}
+
+	clk.Advance(soaMinimum*time.Second - time.Second)
+	if resp := resolveForTest(t, s, "missing.example.test", DNS_Type.A); resp.Header.GetRCODE() != header.NameError {
+		t.Fatalf("expected the cached NXDOMAIN, got %s", resp.Header.GetRCODE())
+	}
+	if len(exchanger.queried) != 1 {
+		t.Fatalf("expected the NXDOMAIN to be answered from the cache, queried %v", exchanger.queried)
+	}
+
+	clk.Advance(2 * time.Second)
+	resolveForTest(t, s, "missing.example.test", DNS_Type.A)
+	if len(exchanger.queried) != 2 {
+		t.Fatalf("expected the NXDOMAIN to expire after the SOA minimum, queried %v", exchanger.queried)
+	}
+}
+
+func TestFakeAuthority_CacheHitKeepsStoredID(t *testing.T) {
+	root := newFakeAuthority(t).a("www.example.test", "192.0.2.80")
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": root.handle}}
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+
+	resolveWithID := func(id [2]byte) *Message.Message {
+		t.Helper()
+		query, err := Message.CreateDNSQuery("www.example.test", DNS_Type.A, DNS_Class.IN, true)
+		if err != nil {
+			t.Fatalf("failed to create query: %v", err)
+		}
+		query.Header.ID = id
+		resp, err := s.resolveRecursively(context.Background(), &query)
+		if err != nil {
+			t.Fatalf("resolveRecursively returned error: %v", err)
+		}
+		return resp
+	}
+
+	first := resolveWithID([2]byte{0x00, 0x01})
+	stored := s.cache.Get(recursiveCacheKey("www.example.test", DNS_Type.A))
+	if stored == nil {
+		t.Fatalf("expected the response to be cached")
+	}
+	storedID := stored.Header.GetMessageID()
+
+	second := resolveWithID([2]byte{0x00, 0x02})
+	third := resolveWithID([2]byte{0x00, 0x03})
+	if len(exchanger.queried) != 1 {
+		t.Fatalf("expected the later queries to be cache hits, queried %v", exchanger.queried)
+	}
+
+	if first.Header.GetMessageID() != 1 || second.Header.GetMessageID() != 2 || third.Header.GetMessageID() != 3 {
+		t.Fatalf("expected every client to get its own ID, got %d, %d and %d",
+			first.Header.GetMessageID(), second.Header.GetMessageID(), third.Header.GetMessageID())
+	}
+	if got := stored.Header.GetMessageID(); got != storedID {
+		t.Fatalf("expected cache hits to leave the stored ID %d unchanged, got %d", storedID, got)
+	}
+	first.Header.SetTC(true)
+	if stored.Header.IsTC() {
+		t.Fatalf("expected the returned response not to share its header with the cache")
+	}
+}
+
+func TestFakeAuthority_ConcurrentCacheHitsKeepTheirIDs(t *testing.T) {
+	const clients = 32
+
+	root := newFakeAuthority(t).a("www.example.test", "192.0.2.80")
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": root.handle}}
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+
+	resolveForTest(t, s, "www.example.test", DNS_Type.A)
+
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(id uint16) {
+			defer wg.Done()
+			query, err := Message.CreateDNSQuery("www.example.test", DNS_Type.A, DNS_Class.IN, true)
+			if err != nil {
+				t.Errorf("failed to create query: %v", err)
+				return
+			}
+			query.Header.ID = [2]byte{byte(id >> 8), byte(id)}
+			resp, err := s.resolveRecursively(context.Background(), &query)
+			if err != nil {
+				t.Errorf("resolveRecursively returned error: %v", err)
+				return
+			}
+			if got := resp.Header.GetMessageID(); got != id {
+				t.Errorf("expected response ID %d, got %d", id, got)
+			}
+		}(uint16(1000 + i)) //nolint:gosec
+	}
+	wg.Wait()
+
+	if len(exchanger.queried) != 1 {
+		t.Fatalf("expected the concurrent queries to be cache hits, queried %v", exchanger.queried)
+	}
+}
+
+func TestFakeAuthority_AAOnlyForAuthoritativeAnswers(t *testing.T) {
+	root := newFakeAuthority(t).
+		a("direct.example.test", "192.0.2.1").
+		cname("alias.example.test", "direct.example.test")
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": root.handle}}
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+
+	if resp := resolveForTest(t, s, "direct.example.test", DNS_Type.A); !resp.Header.IsAA() {
+		t.Fatalf("expected the answer of the authoritative server to keep AA")
+	}
+	if resp := resolveForTest(t, s, "direct.example.test", DNS_Type.A); resp.Header.IsAA() {
+		t.Fatalf("expected the cached answer not to be authoritative")
+	}
+
+	resp := resolveForTest(t, s, "alias.example.test", DNS_Type.A)
+	if len(resp.Answers) != 2 {
+		t.Fatalf("expected the CNAME and the A record, got %v", resp.Answers)
+	}
+	if resp.Header.IsAA() {
+		t.Fatalf("expected the answer assembled from the CNAME chain not to be authoritative")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"log/slog"
+	"net"
+)
+
+// FamilyFilter selects which address records are removed from the answers of forwarded and recursive responses, see
+// WithAddressFamilyFilter.
+type FamilyFilter uint8
+
+const (
+	// FamilyFilterOff keeps every answer.
+	FamilyFilterOff FamilyFilter = iota
+	// FamilyFilterQuestion removes AAAA answers from responses to A queries and A answers from responses to AAAA
+	// queries, which misbehaving upstreams sometimes add.
+	FamilyFilterQuestion
+	// FamilyFilterTransport additionally removes the address answers of the other family than the one the client
+	// connected over, so IPv4 clients only get A and IPv6 clients only get AAAA answers, whatever they asked for.
+	FamilyFilterTransport
+)
+
+// ParseFamilyFilter parses an address family filter, "off", "question" or "transport".
+func ParseFamilyFilter(name string) (FamilyFilter, error) {
+	switch name {
+	case "off":
+		return FamilyFilterOff, nil
+	case "question":
+		return FamilyFilterQuestion, nil
+	case "transport":
+		return FamilyFilterTransport, nil
+	default:
+		return 0, fmt.Errorf("unknown address family filter %q, expected off, question or transport", name)
+	}
+}
+
+// filterAddressFamilies returns a copy of response without the address answers removed by the FamilyFilter configured
+// with WithAddressFamilyFilter. If no answer is removed, response is returned as is.
+func (s *DNSServer) filterAddressFamilies(response *Message.Message, client net.Addr) (*Message.Message, error) {
+	const firstQuestion uint8 = 0
+
+	if s.familyFilter == FamilyFilterOff || len(response.Questions) == 0 {
+		return response, nil
+	}
+
+	var drop map[DNS_Type.Type]bool
+	switch response.Questions[firstQuestion].Type {
+	case DNS_Type.A:
+		drop = map[DNS_Type.Type]bool{DNS_Type.AAAA: true}
+	case DNS_Type.AAAA:
+		drop = map[DNS_Type.Type]bool{DNS_Type.A: true}
+	default:
+		drop = map[DNS_Type.Type]bool{}
+	}
+	if ip := clientIP(client); s.familyFilter == FamilyFilterTransport && ip != nil {
+		if ip.To4() != nil {
+			drop[DNS_Type.AAAA] = true
+		} else {
+			drop[DNS_Type.A] = true
+		}
+	}
+
+	answers := make([]RR.RR, 0, len(response.Answers))
+	for _, answer := range response.Answers {
+		if !drop[answer.Type] {
+			answers = append(answers, answer)
+		}
+	}
+	if len(answers) == len(response.Answers) {
+		return response, nil
+	}
+	s.logger.Debug("Removed answers of the other address family",
+		slog.String("question", response.Questions[firstQuestion].Name),
+		slog.Int("count", len(response.Answers)-len(answers)))
+
+	filtered := *response
+	filtered.Answers = answers
+	if err := filtered.Header.SetANCOUNT(len(filtered.Answers)); err != nil {
+		return nil, fmt.Errorf("failed to set ANCOUNT: %w", err)
+	}
+	return &filtered, nil
+}
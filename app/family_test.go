@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"net"
+	"testing"
+)
+
+// withAAAA returns a stubHandler answering with an A record followed by an AAAA record, whatever the query type.
+func withAAAA(t *testing.T) stubHandler {
+	return func(query Message.Message) Message.Message {
+		resp := answerA(t, "192.0.2.1", 300)(query)
+		aaaa := RR.RR{Name: query.Questions[0].Name, Type: DNS_Type.AAAA, Class: DNS_Class.IN, TTL: 300}
+		aaaa.SetRDATA(net.ParseIP("2001:db8::1"))
+		resp.Answers = append(resp.Answers, aaaa)
+		if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+		return resp
+	}
+}
+
+func TestFamilyFilter_RemovesAAAAFromAResponse(t *testing.T) {
+	stub := startUDPStub(t, withAAAA(t))
+
+	for _, filter := range []FamilyFilter{FamilyFilterOff, FamilyFilterQuestion} {
+		s := newUDPTestServer(t, stub.String())
+		WithAddressFamilyFilter(filter)(s)
+
+		query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, false)
+		if err != nil {
+			t.Fatalf("failed to create query: %v", err)
+		}
+		resp := exchangeUDP(t, s, query)
+
+		want := 2
+		if filter == FamilyFilterQuestion {
+			want = 1
+		}
+		if len(resp.Answers) != want || int(resp.Header.GetANCOUNT()) != want {
+			t.Fatalf("filter %d: expected %d answers, got %d with ANCOUNT %d",
+				filter, want, len(resp.Answers), resp.Header.GetANCOUNT())
+		}
+		if resp.Answers[0].Type != DNS_Type.A {
+			t.Fatalf("filter %d: expected the A answer to be kept, got %s", filter, resp.Answers[0].Type)
+		}
+	}
+}
+
+func TestFamilyFilter_Transport(t *testing.T) {
+	s := newTestServer("127.0.0.1:1")
+	WithAddressFamilyFilter(FamilyFilterTransport)(s)
+
+	query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.ANY, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	response := withAAAA(t)(query)
+
+	tests := []struct {
+		client net.Addr
+		want   DNS_Type.Type
+	}{
+		{client: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10)}, want: DNS_Type.A},
+		{client: &net.TCPAddr{IP: net.ParseIP("2001:db8::10")}, want: DNS_Type.AAAA},
+	}
+	for _, tt := range tests {
+		filtered, err := s.filterAddressFamilies(&response, tt.client)
+		if err != nil {
+			t.Fatalf("filterAddressFamilies returned error: %v", err)
+		}
+		if len(filtered.Answers) != 1 || filtered.Answers[0].Type != tt.want {
+			t.Fatalf("client %s: expected only the %s answer, got %v", tt.client, tt.want, filtered.Answers)
+		}
+	}
+	if len(response.Answers) != 2 {
+		t.Fatalf("expected the original response to be left intact, got %d answers", len(response.Answers))
+	}
+}
+
+func TestParseFamilyFilter(t *testing.T) {
+	for name, want := range map[string]FamilyFilter{
+		"off": FamilyFilterOff, "question": FamilyFilterQuestion, "transport": FamilyFilterTransport,
+	} {
+		if got, err := ParseFamilyFilter(name); err != nil || got != want {
+			t.Errorf("ParseFamilyFilter(%q) = %d, %v, want %d", name, got, err, want)
+		}
+	}
+	if _, err := ParseFamilyFilter("both"); err == nil {
+		t.Error("expected an error for an unknown filter")
+	}
+}
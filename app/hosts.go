@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+)
+
+// BlockStyle is how queries for blocked names are answered.
+type BlockStyle uint8
+
+const (
+	// BlockNXDOMAIN answers blocked names with NXDOMAIN, as if they did not exist.
+	BlockNXDOMAIN BlockStyle = iota
+	// BlockNODATA answers blocked names with NOERROR and no answers, so clients don't cache the absence of the whole
+	// name or fall through to other resolvers.
+	BlockNODATA
+	// BlockSinkhole answers blocked names with the sinkhole address. Queries for other types than the address family of
+	// the sinkhole get NODATA.
+	BlockSinkhole
+)
+
+// BlockResponse configures the answer for blocked names, see WithBlockResponse.
+type BlockResponse struct {
+	// Sinkhole is the address blocked names resolve to with BlockSinkhole.
+	Sinkhole net.IP
+	Style    BlockStyle
+}
+
+// ParseBlockResponse parses a block response style, "nxdomain", "nodata" or a sinkhole IP address.
+func ParseBlockResponse(value string) (BlockResponse, error) {
+	switch value {
+	case "nxdomain":
+		return BlockResponse{Style: BlockNXDOMAIN}, nil
+	case "nodata":
+		return BlockResponse{Style: BlockNODATA}, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return BlockResponse{}, fmt.Errorf("unknown block response %q, expected nxdomain, nodata or an IP address", value)
+	}
+	return BlockResponse{Style: BlockSinkhole, Sinkhole: ip}, nil
+}
+
+// hostsRecordTTL is the TTL of answers from the hosts table.
+const hostsRecordTTL int = 300
+
+// hostsResponse answers query locally if its name is blocked or listed in the hosts table, configured with
+// WithBlockedNames and WithHost. Blocked names get the response configured with WithBlockResponse, NXDOMAIN by default,
+// hosts get their A or AAAA addresses.
+// It returns false if the query has to be resolved normally.
+func (s *DNSServer) hostsResponse(query *Message.Message) (*Message.Message, bool) {
+	const firstQuestion uint8 = 0
+
+	if query == nil || len(query.Questions) == 0 || (s.blocklist == nil && s.hosts == nil) {
+		return nil, false
+	}
+	q := query.Questions[firstQuestion]
+	if q.Class != DNS_Class.IN {
+		return nil, false
+	}
+
+	response := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+	}
+	response.Header.SetQRFlag(true)
+	response.Header.SetAA(true)
+	response.Header.SetRA(true)
+	response.Header.SetRCODE(header.NoError)
+
+	if s.blocklist != nil {
+		if _, blocked := s.blocklist.lookup(q.Name); blocked {
+			switch s.blockResponse.Style {
+			case BlockNODATA:
+			case BlockSinkhole:
+				return s.addressResponse(response, []net.IP{s.blockResponse.Sinkhole})
+			default:
+				response.Header.SetRCODE(header.NameError)
+			}
+			return response, s.addNegativeSOA(response) == nil && finishLocalResponse(response)
+		}
+	}
+
+	if s.hosts == nil {
+		return nil, false
+	}
+	ips, ok := s.hosts.lookup(q.Name)
+	if !ok {
+		return nil, false
+	}
+	return s.addressResponse(response, ips)
+}
+
+// addressResponse adds the addresses matching the question type to the Answer section of response. Without matching
+// addresses response is a NODATA response.
+func (s *DNSServer) addressResponse(response *Message.Message, ips []net.IP) (*Message.Message, bool) {
+	const firstQuestion uint8 = 0
+
+	q := response.Questions[firstQuestion]
+	for _, ip := range ips {
+		answer := RR.RR{}
+		answer.SetName(q.Name)
+		answer.SetClass(DNS_Class.IN)
+		if err := answer.SetTTL(hostsRecordTTL); err != nil {
+			return nil, false
+		}
+		switch {
+		case q.Type == DNS_Type.A && ip.To4() != nil:
+			if err := answer.SetRDATAToARecord(ip); err != nil {
+				return nil, false
+			}
+		case q.Type == DNS_Type.AAAA && ip.To4() == nil && len(ip) == net.IPv6len:
+			answer.SetType(DNS_Type.AAAA)
+			answer.SetRDATA(ip)
+		default:
+			continue
+		}
+		response.Answers = append(response.Answers, answer)
+	}
+	return response, s.addNegativeSOA(response) == nil && finishLocalResponse(response)
+}
+
+// finishLocalResponse sets the section counts of a locally built response, reporting whether it succeeded.
+func finishLocalResponse(response *Message.Message) bool {
+	if err := response.Header.SetANCOUNT(len(response.Answers)); err != nil {
+		return false
+	}
+	if err := response.Header.SetNSCOUNT(len(response.Authority)); err != nil {
+		return false
+	}
+	return response.Header.SetARCOUNT(len(response.Additional)) == nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+	"testing"
+)
+
+func TestHostsAndBlocklistWildcards(t *testing.T) {
+	stub := startUDPStub(t, answerA(t, "192.0.2.99", 300))
+	s := newUDPTestServer(t, stub.String())
+	WithHost("*.lan.example", net.IPv4(10, 0, 0, 1))(s)
+	WithHost("nas.lan.example", net.IPv4(10, 0, 0, 2))(s)
+	WithBlockedNames("*.ads.example", "tracker.example")(s)
+
+	tests := []struct {
+		name      string
+		wantRCODE header.ResponseCode
+		wantIP    string
+	}{
+		{name: "printer.lan.example", wantRCODE: header.NoError, wantIP: "10.0.0.1"},
+		{name: "nas.lan.example", wantRCODE: header.NoError, wantIP: "10.0.0.2"},
+		{name: "banner.ads.example", wantRCODE: header.NameError},
+		{name: "tracker.example", wantRCODE: header.NameError},
+		{name: "sub.tracker.example", wantRCODE: header.NoError, wantIP: "192.0.2.99"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := Message.CreateDNSQuery(tt.name, DNS_Type.A, DNS_Class.IN, false)
+			if err != nil {
+				t.Fatalf("failed to create query: %v", err)
+			}
+
+			resp := exchangeUDP(t, s, query)
+
+			if resp.Header.GetRCODE() != tt.wantRCODE {
+				t.Fatalf("expected RCODE %s, got %s", tt.wantRCODE, resp.Header.GetRCODE())
+			}
+			if tt.wantIP == "" {
+				return
+			}
+			if len(resp.Answers) != 1 {
+				t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+			}
+			ip, err := resp.Answers[0].GetRDATAAsARecord()
+			if err != nil {
+				t.Fatalf("failed to read answer: %v", err)
+			}
+			if !ip.Equal(net.ParseIP(tt.wantIP)) {
+				t.Fatalf("expected answer %s, got %v", tt.wantIP, ip)
+			}
+		})
+	}
+}
+
+func TestBlockedNameNXDOMAINIncludesNegativeSOA(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	WithBlockedNames("tracker.example")(s)
+	WithNegativeSOA(NegativeSOA{MName: "ns.blocked.example", RName: "hostmaster.blocked.example", TTL: 120})(s)
+
+	query, err := Message.CreateDNSQuery("tracker.example", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+
+	resp := exchangeUDP(t, s, query)
+
+	if resp.Header.GetRCODE() != header.NameError {
+		t.Fatalf("expected NXDOMAIN, got %s", resp.Header.GetRCODE())
+	}
+	if len(resp.Authority) != 1 || resp.Header.GetNSCOUNT() != 1 {
+		t.Fatalf("expected one Authority record, got %d with NSCOUNT %d", len(resp.Authority), resp.Header.GetNSCOUNT())
+	}
+	soa := resp.Authority[0]
+	if soa.Type != DNS_Type.SOA || soa.GetTTL() != 120 {
+		t.Fatalf("expected an SOA record with TTL 120, got type %d with TTL %d", soa.Type, soa.GetTTL())
+	}
+	mname, rname, _, _, _, _, minimum, err := soa.GetRDATAAsSOARecord()
+	if err != nil {
+		t.Fatalf("failed to read SOA: %v", err)
+	}
+	if mname != "ns.blocked.example" || rname != "hostmaster.blocked.example" || minimum != 120 {
+		t.Fatalf("unexpected SOA %s %s minimum %d", mname, rname, minimum)
+	}
+}
+
+func TestBlockedNameNXDOMAINWithoutNegativeSOA(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	WithBlockedNames("tracker.example")(s)
+
+	query, err := Message.CreateDNSQuery("tracker.example", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+
+	resp := exchangeUDP(t, s, query)
+
+	if resp.Header.GetRCODE() != header.NameError {
+		t.Fatalf("expected NXDOMAIN, got %s", resp.Header.GetRCODE())
+	}
+	if len(resp.Authority) != 0 {
+		t.Fatalf("expected no Authority records, got %d", len(resp.Authority))
+	}
+}
+
+func TestBlockResponseStyles(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    BlockResponse
+		qtype       DNS_Type.Type
+		wantRCODE   header.ResponseCode
+		wantAnswer  net.IP
+		wantAnswers int
+	}{
+		{name: "nxdomain", response: BlockResponse{Style: BlockNXDOMAIN}, qtype: DNS_Type.A, wantRCODE: header.NameError},
+		{name: "nodata", response: BlockResponse{Style: BlockNODATA}, qtype: DNS_Type.A, wantRCODE: header.NoError},
+		{
+			name:        "sinkhole",
+			response:    BlockResponse{Style: BlockSinkhole, Sinkhole: net.IPv4(0, 0, 0, 0)},
+			qtype:       DNS_Type.A,
+			wantRCODE:   header.NoError,
+			wantAnswer:  net.IPv4(0, 0, 0, 0),
+			wantAnswers: 1,
+		},
+		{
+			name:      "sinkhole other family",
+			response:  BlockResponse{Style: BlockSinkhole, Sinkhole: net.IPv4(0, 0, 0, 0)},
+			qtype:     DNS_Type.AAAA,
+			wantRCODE: header.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUDPTestServer(t, "127.0.0.1:1")
+			WithBlockedNames("tracker.example")(s)
+			WithBlockResponse(tt.response)(s)
+			WithNegativeSOA(NegativeSOA{})(s)
+
+			query, err := Message.CreateDNSQuery("tracker.example", tt.qtype, DNS_Class.IN, false)
+			if err != nil {
+				t.Fatalf("failed to create query: %v", err)
+			}
+
+			resp := exchangeUDP(t, s, query)
+
+			if resp.Header.GetRCODE() != tt.wantRCODE {
+				t.Fatalf("expected RCODE %s, got %s", tt.wantRCODE, resp.Header.GetRCODE())
+			}
+			if len(resp.Answers) != tt.wantAnswers {
+				t.Fatalf("expected %d answers, got %d", tt.wantAnswers, len(resp.Answers))
+			}
+			if tt.wantAnswers == 0 {
+				if len(resp.Authority) != 1 || resp.Authority[0].Type != DNS_Type.SOA {
+					t.Fatalf("expected a negative SOA in the Authority section, got %d records", len(resp.Authority))
+				}
+				return
+			}
+			ip, err := resp.Answers[0].GetRDATAAsARecord()
+			if err != nil {
+				t.Fatalf("failed to read answer: %v", err)
+			}
+			if !ip.Equal(tt.wantAnswer) {
+				t.Fatalf("expected answer %v, got %v", tt.wantAnswer, ip)
+			}
+		})
+	}
+}
+
+func TestParseBlockResponse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    BlockStyle
+		wantErr bool
+	}{
+		{value: "nxdomain", want: BlockNXDOMAIN},
+		{value: "nodata", want: BlockNODATA},
+		{value: "::", want: BlockSinkhole},
+		{value: "refused", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBlockResponse(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseBlockResponse(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if err == nil && got.Style != tt.want {
+			t.Fatalf("ParseBlockResponse(%q) = %d, want %d", tt.value, got.Style, tt.want)
+		}
+	}
+}
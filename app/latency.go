@@ -0,0 +1,96 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWeight is the weight of a new sample in the exponentially weighted moving average.
+	latencyWeight = 0.3
+	// latencyFailurePenalty is recorded for queries which failed, so broken servers sink to the back.
+	latencyFailurePenalty = 5 * time.Second
+)
+
+// latencyTracker keeps an exponentially weighted moving average of the response time of every upstream resolver and
+// nameserver the server talks to, keyed by "host:port" address. A nil *latencyTracker tracks nothing.
+type latencyTracker struct {
+	ewma map[string]time.Duration
+	mu   sync.RWMutex
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{ewma: make(map[string]time.Duration)}
+}
+
+// observe records a response time sample for addr.
+func (l *latencyTracker) observe(addr string, sample time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.ewma[addr]
+	if !ok {
+		l.ewma[addr] = sample
+		return
+	}
+	l.ewma[addr] = time.Duration(latencyWeight*float64(sample) + (1-latencyWeight)*float64(current))
+}
+
+// observeResult records how long a query to addr which started at start took, or the failure penalty if it failed.
+func (l *latencyTracker) observeResult(addr string, start time.Time, err error) {
+	if err != nil {
+		l.observe(addr, latencyFailurePenalty)
+		return
+	}
+	l.observe(addr, time.Since(start))
+}
+
+// latency returns the average response time of addr. Servers without samples are reported as 0, so that they are
+// tried before servers which are known to be slow.
+func (l *latencyTracker) latency(addr string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.ewma[addr]
+}
+
+// snapshot returns a copy of the averages.
+func (l *latencyTracker) snapshot() map[string]time.Duration {
+	if l == nil {
+		return map[string]time.Duration{}
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	snapshot := make(map[string]time.Duration, len(l.ewma))
+	for addr, avg := range l.ewma {
+		snapshot[addr] = avg
+	}
+	return snapshot
+}
+
+// sortAddrs returns a copy of addrs ordered from the fastest to the slowest. The order of servers with equal latency
+// is kept.
+func (l *latencyTracker) sortAddrs(addrs []string) []string {
+	sorted := slices.Clone(addrs)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		return cmp.Compare(l.latency(a), l.latency(b))
+	})
+	return sorted
+}
+
+// orderNameservers returns a copy of servers ordered from the fastest to the slowest nameserver.
+func (s *DNSServer) orderNameservers(servers []RootServer) []RootServer {
+	sorted := slices.Clone(servers)
+	slices.SortStableFunc(sorted, func(a, b RootServer) int {
+		return cmp.Compare(s.latency.latency(s.nameserverAddr(a.IP)), s.latency.latency(s.nameserverAddr(b.IP)))
+	})
+	return sorted
+}
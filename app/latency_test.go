@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker_SortAddrs(t *testing.T) {
+	l := newLatencyTracker()
+	l.observe("slow:53", 200*time.Millisecond)
+	l.observe("fast:53", 10*time.Millisecond)
+
+	sorted := l.sortAddrs([]string{"slow:53", "fast:53", "unknown:53"})
+	if sorted[0] != "unknown:53" || sorted[1] != "fast:53" || sorted[2] != "slow:53" {
+		t.Fatalf("expected unknown, fast, slow order, got %v", sorted)
+	}
+
+	l.observe("fast:53", latencyFailurePenalty)
+	if avg := l.latency("fast:53"); avg <= 10*time.Millisecond || avg >= latencyFailurePenalty {
+		t.Fatalf("expected a failure to raise the moving average without replacing it, got %v", avg)
+	}
+}
+
+func TestRecursion_PrefersFasterNameserver(t *testing.T) {
+	authoritative := func(ip string, delay time.Duration) stubHandler {
+		return func(query Message.Message) Message.Message {
+			time.Sleep(delay)
+			resp := answerA(t, ip, 300)(query)
+			resp.Header.SetAA(true)
+			return resp
+		}
+	}
+
+	fast := startUDPStubAt(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, authoritative("192.0.2.1", 0))
+	slow := startUDPStubAt(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: fast.Port},
+		authoritative("192.0.2.2", 100*time.Millisecond))
+
+	s := newTestServer("127.0.0.1:0")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.latency = newLatencyTracker()
+	s.nameserverPort = fast.Port
+	s.rootServers = []RootServer{{Name: "slow.test", IP: slow.IP}, {Name: "fast.test", IP: fast.IP}}
+
+	var last net.IP
+	for i := range 5 {
+		query, err := Message.CreateDNSQuery(fmt.Sprintf("host%d.example.com", i), DNS_Type.A, DNS_Class.IN, true)
+		if err != nil {
+			t.Fatalf("failed to create query: %v", err)
+		}
+		resp, err := s.resolveRecursively(context.Background(), &query)
+		if err != nil {
+			t.Fatalf("resolveRecursively returned error: %v", err)
+		}
+		if len(resp.Answers) != 1 {
+			t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+		}
+		last, err = resp.Answers[0].GetRDATAAsARecord()
+		if err != nil {
+			t.Fatalf("failed to read answer: %v", err)
+		}
+	}
+
+	if !last.Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("expected the faster nameserver to answer after warm-up, got %v", last)
+	}
+
+	latency := s.Stats().Latency
+	fastAvg, fastOK := latency[fast.String()]
+	slowAvg, slowOK := latency[slow.String()]
+	if !fastOK || !slowOK || fastAvg >= slowAvg {
+		t.Fatalf("expected stats for both nameservers with the fast one ahead, got %v", latency)
+	}
+}
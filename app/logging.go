@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// LogFormat selects the output format of the logger New creates when it is not given one.
+type LogFormat uint8
+
+const (
+	// LogFormatText logs human-readable key=value lines.
+	LogFormatText LogFormat = iota
+	// LogFormatJSON logs one JSON object per line, for log pipelines.
+	LogFormatJSON
+)
+
+// ParseLogFormat parses the name of a log format, "text" or "json".
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch name {
+	case "text":
+		return LogFormatText, nil
+	case "json":
+		return LogFormatJSON, nil
+	default:
+		return 0, fmt.Errorf("unknown log format %q, expected text or json", name)
+	}
+}
+
+// logConfig configures the logger New creates when it is not given one.
+type logConfig struct {
+	output io.Writer
+	level  slog.Level
+	format LogFormat
+}
+
+// newLogger creates a logger writing to the configured output in the configured format.
+func (c logConfig) newLogger() *slog.Logger {
+	options := &slog.HandlerOptions{
+		AddSource:   false,
+		Level:       c.level,
+		ReplaceAttr: nil,
+	}
+	if c.format == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(c.output, options))
+	}
+	return slog.New(slog.NewTextHandler(c.output, options))
+}
+
+// logSuccess logs the info message of a successfully answered query. With sampling configured by WithLogSampling only
+// one in every N such messages is logged. Errors are always logged directly and are not subject to sampling.
+func (s *DNSServer) logSuccess(msg string, args ...any) {
+	if !s.logger.Enabled(context.Background(), slog.LevelInfo) {
+		return
+	}
+	if s.successLogSampling > 1 && s.successLogs.Add(1)%s.successLogSampling != 1 {
+		return
+	}
+	s.logger.Info(msg, args...)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, as the server logs from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestNew_JSONLogFormat(t *testing.T) {
+	out := &syncBuffer{}
+	s, cleanup, err := New("127.0.0.1:0", "127.0.0.1:53", false, nil,
+		WithLogFormat(LogFormatJSON),
+		WithLogLevel(slog.LevelDebug),
+		WithLogOutput(out),
+	)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	runServer(t, s, cleanup)
+
+	select {
+	case <-s.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not become ready")
+	}
+
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("expected every log line to be JSON, got %q: %v", scanner.Text(), err)
+		}
+		for _, field := range []string{"time", "level", "msg"} {
+			if _, ok := entry[field]; !ok {
+				t.Fatalf("expected log entry to contain %q, got %v", field, entry)
+			}
+		}
+		if entry["msg"] == "TCP listener started" {
+			found = true
+			if _, ok := entry["listener"]; !ok {
+				t.Fatalf("expected the listener attribute in %v", entry)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected the TCP listener start to be logged, got %q", out.String())
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	if format, err := ParseLogFormat("json"); err != nil || format != LogFormatJSON {
+		t.Fatalf("expected json to parse as LogFormatJSON, got %v, %v", format, err)
+	}
+	if _, err := ParseLogFormat("xml"); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
+
+func TestLogSampling_LogsFractionOfSuccesses(t *testing.T) {
+	const queries = 8
+	const sampling = 4
+
+	stub := startUDPStub(t, answerA(t, "192.0.2.1", 300))
+	s := newUDPTestServer(t, stub.String())
+	out := &syncBuffer{}
+	s.logger = slog.New(slog.NewTextHandler(out, nil))
+	WithLogSampling(sampling)(s)
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	for range queries {
+		exchangeUDP(t, s, query)
+	}
+	s.wg.Wait()
+
+	if logged := strings.Count(out.String(), "Sent forwarded response"); logged != queries/sampling {
+		t.Fatalf("expected %d of %d successes to be logged, got %d", queries/sampling, queries, logged)
+	}
+}
@@ -3,15 +3,60 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
 	"log"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"strings"
 )
 
 func main() {
 	resolverAddr := flag.String("resolver", "", "Address of the DNS resolver to forward queries to")
 	servingAddress := flag.String("address", "127.0.0.1:2053", "Address of the DNS server")
+	upstreamTCP := flag.Bool("upstream-tcp", false, "Forward every query to the resolver over TCP instead of UDP")
+	sharedUpstream := flag.Bool("shared-upstream-socket", false, "Forward UDP queries to the resolver over a single socket")
 	recursive := flag.Bool("recursive", false, "Recursively resolve DNS records")
+	minimalResponses := flag.Bool("minimal-responses", false, "Omit optional Additional records from recursive responses")
+	normalizeNames := flag.Bool("normalize-names", false, "Lowercase names of recursive queries and nameserver responses before resolving and caching")
+	synthesizePTR := flag.Bool("synthesize-ptr", false, "Answer reverse lookups of recently resolved addresses from the cache")
+	stripAdditional := flag.Bool("strip-additional", false, "Omit all Additional records from forwarded and recursive responses")
+	familyFilter := flag.String("family-filter", "off", "Remove address answers of the other family, off, question or transport")
+	ttlFloor := flag.Uint("ttl-floor", 0, "Minimum TTL in seconds of answers in forwarded and recursive responses")
+	bootstrapTimeout := flag.Duration("bootstrap-timeout", defaultBootstrapTimeout, "Time limit of every attempt to bootstrap the root servers")
+	cacheMaxTTL := flag.Duration("cache-max-ttl", cache.DefaultMaxTTL, "Longest time anything is cached, 0 to honor the full record TTLs")
+	udpBufferSize := flag.Int("udp-buffer-size", 0, "Receive and send buffer size in bytes of the UDP socket, 0 for the system default")
+	localRoot := flag.Bool("local-root", false, "Answer root zone NS queries from the root hints instead of forwarding them")
+	logFormat := flag.String("log-format", "text", "Log output format, text or json")
+	logLevel := flag.String("log-level", "info", "Minimum log level, one of debug, info, warn or error")
+	zoneFile := flag.String("zone", "", "Zone file to serve authoritatively")
+	zoneOrigin := flag.String("zone-origin", "", "Origin of the zone in the -zone file")
+	hostsFile := flag.String("hosts", "", "Hosts file with local answers, an address followed by names on every line")
+	answersFile := flag.String("answers", "", "JSON file mapping names to the records they are answered with")
+	blocklistFile := flag.String("blocklist", "", "File with one name to block per line")
+	blockResponse := flag.String("block-response", "nxdomain", "Answer for blocked names, nxdomain, nodata or a sinkhole IP address")
+	rebindingProtection := flag.Bool("rebinding-protection", false, "Reject answers resolving names to private, loopback or link-local addresses")
+	rebindingAllow := flag.String("rebinding-allow", "", "Comma-separated names allowed to resolve to private addresses, with -rebinding-protection")
+	noCache := flag.String("no-cache", "", "Comma-separated names resolved on every query instead of being cached")
+	recursionAllow := flag.String("recursion-allow", "", "Comma-separated networks allowed to use recursion and forwarding, everyone if empty")
+	check := flag.Bool("check", false, "Validate the zone, hosts, blocklist and answers files and exit")
 	flag.Parse()
 
+	files := ConfigFiles{Zone: *zoneFile, ZoneOrigin: *zoneOrigin, Hosts: *hostsFile, Blocklist: *blocklistFile, Answers: *answersFile}
+	fileOpts, err := files.Load()
+	if *check {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration files are valid")
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	if *resolverAddr == "" {
 		log.Fatalln("Resolver address is required. Use -resolver flag.")
 	}
@@ -19,9 +64,69 @@ func main() {
 		log.Fatalln("Server address is required. Use -address flag.")
 	}
 
+	var recursionACL []*net.IPNet
+	for _, cidr := range strings.FieldsFunc(*recursionAllow, func(r rune) bool { return r == ',' }) {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		recursionACL = append(recursionACL, network)
+	}
+
+	block, err := ParseBlockResponse(*blockResponse)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	family, err := ParseFamilyFilter(*familyFilter)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *ttlFloor > math.MaxUint32 {
+		log.Fatalf("TTL floor %d is larger than the largest TTL of %d seconds", *ttlFloor, uint32(math.MaxUint32))
+	}
+
+	format, err := ParseLogFormat(*logFormat)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalln(err)
+	}
+
 	fmt.Println("Starting DNS forwarder with resolver:", *resolverAddr)
 
-	dns, closeCon, err := New(*servingAddress, *resolverAddr, *recursive, nil)
+	opts := append([]Option{
+		WithMinimalResponses(*minimalResponses),
+		WithStripAdditional(*stripAdditional),
+		WithSharedUpstreamSocket(*sharedUpstream),
+		WithAddressFamilyFilter(family),
+		WithSynthesizedPTR(*synthesizePTR),
+		WithNameNormalization(*normalizeNames),
+		WithRecursionACL(recursionACL...),
+		WithBlockResponse(block),
+		WithTTLFloor(uint32(*ttlFloor)),
+		WithBootstrapTimeout(*bootstrapTimeout),
+		WithCacheMaxTTL(*cacheMaxTTL),
+		WithUDPBufferSize(*udpBufferSize),
+		WithNoCacheNames(strings.FieldsFunc(*noCache, func(r rune) bool { return r == ',' })...),
+		WithLogFormat(format),
+		WithLogLevel(level),
+	}, fileOpts...)
+	if *rebindingProtection {
+		opts = append(opts, WithRebindingProtection(RebindingProtection{
+			Allowed: strings.FieldsFunc(*rebindingAllow, func(r rune) bool { return r == ',' }),
+		}))
+	}
+	if *upstreamTCP {
+		opts = append(opts, WithUpstreamProtocol(UpstreamTCP))
+	}
+	if *localRoot {
+		opts = append(opts, WithLocalRootZone(RootZone{}))
+	}
+	dns, closeCon, err := New(*servingAddress, *resolverAddr, *recursive, nil, opts...)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"strings"
+)
+
+// nameTrie maps domain names to values. Names are stored as a trie of their labels in reverse order, so that all
+// names sharing a suffix share a path, and entries can be either exact names or suffix wildcards like "*.example.com".
+// Lookups prefer an exact entry for the name, then the wildcard of the longest matching suffix.
+type nameTrie[V any] struct {
+	root trieNode[V]
+}
+
+type trieNode[V any] struct {
+	children    map[string]*trieNode[V]
+	exact       V
+	wildcard    V
+	hasExact    bool
+	hasWildcard bool
+}
+
+// reversedLabels returns the lowercase labels of domain from the top-level domain down.
+func reversedLabels(domain string) []string {
+	domain = name.Canonicalize(domain)
+	if domain == "" {
+		return nil
+	}
+	labels := strings.Split(domain, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels
+}
+
+// insert adds pattern to the trie. A pattern starting with "*." matches every name below the rest of the pattern,
+// but not the name itself. Unicode labels are stored as A-labels, the form names have in queries.
+func (t *nameTrie[V]) insert(pattern string, value V) {
+	wildcard := strings.HasPrefix(pattern, "*.")
+	if wildcard {
+		pattern = strings.TrimPrefix(pattern, "*.")
+	}
+	if ascii, err := name.ToASCII(pattern); err == nil {
+		pattern = ascii
+	}
+
+	node := &t.root
+	for _, label := range reversedLabels(pattern) {
+		if node.children == nil {
+			node.children = make(map[string]*trieNode[V])
+		}
+		child, ok := node.children[label]
+		if !ok {
+			child = &trieNode[V]{}
+			node.children[label] = child
+		}
+		node = child
+	}
+
+	if wildcard {
+		node.wildcard, node.hasWildcard = value, true
+	} else {
+		node.exact, node.hasExact = value, true
+	}
+}
+
+// lookup returns the value of the exact entry for name, or of the wildcard entry with the longest suffix of name.
+func (t *nameTrie[V]) lookup(name string) (V, bool) {
+	var match V
+	found := false
+
+	node := &t.root
+	for _, label := range reversedLabels(name) {
+		if node.hasWildcard {
+			match, found = node.wildcard, true
+		}
+		next, ok := node.children[label]
+		if !ok {
+			return match, found
+		}
+		node = next
+	}
+
+	if node.hasExact {
+		return node.exact, true
+	}
+	return match, found
+}
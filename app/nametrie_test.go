@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNameTrie_OverlappingExactAndWildcard(t *testing.T) {
+	trie := &nameTrie[string]{}
+	trie.insert("*.example.com", "wildcard")
+	trie.insert("*.corp.example.com", "corp wildcard")
+	trie.insert("www.example.com", "exact")
+	trie.insert("vpn.corp.example.com", "corp exact")
+
+	tests := []struct {
+		name  string
+		want  string
+		found bool
+	}{
+		{name: "www.example.com", want: "exact", found: true},
+		{name: "WWW.Example.com.", want: "exact", found: true},
+		{name: "mail.example.com", want: "wildcard", found: true},
+		{name: "a.b.example.com", want: "wildcard", found: true},
+		{name: "sub.www.example.com", want: "wildcard", found: true},
+		{name: "host.corp.example.com", want: "corp wildcard", found: true},
+		{name: "vpn.corp.example.com", want: "corp exact", found: true},
+		{name: "corp.example.com", want: "wildcard", found: true},
+		{name: "example.com", found: false},
+		{name: "example.org", found: false},
+	}
+	for _, tt := range tests {
+		got, found := trie.lookup(tt.name)
+		if found != tt.found || got != tt.want {
+			t.Errorf("lookup(%q) = %q, %v, want %q, %v", tt.name, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestBlocklist_MatchesInternationalizedNames(t *testing.T) {
+	names, err := parseBlocklist(strings.NewReader("café.example\n*.bücher.example\n"))
+	if err != nil {
+		t.Fatalf("failed to parse blocklist: %v", err)
+	}
+	s := newTestServer("127.0.0.1:0")
+	WithBlockedNames(names...)(s)
+
+	tests := []struct {
+		name    string
+		blocked bool
+	}{
+		{name: "xn--caf-dma.example", blocked: true},
+		{name: "XN--CAF-DMA.example.", blocked: true},
+		{name: "shop.xn--bcher-kva.example", blocked: true},
+		{name: "cafe.example", blocked: false},
+		{name: "xn--bcher-kva.example", blocked: false},
+	}
+	for _, tt := range tests {
+		if _, blocked := s.blocklist.lookup(tt.name); blocked != tt.blocked {
+			t.Errorf("lookup(%q) blocked = %v, want %v", tt.name, blocked, tt.blocked)
+		}
+	}
+}
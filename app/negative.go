@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"time"
+)
+
+// NegativeSOA is the SOA record added to the Authority section of negative responses the server synthesizes itself,
+// so clients can cache them (https://datatracker.ietf.org/doc/html/rfc2308#section-3).
+type NegativeSOA struct {
+	// MName and RName are the primary nameserver and the responsible mailbox of the SOA record.
+	MName string
+	RName string
+	// TTL is both the TTL and the MINIMUM field of the record, which together bound how long clients cache the
+	// negative answer.
+	TTL uint32
+}
+
+// Negative SOA values used when the fields are left empty.
+const (
+	defaultNegativeSOAMName = "localhost"
+	defaultNegativeSOARName = "nobody.invalid"
+	defaultNegativeSOATTL   = 300
+)
+
+// SOA timers of the synthesized record, which does not belong to any real zone.
+const (
+	negativeSOASerial  uint32 = 1
+	negativeSOARefresh uint32 = 3600
+	negativeSOARetry   uint32 = 600
+	negativeSOAExpire  uint32 = 86400
+)
+
+// addNegativeSOA adds the SOA configured with WithNegativeSOA to the Authority section of a locally synthesized
+// response, if it is negative: NXDOMAIN, or NOERROR without answers. The record is owned by the question name.
+func (s *DNSServer) addNegativeSOA(response *Message.Message) error {
+	const firstQuestion uint8 = 0
+
+	if s.negativeSOA == nil || len(response.Answers) > 0 || len(response.Questions) == 0 {
+		return nil
+	}
+
+	config := *s.negativeSOA
+	if config.MName == "" {
+		config.MName = defaultNegativeSOAMName
+	}
+	if config.RName == "" {
+		config.RName = defaultNegativeSOARName
+	}
+	if config.TTL == 0 {
+		config.TTL = defaultNegativeSOATTL
+	}
+
+	soa := RR.RR{}
+	soa.SetName(response.Questions[firstQuestion].Name)
+	soa.SetClass(DNS_Class.IN)
+	soa.TTL = config.TTL
+	err := soa.SetRDATAToSOARecord(config.MName, config.RName, negativeSOASerial, negativeSOARefresh, negativeSOARetry,
+		negativeSOAExpire, config.TTL)
+	if err != nil {
+		return fmt.Errorf("failed to create negative SOA record: %w", err)
+	}
+
+	response.Authority = append(response.Authority, soa)
+	return response.Header.SetNSCOUNT(len(response.Authority))
+}
+
+// negativeCacheTTL returns how long a negative response, NXDOMAIN or NOERROR without answers, may be cached: the
+// smaller of the TTL and the MINIMUM field of the SOA record in its Authority section
+// (https://datatracker.ietf.org/doc/html/rfc2308#section-5). It returns false for positive responses and responses
+// without an SOA record.
+func negativeCacheTTL(response *Message.Message) (time.Duration, bool) {
+	if len(response.Answers) > 0 {
+		return 0, false
+	}
+	for _, auth := range response.Authority {
+		if auth.Type != DNS_Type.SOA {
+			continue
+		}
+		_, _, _, _, _, _, minimum, err := auth.GetRDATAAsSOARecord()
+		if err != nil {
+			continue
+		}
+		return time.Duration(min(auth.GetTTL(), minimum)) * time.Second, true
+	}
+	return 0, false
+}
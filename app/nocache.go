@@ -0,0 +1,11 @@
+package main
+
+// cacheable reports whether responses for domain may be cached, that is whether it was not listed with
+// WithNoCacheNames.
+func (s *DNSServer) cacheable(domain string) bool {
+	if s.noCache == nil {
+		return true
+	}
+	_, listed := s.noCache.lookup(domain)
+	return !listed
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"net"
+	"testing"
+)
+
+func TestNoCacheNames_AlwaysResolved(t *testing.T) {
+	root := newFakeAuthority(t).
+		a("dynamic.example.test", "192.0.2.1").
+		a("static.example.test", "192.0.2.2")
+	exchanger := &scriptedExchanger{servers: map[string]stubHandler{"198.41.0.4": root.handle}}
+	s := newTestServer("192.0.2.53:53")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithExchanger(exchanger)(s)
+	WithNoCacheNames("dynamic.example.test")(s)
+
+	for range 2 {
+		resolveForTest(t, s, "dynamic.example.test", DNS_Type.A)
+	}
+	if got := len(exchanger.queried); got != 2 {
+		t.Fatalf("expected the listed name to be resolved on every query, got %d queries", got)
+	}
+
+	for range 2 {
+		resolveForTest(t, s, "static.example.test", DNS_Type.A)
+	}
+	if got := len(exchanger.queried); got != 3 {
+		t.Fatalf("expected the other name to be answered from the cache the second time, got %d queries", got-2)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/clock"
+	"github.com/blazskufca/dns_server_in_go/internal/edns"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"github.com/blazskufca/dns_server_in_go/internal/tsig"
+	"github.com/blazskufca/dns_server_in_go/internal/zone"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Option configures optional behaviour of a DNSServer created with New.
+type Option func(*DNSServer)
+
+// WithMinimalResponses makes the server omit optional Additional records (such as glue) from recursive responses,
+// keeping responses small.
+func WithMinimalResponses(enabled bool) Option {
+	return func(s *DNSServer) {
+		s.minimalResponses = enabled
+	}
+}
+
+// WithBestEffortQuestions makes the server answer queries of which only some of the questions announced by QDCOUNT can be
+// parsed, using the questions before the first one that failed. By default such queries are rejected with FORMERR.
+// The discrepancy is logged in both modes.
+func WithBestEffortQuestions(enabled bool) Option {
+	return func(s *DNSServer) {
+		s.bestEffortQuestions = enabled
+	}
+}
+
+// WithNameNormalization makes the recursive resolver lowercase the names of queries and nameserver responses before
+// resolving and caching them, so that names differing only in case (https://datatracker.ietf.org/doc/html/rfc4343)
+// share cache entries and CNAME chains. Responses still echo the question exactly as the client sent it.
+func WithNameNormalization(enabled bool) Option {
+	return func(s *DNSServer) {
+		s.normalizeNames = enabled
+	}
+}
+
+// WithSynthesizedPTR makes the recursive resolver answer PTR queries for addresses it recently resolved from the cache,
+// with the owner name of the cached A or AAAA record, instead of resolving the reverse name.
+func WithSynthesizedPTR(enabled bool) Option {
+	return func(s *DNSServer) {
+		s.synthesizePTR = enabled
+	}
+}
+
+// WithStripAdditional makes the server drop every Additional record except the OPT record from forwarded and
+// recursive responses, so clients learn no more than the answer they asked for.
+func WithStripAdditional(enabled bool) Option {
+	return func(s *DNSServer) {
+		s.stripAdditionalRecords = enabled
+	}
+}
+
+// WithAddressFamilyFilter removes address answers of the other family from forwarded and recursive responses, as
+// selected by filter. The default is FamilyFilterOff.
+func WithAddressFamilyFilter(filter FamilyFilter) Option {
+	return func(s *DNSServer) {
+		s.familyFilter = filter
+	}
+}
+
+// WithSigningKey makes the server sign every response with an HMAC using key, so that clients sharing the key can
+// verify a response really came from this server. See the signing package for the format.
+func WithSigningKey(key []byte) Option {
+	return func(s *DNSServer) {
+		s.signingKey = key
+	}
+}
+
+// WithZone makes the server authoritative for z. Queries for names inside the zone are answered from it, and
+// dynamic updates received over TCP are applied to it.
+func WithZone(z *zone.Zone) Option {
+	return func(s *DNSServer) {
+		s.zone = z
+	}
+}
+
+// WithStaticAnswers answers queries for the names in answers authoritatively from their records, before resolving
+// them. Unlike with WithZone, names without static records are resolved as usual.
+func WithStaticAnswers(answers *zone.Zone) Option {
+	return func(s *DNSServer) {
+		s.staticAnswers = answers
+	}
+}
+
+// WithUpdateKey allows dynamic updates signed with the TSIG key.
+func WithUpdateKey(key tsig.Key) Option {
+	return func(s *DNSServer) {
+		s.updateKey = &key
+	}
+}
+
+// WithUpdateACL allows dynamic updates from clients inside any of the networks, even if they are not signed.
+func WithUpdateACL(networks ...*net.IPNet) Option {
+	return func(s *DNSServer) {
+		s.updateACL = append(s.updateACL, networks...)
+	}
+}
+
+// WithRecursionACL limits recursion and forwarding to clients inside any of the networks. Other clients are refused
+// unless their query is answered locally, from the zone, the hosts table or the special-use names. Without networks
+// everyone is served.
+func WithRecursionACL(networks ...*net.IPNet) Option {
+	return func(s *DNSServer) {
+		s.recursionACL = append(s.recursionACL, networks...)
+	}
+}
+
+// WithSpecialNames toggles answering RFC 6761 special-use names (localhost, the loopback reverse names and invalid)
+// locally. It is enabled by default.
+func WithSpecialNames(enabled bool) Option {
+	return func(s *DNSServer) {
+		s.specialNames = enabled
+	}
+}
+
+// WithTTLFloor raises the TTL of answers in forwarded and recursive responses to at least floor seconds, before they
+// are cached and sent to the client. This trades freshness for fewer upstream queries. A floor of 0 disables it.
+func WithTTLFloor(floor uint32) Option {
+	return func(s *DNSServer) {
+		s.ttlFloor = floor
+	}
+}
+
+// WithMaxOutboundQueries limits the number of queries sent concurrently to upstream resolvers and nameservers, across
+// all in-flight resolutions. Further queries wait for a slot to free up. The default limit is 256.
+func WithMaxOutboundQueries(limit int) Option {
+	return func(s *DNSServer) {
+		if limit > 0 {
+			s.outbound = make(chan struct{}, limit)
+		}
+	}
+}
+
+// WithQueryBudget bounds the total time spent resolving a single client query, across all delegation hops and the
+// fallback to the upstream resolver. Once it is used up the client gets SERVFAIL. The default budget is 5 seconds and
+// a budget of 0 disables it, leaving only the per-hop timeouts.
+func WithQueryBudget(budget time.Duration) Option {
+	return func(s *DNSServer) {
+		s.queryBudget = budget
+	}
+}
+
+// WithRootHints replaces the built-in root hints, the root servers used for recursion when they cannot be bootstrapped
+// from the upstream resolver.
+func WithRootHints(servers ...RootServer) Option {
+	return func(s *DNSServer) {
+		s.rootHints = servers
+	}
+}
+
+// WithClock makes the server tell time with clk instead of the system clock, for cache expiry, upstream backoff and the
+// signing times of responses. Network timeouts always use the system clock.
+func WithClock(clk clock.Clock) Option {
+	return func(s *DNSServer) {
+		s.clock = clk
+	}
+}
+
+// WithCacheMaxTTL caps how long responses and RRsets are cached at maxTTL instead of cache.DefaultMaxTTL. Zero disables
+// the cap, so the full TTL of every record is honored.
+func WithCacheMaxTTL(maxTTL time.Duration) Option {
+	return func(s *DNSServer) {
+		s.cacheMaxTTL = maxTTL
+	}
+}
+
+// WithLocalRootZone answers NS and SOA queries for the root zone from a local copy of its metadata instead of forwarding
+// or resolving them. The NS set defaults to the root hints, the SOA is only answered if configured.
+func WithLocalRootZone(zone RootZone) Option {
+	return func(s *DNSServer) {
+		s.localRoot = &zone
+	}
+}
+
+// WithBootstrapTimeout limits how long every attempt to bootstrap the root servers from the upstream resolver may take,
+// including the TCP retry of a truncated response. Zero disables the limit, leaving only the per-query timeouts.
+func WithBootstrapTimeout(timeout time.Duration) Option {
+	return func(s *DNSServer) {
+		s.bootstrapTimeout = timeout
+	}
+}
+
+// WithStubZone makes recursive resolution of names inside zone start from the given nameservers instead of the root
+// servers, so the zone can be served by specific authoritative servers without being delegated to them. Names inside
+// several stub zones use the most specific one. Without nameservers the option is ignored.
+func WithStubZone(zone string, nameservers ...net.IP) Option {
+	return func(s *DNSServer) {
+		if len(nameservers) == 0 {
+			return
+		}
+		if s.stubZones == nil {
+			s.stubZones = make(map[string][]RootServer)
+		}
+		servers := make([]RootServer, 0, len(nameservers))
+		for _, ip := range nameservers {
+			servers = append(servers, RootServer{Name: ip.String(), IP: ip})
+		}
+		s.stubZones[name.Canonicalize(zone)] = servers
+	}
+}
+
+// WithResponsePolicy filters forwarded and recursive responses by the addresses they resolve to. A response with an A or
+// AAAA answer inside any of the blocked networks is answered with NXDOMAIN or rewritten to the sinkhole address,
+// depending on the policy action.
+func WithResponsePolicy(policy ResponsePolicy) Option {
+	return func(s *DNSServer) {
+		s.responsePolicy = &policy
+	}
+}
+
+// WithRebindingProtection rejects forwarded and recursive responses with an A or AAAA answer inside a private network,
+// unless the question name is one of the allowed names. Rejected responses are answered with NXDOMAIN or REFUSED,
+// depending on the action.
+func WithRebindingProtection(protection RebindingProtection) Option {
+	return func(s *DNSServer) {
+		s.rebinding = newRebindingFilter(protection)
+	}
+}
+
+// WithHost answers queries for name with the given addresses, without resolving it. A name starting with "*." matches
+// every name below the rest of it, for example "*.example.com" matches "www.example.com" but not "example.com".
+// Exact names take precedence over wildcards, and longer wildcards over shorter ones.
+func WithHost(name string, ips ...net.IP) Option {
+	return func(s *DNSServer) {
+		if s.hosts == nil {
+			s.hosts = &nameTrie[[]net.IP]{}
+		}
+		s.hosts.insert(name, ips)
+	}
+}
+
+// WithBlockedNames answers queries for the names with NXDOMAIN, or the response set with WithBlockResponse, without
+// resolving them. Names may be wildcards like in WithHost. The blocklist takes precedence over the hosts table.
+func WithBlockedNames(names ...string) Option {
+	return func(s *DNSServer) {
+		if s.blocklist == nil {
+			s.blocklist = &nameTrie[struct{}]{}
+		}
+		for _, name := range names {
+			s.blocklist.insert(name, struct{}{})
+		}
+	}
+}
+
+// WithNoCacheNames makes the recursive resolver resolve the names on every query, without caching the responses or
+// the records of their nameserver responses, whatever their TTL. Names may be wildcards like in WithHost.
+func WithNoCacheNames(names ...string) Option {
+	return func(s *DNSServer) {
+		if s.noCache == nil {
+			s.noCache = &nameTrie[struct{}]{}
+		}
+		for _, name := range names {
+			s.noCache.insert(name, struct{}{})
+		}
+	}
+}
+
+// WithBlockResponse sets how queries for names blocked with WithBlockedNames are answered: with NXDOMAIN, which is the
+// default, with NODATA, or with a sinkhole address.
+func WithBlockResponse(response BlockResponse) Option {
+	return func(s *DNSServer) {
+		s.blockResponse = response
+	}
+}
+
+// WithNegativeSOA adds a SOA record to the Authority section of the NXDOMAIN and empty responses the server answers
+// locally, for blocked and special-use names and the response policy, so clients can cache them.
+func WithNegativeSOA(soa NegativeSOA) Option {
+	return func(s *DNSServer) {
+		s.negativeSOA = &soa
+	}
+}
+
+// WithLogFormat selects the format of the logger New creates when it is passed a nil logger. The default is text.
+func WithLogFormat(format LogFormat) Option {
+	return func(s *DNSServer) {
+		s.logConfig.format = format
+	}
+}
+
+// WithLogLevel sets the minimum level of the logger New creates when it is passed a nil logger. The default is info.
+func WithLogLevel(level slog.Level) Option {
+	return func(s *DNSServer) {
+		s.logConfig.level = level
+	}
+}
+
+// WithLogOutput sets where the logger New creates when it is passed a nil logger writes to. The default is stdout.
+func WithLogOutput(w io.Writer) Option {
+	return func(s *DNSServer) {
+		s.logConfig.output = w
+	}
+}
+
+// WithLogSampling logs only one in every n successfully answered queries, to keep logs manageable at high query rates.
+// Errors are always logged. A rate of 0 or 1 logs every query, which is the default.
+func WithLogSampling(n uint64) Option {
+	return func(s *DNSServer) {
+		s.successLogSampling = n
+	}
+}
+
+// WithEDNSUDPSize sets the UDP payload size the server advertises to clients in the OPT record of its responses.
+// The default is 1232 bytes. Sizes below 512 are raised to 512, the minimum allowed by RFC 6891.
+func WithEDNSUDPSize(size uint16) Option {
+	return func(s *DNSServer) {
+		s.ednsUDPSize = max(size, 512)
+	}
+}
+
+// WithUDPBufferSize sets the receive and send buffers of the UDP socket to size bytes, so that bursts of queries are
+// not dropped by the operating system before they are read. The operating system may cap the size, the sizes it
+// granted are logged. By default the operating system defaults are used.
+func WithUDPBufferSize(size int) Option {
+	return func(s *DNSServer) {
+		s.udpBufferSize = size
+	}
+}
+
+// WithUpstreamProtocol sets the transport queries are forwarded to the upstream resolver over. The default is
+// UpstreamUDP, which falls back to TCP for truncated responses.
+func WithUpstreamProtocol(protocol UpstreamProtocol) Option {
+	return func(s *DNSServer) {
+		s.upstreamProtocol = protocol
+	}
+}
+
+// WithSharedUpstreamSocket forwards UDP queries to the upstream resolver over a single socket instead of a new socket
+// per query, reducing socket churn under load. Queries in flight are given message IDs unique per upstream address and
+// responses are matched to them by address, ID and question.
+func WithSharedUpstreamSocket(enabled bool) Option {
+	return func(s *DNSServer) {
+		s.useSharedUpstream = enabled
+	}
+}
+
+// WithExchanger sends every query to nameservers and the upstream resolver through exchanger instead of over UDP and
+// TCP, for example to run the resolver against scripted in-memory nameservers.
+func WithExchanger(exchanger Exchanger) Option {
+	return func(s *DNSServer) {
+		s.exchanger = exchanger
+	}
+}
+
+// WithCookieSecret enables DNS cookies (https://datatracker.ietf.org/doc/html/rfc7873). Clients sending a cookie get
+// a server cookie derived from their address and the secret, which should be at least 16 random bytes. Cookies are
+// then no longer relayed to and from the upstream resolver.
+func WithCookieSecret(secret []byte) Option {
+	return func(s *DNSServer) {
+		s.cookieSecret = secret
+		if s.ednsOptions == nil {
+			s.ednsOptions = edns.NewRegistry()
+		}
+		s.ednsOptions.SetPolicy(edns.Cookie, edns.Strip)
+	}
+}
+
+// WithChainQueries decides whether the EDNS CHAIN option (https://datatracker.ietf.org/doc/html/rfc7901) is relayed
+// between clients and the upstream resolver, so that clients can ask for DNSSEC chains through the forwarder. It is
+// relayed by default, like every option without a policy.
+func WithChainQueries(enabled bool) Option {
+	return func(s *DNSServer) {
+		if s.ednsOptions == nil {
+			s.ednsOptions = edns.NewRegistry()
+		}
+		policy := edns.Strip
+		if enabled {
+			policy = edns.Pass
+		}
+		s.ednsOptions.SetPolicy(edns.Chain, policy)
+	}
+}
+
+// WithEDNSOptionPolicy sets the policy for the EDNS option code when relaying messages to and from the upstream
+// resolver. Options without a policy, including ones the server does not understand, are passed through unchanged.
+func WithEDNSOptionPolicy(code edns.OptionCode, policy edns.Policy) Option {
+	return func(s *DNSServer) {
+		s.ednsOptions.SetPolicy(code, policy)
+	}
+}
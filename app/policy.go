@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"log/slog"
+	"net"
+)
+
+/*
+Response policy is a lightweight take on Response Policy Zones (https://datatracker.ietf.org/doc/html/draft-vixie-dnsop-dns-rpz).
+Only the IP trigger is supported: once a response is resolved, every A and AAAA answer is checked against a list of
+blocked networks, and if any of them matches the configured action is taken before the response is sent to the client.
+*/
+
+// PolicyAction is what happens to a response with an answer inside a blocked network.
+type PolicyAction uint8
+
+const (
+	// PolicyNXDOMAIN answers the query with NXDOMAIN, as if the name did not exist.
+	PolicyNXDOMAIN PolicyAction = iota
+	// PolicyRewrite replaces the address of every blocked answer with the sinkhole address. Blocked answers of the
+	// other address family than the sinkhole are removed.
+	PolicyRewrite
+	// PolicyRefused answers the query with REFUSED.
+	PolicyRefused
+)
+
+// ResponsePolicy configures IP-based response filtering, see WithResponsePolicy.
+type ResponsePolicy struct {
+	// Sinkhole is the address blocked answers are rewritten to by PolicyRewrite.
+	Sinkhole net.IP
+	// Blocked are the networks answers must not point into.
+	Blocked []*net.IPNet
+	Action  PolicyAction
+}
+
+// blocks reports whether ip is inside any of the blocked networks.
+func (p *ResponsePolicy) blocks(ip net.IP) bool {
+	for _, network := range p.Blocked {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// answerAddress returns the address of an A or AAAA record.
+func answerAddress(rr *RR.RR) (net.IP, bool) {
+	switch rr.Type {
+	case DNS_Type.A:
+		ip, err := rr.GetRDATAAsARecord()
+		return ip, err == nil
+	case DNS_Type.AAAA:
+		if len(rr.RDATA) != net.IPv6len {
+			return nil, false
+		}
+		return net.IP(rr.RDATA), true
+	default:
+		return nil, false
+	}
+}
+
+// applyResponsePolicy returns the response to send instead of response. With rebinding protection enabled, a response
+// pointing a public name into a private network is rejected first, whether or not a response policy is configured.
+// Otherwise the response is replaced if one of its answers points into a blocked network, and returned as is if none
+// does. The original response is never modified, so cached messages stay intact.
+func (s *DNSServer) applyResponsePolicy(response *Message.Message) (*Message.Message, error) {
+	if s.rebinding.rejects(response) {
+		s.logger.Warn("Rejected response resolving to a private address",
+			slog.String("question", response.Questions[0].Name))
+		return s.rejectedResponse(response, s.rebinding.action)
+	}
+	if s.responsePolicy == nil || len(s.responsePolicy.Blocked) == 0 {
+		return response, nil
+	}
+
+	blocked := false
+	for i := range response.Answers {
+		if ip, ok := answerAddress(&response.Answers[i]); ok && s.responsePolicy.blocks(ip) {
+			blocked = true
+			break
+		}
+	}
+	if !blocked {
+		return response, nil
+	}
+
+	if s.responsePolicy.Action == PolicyRewrite {
+		return s.rewriteBlockedAnswers(response)
+	}
+	return s.rejectedResponse(response, s.responsePolicy.Action)
+}
+
+// rejectedResponse returns the response replacing a rejected response: REFUSED for PolicyRefused, NXDOMAIN otherwise.
+func (s *DNSServer) rejectedResponse(response *Message.Message, action PolicyAction) (*Message.Message, error) {
+	rcode := header.NameError
+	if action == PolicyRefused {
+		rcode = header.Refused
+	}
+	rejected := &Message.Message{
+		Header:    response.Header,
+		Questions: response.Questions,
+	}
+	rejected.Header.SetRCODE(rcode)
+	if err := rejected.Header.SetANCOUNT(0); err != nil {
+		return nil, err
+	}
+	if err := rejected.Header.SetNSCOUNT(0); err != nil {
+		return nil, err
+	}
+	if err := rejected.Header.SetARCOUNT(0); err != nil {
+		return nil, err
+	}
+	if rcode == header.NameError {
+		if err := s.addNegativeSOA(rejected); err != nil {
+			return nil, err
+		}
+	}
+	return rejected, nil
+}
+
+// rewriteBlockedAnswers returns a copy of response with the blocked answers pointed at the sinkhole address.
+func (s *DNSServer) rewriteBlockedAnswers(response *Message.Message) (*Message.Message, error) {
+	rewritten, err := Message.Copy(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy response for rewriting: %w", err)
+	}
+
+	sinkhole4 := s.responsePolicy.Sinkhole.To4()
+	answers := rewritten.Answers[:0]
+	for _, answer := range rewritten.Answers {
+		ip, ok := answerAddress(&answer)
+		if !ok || !s.responsePolicy.blocks(ip) {
+			answers = append(answers, answer)
+			continue
+		}
+
+		switch {
+		case answer.Type == DNS_Type.A && sinkhole4 != nil:
+			if err := answer.SetRDATAToARecord(sinkhole4); err != nil {
+				return nil, err
+			}
+		case answer.Type == DNS_Type.AAAA && sinkhole4 == nil && len(s.responsePolicy.Sinkhole) == net.IPv6len:
+			answer.SetRDATA(s.responsePolicy.Sinkhole)
+		default:
+			continue
+		}
+		answers = append(answers, answer)
+	}
+	rewritten.Answers = answers
+
+	if err := rewritten.Header.SetANCOUNT(len(rewritten.Answers)); err != nil {
+		return nil, err
+	}
+	return &rewritten, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+	"testing"
+)
+
+func TestResponsePolicy_BlockedAnswer(t *testing.T) {
+	_, blocked, err := net.ParseCIDR("203.0.113.0/24")
+	if err != nil {
+		t.Fatalf("failed to parse CIDR: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		answerIP   string
+		action     PolicyAction
+		wantRCODE  header.ResponseCode
+		wantAnswer string
+	}{
+		{name: "nxdomain", answerIP: "203.0.113.7", action: PolicyNXDOMAIN, wantRCODE: header.NameError},
+		{name: "rewrite", answerIP: "203.0.113.7", action: PolicyRewrite, wantRCODE: header.NoError, wantAnswer: "0.0.0.0"},
+		{name: "not blocked", answerIP: "192.0.2.1", action: PolicyNXDOMAIN, wantRCODE: header.NoError, wantAnswer: "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := startUDPStub(t, answerA(t, tt.answerIP, 300))
+			s := newUDPTestServer(t, stub.String())
+			WithResponsePolicy(ResponsePolicy{
+				Blocked:  []*net.IPNet{blocked},
+				Action:   tt.action,
+				Sinkhole: net.IPv4zero,
+			})(s)
+
+			query, err := Message.CreateDNSQuery("malicious.example.com", DNS_Type.A, DNS_Class.IN, false)
+			if err != nil {
+				t.Fatalf("failed to create query: %v", err)
+			}
+
+			resp := exchangeUDP(t, s, query)
+
+			if resp.Header.GetRCODE() != tt.wantRCODE {
+				t.Fatalf("expected RCODE %s, got %s", tt.wantRCODE, resp.Header.GetRCODE())
+			}
+			if tt.wantAnswer == "" {
+				if len(resp.Answers) != 0 {
+					t.Fatalf("expected no answers, got %d", len(resp.Answers))
+				}
+				return
+			}
+			if len(resp.Answers) != 1 {
+				t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+			}
+			ip, err := resp.Answers[0].GetRDATAAsARecord()
+			if err != nil {
+				t.Fatalf("failed to read answer: %v", err)
+			}
+			if !ip.Equal(net.ParseIP(tt.wantAnswer)) {
+				t.Fatalf("expected answer %s, got %v", tt.wantAnswer, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"net"
+	"strings"
+)
+
+/*
+Rebinding protection guards clients against DNS rebinding (https://en.wikipedia.org/wiki/DNS_rebinding): a public name
+which resolves to a private or loopback address lets a web page reach services on the client's own network. Responses
+with such answers are rejected, unless the question name is one of the exceptions, such as a local domain.
+*/
+
+// defaultPrivateNetworks are the networks public names must not resolve to unless configured otherwise: "this
+// network", RFC 1918 private ranges, loopback, link-local and IPv6 unique local addresses.
+var defaultPrivateNetworks = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(169, 254, 0, 0), Mask: net.CIDRMask(16, 32)},
+	{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)},
+	{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)},
+	{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+	{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(7, 128)},
+	{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(10, 128)},
+}
+
+// RebindingProtection configures the rejection of responses resolving public names to private addresses, see
+// WithRebindingProtection.
+type RebindingProtection struct {
+	// Private are the networks answers must not point into, the RFC 1918, loopback and link-local ranges if empty.
+	Private []*net.IPNet
+	// Allowed are the names which may resolve to private addresses. A name starting with "*." matches every name below
+	// the rest of it.
+	Allowed []string
+	// Action is how rejected responses are answered, PolicyNXDOMAIN or PolicyRefused.
+	Action PolicyAction
+}
+
+// rebindingFilter is a RebindingProtection prepared for lookups.
+type rebindingFilter struct {
+	private []*net.IPNet
+	allowed *nameTrie[struct{}]
+	action  PolicyAction
+}
+
+func newRebindingFilter(protection RebindingProtection) *rebindingFilter {
+	filter := &rebindingFilter{
+		private: protection.Private,
+		allowed: &nameTrie[struct{}]{},
+		action:  protection.Action,
+	}
+	if len(filter.private) == 0 {
+		filter.private = defaultPrivateNetworks
+	}
+	for _, name := range protection.Allowed {
+		filter.allowed.insert(strings.TrimSpace(name), struct{}{})
+	}
+	return filter
+}
+
+// rejects reports whether response has an A or AAAA answer inside a private network while its question name is not
+// allowed to resolve to one.
+func (f *rebindingFilter) rejects(response *Message.Message) bool {
+	const firstQuestion uint8 = 0
+
+	if f == nil || len(response.Questions) == 0 {
+		return false
+	}
+	if _, allowed := f.allowed.lookup(response.Questions[firstQuestion].Name); allowed {
+		return false
+	}
+	for i := range response.Answers {
+		ip, ok := answerAddress(&response.Answers[i])
+		if !ok {
+			continue
+		}
+		for _, network := range f.private {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
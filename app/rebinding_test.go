@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"testing"
+)
+
+func TestRebindingProtection(t *testing.T) {
+	tests := []struct {
+		name      string
+		question  string
+		answerIP  string
+		action    PolicyAction
+		wantRCODE header.ResponseCode
+	}{
+		{name: "private address", question: "rebind.example.com", answerIP: "192.168.1.1", wantRCODE: header.NameError},
+		{name: "loopback address", question: "rebind.example.com", answerIP: "127.0.0.1", wantRCODE: header.NameError},
+		{name: "refused", question: "rebind.example.com", answerIP: "10.0.0.1", action: PolicyRefused, wantRCODE: header.Refused},
+		{name: "public address", question: "www.example.com", answerIP: "192.0.2.1", wantRCODE: header.NoError},
+		{name: "allowed name", question: "nas.home.example", answerIP: "192.168.1.1", wantRCODE: header.NoError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := startUDPStub(t, answerA(t, tt.answerIP, 300))
+			s := newUDPTestServer(t, stub.String())
+			WithRebindingProtection(RebindingProtection{
+				Allowed: []string{"*.home.example"},
+				Action:  tt.action,
+			})(s)
+
+			query, err := Message.CreateDNSQuery(tt.question, DNS_Type.A, DNS_Class.IN, false)
+			if err != nil {
+				t.Fatalf("failed to create query: %v", err)
+			}
+			resp := exchangeUDP(t, s, query)
+
+			if resp.Header.GetRCODE() != tt.wantRCODE {
+				t.Fatalf("expected RCODE %s, got %s", tt.wantRCODE, resp.Header.GetRCODE())
+			}
+			wantAnswers := 0
+			if tt.wantRCODE == header.NoError {
+				wantAnswers = 1
+			}
+			if len(resp.Answers) != wantAnswers {
+				t.Fatalf("expected %d answers, got %d", wantAnswers, len(resp.Answers))
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+)
+
+const (
+	// rootNSTTL and rootSOATTL are the TTLs the root zone publishes its NS and SOA records with.
+	rootNSTTL  int = 518400
+	rootSOATTL int = 86400
+)
+
+// RootZone is a local copy of the root zone metadata, answered instead of forwarding ". NS" and ". SOA" queries, see
+// WithLocalRootZone.
+type RootZone struct {
+	// SOA answers ". SOA" queries. Without it they are resolved as usual.
+	SOA *RootSOA
+	// Servers answer ". NS" queries, with their addresses as glue. Without them the root hints are used.
+	Servers []RootServer
+}
+
+// RootSOA is the SOA record of a RootZone.
+type RootSOA struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+// localRootResponse answers NS and SOA queries for the root zone from the RootZone configured with WithLocalRootZone.
+// It returns false if the query has to be resolved normally.
+func (s *DNSServer) localRootResponse(query *Message.Message) (*Message.Message, bool) {
+	const firstQuestion uint8 = 0
+
+	if s.localRoot == nil || query == nil || len(query.Questions) == 0 {
+		return nil, false
+	}
+	q := query.Questions[firstQuestion]
+	if !isRootName(q.Name) || q.Class != DNS_Class.IN {
+		return nil, false
+	}
+
+	switch {
+	case q.Type == DNS_Type.NS:
+		servers := s.localRoot.Servers
+		if len(servers) == 0 {
+			servers = s.rootHints
+		}
+		if len(servers) == 0 {
+			return nil, false
+		}
+		response, err := rootNSResponse(query, servers)
+		return response, err == nil
+	case q.Type == DNS_Type.SOA && s.localRoot.SOA != nil:
+		response, err := rootSOAResponse(query, s.localRoot.SOA)
+		return response, err == nil
+	default:
+		return nil, false
+	}
+}
+
+// rootNSResponse answers a root NS query with the NS set of servers, with their IPv4 addresses as glue.
+func rootNSResponse(query *Message.Message, servers []RootServer) (*Message.Message, error) {
+	response := rootResponse(query)
+
+	seen := make(map[string]struct{})
+	for _, server := range servers {
+		if _, ok := seen[server.Name]; !ok {
+			seen[server.Name] = struct{}{}
+			ns := RR.RR{}
+			ns.SetName(".")
+			ns.SetClass(DNS_Class.IN)
+			if err := ns.SetTTL(rootNSTTL); err != nil {
+				return nil, err
+			}
+			if err := ns.SetRDATAToNSRecord(server.Name); err != nil {
+				return nil, fmt.Errorf("failed to create root NS record: %w", err)
+			}
+			response.Answers = append(response.Answers, ns)
+		}
+
+		if server.IP.To4() != nil {
+			glue := RR.RR{}
+			glue.SetName(server.Name)
+			glue.SetClass(DNS_Class.IN)
+			if err := glue.SetTTL(rootNSTTL); err != nil {
+				return nil, err
+			}
+			if err := glue.SetRDATAToARecord(server.IP); err != nil {
+				return nil, err
+			}
+			response.Additional = append(response.Additional, glue)
+		}
+	}
+
+	if !finishLocalResponse(response) {
+		return nil, fmt.Errorf("failed to set section counts of root NS response")
+	}
+	return response, nil
+}
+
+// rootSOAResponse answers a root SOA query with soa.
+func rootSOAResponse(query *Message.Message, soa *RootSOA) (*Message.Message, error) {
+	response := rootResponse(query)
+
+	record := RR.RR{}
+	record.SetName(".")
+	record.SetClass(DNS_Class.IN)
+	if err := record.SetTTL(rootSOATTL); err != nil {
+		return nil, err
+	}
+	err := record.SetRDATAToSOARecord(soa.MName, soa.RName, soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minimum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create root SOA record: %w", err)
+	}
+	response.Answers = append(response.Answers, record)
+
+	if !finishLocalResponse(response) {
+		return nil, fmt.Errorf("failed to set section counts of root SOA response")
+	}
+	return response, nil
+}
+
+// rootResponse returns an empty NOERROR response to a root zone query.
+func rootResponse(query *Message.Message) *Message.Message {
+	response := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+	}
+	response.Header.SetQRFlag(true)
+	response.Header.SetRA(true)
+	response.Header.SetRCODE(header.NoError)
+	return response
+}
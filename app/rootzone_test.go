@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+	"testing"
+)
+
+func TestLocalRootZone_AnswersNS(t *testing.T) {
+	log := &queryLog{}
+	upstream := startUDPStub(t, func(query Message.Message) Message.Message {
+		log.record(query)
+		return answerA(t, "192.0.2.53", 300)(query)
+	})
+
+	s := newUDPTestServer(t, upstream.String())
+	WithLocalRootZone(RootZone{Servers: []RootServer{
+		{Name: "a.root.test", IP: net.IPv4(192, 0, 2, 1)},
+		{Name: "b.root.test", IP: net.IPv4(192, 0, 2, 2)},
+	}})(s)
+
+	query, err := Message.CreateDNSQuery(".", DNS_Type.NS, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+
+	if resp.Header.GetRCODE() != header.NoError || len(resp.Answers) != 2 {
+		t.Fatalf("expected the local root NS set, got %s with %d answers", resp.Header.GetRCODE(), len(resp.Answers))
+	}
+	for i, want := range []string{"a.root.test", "b.root.test"} {
+		ns, err := resp.Answers[i].GetRDATAAsNSRecord()
+		if err != nil || ns != want {
+			t.Errorf("answer %d: expected NS %s, got %q (err: %v)", i, want, ns, err)
+		}
+	}
+	if len(resp.Additional) != 2 {
+		t.Errorf("expected 2 glue records, got %d", len(resp.Additional))
+	}
+	if log.count() != 0 {
+		t.Errorf("expected no upstream queries, got %d", log.count())
+	}
+}
+
+func TestLocalRootZone_SOA(t *testing.T) {
+	log := &queryLog{}
+	upstream := startUDPStub(t, func(query Message.Message) Message.Message {
+		log.record(query)
+		return answerA(t, "192.0.2.53", 300)(query)
+	})
+
+	s := newUDPTestServer(t, upstream.String())
+	WithLocalRootZone(RootZone{SOA: &RootSOA{
+		MName: "a.root.test", RName: "hostmaster.root.test", Serial: 2026101600,
+		Refresh: 1800, Retry: 900, Expire: 604800, Minimum: 86400,
+	}})(s)
+
+	query, err := Message.CreateDNSQuery(".", DNS_Type.SOA, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	mname, _, serial, _, _, _, _, err := resp.Answers[0].GetRDATAAsSOARecord()
+	if err != nil || mname != "a.root.test" || serial != 2026101600 {
+		t.Errorf("expected the local SOA, got %s serial %d (err: %v)", mname, serial, err)
+	}
+	if log.count() != 0 {
+		t.Errorf("expected no upstream queries, got %d", log.count())
+	}
+}
+
+func TestLocalRootZone_ForwardsWithoutSOA(t *testing.T) {
+	log := &queryLog{}
+	upstream := startUDPStub(t, func(query Message.Message) Message.Message {
+		log.record(query)
+		return answerA(t, "192.0.2.53", 300)(query)
+	})
+
+	s := newUDPTestServer(t, upstream.String())
+	WithLocalRootZone(RootZone{})(s)
+
+	query, err := Message.CreateDNSQuery(".", DNS_Type.SOA, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	exchangeUDP(t, s, query)
+
+	if log.count() != 1 {
+		t.Errorf("expected the SOA query to be forwarded, got %d upstream queries", log.count())
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"strings"
+)
+
+// cachedCNAMEResponse answers a query for domain by following its cached CNAME RRset, which may have been learned
+// while resolving another type for the same name. It returns nil if no CNAME is cached or following it failed.
+func (s *DNSServer) cachedCNAMEResponse(ctx context.Context, query *Message.Message,
+	questionType DNS_Type.Type) *Message.Message {
+	const firstQuestion uint8 = 0
+
+	if questionType == DNS_Type.CNAME {
+		return nil
+	}
+	domain := query.Questions[firstQuestion].Name
+	cnames := s.cache.GetRRSet(domain, DNS_Type.CNAME, DNS_Class.IN)
+	if len(cnames) == 0 {
+		return nil
+	}
+
+	cached := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+		Answers:   cnames,
+	}
+	if err := cached.Header.SetANCOUNT(len(cached.Answers)); err != nil {
+		return nil
+	}
+	return s.handleCNAMEs(ctx, domain, questionType, cached, make(map[string]struct{}))
+}
+
+// cachedDelegation returns the closest enclosing zone of domain with a cached NS RRset and the addresses of its
+// nameservers, so that resolution can skip the delegations above it. It returns nil nameservers if no delegation
+// with nameserver addresses is cached.
+func (s *DNSServer) cachedDelegation(domain string) (string, []RootServer) {
+	for zone := name.Canonicalize(domain); zone != ""; {
+		var nameservers []RootServer
+		for _, ns := range s.cache.GetRRSet(zone, DNS_Type.NS, DNS_Class.IN) {
+			nsName, err := ns.GetRDATAAsNSRecord()
+			if err != nil {
+				continue
+			}
+			for _, glue := range s.cache.GetRRSet(nsName, DNS_Type.A, DNS_Class.IN) {
+				if ip, err := glue.GetRDATAAsARecord(); err == nil {
+					nameservers = append(nameservers, RootServer{Name: nsName, IP: ip})
+				}
+			}
+		}
+		if len(nameservers) > 0 {
+			return zone, nameservers
+		}
+
+		_, parent, found := strings.Cut(zone, ".")
+		if !found {
+			break
+		}
+		zone = parent
+	}
+	return "", nil
+}
+
+// synthesizedPTRResponse answers a PTR query for a reverse name with the owner name of a cached A or AAAA record of the
+// address, see WithSynthesizedPTR. It returns false if the name is not a reverse name or the address is not cached.
+func (s *DNSServer) synthesizedPTRResponse(query *Message.Message) (*Message.Message, bool) {
+	const firstQuestion uint8 = 0
+
+	q := query.Questions[firstQuestion]
+	ip, ok := name.ReverseAddress(q.Name)
+	if !ok {
+		return nil, false
+	}
+	owner, ttl, ok := s.cache.GetAddressName(ip)
+	if !ok {
+		return nil, false
+	}
+
+	answer := RR.RR{Name: q.Name, Class: DNS_Class.IN, TTL: ttl}
+	if err := answer.SetRDATAToPTRRecord(owner); err != nil {
+		return nil, false
+	}
+	response := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+		Answers:   []RR.RR{answer},
+	}
+	response.Header.SetQRFlag(true)
+	response.Header.SetAA(false)
+	response.Header.SetRA(true)
+	response.Header.SetRCODE(header.NoError)
+	return response, finishLocalResponse(response)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// queryLog records the questions received by a stub nameserver.
+type queryLog struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (l *queryLog) record(query Message.Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queries = append(l.queries, query.Questions[0].Name+" "+query.Questions[0].Type.String())
+}
+
+// snapshot returns a copy of the questions received so far.
+func (l *queryLog) snapshot() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.queries...)
+}
+
+func (l *queryLog) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queries)
+}
+
+func (l *queryLog) received(question string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, q := range l.queries {
+		if q == question {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveForTest(t *testing.T, s *DNSServer, name string, qtype DNS_Type.Type) *Message.Message {
+	t.Helper()
+	query, err := Message.CreateDNSQuery(name, qtype, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp, err := s.resolveRecursively(context.Background(), &query)
+	if err != nil {
+		t.Fatalf("resolveRecursively returned error: %v", err)
+	}
+	return resp
+}
+
+func TestRecursion_ReusesCachedCNAMEForOtherTypes(t *testing.T) {
+	log := &queryLog{}
+	authoritative := func(query Message.Message) Message.Message {
+		log.record(query)
+		resp := query
+		resp.Header.SetQRFlag(true)
+		resp.Header.SetAA(true)
+
+		q := query.Questions[0]
+		rr := RR.RR{Name: q.Name, Class: DNS_Class.IN, TTL: 300}
+		switch {
+		case q.Name == "www.example.test":
+			if err := rr.SetRDATAToCNAMERecord("web.example.test"); err != nil {
+				t.Errorf("failed to set CNAME record: %v", err)
+			}
+			rr.SetType(DNS_Type.CNAME)
+		case q.Type == DNS_Type.AAAA:
+			rr.SetType(DNS_Type.AAAA)
+			rr.SetRDATA(net.ParseIP("2001:db8::1"))
+		default:
+			if err := rr.SetRDATAToARecord(net.IPv4(192, 0, 2, 1)); err != nil {
+				t.Errorf("failed to set A record: %v", err)
+			}
+		}
+		resp.Answers = []RR.RR{rr}
+		if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+		return resp
+	}
+
+	stub := startUDPStub(t, authoritative)
+	s := newTestServer("127.0.0.1:0")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.nameserverPort = stub.Port
+	s.rootServers = []RootServer{{Name: "root.test", IP: stub.IP}}
+
+	resolveForTest(t, s, "www.example.test", DNS_Type.A)
+	resp := resolveForTest(t, s, "www.example.test", DNS_Type.AAAA)
+
+	if log.received("www.example.test AAAA") {
+		t.Fatalf("expected the AAAA query to reuse the cached CNAME, nameserver saw %v", log.snapshot())
+	}
+	if len(resp.Answers) != 2 || resp.Answers[0].Type != DNS_Type.CNAME || resp.Answers[1].Type != DNS_Type.AAAA {
+		t.Fatalf("expected the cached CNAME followed by the AAAA record of its target, got %v", resp.Answers)
+	}
+}
+
+func TestRecursion_ReusesCachedDelegation(t *testing.T) {
+	rootLog := &queryLog{}
+	authoritative := startUDPStubAt(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)}, func(query Message.Message) Message.Message {
+		resp := answerA(t, "192.0.2.1", 300)(query)
+		resp.Header.SetAA(true)
+		return resp
+	})
+	referral := func(query Message.Message) Message.Message {
+		rootLog.record(query)
+		resp := query
+		resp.Header.SetQRFlag(true)
+
+		ns := RR.RR{Name: "example.test", Class: DNS_Class.IN, TTL: 300}
+		if err := ns.SetRDATAToNSRecord("ns.example.test"); err != nil {
+			t.Errorf("failed to set NS record: %v", err)
+		}
+		glue := RR.RR{Name: "ns.example.test", Class: DNS_Class.IN, TTL: 300}
+		if err := glue.SetRDATAToARecord(authoritative.IP); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+
+		resp.Authority = []RR.RR{ns}
+		resp.Additional = []RR.RR{glue}
+		if err := resp.Header.SetNSCOUNT(len(resp.Authority)); err != nil {
+			t.Errorf("failed to set NSCOUNT: %v", err)
+		}
+		if err := resp.Header.SetARCOUNT(len(resp.Additional)); err != nil {
+			t.Errorf("failed to set ARCOUNT: %v", err)
+		}
+		return resp
+	}
+	root := startUDPStubAt(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: authoritative.Port}, referral)
+
+	s := newTestServer("127.0.0.1:0")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.nameserverPort = root.Port
+	s.rootServers = []RootServer{{Name: "root.test", IP: root.IP}}
+
+	resolveForTest(t, s, "www.example.test", DNS_Type.A)
+	queried := rootLog.count()
+	resp := resolveForTest(t, s, "mail.example.test", DNS_Type.A)
+
+	if rootLog.count() != queried {
+		t.Fatalf("expected the cached delegation to skip the root servers, root saw %v", rootLog.snapshot())
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+}
+
+func TestRecursion_SynthesizesPTRFromCachedAnswers(t *testing.T) {
+	log := &queryLog{}
+	authoritative := func(query Message.Message) Message.Message {
+		log.record(query)
+		resp := answerA(t, "192.0.2.7", 300)(query)
+		resp.Header.SetAA(true)
+		return resp
+	}
+
+	stub := startUDPStub(t, authoritative)
+	s := newTestServer("127.0.0.1:0")
+	WithSynthesizedPTR(true)(s)
+	s.cache = cache.NewDNSCache(s.logger)
+	s.cache.EnableReverseIndex()
+	s.nameserverPort = stub.Port
+	s.rootServers = []RootServer{{Name: "root.test", IP: stub.IP}}
+
+	resolveForTest(t, s, "host.example", DNS_Type.A)
+	queried := log.count()
+	resp := resolveForTest(t, s, "7.2.0.192.in-addr.arpa", DNS_Type.PTR)
+
+	if log.count() != queried {
+		t.Fatalf("expected the PTR query to be answered from the reverse index, nameserver saw %v", log.snapshot())
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	ptr, err := resp.Answers[0].GetRDATAAsPTRRecord()
+	if err != nil {
+		t.Fatalf("failed to read PTR answer: %v", err)
+	}
+	if ptr != "host.example" {
+		t.Fatalf("expected PTR to host.example, got %q", ptr)
+	}
+	if resp.Answers[0].GetTTL() == 0 || resp.Answers[0].GetTTL() > 300 {
+		t.Fatalf("expected the TTL of the cached A record, got %d", resp.Answers[0].GetTTL())
+	}
+}
+
+func TestRecursion_NameNormalization(t *testing.T) {
+	log := &queryLog{}
+	authoritative := func(query Message.Message) Message.Message {
+		log.record(query)
+		resp := query
+		resp.Header.SetQRFlag(true)
+		resp.Header.SetAA(true)
+
+		q := query.Questions[0]
+		rr := RR.RR{Name: q.Name, Class: DNS_Class.IN, TTL: 300}
+		switch {
+		case strings.EqualFold(q.Name, "www.example.test"):
+			rr.Name = "WWW.Example.TEST"
+			if err := rr.SetRDATAToCNAMERecord("Web.Example.Test"); err != nil {
+				t.Errorf("failed to set CNAME record: %v", err)
+			}
+		case q.Type == DNS_Type.AAAA:
+			rr.SetType(DNS_Type.AAAA)
+			rr.SetRDATA(net.ParseIP("2001:db8::1"))
+		default:
+			if err := rr.SetRDATAToARecord(net.IPv4(192, 0, 2, 1)); err != nil {
+				t.Errorf("failed to set A record: %v", err)
+			}
+		}
+		resp.Answers = []RR.RR{rr}
+		if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+		return resp
+	}
+
+	stub := startUDPStub(t, authoritative)
+	s := newTestServer("127.0.0.1:0")
+	WithNameNormalization(true)(s)
+	s.cache = cache.NewDNSCache(s.logger)
+	s.nameserverPort = stub.Port
+	s.rootServers = []RootServer{{Name: "root.test", IP: stub.IP}}
+
+	first := resolveForTest(t, s, "WWW.EXAMPLE.TEST", DNS_Type.A)
+	if len(first.Answers) != 2 || first.Answers[0].Type != DNS_Type.CNAME {
+		t.Fatalf("expected the CNAME followed by the A record of its target, got %v", first.Answers)
+	}
+	for _, q := range log.snapshot() {
+		if qname := strings.Fields(q)[0]; qname != strings.ToLower(qname) {
+			t.Fatalf("expected only lowercase names to be sent to nameservers, got %v", log.snapshot())
+		}
+	}
+	queried := log.count()
+
+	resp := resolveForTest(t, s, "Www.Example.Test", DNS_Type.A)
+	if log.count() != queried {
+		t.Fatalf("expected a cache hit for the differently cased name, nameserver saw %v", log.snapshot())
+	}
+	if resp.Questions[0].Name != "Www.Example.Test" {
+		t.Fatalf("expected the question to be echoed as sent, got %q", resp.Questions[0].Name)
+	}
+
+	resp = resolveForTest(t, s, "www.EXAMPLE.test", DNS_Type.AAAA)
+	if log.received("www.example.test AAAA") {
+		t.Fatalf("expected the AAAA query to reuse the cached CNAME, nameserver saw %v", log.snapshot())
+	}
+	if len(resp.Answers) != 2 || resp.Answers[0].Type != DNS_Type.CNAME || resp.Answers[1].Type != DNS_Type.AAAA {
+		t.Fatalf("expected the cached CNAME followed by the AAAA record of its target, got %v", resp.Answers)
+	}
+	if resp.Questions[0].Name != "www.EXAMPLE.test" {
+		t.Fatalf("expected the question to be echoed as sent, got %q", resp.Questions[0].Name)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// sharedExchangeTimeout limits how long a query forwarded over the shared socket waits for its response.
+const sharedExchangeTimeout = 5 * time.Second
+
+// errSharedSocketClosed is returned for queries pending or sent after the shared socket was closed.
+var errSharedSocketClosed = errors.New("shared upstream socket closed")
+
+// pendingKey identifies a query in flight on the shared socket: the upstream it was sent to and its message ID.
+type pendingKey struct {
+	addr netip.AddrPort
+	id   uint16
+}
+
+// pendingQuery is a query in flight on the shared socket, waiting for its response.
+type pendingQuery struct {
+	query    *Message.Message
+	response chan *Message.Message
+}
+
+// sharedUpstream forwards queries to the upstream resolver over a single UDP socket, see WithSharedUpstreamSocket.
+// Every query in flight gets a message ID unique per upstream address, so responses are matched to their queries even
+// when clients happen to use the same ID. Responses for no pending query, or not echoing its question, are dropped.
+type sharedUpstream struct {
+	conn    *net.UDPConn
+	logger  *slog.Logger
+	pending map[pendingKey]pendingQuery
+	closed  bool
+	mu      sync.Mutex
+}
+
+// newSharedUpstream opens the shared socket and starts reading responses from it.
+func newSharedUpstream(logger *slog.Logger) (*sharedUpstream, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open shared upstream socket: %w", err)
+	}
+	u := &sharedUpstream{
+		conn:    conn,
+		logger:  logger,
+		pending: make(map[pendingKey]pendingQuery),
+	}
+	go u.readResponses()
+	return u, nil
+}
+
+// exchange sends query to the upstream at addr and waits for the matching response, which carries the ID of query.
+func (u *sharedUpstream) exchange(ctx context.Context, addr string, query []byte) (*Message.Message, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolver address %q: %w", addr, err)
+	}
+	target := udpAddr.AddrPort()
+	target = netip.AddrPortFrom(target.Addr().Unmap(), target.Port())
+
+	queryMsg, err := Message.New(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal query: %w", err)
+	}
+	originalID := queryMsg.Header.ID
+
+	key, pending, err := u.register(target, &queryMsg)
+	if err != nil {
+		return nil, err
+	}
+	defer u.unregister(key)
+
+	wire := append([]byte(nil), query...)
+	binary.BigEndian.PutUint16(wire, key.id)
+	if _, err := u.conn.WriteToUDPAddrPort(wire, target); err != nil {
+		return nil, fmt.Errorf("failed to send query to resolver: %w", err)
+	}
+
+	timer := time.NewTimer(sharedExchangeTimeout)
+	defer timer.Stop()
+	select {
+	case response, ok := <-pending.response:
+		if !ok {
+			return nil, errSharedSocketClosed
+		}
+		response.Header.ID = originalID
+		return response, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no response from resolver %s within %s", addr, sharedExchangeTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// register picks a random message ID not in flight to target and records query as pending under it.
+func (u *sharedUpstream) register(target netip.AddrPort, query *Message.Message) (pendingKey, pendingQuery, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return pendingKey{}, pendingQuery{}, errSharedSocketClosed
+	}
+	var id [2]byte
+	for {
+		if _, err := rand.Read(id[:]); err != nil {
+			return pendingKey{}, pendingQuery{}, fmt.Errorf("failed to generate message ID: %w", err)
+		}
+		key := pendingKey{addr: target, id: binary.BigEndian.Uint16(id[:])}
+		if _, inFlight := u.pending[key]; inFlight {
+			continue
+		}
+		pending := pendingQuery{query: query, response: make(chan *Message.Message, 1)}
+		u.pending[key] = pending
+		return key, pending, nil
+	}
+}
+
+// unregister removes the pending query under key, once it got its response or gave up waiting.
+func (u *sharedUpstream) unregister(key pendingKey) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.pending, key)
+}
+
+// readResponses delivers every response read from the shared socket to its pending query until the socket is closed.
+func (u *sharedUpstream) readResponses() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, from, err := u.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			u.mu.Lock()
+			closed := u.closed
+			u.mu.Unlock()
+			if closed {
+				return
+			}
+			u.logger.Warn("Failed to read from shared upstream socket", slog.Any("error", err))
+			continue
+		}
+
+		response, err := Message.New(append([]byte(nil), buf[:n]...))
+		if err != nil {
+			u.logger.Debug("Dropped malformed response on shared upstream socket", slog.Any("error", err))
+			continue
+		}
+		key := pendingKey{
+			addr: netip.AddrPortFrom(from.Addr().Unmap(), from.Port()),
+			id:   response.Header.GetMessageID(),
+		}
+
+		// A response not echoing the question leaves the query pending, so that a spoofed or stray packet carrying an
+		// ID in flight can't take the place of the real response.
+		u.mu.Lock()
+		pending, ok := u.pending[key]
+		ok = ok && response.QuestionMatches(pending.query)
+		if ok {
+			delete(u.pending, key)
+		}
+		u.mu.Unlock()
+		if !ok {
+			u.logger.Debug("Dropped unexpected response on shared upstream socket",
+				slog.String("from", from.String()),
+				slog.Int("id", int(key.id)))
+			continue
+		}
+		pending.response <- &response
+	}
+}
+
+// close closes the shared socket, failing the queries still waiting for a response.
+func (u *sharedUpstream) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return
+	}
+	u.closed = true
+	_ = u.conn.Close()
+	for key, pending := range u.pending {
+		close(pending.response)
+		delete(u.pending, key)
+	}
+}
+
+// sharedUpstreamSocket returns the shared upstream socket, opening it on first use.
+func (s *DNSServer) sharedUpstreamSocket() (*sharedUpstream, error) {
+	s.sharedUpstreamMu.Lock()
+	defer s.sharedUpstreamMu.Unlock()
+
+	if s.sharedUpstream == nil {
+		shared, err := newSharedUpstream(s.logger)
+		if err != nil {
+			return nil, err
+		}
+		s.sharedUpstream = shared
+	}
+	return s.sharedUpstream, nil
+}
+
+// closeSharedUpstream closes the shared upstream socket, if it was opened.
+func (s *DNSServer) closeSharedUpstream() {
+	s.sharedUpstreamMu.Lock()
+	defer s.sharedUpstreamMu.Unlock()
+
+	if s.sharedUpstream != nil {
+		s.sharedUpstream.close()
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestSharedUpstreamSocket_CorrelatesConcurrentQueries(t *testing.T) {
+	const queries int = 50
+
+	// The upstream collects every query before answering them in reverse order, each with an address derived from
+	// the queried name, and records the source ports and IDs the queries arrived with.
+	upstream, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	t.Cleanup(func() { _ = upstream.Close() })
+	ports := make(map[int]struct{})
+	ids := make(map[uint16]struct{})
+	answered := make(chan struct{})
+	go func() {
+		defer close(answered)
+		type received struct {
+			query Message.Message
+			from  *net.UDPAddr
+		}
+		var batch []received
+		buf := make([]byte, 65535)
+		for len(batch) < queries {
+			n, from, err := upstream.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query, err := Message.New(append([]byte(nil), buf[:n]...))
+			if err != nil {
+				t.Errorf("failed to parse query: %v", err)
+				return
+			}
+			ports[from.Port] = struct{}{}
+			ids[query.Header.GetMessageID()] = struct{}{}
+			batch = append(batch, received{query: query, from: from})
+		}
+		for i := len(batch) - 1; i >= 0; i-- {
+			var index int
+			if _, err := fmt.Sscanf(batch[i].query.Questions[0].Name, "host%d.example.test", &index); err != nil {
+				t.Errorf("unexpected question %s", batch[i].query.Questions[0].Name)
+				return
+			}
+			resp := answerA(t, fmt.Sprintf("192.0.2.%d", index+1), 300)(batch[i].query)
+			data, err := resp.MarshalBinary()
+			if err != nil {
+				t.Errorf("failed to marshal response: %v", err)
+				return
+			}
+			if _, err := upstream.WriteToUDP(data, batch[i].from); err != nil {
+				t.Errorf("failed to send response: %v", err)
+				return
+			}
+		}
+	}()
+
+	s := newTestServer(upstream.LocalAddr().String())
+	WithSharedUpstreamSocket(true)(s)
+	t.Cleanup(s.closeSharedUpstream)
+
+	var wg sync.WaitGroup
+	for i := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every client uses the same ID, the shared socket has to keep them apart.
+			query, err := Message.CreateDNSQuery(fmt.Sprintf("host%d.example.test", i), DNS_Type.A, DNS_Class.IN, true)
+			if err != nil {
+				t.Errorf("failed to create query: %v", err)
+				return
+			}
+			query.Header.ID = [2]byte{0x12, 0x34}
+			data, err := query.MarshalBinary()
+			if err != nil {
+				t.Errorf("failed to marshal query: %v", err)
+				return
+			}
+
+			resp, err := s.forwardToResolver(context.Background(), data)
+			if err != nil {
+				t.Errorf("query %d: forwardToResolver returned error: %v", i, err)
+				return
+			}
+			if err := Message.ValidateResponse(&query, resp); err != nil {
+				t.Errorf("query %d: got a response to another query: %v", i, err)
+				return
+			}
+			want := net.IPv4(192, 0, 2, byte(i+1))
+			if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(want) {
+				t.Errorf("query %d: expected %s, got %v (%v)", i, want, ip, err)
+			}
+		}()
+	}
+	wg.Wait()
+	<-answered
+
+	if len(ports) != 1 {
+		t.Errorf("expected every query to come from one socket, got %d source ports", len(ports))
+	}
+	if len(ids) != queries {
+		t.Errorf("expected %d distinct message IDs on the wire, got %d", queries, len(ids))
+	}
+}
+
+func TestSharedUpstreamSocket_KeepsQueryPendingOnQuestionMismatch(t *testing.T) {
+	// The upstream answers every query twice: first with its ID but another question, as an off-path attacker guessing
+	// the ID would, then with the real response.
+	upstream, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	t.Cleanup(func() { _ = upstream.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		n, from, err := upstream.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		query, err := Message.New(append([]byte(nil), buf[:n]...))
+		if err != nil {
+			t.Errorf("failed to parse query: %v", err)
+			return
+		}
+		spoofed := query
+		spoofed.Questions = append(spoofed.Questions[:0:0], spoofed.Questions...)
+		spoofed.Questions[0].Name = "other.example.test"
+		for _, resp := range []Message.Message{
+			answerA(t, "198.51.100.1", 300)(spoofed),
+			answerA(t, "192.0.2.1", 300)(query),
+		} {
+			data, err := resp.MarshalBinary()
+			if err != nil {
+				t.Errorf("failed to marshal response: %v", err)
+				return
+			}
+			if _, err := upstream.WriteToUDP(data, from); err != nil {
+				t.Errorf("failed to send response: %v", err)
+				return
+			}
+		}
+	}()
+
+	s := newTestServer(upstream.LocalAddr().String())
+	WithSharedUpstreamSocket(true)(s)
+	t.Cleanup(s.closeSharedUpstream)
+
+	query, err := Message.CreateDNSQuery("host.example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	resp, err := s.forwardToResolver(context.Background(), data)
+	if err != nil {
+		t.Fatalf("forwardToResolver returned error: %v", err)
+	}
+	if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("expected the real answer 192.0.2.1, got %v (%v)", ip, err)
+	}
+}
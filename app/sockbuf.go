@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"syscall"
+)
+
+// listenUDP opens the UDP listener on addr. If a buffer size was configured with WithUDPBufferSize, the receive and send
+// buffers of the socket are set to it before it is bound, and the sizes the operating system actually granted are
+// logged, since it may cap or adjust the requested size.
+func (s *DNSServer) listenUDP(addr *net.UDPAddr) (*net.UDPConn, error) {
+	if s.udpBufferSize <= 0 {
+		return net.ListenUDP("udp", addr)
+	}
+
+	lc := net.ListenConfig{Control: func(_, _ string, c syscall.RawConn) error {
+		var setErr error
+		if err := c.Control(func(fd uintptr) {
+			setErr = setSocketBuffers(fd, s.udpBufferSize)
+		}); err != nil {
+			return err
+		}
+		if setErr != nil {
+			return fmt.Errorf("failed to set UDP socket buffers to %d bytes: %w", s.udpBufferSize, setErr)
+		}
+		return nil
+	}}
+	conn, err := lc.ListenPacket(context.Background(), "udp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	udpConn := conn.(*net.UDPConn)
+
+	receive, send, err := udpBufferSizes(udpConn)
+	if err != nil {
+		s.logger.Warn("Failed to read back UDP socket buffer sizes", slog.Any("error", err))
+		return udpConn, nil
+	}
+	s.logger.Info("Set UDP socket buffer sizes",
+		slog.Int("requested", s.udpBufferSize),
+		slog.Int("receive", receive),
+		slog.Int("send", send))
+	return udpConn, nil
+}
+
+// udpBufferSizes returns the effective receive and send buffer sizes of conn.
+func udpBufferSizes(conn *net.UDPConn) (receive, send int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var getErr error
+	if err := raw.Control(func(fd uintptr) {
+		receive, send, getErr = socketBuffers(fd)
+	}); err != nil {
+		return 0, 0, err
+	}
+	return receive, send, getErr
+}
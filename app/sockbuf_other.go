@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// errSocketBuffersUnsupported is returned where socket buffer sizes cannot be set.
+var errSocketBuffersUnsupported = errors.New("setting socket buffer sizes is not supported on this platform")
+
+func setSocketBuffers(uintptr, int) error {
+	return errSocketBuffersUnsupported
+}
+
+func socketBuffers(uintptr) (int, int, error) {
+	return 0, 0, errSocketBuffersUnsupported
+}
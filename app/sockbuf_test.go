@@ -0,0 +1,28 @@
+//go:build linux
+
+package main
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestNew_WithUDPBufferSize(t *testing.T) {
+	// Below the default net.core.rmem_max and wmem_max, so the kernel grants it without CAP_NET_ADMIN.
+	const size int = 64 * 1024
+
+	s, cleanup, err := New("127.0.0.1:0", "127.0.0.1:53", false, slog.New(slog.DiscardHandler), WithUDPBufferSize(size))
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	defer cleanup()
+
+	receive, send, err := udpBufferSizes(s.udpConn)
+	if err != nil {
+		t.Fatalf("failed to read socket buffer sizes: %v", err)
+	}
+	// Linux doubles the requested size to leave room for its bookkeeping, see socket(7).
+	if receive != 2*size || send != 2*size {
+		t.Fatalf("expected receive and send buffers of %d bytes, got %d and %d", 2*size, receive, send)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// setSocketBuffers sets the SO_RCVBUF and SO_SNDBUF options of the socket fd to size bytes.
+func setSocketBuffers(fd uintptr, size int) error {
+	if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, size); err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, size)
+}
+
+// socketBuffers returns the SO_RCVBUF and SO_SNDBUF options of the socket fd.
+func socketBuffers(fd uintptr) (receive, send int, err error) {
+	receive, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	if err != nil {
+		return 0, 0, err
+	}
+	send, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	return receive, send, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"net"
+)
+
+/*
+Special-use domain names (https://datatracker.ietf.org/doc/html/rfc6761) must never be forwarded:
+
+  - "localhost." and names below it always resolve to the loopback addresses (section 6.3).
+  - The reverse names of the loopback addresses resolve back to "localhost".
+  - "invalid." and names below it never exist (section 6.4).
+*/
+
+const (
+	localhostName        = "localhost"
+	invalidName          = "invalid"
+	ipv4LoopbackReverse  = "1.0.0.127.in-addr.arpa"
+	ipv6LoopbackReverse  = "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa"
+	specialNameRecordTTL = 86400
+)
+
+// specialNameResponse answers query locally if it asks about a special-use domain name.
+// It returns false if the query has to be resolved normally.
+func specialNameResponse(query *Message.Message) (*Message.Message, bool) {
+	const firstQuestion uint8 = 0
+
+	if query == nil || len(query.Questions) == 0 {
+		return nil, false
+	}
+	q := query.Questions[firstQuestion]
+	if q.Class != DNS_Class.IN {
+		return nil, false
+	}
+	qname := name.Canonicalize(q.Name)
+
+	response := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+	}
+	response.Header.SetQRFlag(true)
+	response.Header.SetAA(true)
+	response.Header.SetRA(true)
+	response.Header.SetRCODE(header.NoError)
+
+	answer := RR.RR{}
+	answer.SetName(q.Name)
+	answer.SetClass(DNS_Class.IN)
+	if err := answer.SetTTL(specialNameRecordTTL); err != nil {
+		return nil, false
+	}
+
+	switch {
+	case name.IsSubdomain(qname, invalidName):
+		response.Header.SetRCODE(header.NameError)
+	case name.IsSubdomain(qname, localhostName):
+		switch q.Type {
+		case DNS_Type.A:
+			if err := answer.SetRDATAToARecord(net.IPv4(127, 0, 0, 1)); err != nil {
+				return nil, false
+			}
+			response.Answers = append(response.Answers, answer)
+		case DNS_Type.AAAA:
+			answer.SetType(DNS_Type.AAAA)
+			answer.SetRDATA(net.IPv6loopback)
+			response.Answers = append(response.Answers, answer)
+		}
+	case qname == ipv4LoopbackReverse || qname == ipv6LoopbackReverse:
+		if q.Type == DNS_Type.PTR {
+			if err := answer.SetRDATAToPTRRecord(localhostName); err != nil {
+				return nil, false
+			}
+			response.Answers = append(response.Answers, answer)
+		}
+	default:
+		return nil, false
+	}
+
+	if err := response.Header.SetANCOUNT(len(response.Answers)); err != nil {
+		return nil, false
+	}
+	if err := response.Header.SetNSCOUNT(0); err != nil {
+		return nil, false
+	}
+	if err := response.Header.SetARCOUNT(0); err != nil {
+		return nil, false
+	}
+	return response, true
+}
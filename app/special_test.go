@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+	"testing"
+)
+
+func TestSpecialNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		qname     string
+		wantIP    net.IP
+		wantPTR   string
+		qtype     DNS_Type.Type
+		wantRCODE header.ResponseCode
+	}{
+		{name: "localhost A", qname: "localhost", qtype: DNS_Type.A, wantIP: net.IPv4(127, 0, 0, 1)},
+		{name: "localhost AAAA", qname: "localhost.", qtype: DNS_Type.AAAA, wantIP: net.IPv6loopback},
+		{name: "Below localhost A", qname: "app.LOCALHOST", qtype: DNS_Type.A, wantIP: net.IPv4(127, 0, 0, 1)},
+		{name: "localhost MX is empty", qname: "localhost", qtype: DNS_Type.MX},
+		{name: "IPv4 loopback PTR", qname: ipv4LoopbackReverse, qtype: DNS_Type.PTR, wantPTR: "localhost"},
+		{name: "IPv6 loopback PTR", qname: ipv6LoopbackReverse, qtype: DNS_Type.PTR, wantPTR: "localhost"},
+		{name: "invalid TLD", qname: "invalid", qtype: DNS_Type.A, wantRCODE: header.NameError},
+		{name: "Below invalid TLD", qname: "foo.invalid", qtype: DNS_Type.A, wantRCODE: header.NameError},
+	}
+
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	WithSpecialNames(true)(s)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := Message.CreateDNSQuery(tt.qname, tt.qtype, DNS_Class.IN, true)
+			if err != nil {
+				t.Fatalf("failed to create query: %v", err)
+			}
+
+			resp := exchangeUDP(t, s, query)
+
+			if resp.Header.GetMessageID() != query.Header.GetMessageID() {
+				t.Fatalf("response ID %d does not match query ID %d",
+					resp.Header.GetMessageID(), query.Header.GetMessageID())
+			}
+			if resp.Header.GetRCODE() != tt.wantRCODE {
+				t.Fatalf("expected RCODE %s, got %s", tt.wantRCODE, resp.Header.GetRCODE())
+			}
+
+			switch {
+			case tt.wantIP != nil:
+				if len(resp.Answers) != 1 {
+					t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+				}
+				if !net.IP(resp.Answers[0].GetRDATA()).Equal(tt.wantIP) {
+					t.Fatalf("expected %s, got %v", tt.wantIP, resp.Answers[0].GetRDATA())
+				}
+			case tt.wantPTR != "":
+				if len(resp.Answers) != 1 {
+					t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+				}
+				ptr, err := resp.Answers[0].GetRDATAAsPTRRecord()
+				if err != nil || ptr != tt.wantPTR {
+					t.Fatalf("expected PTR %s, got %s (err: %v)", tt.wantPTR, ptr, err)
+				}
+			default:
+				if len(resp.Answers) != 0 {
+					t.Fatalf("expected no answers, got %d", len(resp.Answers))
+				}
+			}
+		})
+	}
+}
+
+func TestSpecialNamesDisabled(t *testing.T) {
+	stub := startUDPStub(t, answerA(t, "10.0.0.1", 300))
+	s := newUDPTestServer(t, stub.String())
+	WithSpecialNames(false)(s)
+
+	query, err := Message.CreateDNSQuery("localhost", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+
+	resp := exchangeUDP(t, s, query)
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	ip, err := resp.Answers[0].GetRDATAAsARecord()
+	if err != nil || !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("expected localhost to be forwarded upstream when disabled, got %v (err: %v)", ip, err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/zone"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// staticRecord is a record of a static answers file.
+type staticRecord struct {
+	// Type is the record type, one of the types supported in zone files.
+	Type string `json:"type"`
+	// Data is the RDATA in zone file syntax, e.g. "10 mail.example.com" for an MX record. Names are absolute.
+	Data string `json:"data"`
+	// TTL defaults to the TTL of the hosts table.
+	TTL *uint32 `json:"ttl"`
+}
+
+/*
+parseStaticAnswers parses a static answers file, a JSON object mapping names to their records:
+
+	{
+	  "www.example.com": [{"type": "A", "data": "192.0.2.1"}],
+	  "example.com": [{"type": "TXT", "ttl": 60, "data": "v=spf1 -all"}]
+	}
+
+Every invalid record is reported with its name and position; if there are any, they are all returned joined together
+and no answers are returned.
+*/
+func parseStaticAnswers(r io.Reader) (*zone.Zone, error) {
+	var records map[string][]staticRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, fmt.Errorf("failed to decode static answers: %w", err)
+	}
+
+	answers := zone.New("")
+	var errs []error
+	for _, owner := range slices.Sorted(maps.Keys(records)) {
+		if strings.HasPrefix(owner, "*.") {
+			errs = append(errs, fmt.Errorf("invalid name %q: wildcards are not supported", owner))
+			continue
+		}
+		if err := validateTableName(owner); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for i, record := range records[owner] {
+			ttl := uint32(hostsRecordTTL)
+			if record.TTL != nil {
+				ttl = *record.TTL
+			}
+			rr, err := zone.ParseRecord(fmt.Sprintf("%s %d IN %s %s", owner, ttl, record.Type, record.Data), "")
+			if err == nil {
+				err = answers.Add(rr)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("record %d of %s: %w", i+1, owner, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return answers, nil
+}
+
+// staticResponse answers query authoritatively from the static answers configured with WithStaticAnswers. Names with
+// static records but none of the queried type get a NODATA response, or their CNAME.
+// It returns false if the query has to be resolved normally.
+func (s *DNSServer) staticResponse(query *Message.Message) (*Message.Message, bool) {
+	const firstQuestion uint8 = 0
+
+	if s.staticAnswers == nil || query == nil || len(query.Questions) == 0 {
+		return nil, false
+	}
+	q := query.Questions[firstQuestion]
+	if q.Class != DNS_Class.IN {
+		return nil, false
+	}
+	answers, exists := s.staticAnswers.Lookup(q.Name, q.Type)
+	if !exists {
+		return nil, false
+	}
+	if len(answers) == 0 && q.Type != DNS_Type.CNAME {
+		answers, _ = s.staticAnswers.Lookup(q.Name, DNS_Type.CNAME)
+	}
+
+	response := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+		Answers:   answers,
+	}
+	response.Header.SetQRFlag(true)
+	response.Header.SetAA(true)
+	response.Header.SetRA(true)
+	response.Header.SetRCODE(header.NoError)
+	return response, s.addNegativeSOA(response) == nil && finishLocalResponse(response)
+}
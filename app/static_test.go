@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestStaticAnswers(t *testing.T) {
+	const file = `{
+	"www.example.test": [{"type": "A", "data": "192.0.2.1"}],
+	"example.test": [{"type": "TXT", "ttl": 60, "data": "\"hello world\""}]
+}`
+	answers, err := parseStaticAnswers(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("parseStaticAnswers failed: %v", err)
+	}
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	WithStaticAnswers(answers)(s)
+
+	query, err := Message.CreateDNSQuery("www.example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp := exchangeUDP(t, s, query)
+	if !resp.Header.IsAA() || len(resp.Answers) != 1 {
+		t.Fatalf("expected one authoritative answer, got AA %v and %v", resp.Header.IsAA(), resp.Answers)
+	}
+	if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("expected 192.0.2.1, got %v (%v)", ip, err)
+	}
+	if resp.Answers[0].TTL != uint32(hostsRecordTTL) {
+		t.Errorf("expected the default TTL %d, got %d", hostsRecordTTL, resp.Answers[0].TTL)
+	}
+
+	query, err = Message.CreateDNSQuery("example.test", DNS_Type.TXT, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp = exchangeUDP(t, s, query)
+	if !resp.Header.IsAA() || len(resp.Answers) != 1 || resp.Answers[0].TTL != 60 {
+		t.Fatalf("expected one authoritative TXT answer with TTL 60, got AA %v and %v", resp.Header.IsAA(), resp.Answers)
+	}
+	if text, err := resp.Answers[0].GetRDATAAsTXTRecord(); err != nil || text != "hello world" {
+		t.Errorf("expected text %q, got %q (%v)", "hello world", text, err)
+	}
+
+	query, err = Message.CreateDNSQuery("example.test", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp = exchangeUDP(t, s, query)
+	if resp.Header.GetRCODE() != header.NoError || len(resp.Answers) != 0 {
+		t.Fatalf("expected NODATA for a name without A records, got %s and %v", resp.Header.GetRCODE(), resp.Answers)
+	}
+}
+
+func TestParseStaticAnswers_ReportsInvalidRecords(t *testing.T) {
+	const file = `{
+	"a.example.test": [{"type": "A", "data": "192.0.2.300"}],
+	"*.example.test": [{"type": "A", "data": "192.0.2.1"}],
+	"b.example.test": [{"type": "A", "data": "192.0.2.2"}, {"type": "SRV", "data": "0 0 53 ns.example.test"}]
+}`
+	_, err := parseStaticAnswers(strings.NewReader(file))
+	if err == nil {
+		t.Fatal("expected an error for the invalid records")
+	}
+	for _, want := range []string{"*.example.test", "record 1 of a.example.test", "record 2 of b.example.test"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error %q to contain %q", err, want)
+		}
+	}
+}
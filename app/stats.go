@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"sync"
+	"time"
+)
+
+// Stats is a point in time snapshot of the server's operational statistics.
+type Stats struct {
+	// Latency is the moving average response time of every upstream resolver and nameserver queried so far, keyed by
+	// "host:port" address.
+	Latency map[string]time.Duration
+	// TruncatedResponses is the number of responses sent with the TC flag because they did not fit into a UDP response
+	// or TCP framing. Many truncations suggest the EDNS buffer size needs tuning.
+	TruncatedResponses uint64
+	// BadCookies is the number of queries answered with BADCOOKIE because they carried a server cookie the server did
+	// not hand out, see WithCookieSecret.
+	BadCookies uint64
+	// QueriesByType is the number of queries received over UDP and TCP, keyed by the type of their question.
+	QueriesByType map[DNS_Type.Type]uint64
+}
+
+// Stats returns a snapshot of the server's operational statistics.
+func (s *DNSServer) Stats() Stats {
+	return Stats{
+		Latency:            s.latency.snapshot(),
+		TruncatedResponses: s.truncatedResponses.Load(),
+		BadCookies:         s.badCookies.Load(),
+		QueriesByType:      s.queryTypes.snapshot(),
+	}
+}
+
+// queryTypeCounter counts received queries by their question type. The zero value is ready to use.
+type queryTypeCounter struct {
+	counts map[DNS_Type.Type]uint64
+	mu     sync.Mutex
+}
+
+// add counts one query of type qtype.
+func (c *queryTypeCounter) add(qtype DNS_Type.Type) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[DNS_Type.Type]uint64)
+	}
+	c.counts[qtype]++
+}
+
+// snapshot returns a copy of the counts.
+func (c *queryTypeCounter) snapshot() map[DNS_Type.Type]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot := make(map[DNS_Type.Type]uint64, len(c.counts))
+	for qtype, count := range c.counts {
+		snapshot[qtype] = count
+	}
+	return snapshot
+}
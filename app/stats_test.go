@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"net"
+	"testing"
+)
+
+func TestStats_CountsTruncatedResponses(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	ips := make([]net.IP, 0, 64)
+	for i := 1; i <= 64; i++ {
+		ips = append(ips, net.IPv4(192, 0, 2, byte(i)))
+	}
+	WithHost("big.example", ips...)(s)
+	WithHost("small.example", net.IPv4(192, 0, 2, 1))(s)
+
+	for _, name := range []string{"small.example", "big.example"} {
+		query, err := Message.CreateDNSQuery(name, DNS_Type.A, DNS_Class.IN, false)
+		if err != nil {
+			t.Fatalf("failed to create query: %v", err)
+		}
+		resp := exchangeUDP(t, s, query)
+		if want := name == "big.example"; resp.Header.IsTC() != want {
+			t.Fatalf("expected TC %v for %s, got %v", want, name, resp.Header.IsTC())
+		}
+	}
+
+	if got := s.Stats().TruncatedResponses; got != 1 {
+		t.Fatalf("expected 1 truncated response, got %d", got)
+	}
+}
+
+func TestStats_CountsQueriesByType(t *testing.T) {
+	s := newUDPTestServer(t, "127.0.0.1:1")
+	WithHost("host.example", net.IPv4(192, 0, 2, 1))(s)
+
+	for _, qtype := range []DNS_Type.Type{DNS_Type.A, DNS_Type.MX, DNS_Type.A} {
+		query, err := Message.CreateDNSQuery("host.example", qtype, DNS_Class.IN, false)
+		if err != nil {
+			t.Fatalf("failed to create query: %v", err)
+		}
+		exchangeUDP(t, s, query)
+	}
+
+	counts := s.Stats().QueriesByType
+	if counts[DNS_Type.A] != 2 || counts[DNS_Type.MX] != 1 || len(counts) != 2 {
+		t.Fatalf("expected 2 A and 1 MX queries, got %v", counts)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+)
+
+// stubNameservers returns the most specific stub zone containing domain and its nameservers, or nil nameservers if
+// domain is not inside any stub zone.
+func (s *DNSServer) stubNameservers(domain string) (string, []RootServer) {
+	var match string
+	var nameservers []RootServer
+	for zone, servers := range s.stubZones {
+		if name.IsSubdomain(domain, zone) && (nameservers == nil || len(zone) > len(match)) {
+			match = zone
+			nameservers = servers
+		}
+	}
+	return match, nameservers
+}
+
+// startingNameservers returns the nameservers recursive resolution of domain starts from, and the zone they are
+// authoritative for: the nameservers of its stub zone if it is inside one, otherwise the nameservers of the closest
+// cached delegation, and the root servers if there is none.
+func (s *DNSServer) startingNameservers(domain string) (string, []RootServer) {
+	if zone, nameservers := s.stubNameservers(domain); nameservers != nil {
+		return zone, nameservers
+	}
+	if zone, nameservers := s.cachedDelegation(domain); nameservers != nil {
+		return zone, nameservers
+	}
+	return ".", s.rootServers
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/cache"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRecursion_StubZoneQueriesConfiguredNameservers(t *testing.T) {
+	var rootQueries atomic.Int32
+	root := startUDPStubAt(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, func(query Message.Message) Message.Message {
+		rootQueries.Add(1)
+		return answerA(t, "192.0.2.1", 300)(query)
+	})
+	stubNS := startUDPStubAt(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: root.Port},
+		func(query Message.Message) Message.Message {
+			resp := answerA(t, "192.0.2.53", 300)(query)
+			resp.Header.SetAA(true)
+			return resp
+		})
+
+	s := newTestServer("127.0.0.1:0")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.nameserverPort = root.Port
+	s.rootServers = []RootServer{{Name: "root.test", IP: root.IP}}
+	WithStubZone("Corp.Example.", stubNS.IP)(s)
+
+	query, err := Message.CreateDNSQuery("www.corp.example", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp, err := s.resolveRecursively(context.Background(), &query)
+	if err != nil {
+		t.Fatalf("resolveRecursively returned error: %v", err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	ip, err := resp.Answers[0].GetRDATAAsARecord()
+	if err != nil {
+		t.Fatalf("failed to read answer: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("192.0.2.53")) {
+		t.Fatalf("expected the answer of the stub zone nameserver, got %v", ip)
+	}
+	if n := rootQueries.Load(); n != 0 {
+		t.Fatalf("expected the root servers not to be queried for a stub zone name, got %d queries", n)
+	}
+
+	if zone, got := s.startingNameservers("www.example.org"); zone != "." || len(got) != 1 || !got[0].IP.Equal(root.IP) {
+		t.Fatalf("expected names outside the stub zone to start from the root servers, got %q %v", zone, got)
+	}
+}
+
+func TestWithStubZone_IgnoresZoneWithoutNameservers(t *testing.T) {
+	s := newTestServer("127.0.0.1:0")
+	s.cache = cache.NewDNSCache(s.logger)
+	s.rootServers = []RootServer{{Name: "a.root-servers.net", IP: net.IPv4(198, 41, 0, 4)}}
+	WithStubZone("corp.example")(s)
+
+	zone, got := s.startingNameservers("www.corp.example")
+	if zone != "." || len(got) != 1 || !got[0].IP.Equal(net.IPv4(198, 41, 0, 4)) {
+		t.Fatalf("expected a stub zone without nameservers to start from the root servers, got %q %v", zone, got)
+	}
+}
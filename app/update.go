@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/tsig"
+	"log/slog"
+	"net"
+)
+
+// processUpdateTCP handles a dynamic update (RFC 2136) received over TCP and returns the marshalled response.
+// Updates are only accepted if they carry a valid TSIG signature made with the configured update key, or if they
+// come from a client inside the update ACL.
+func (s *DNSServer) processUpdateTCP(data []byte, msg *Message.Message, from net.Addr) ([]byte, error) {
+	if s.zone == nil {
+		return s.updateResponse(msg, header.NotImplemented, nil)
+	}
+
+	authorized := s.updateAllowedFrom(from)
+
+	var requestMAC []byte
+	if s.updateKey != nil && tsig.HasTSIG(data) {
+		mac, err := tsig.Verify(data, *s.updateKey, nil, s.now())
+		if err != nil {
+			s.logger.Warn("Rejected update with invalid TSIG", slog.Any("from", from), slog.Any("error", err))
+			return s.updateResponse(msg, header.NotAuth, nil)
+		}
+		requestMAC = mac
+		authorized = true
+	}
+
+	if !authorized {
+		s.logger.Warn("Refused unauthorized update", slog.Any("from", from))
+		return s.updateResponse(msg, header.Refused, nil)
+	}
+
+	rcode := s.zone.ApplyUpdate(msg)
+	s.logger.Info("Processed dynamic update",
+		slog.Any("from", from),
+		slog.String("zone", s.zone.Origin()),
+		slog.Any("rcode", rcode))
+
+	return s.updateResponse(msg, rcode, requestMAC)
+}
+
+// updateAllowedFrom reports whether from is inside one of the networks allowed to send updates.
+func (s *DNSServer) updateAllowedFrom(from net.Addr) bool {
+	ip := clientIP(from)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range s.updateACL {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateResponse marshals the response to an update, echoing its zone section. If requestMAC is set the response is
+// signed with the update key.
+func (s *DNSServer) updateResponse(msg *Message.Message, rcode header.ResponseCode, requestMAC []byte) ([]byte, error) {
+	resp := Message.MakeError(msg, rcode)
+
+	data, err := resp.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal update response: %w", err)
+	}
+	if requestMAC == nil {
+		return data, nil
+	}
+
+	signed, _, err := tsig.Sign(data, *s.updateKey, requestMAC, s.now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign update response: %w", err)
+	}
+	return signed, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/tsig"
+	"github.com/blazskufca/dns_server_in_go/internal/zone"
+	"net"
+	"testing"
+	"time"
+)
+
+func createUpdateRequest(t *testing.T, updates ...RR.RR) []byte {
+	t.Helper()
+	msg, err := Message.CreateDNSQuery("example.com", DNS_Type.SOA, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create update: %v", err)
+	}
+	msg.Header.SetOpcode(header.Update)
+	msg.Authority = updates
+	if err := msg.Header.SetNSCOUNT(len(updates)); err != nil {
+		t.Fatalf("failed to set NSCOUNT: %v", err)
+	}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal update: %v", err)
+	}
+	return data
+}
+
+func createZoneRecordA(t *testing.T, name, ip string) RR.RR {
+	t.Helper()
+	rr := RR.RR{}
+	rr.SetName(name)
+	rr.SetClass(DNS_Class.IN)
+	if err := rr.SetTTL(300); err != nil {
+		t.Fatalf("failed to set TTL: %v", err)
+	}
+	if err := rr.SetRDATAToARecord(net.ParseIP(ip)); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	return rr
+}
+
+func TestProcessUpdateTCP(t *testing.T) {
+	key := tsig.Key{Name: "update-key", Algorithm: tsig.HmacSHA256, Secret: []byte("update-secret")}
+	client := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 40000}
+
+	newServer := func() (*DNSServer, *zone.Zone) {
+		z := zone.New("example.com")
+		s := newTestServer("127.0.0.1:53")
+		WithZone(z)(s)
+		WithUpdateKey(key)(s)
+		return s, z
+	}
+
+	send := func(t *testing.T, s *DNSServer, data []byte) header.ResponseCode {
+		t.Helper()
+		respData, err := s.processDNSRequestTCP(data, client)
+		if err != nil {
+			t.Fatalf("processDNSRequestTCP returned error: %v", err)
+		}
+		resp, err := Message.New(respData)
+		if err != nil {
+			t.Fatalf("failed to unmarshal update response: %v", err)
+		}
+		if !resp.Header.IsResponse() || resp.Header.GetOpcode() != header.Update {
+			t.Fatalf("response is not an update response")
+		}
+		return resp.Header.GetRCODE()
+	}
+
+	t.Run("Signed update adds an A record", func(t *testing.T) {
+		s, z := newServer()
+		data := createUpdateRequest(t, createZoneRecordA(t, "host.example.com", "192.0.2.55"))
+		signed, requestMAC, err := tsig.Sign(data, key, nil, time.Now())
+		if err != nil {
+			t.Fatalf("failed to sign update: %v", err)
+		}
+
+		respData, err := s.processDNSRequestTCP(signed, client)
+		if err != nil {
+			t.Fatalf("processDNSRequestTCP returned error: %v", err)
+		}
+		if _, err := tsig.Verify(respData, key, requestMAC, time.Now()); err != nil {
+			t.Fatalf("update response is not signed with the request MAC: %v", err)
+		}
+		resp, err := Message.New(respData)
+		if err != nil {
+			t.Fatalf("failed to unmarshal update response: %v", err)
+		}
+		if resp.Header.GetRCODE() != header.NoError {
+			t.Fatalf("expected NoError, got %s", resp.Header.GetRCODE())
+		}
+
+		rrs, _ := z.Lookup("host.example.com", DNS_Type.A)
+		if len(rrs) != 1 {
+			t.Fatalf("expected the A record to be added, got %d records", len(rrs))
+		}
+	})
+
+	t.Run("Signed update deletes an RRset", func(t *testing.T) {
+		s, z := newServer()
+		if err := z.Add(createZoneRecordA(t, "host.example.com", "192.0.2.55")); err != nil {
+			t.Fatalf("failed to add record: %v", err)
+		}
+
+		del := RR.RR{}
+		del.SetName("host.example.com")
+		del.SetType(DNS_Type.A)
+		del.SetClass(DNS_Class.ANY)
+		signed, _, err := tsig.Sign(createUpdateRequest(t, del), key, nil, time.Now())
+		if err != nil {
+			t.Fatalf("failed to sign update: %v", err)
+		}
+
+		if rcode := send(t, s, signed); rcode != header.NoError {
+			t.Fatalf("expected NoError, got %s", rcode)
+		}
+		if rrs, _ := z.Lookup("host.example.com", DNS_Type.A); len(rrs) != 0 {
+			t.Fatalf("expected the RRset to be deleted, got %d records", len(rrs))
+		}
+	})
+
+	t.Run("Wrong key is rejected", func(t *testing.T) {
+		s, z := newServer()
+		wrong := key
+		wrong.Secret = []byte("wrong-secret")
+		signed, _, err := tsig.Sign(createUpdateRequest(t, createZoneRecordA(t, "host.example.com", "192.0.2.55")),
+			wrong, nil, time.Now())
+		if err != nil {
+			t.Fatalf("failed to sign update: %v", err)
+		}
+
+		if rcode := send(t, s, signed); rcode != header.NotAuth {
+			t.Fatalf("expected NotAuth, got %s", rcode)
+		}
+		if _, exists := z.Lookup("host.example.com", DNS_Type.A); exists {
+			t.Fatalf("rejected update must not be applied")
+		}
+	})
+
+	t.Run("Unsigned update outside of ACL is refused", func(t *testing.T) {
+		s, _ := newServer()
+		data := createUpdateRequest(t, createZoneRecordA(t, "host.example.com", "192.0.2.55"))
+		if rcode := send(t, s, data); rcode != header.Refused {
+			t.Fatalf("expected Refused, got %s", rcode)
+		}
+	})
+
+	t.Run("Unsigned update inside of ACL is accepted", func(t *testing.T) {
+		s, z := newServer()
+		_, network, err := net.ParseCIDR("198.51.100.0/24")
+		if err != nil {
+			t.Fatalf("failed to parse CIDR: %v", err)
+		}
+		WithUpdateACL(network)(s)
+
+		data := createUpdateRequest(t, createZoneRecordA(t, "host.example.com", "192.0.2.55"))
+		if rcode := send(t, s, data); rcode != header.NoError {
+			t.Fatalf("expected NoError, got %s", rcode)
+		}
+		if _, exists := z.Lookup("host.example.com", DNS_Type.A); !exists {
+			t.Fatalf("accepted update must be applied")
+		}
+	})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultMaxOutboundQueries is the default limit of concurrent queries sent to upstream resolvers and nameservers.
+const defaultMaxOutboundQueries = 256
+
+// defaultQueryBudget is the default time allowed for resolving a single client query, across all delegation hops.
+const defaultQueryBudget = 5 * time.Second
+
+// defaultEDNSUDPSize is the default UDP payload size advertised to clients, the size recommended by DNS Flag Day 2020
+// to avoid IP fragmentation.
+const defaultEDNSUDPSize uint16 = 1232
+
+// serverFailureTTL is how long a failed resolution is remembered, so that repeated queries for a broken name are
+// answered with SERVFAIL without resolving it again. RFC 9520 requires at least 1 second and at most 5 minutes.
+const serverFailureTTL = 5 * time.Second
+
+// upstreamAddrsTTL is how long the addresses a hostname upstream resolver resolved to are reused before it is resolved
+// again, so that forwarded queries don't wait for the system resolver.
+const upstreamAddrsTTL = 5 * time.Minute
+
+// happyEyeballsDelay is how long the first upstream address gets to answer before the next address family is tried
+// in parallel, as recommended by RFC 8305 section 5.
+const happyEyeballsDelay = 300 * time.Millisecond
+
+// UpstreamProtocol is the transport queries are forwarded to the upstream resolver over, see WithUpstreamProtocol.
+type UpstreamProtocol uint8
+
+const (
+	// UpstreamUDP forwards queries over UDP and retries truncated responses over TCP.
+	UpstreamUDP UpstreamProtocol = iota
+	// UpstreamTCP forwards every query over TCP, for networks which drop or mangle UDP, like some VPNs.
+	UpstreamTCP
+)
+
+// upstreamAddrCache holds the addresses the upstream resolver host last resolved to, see upstreamAddrs.
+type upstreamAddrCache struct {
+	addrs   []string
+	expires time.Time
+	mu      sync.Mutex
+}
+
+// upstreamAddrs resolves the configured upstream resolver into a list of "host:port" addresses to try.
+// If the upstream is a hostname that resolves to both IPv6 and IPv4 addresses one address of each family is returned,
+// IPv6 first, so that they can be raced against each other. The addresses of a hostname are reused for
+// upstreamAddrsTTL, failed lookups are not cached.
+func (s *DNSServer) upstreamAddrs() ([]string, error) {
+	host, port, err := net.SplitHostPort(s.resolverHost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolver address %q: %w", s.resolverHost, err)
+	}
+	if net.ParseIP(host) != nil {
+		return []string{s.resolverHost}, nil
+	}
+
+	s.upstreamAddrCache.mu.Lock()
+	defer s.upstreamAddrCache.mu.Unlock()
+
+	now := s.now()
+	if now.Before(s.upstreamAddrCache.expires) {
+		return s.upstreamAddrCache.addrs, nil
+	}
+	addrs, err := s.lookupUpstreamAddrs(host, port)
+	if err != nil {
+		return nil, err
+	}
+	s.upstreamAddrCache.addrs = addrs
+	s.upstreamAddrCache.expires = now.Add(upstreamAddrsTTL)
+	return addrs, nil
+}
+
+// lookupUpstreamAddrs resolves the upstream resolver hostname host into one "host:port" address per address family,
+// IPv6 first.
+func (s *DNSServer) lookupUpstreamAddrs(host, port string) ([]string, error) {
+	const lookupTimeout = 5 * time.Second
+
+	lookup := s.lookupIPAddr
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	ips, err := lookup(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve resolver host %s: %w", host, err)
+	}
+
+	var v4, v6 string
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			if v4 == "" {
+				v4 = net.JoinHostPort(ip.String(), port)
+			}
+		} else if v6 == "" {
+			v6 = net.JoinHostPort(ip.String(), port)
+		}
+	}
+
+	var addrs []string
+	if v6 != "" {
+		addrs = append(addrs, v6)
+	}
+	if v4 != "" {
+		addrs = append(addrs, v4)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("resolver host %s resolved to no addresses", host)
+	}
+	return addrs, nil
+}
+
+// availableUpstreamAddrs returns the addresses of the upstream resolver which are not backing off after repeated
+// failures.
+func (s *DNSServer) availableUpstreamAddrs() ([]string, error) {
+	addrs, err := s.upstreamAddrs()
+	if err != nil {
+		return nil, err
+	}
+	return s.backoff.available(addrs)
+}
+
+// errOutpaced is the cause an upstream attempt is cancelled with when an attempt started after it answered first.
+var errOutpaced = errors.New("outpaced by a later upstream attempt")
+
+// isTimeout reports whether err is a query running into its deadline.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// observeUpstream records the response time and outcome of a query to the upstream address addr which started at
+// start. Queries which timed out or were outpaced by a later attempt, see raceUpstreams, count as failures. Other
+// failures after ctx was cancelled, because a query started earlier won the race or the client query is done, are
+// not held against the upstream.
+func (s *DNSServer) observeUpstream(ctx context.Context, addr string, start time.Time, err error) {
+	if err != nil && !isTimeout(err) && !errors.Is(context.Cause(ctx), errOutpaced) &&
+		errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	s.latency.observeResult(addr, start, err)
+	s.backoff.observe(addr, err)
+}
+
+// raceUpstreams runs exchange against each of the addresses and returns the first successful response.
+// The first address is tried immediately, and every following address is started either after happyEyeballsDelay
+// or as soon as the previous attempt fails, whichever happens first. Every attempt gets its own context derived from
+// ctx, which is cancelled once raceUpstreams returns. Attempts started before the winning one are cancelled with
+// errOutpaced as the cause, as they failed to answer in time.
+func raceUpstreams(ctx context.Context, addrs []string,
+	exchange func(ctx context.Context, addr string) (*Message.Message, error)) (*Message.Message, error) {
+	type result struct {
+		index int
+		msg   *Message.Message
+		err   error
+	}
+
+	if len(addrs) == 0 {
+		return nil, errors.New("no upstream addresses to query")
+	}
+	if len(addrs) == 1 {
+		return exchange(ctx, addrs[0])
+	}
+
+	results := make(chan result, len(addrs))
+	cancels := make([]context.CancelCauseFunc, 0, len(addrs))
+	defer func() {
+		for _, cancel := range cancels {
+			cancel(nil)
+		}
+	}()
+
+	next, pending := 0, 0
+	startNext := func() {
+		index, addr := next, addrs[next]
+		attemptCtx, cancel := context.WithCancelCause(ctx)
+		cancels = append(cancels, cancel)
+		next++
+		pending++
+		go func() {
+			msg, err := exchange(attemptCtx, addr)
+			results <- result{index: index, msg: msg, err: err}
+		}()
+	}
+
+	timer := time.NewTimer(happyEyeballsDelay)
+	defer timer.Stop()
+
+	startNext()
+
+	var errs []error
+	for pending > 0 {
+		timerC := timer.C
+		if next >= len(addrs) {
+			timerC = nil
+		}
+
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil && r.msg != nil {
+				for _, cancel := range cancels[:r.index] {
+					cancel(errOutpaced)
+				}
+				return r.msg, nil
+			}
+			if r.err == nil {
+				r.err = errors.New("upstream returned nil response")
+			}
+			errs = append(errs, r.err)
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(happyEyeballsDelay)
+			}
+		case <-timerC:
+			startNext()
+			timer.Reset(happyEyeballsDelay)
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// acquireOutbound blocks until an outbound query slot is free, or ctx is done, and returns the function releasing it.
+// Releasing more than once is a no-op, so callers can release early and still defer the release.
+// Without a limit configured, the slots are unlimited.
+func (s *DNSServer) acquireOutbound(ctx context.Context) (func(), error) {
+	if s.outbound == nil {
+		return func() {}, nil
+	}
+	select {
+	case s.outbound <- struct{}{}:
+		return sync.OnceFunc(func() { <-s.outbound }), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for an outbound query slot: %w", ctx.Err())
+	}
+}
+
+// queryContext returns the context bounding the resolution of a single client query by the configured budget.
+func (s *DNSServer) queryContext() (context.Context, context.CancelFunc) {
+	if s.queryBudget <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.queryBudget)
+}
+
+// upstreamConn is a connection to an upstream which is closed as soon as the context it was dialled with is done.
+type upstreamConn struct {
+	net.Conn
+	stop func() bool
+}
+
+// Close closes the connection and stops watching its context.
+func (c *upstreamConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// dialUpstream connects to addr and sets the connection deadline to timeout from now, or to the deadline of ctx if
+// that comes first. This keeps a single hop from outliving the budget of the whole resolution. The connection is
+// closed once ctx is cancelled, so that a query which is no longer needed does not wait for its deadline.
+func dialUpstream(ctx context.Context, network, addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to set connection deadline: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	return &upstreamConn{Conn: conn, stop: stop}, nil
+}
+
+// nameserverAddr returns the "host:port" address nameserver ip is queried on.
+func (s *DNSServer) nameserverAddr(ip net.IP) string {
+	const standardDNSPort int = 53
+
+	port := standardDNSPort
+	if s.nameserverPort != 0 {
+		port = s.nameserverPort
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
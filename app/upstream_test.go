@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/clock"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubHandler builds a response for a query received by a stub upstream.
+type stubHandler func(query Message.Message) Message.Message
+
+// answerA returns a stubHandler which answers every query with a single A record.
+func answerA(t testing.TB, ip string, ttl int) stubHandler {
+	t.Helper()
+	return func(query Message.Message) Message.Message {
+		resp := query
+		resp.Header.SetQRFlag(true)
+		rr := RR.RR{}
+		rr.SetName(query.Questions[0].Name)
+		rr.SetClass(DNS_Class.IN)
+		if err := rr.SetTTL(ttl); err != nil {
+			t.Errorf("failed to set TTL: %v", err)
+		}
+		if err := rr.SetRDATAToARecord(net.ParseIP(ip)); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+		resp.Answers = []RR.RR{rr}
+		if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+		return resp
+	}
+}
+
+// startUDPStub starts a UDP upstream on 127.0.0.1 which answers queries with handler and returns its address.
+// Every query is handled in its own goroutine.
+func startUDPStub(t *testing.T, handler stubHandler) *net.UDPAddr {
+	t.Helper()
+	return startUDPStubAt(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, handler)
+}
+
+// startUDPStubAt is startUDPStub listening on addr.
+func startUDPStubAt(t *testing.T, addr *net.UDPAddr, handler stubHandler) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to start UDP stub: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query, err := Message.New(append([]byte(nil), buf[:n]...))
+			if err != nil {
+				continue
+			}
+			go func() {
+				resp := handler(query)
+				data, err := resp.MarshalBinary()
+				if err != nil {
+					return
+				}
+				_, _ = conn.WriteToUDP(data, addr)
+			}()
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// startTCPStub starts a TCP upstream on 127.0.0.1 and port (0 picks a free one) which answers queries with handler
+// and returns its address.
+func startTCPStub(t *testing.T, port int, handler stubHandler) *net.TCPAddr {
+	t.Helper()
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("failed to start TCP stub: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				lenBuf := make([]byte, 2)
+				if _, err := io.ReadFull(conn, lenBuf); err != nil {
+					return
+				}
+				msgBuf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+				if _, err := io.ReadFull(conn, msgBuf); err != nil {
+					return
+				}
+				query, err := Message.New(msgBuf)
+				if err != nil {
+					return
+				}
+				resp := handler(query)
+				data, err := resp.MarshalBinary()
+				if err != nil {
+					return
+				}
+				binary.BigEndian.PutUint16(lenBuf, uint16(len(data)))
+				_, _ = conn.Write(append(lenBuf, data...))
+			}()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr)
+}
+
+// dualStackLookup pretends that every host resolves to both ::1 and 127.0.0.1.
+func dualStackLookup(context.Context, string) ([]net.IPAddr, error) {
+	return []net.IPAddr{{IP: net.IPv6loopback}, {IP: net.IPv4(127, 0, 0, 1)}}, nil
+}
+
+func newTestServer(resolverHost string) *DNSServer {
+	return &DNSServer{
+		resolverHost: resolverHost,
+		logger:       slog.New(slog.DiscardHandler),
+	}
+}
+
+func TestUpstreamAddrs(t *testing.T) {
+	s := newTestServer("dual.test:53")
+	s.lookupIPAddr = dualStackLookup
+
+	addrs, err := s.upstreamAddrs()
+	if err != nil {
+		t.Fatalf("upstreamAddrs returned error: %v", err)
+	}
+	if len(addrs) != 2 || addrs[0] != "[::1]:53" || addrs[1] != "127.0.0.1:53" {
+		t.Fatalf("expected IPv6 then IPv4 address, got %v", addrs)
+	}
+
+	s = newTestServer("8.8.8.8:53")
+	addrs, err = s.upstreamAddrs()
+	if err != nil {
+		t.Fatalf("upstreamAddrs returned error: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0] != "8.8.8.8:53" {
+		t.Fatalf("expected literal address to be used as is, got %v", addrs)
+	}
+}
+
+func TestUpstreamAddrs_CachesLookup(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	s := newTestServer("dual.test:53")
+	s.clock = clk
+	var lookups int
+	s.lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		return dualStackLookup(ctx, host)
+	}
+
+	for range 3 {
+		if _, err := s.upstreamAddrs(); err != nil {
+			t.Fatalf("upstreamAddrs returned error: %v", err)
+		}
+	}
+	if lookups != 1 {
+		t.Fatalf("expected the upstream host to be resolved once, got %d lookups", lookups)
+	}
+
+	clk.Advance(upstreamAddrsTTL)
+	if _, err := s.upstreamAddrs(); err != nil {
+		t.Fatalf("upstreamAddrs returned error: %v", err)
+	}
+	if lookups != 2 {
+		t.Fatalf("expected the upstream host to be resolved again after %s, got %d lookups", upstreamAddrsTTL, lookups)
+	}
+}
+
+func TestForwardToResolver_DualStackUsesWorkingFamily(t *testing.T) {
+	stub := startUDPStub(t, answerA(t, "1.2.3.4", 300))
+
+	s := newTestServer(net.JoinHostPort("dual.test", strconv.Itoa(stub.Port)))
+	s.lookupIPAddr = dualStackLookup
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	queryData, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := s.forwardToResolver(context.Background(), queryData)
+	if err != nil {
+		t.Fatalf("forwardToResolver returned error: %v", err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	ip, err := resp.Answers[0].GetRDATAAsARecord()
+	if err != nil || !ip.Equal(net.ParseIP("1.2.3.4")) {
+		t.Fatalf("expected answer 1.2.3.4, got %v (err: %v)", ip, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("dual stack race took too long: %v", elapsed)
+	}
+}
+
+func TestForwardToResolverTCP_DualStackUsesWorkingFamily(t *testing.T) {
+	stub := startTCPStub(t, 0, answerA(t, "5.6.7.8", 300))
+
+	s := newTestServer(net.JoinHostPort("dual.test", strconv.Itoa(stub.Port)))
+	s.lookupIPAddr = dualStackLookup
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	queryData, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	resp, err := s.forwardToResolverTCP(context.Background(), queryData)
+	if err != nil {
+		t.Fatalf("forwardToResolverTCP returned error: %v", err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	ip, err := resp.Answers[0].GetRDATAAsARecord()
+	if err != nil || !ip.Equal(net.ParseIP("5.6.7.8")) {
+		t.Fatalf("expected answer 5.6.7.8, got %v (err: %v)", ip, err)
+	}
+}
+
+func TestForwardToResolver_InvalidResponseTriesNextAddress(t *testing.T) {
+	refused := func(query Message.Message) Message.Message {
+		resp := query
+		resp.Header.SetQRFlag(true)
+		resp.Header.SetRCODE(header.Refused)
+		return resp
+	}
+
+	// The IPv6 address is tried first and refuses the query, the IPv4 address answers.
+	v4 := startUDPStub(t, answerA(t, "1.2.3.4", 300))
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback, Port: v4.Port})
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	_ = conn.Close()
+	startUDPStubAt(t, &net.UDPAddr{IP: net.IPv6loopback, Port: v4.Port}, refused)
+
+	s := newTestServer(net.JoinHostPort("dual.test", strconv.Itoa(v4.Port)))
+	s.lookupIPAddr = dualStackLookup
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	queryData, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	resp, err := s.forwardToResolver(context.Background(), queryData)
+	if err != nil {
+		t.Fatalf("forwardToResolver returned error: %v", err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected the answer of the IPv4 address, got %v", resp.Answers)
+	}
+	if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(net.ParseIP("1.2.3.4")) {
+		t.Fatalf("expected answer 1.2.3.4, got %v (err: %v)", ip, err)
+	}
+}
+
+func TestForwardToResolver_RetriesTruncatedOverTCP(t *testing.T) {
+	truncated := func(query Message.Message) Message.Message {
+		resp := answerA(t, "1.1.1.1", 300)(query)
+		resp.Header.SetTC(true)
+		return resp
+	}
+	full := func(query Message.Message) Message.Message {
+		resp := answerA(t, "1.1.1.1", 300)(query)
+		extra := answerA(t, "2.2.2.2", 300)(query)
+		resp.Answers = append(resp.Answers, extra.Answers...)
+		if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+		return resp
+	}
+
+	udp := startUDPStub(t, truncated)
+	startTCPStub(t, udp.Port, full)
+
+	s := newTestServer(udp.String())
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	queryData, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	resp, err := s.forwardToResolver(context.Background(), queryData)
+	if err != nil {
+		t.Fatalf("forwardToResolver returned error: %v", err)
+	}
+	if resp.Header.IsTC() {
+		t.Fatalf("expected the complete response, got a truncated one")
+	}
+	if len(resp.Answers) != 2 {
+		t.Fatalf("expected both answers fetched over TCP, got %d", len(resp.Answers))
+	}
+}
+
+func TestForwardToResolver_TCPOnly(t *testing.T) {
+	var udpQueries atomic.Int32
+	countUDP := func(query Message.Message) Message.Message {
+		udpQueries.Add(1)
+		return answerA(t, "192.0.2.1", 300)(query)
+	}
+	udp := startUDPStub(t, countUDP)
+	startTCPStub(t, udp.Port, answerA(t, "192.0.2.2", 300))
+
+	s := newTestServer(udp.String())
+	WithUpstreamProtocol(UpstreamTCP)(s)
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	queryData, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	for range 3 {
+		resp, err := s.forwardToResolver(context.Background(), queryData)
+		if err != nil {
+			t.Fatalf("forwardToResolver returned error: %v", err)
+		}
+		if ip, err := resp.Answers[0].GetRDATAAsARecord(); err != nil || !ip.Equal(net.IPv4(192, 0, 2, 2)) {
+			t.Fatalf("expected the answer of the TCP upstream, got %v (%v)", ip, err)
+		}
+	}
+	if got := udpQueries.Load(); got != 0 {
+		t.Fatalf("expected no UDP queries, got %d", got)
+	}
+}
+
+func TestForwardToResolver_ReadsLargeEDNSResponse(t *testing.T) {
+	const ednsPayloadSize = 4096
+	text := strings.Repeat("x", 1000)
+
+	largeTXT := func(query Message.Message) Message.Message {
+		resp := query
+		resp.Header.SetQRFlag(true)
+		rr := RR.RR{}
+		rr.SetName(query.Questions[0].Name)
+		rr.SetClass(DNS_Class.IN)
+		if err := rr.SetTTL(300); err != nil {
+			t.Errorf("failed to set TTL: %v", err)
+		}
+		rr.SetRDATAToTXTRecord(text)
+		resp.Answers = []RR.RR{rr}
+		if err := resp.Header.SetANCOUNT(len(resp.Answers)); err != nil {
+			t.Errorf("failed to set ANCOUNT: %v", err)
+		}
+		return resp
+	}
+
+	udp := startUDPStub(t, largeTXT)
+	s := newTestServer(udp.String())
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.TXT, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	if err := query.SetOPT(&Message.OPTRecord{UDPSize: ednsPayloadSize}); err != nil {
+		t.Fatalf("failed to set OPT record: %v", err)
+	}
+	queryData, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	resp, err := s.forwardToResolver(context.Background(), queryData)
+	if err != nil {
+		t.Fatalf("forwardToResolver returned error: %v", err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answers))
+	}
+	got, err := resp.Answers[0].GetRDATAAsTXTRecord()
+	if err != nil {
+		t.Fatalf("failed to read TXT record: %v", err)
+	}
+	if got != text {
+		t.Fatalf("expected the complete %d byte TXT record, got %d bytes", len(text), len(got))
+	}
+}
+
+func TestForwardToResolver_LimitsOutboundConcurrency(t *testing.T) {
+	const limit = 3
+	const clients = 20
+
+	var inFlight, maxInFlight atomic.Int32
+	stub := startUDPStub(t, func(query Message.Message) Message.Message {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return answerA(t, "192.0.2.1", 300)(query)
+	})
+
+	s := newTestServer(stub.String())
+	WithMaxOutboundQueries(limit)(s)
+
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	queryData, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.forwardToResolver(context.Background(), queryData); err != nil {
+				t.Errorf("forwardToResolver returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := maxInFlight.Load(); got > limit {
+		t.Fatalf("expected at most %d concurrent upstream queries, got %d", limit, got)
+	}
+	if got := maxInFlight.Load(); got == 0 {
+		t.Fatalf("expected the upstream to receive queries")
+	}
+}
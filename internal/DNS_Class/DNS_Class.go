@@ -11,6 +11,10 @@ const (
 	CH Class = 3
 	// HS represents the Hesiod [Dyer 87]
 	HS Class = 4
+	// NONE is used by dynamic updates to delete a specific RR (RFC 2136)
+	NONE Class = 254
+	// ANY matches any class, also used by TSIG and dynamic updates
+	ANY Class = 255
 )
 
 func (c Class) String() string {
@@ -23,6 +27,10 @@ func (c Class) String() string {
 		return "CH - CHAOS class"
 	case HS:
 		return "HS - Hesiod class"
+	case NONE:
+		return "NONE - No class"
+	case ANY:
+		return "ANY - Any class"
 	default:
 		return "Unknown class"
 	}
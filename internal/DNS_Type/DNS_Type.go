@@ -37,6 +37,28 @@ const (
 	TXT Type = 16
 	// AAAA represents a IPv6 host address query
 	AAAA Type = 28
+	// SRV represents a service location (RFC 2782)
+	SRV Type = 33
+	// NAPTR represents a naming authority pointer (RFC 3403)
+	NAPTR Type = 35
+	// OPT represents the EDNS(0) pseudo record
+	OPT Type = 41
+	// DS represents a delegation signer (RFC 4034)
+	DS Type = 43
+	// RRSIG represents a DNSSEC signature over an RRset (RFC 4034)
+	RRSIG Type = 46
+	// NSEC represents the next secure record of a DNSSEC signed zone (RFC 4034)
+	NSEC Type = 47
+	// DNSKEY represents a DNSSEC public key (RFC 4034)
+	DNSKEY Type = 48
+	// TSIG represents a transaction signature (RFC 2845)
+	TSIG Type = 250
+	// AXFR represents a request for a transfer of an entire zone
+	AXFR Type = 252
+	// ANY represents a request for all records
+	ANY Type = 255
+	// CAA represents a certification authority authorization (RFC 8659)
+	CAA Type = 257
 )
 
 func (t Type) String() string {
@@ -75,6 +97,28 @@ func (t Type) String() string {
 		return "TXT - Text strings"
 	case AAAA:
 		return "AAAA - IPv6 host addresses"
+	case SRV:
+		return "SRV - Service location"
+	case NAPTR:
+		return "NAPTR - Naming authority pointer"
+	case OPT:
+		return "OPT - EDNS(0) pseudo record"
+	case DS:
+		return "DS - Delegation signer"
+	case RRSIG:
+		return "RRSIG - DNSSEC signature"
+	case NSEC:
+		return "NSEC - Next secure record"
+	case DNSKEY:
+		return "DNSKEY - DNSSEC public key"
+	case TSIG:
+		return "TSIG - Transaction signature"
+	case AXFR:
+		return "AXFR - Transfer of an entire zone"
+	case ANY:
+		return "ANY - All records"
+	case CAA:
+		return "CAA - Certification authority authorization"
 	default:
 		return "Unknown"
 	}
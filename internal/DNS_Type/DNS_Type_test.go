@@ -0,0 +1,27 @@
+package DNS_Type
+
+import "testing"
+
+func TestTypeString(t *testing.T) {
+	tests := []struct {
+		want string
+		t    Type
+	}{
+		{t: AAAA, want: "AAAA - IPv6 host addresses"},
+		{t: SRV, want: "SRV - Service location"},
+		{t: NAPTR, want: "NAPTR - Naming authority pointer"},
+		{t: OPT, want: "OPT - EDNS(0) pseudo record"},
+		{t: DS, want: "DS - Delegation signer"},
+		{t: RRSIG, want: "RRSIG - DNSSEC signature"},
+		{t: NSEC, want: "NSEC - Next secure record"},
+		{t: DNSKEY, want: "DNSKEY - DNSSEC public key"},
+		{t: ANY, want: "ANY - All records"},
+		{t: CAA, want: "CAA - Certification authority authorization"},
+		{t: Type(65280), want: "Unknown"},
+	}
+	for _, tt := range tests {
+		if got := tt.t.String(); got != tt.want {
+			t.Errorf("Type(%d).String() = %q, want %q", uint16(tt.t), got, tt.want)
+		}
+	}
+}
@@ -8,9 +8,24 @@ import (
 	"github.com/blazskufca/dns_server_in_go/internal/RR"
 
 	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
 	"github.com/blazskufca/dns_server_in_go/internal/question"
 )
 
+var (
+	ErrNilMessage       = errors.New("message is nil")
+	ErrIDMismatch       = errors.New("response ID does not match the query ID")
+	ErrNotResponse      = errors.New("message is not a response")
+	ErrQuestionMismatch = errors.New("response does not echo the query question")
+	ErrCountMismatch    = errors.New("header section count does not match the section length")
+	ErrUnexpectedRCODE  = errors.New("response has unexpected RCODE")
+	// ErrMultipleOPT is returned when unmarshalling a message with more than one OPT record, which RFC 6891 section
+	// 6.1.1 requires to be answered with FORMERR.
+	ErrMultipleOPT = errors.New("message contains more than one OPT record")
+	// ErrQuestionCount is returned when fewer questions than QDCOUNT announces can be parsed.
+	ErrQuestionCount = errors.New("questions do not match QDCOUNT")
+)
+
 // Message represents a DNS message.
 type Message struct {
 	Questions  []question.Question
@@ -48,7 +63,7 @@ func (msg *Message) UnmarshalBinary(buf []byte) error {
 	for i := 0; i < int(msg.Header.GetQDCOUNT()); i++ {
 		q, bytesRead, err := question.Unmarshal(buf[curOffset:], buf)
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: question %d of %d: %w", ErrQuestionCount, i+1, msg.Header.GetQDCOUNT(), err)
 		}
 		msg.Questions[i] = q
 		curOffset += bytesRead
@@ -89,6 +104,11 @@ func (msg *Message) UnmarshalBinary(buf []byte) error {
 		if err != nil {
 			return err
 		}
+		if add.Type == DNS_Type.OPT {
+			if _, hasOPT := msg.GetOPT(); hasOPT {
+				return ErrMultipleOPT
+			}
+		}
 		msg.Additional = append(msg.Additional, add)
 		curOffset += bytesRead
 	}
@@ -99,46 +119,150 @@ func (msg *Message) UnmarshalBinary(buf []byte) error {
 // MarshalBinary marshals the Message into binary format which will be sent across the wire.
 // It fulfills the encoding.BinaryMarshaler interface.
 func (msg *Message) MarshalBinary() ([]byte, error) {
+	result, _, err := msg.marshal()
+	return result, err
+}
+
+// MarshalBinaryWithLimit marshals the Message like MarshalBinary, but never returns more than limit bytes.
+// A message that does not fit is truncated by dropping the Additional records, then the Authority records and finally
+// Answers from the end. The TC flag is only set if Answer or Authority records were dropped, Additional records are
+// optional and dropping them alone does not make the message truncated
+// (https://datatracker.ietf.org/doc/html/rfc2181#section-9). The OPT record is kept, so a truncated response still carries its EDNS
+// payload size, extended RCODE and options (https://datatracker.ietf.org/doc/html/rfc6891#section-7), and its size is
+// reserved when deciding what fits.
+// When it truncates, MarshalBinaryWithLimit modifies msg: the dropped records are removed from its sections and its TC
+// flag and section counts are set, so that msg keeps matching the returned bytes. Callers which need the original
+// message have to pass a copy.
+// The message is only marshalled once, whether it fits or not.
+func (msg *Message) MarshalBinaryWithLimit(limit int) ([]byte, error) {
+	result, ends, err := msg.marshal()
+	if err != nil {
+		return nil, err
+	}
+	if len(result) <= limit {
+		return result, nil
+	}
+
+	var optBytes []byte
+	var kept []RR.RR
+	for _, add := range msg.Additional {
+		if add.Type == DNS_Type.OPT {
+			if optBytes, err = add.MarshalBinary(); err != nil {
+				return nil, fmt.Errorf("failed to marshal OPT record: %w", err)
+			}
+			kept = []RR.RR{add}
+			break
+		}
+	}
+	budget := limit - len(optBytes)
+
+	questionsEnd := ends[0]
+	if questionsEnd > budget {
+		return nil, fmt.Errorf("message without records exceeds %d bytes", limit)
+	}
+
+	answers := 0
+	end := questionsEnd
+	for answers < len(msg.Answers) && ends[answers+1] <= budget {
+		end = ends[answers+1]
+		answers++
+	}
+	authorityEnd := ends[len(msg.Answers)+len(msg.Authority)]
+	keepAuthority := answers == len(msg.Answers) && len(msg.Authority) > 0 && authorityEnd <= budget
+	if keepAuthority {
+		end = authorityEnd
+	}
+
+	if answers < len(msg.Answers) || (len(msg.Authority) > 0 && !keepAuthority) {
+		msg.Header.SetTC(true)
+	}
+	msg.Answers = msg.Answers[:answers]
+	if !keepAuthority {
+		msg.Authority = nil
+	}
+	msg.Additional = kept
+	if err := msg.Header.SetANCOUNT(len(msg.Answers)); err != nil {
+		return nil, err
+	}
+	if err := msg.Header.SetNSCOUNT(len(msg.Authority)); err != nil {
+		return nil, err
+	}
+	if err := msg.Header.SetARCOUNT(len(msg.Additional)); err != nil {
+		return nil, err
+	}
+
 	headerBytes, err := msg.Header.MarshalBinary()
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal header: %w", err)
 	}
+	copy(result, headerBytes)
+
+	return append(result[:end], optBytes...), nil
+}
+
+// WireSize returns the size of the Message on the wire without name compression, without marshalling it. Since
+// MarshalBinary compresses names it never returns more bytes, so a Message whose WireSize is within a limit is
+// guaranteed to fit into it.
+func (msg *Message) WireSize() int {
+	const headerSize int = 12
+
+	size := headerSize
+	for i := range msg.Questions {
+		size += msg.Questions[i].WireSize()
+	}
+	for _, section := range [][]RR.RR{msg.Answers, msg.Authority, msg.Additional} {
+		for i := range section {
+			size += section[i].WireSize()
+		}
+	}
+	return size
+}
+
+// marshal marshals the Message and also returns the offsets at which its sections end: the first offset is the end of
+// the Questions, followed by the end of every Answer, Authority and Additional record in order.
+// Question names and record owner names are compressed against the names marshalled before them.
+func (msg *Message) marshal() ([]byte, []int, error) {
+	headerBytes, err := msg.Header.MarshalBinary()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal header: %w", err)
+	}
 
 	result := headerBytes
+	ends := make([]int, 0, 1+len(msg.Answers)+len(msg.Authority)+len(msg.Additional))
 
 	for _, q := range msg.Questions {
-		qBytes, err := q.MarshalBinary()
+		result, err = q.AppendBinary(result)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal question: %w", err)
+			return nil, nil, fmt.Errorf("failed to marshal question: %w", err)
 		}
-		result = append(result, qBytes...)
 	}
+	ends = append(ends, len(result))
 
 	for _, a := range msg.Answers {
-		aBytes, err := a.MarshalBinary()
+		result, err = a.AppendBinary(result)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal answer: %w", err)
+			return nil, nil, fmt.Errorf("failed to marshal answer: %w", err)
 		}
-		result = append(result, aBytes...)
+		ends = append(ends, len(result))
 	}
 
 	for _, auth := range msg.Authority {
-		authBytes, err := auth.MarshalBinary()
+		result, err = auth.AppendBinary(result)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal authority: %w", err)
+			return nil, nil, fmt.Errorf("failed to marshal authority: %w", err)
 		}
-		result = append(result, authBytes...)
+		ends = append(ends, len(result))
 	}
 
 	for _, add := range msg.Additional {
-		addBytes, err := add.MarshalBinary()
+		result, err = add.AppendBinary(result)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal additional: %w", err)
+			return nil, nil, fmt.Errorf("failed to marshal additional: %w", err)
 		}
-		result = append(result, addBytes...)
+		ends = append(ends, len(result))
 	}
 
-	return result, nil
+	return result, ends, nil
 }
 
 func Copy(source *Message) (Message, error) {
@@ -191,26 +315,106 @@ func Copy(source *Message) (Message, error) {
 	return msg, nil
 }
 
+// LowercaseNames lowercases the question names and the owner names of all records in msg, as names are compared
+// case-insensitively (https://datatracker.ietf.org/doc/html/rfc4343). Names inside RDATA are left as they are.
+// The Questions slice is replaced rather than modified, so copies of msg sharing it keep their original names.
+func (msg *Message) LowercaseNames() {
+	questions := make([]question.Question, len(msg.Questions), len(msg.Questions)) //nolint:gosimple
+	for i, q := range msg.Questions {
+		q.Name = name.ToLower(q.Name)
+		questions[i] = q
+	}
+	msg.Questions = questions
+
+	for _, section := range [][]RR.RR{msg.Answers, msg.Authority, msg.Additional} {
+		for i := range section {
+			section[i].Name = name.ToLower(section[i].Name)
+		}
+	}
+}
+
+// MakeError returns an error response to query with the given RCODE. It echoes the ID, flags and questions of query,
+// sets the QR flag and has empty Answer, Authority and Additional sections, with the header counts to match. A nil
+// query gives a response with a zero header.
+func MakeError(query *Message, rcode header.ResponseCode) *Message {
+	response := &Message{}
+	if query != nil {
+		response.Header = query.Header
+		response.Questions = query.Questions
+	}
+	response.Header.SetQRFlag(true)
+	response.Header.SetRCODE(rcode)
+
+	if err := response.Header.SetQDCOUNT(len(response.Questions)); err != nil {
+		response.Questions = nil
+		_ = response.Header.SetQDCOUNT(0)
+	}
+	_ = response.Header.SetANCOUNT(0)
+	_ = response.Header.SetNSCOUNT(0)
+	_ = response.Header.SetARCOUNT(0)
+	return response
+}
+
 // AddQuestion adds a question to the Message.Questions slice and increments the Message.Header.QDCOUNT
 func (msg *Message) AddQuestion(q question.Question) error {
 	msg.Questions = append(msg.Questions, q)
 	return msg.Header.SetQDCOUNT(int(msg.Header.GetQDCOUNT()) + 1)
 }
 
-// IsNoErrWithMatchingID asserts that a response is header.Header has RCODE of header.NoError, is a response Message
-// and that the response ID  matches the query id.
-func (msg *Message) IsNoErrWithMatchingID(queryID uint16) bool {
-	if msg.Header.GetRCODE() != header.NoError {
-		return false
+// ValidateResponse checks that response is a well-formed answer to query:
+//   - the response ID matches the query ID,
+//   - the QR flag is set,
+//   - the query questions are echoed back (names are compared case-insensitively),
+//   - the header section counts match the length of each section and
+//   - the RCODE carries an answer, that is header.NoError or header.NameError. Any other RCODE means the server
+//     could not or would not answer the query.
+//
+// The returned error wraps one of the Err* sentinel errors of this package describing the first failed check.
+func ValidateResponse(query, response *Message) error {
+	if query == nil || response == nil {
+		return ErrNilMessage
+	}
+	if response.Header.GetMessageID() != query.Header.GetMessageID() {
+		return fmt.Errorf("%w: sent %d, got %d", ErrIDMismatch, query.Header.GetMessageID(),
+			response.Header.GetMessageID())
+	}
+	if !response.Header.IsResponse() {
+		return ErrNotResponse
+	}
+
+	if !response.QuestionMatches(query) {
+		return fmt.Errorf("%w: sent %s, got %s", ErrQuestionMismatch, questionsString(query.Questions),
+			questionsString(response.Questions))
 	}
-	if msg.Header.GetMessageID() != queryID {
-		return false
+
+	counts := []struct {
+		section string
+		count   uint16
+		length  int
+	}{
+		{"question", response.Header.GetQDCOUNT(), len(response.Questions)},
+		{"answer", response.Header.GetANCOUNT(), len(response.Answers)},
+		{"authority", response.Header.GetNSCOUNT(), len(response.Authority)},
+		{"additional", response.Header.GetARCOUNT(), len(response.Additional)},
 	}
-	return true
+	for _, c := range counts {
+		if int(c.count) != c.length {
+			return fmt.Errorf("%w: %s count %d, got %d records", ErrCountMismatch, c.section, c.count, c.length)
+		}
+	}
+
+	if rcode := response.Header.GetRCODE(); rcode != header.NoError && rcode != header.NameError {
+		return fmt.Errorf("%w: %s", ErrUnexpectedRCODE, rcode)
+	}
+	return nil
 }
 
-// CreateDNSQuery creates a new DNS query message
+// CreateDNSQuery creates a new DNS query message. An empty name is the root domain, like ".", which is marshalled as a
+// single zero byte and unmarshalled as ".".
 func CreateDNSQuery(name string, qtype DNS_Type.Type, qclass DNS_Class.Class, desireRecursion bool) (Message, error) {
+	if name == "" {
+		name = "."
+	}
 	msg := Message{}
 	err := msg.Header.SetRandomID()
 	if err != nil {
@@ -239,3 +443,51 @@ func New(Data []byte) (Message, error) {
 	}
 	return msg, nil
 }
+
+// NewBestEffort creates a new Message from data like New, but does not reject a message of which only some of the
+// questions announced by QDCOUNT can be parsed. Such a message is returned with the questions parsed before the first
+// failure, QDCOUNT lowered to match and no records, as they can't be located past the failed question. The number of
+// questions which could not be parsed is returned alongside it. Messages without a single parsable question are still
+// rejected.
+func NewBestEffort(data []byte) (Message, int, error) {
+	const headerLength int = 12
+
+	msg, err := New(data)
+	if !errors.Is(err, ErrQuestionCount) {
+		return msg, 0, err
+	}
+
+	unmarshalledHeader, errHeader := header.Unmarshal(data[:headerLength])
+	if errHeader != nil {
+		return Message{}, 0, errHeader
+	}
+	msg = Message{Header: *unmarshalledHeader}
+	announced := int(msg.Header.GetQDCOUNT())
+
+	offset := headerLength
+	for len(msg.Questions) < announced {
+		q, bytesRead, errQuestion := question.Unmarshal(data[offset:], data)
+		if errQuestion != nil {
+			break
+		}
+		msg.Questions = append(msg.Questions, q)
+		offset += bytesRead
+	}
+	if len(msg.Questions) == 0 {
+		return Message{}, 0, err
+	}
+
+	if err := msg.Header.SetQDCOUNT(len(msg.Questions)); err != nil {
+		return Message{}, 0, err
+	}
+	if err := msg.Header.SetANCOUNT(0); err != nil {
+		return Message{}, 0, err
+	}
+	if err := msg.Header.SetNSCOUNT(0); err != nil {
+		return Message{}, 0, err
+	}
+	if err := msg.Header.SetARCOUNT(0); err != nil {
+		return Message{}, 0, err
+	}
+	return msg, announced - len(msg.Questions), nil
+}
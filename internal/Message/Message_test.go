@@ -2,12 +2,14 @@ package Message
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
 	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
 	"github.com/blazskufca/dns_server_in_go/internal/RR"
 	"github.com/blazskufca/dns_server_in_go/internal/header"
 	"github.com/blazskufca/dns_server_in_go/internal/question"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
 	"net"
 	"testing"
 )
@@ -16,6 +18,7 @@ func TestCreateDNSQuery(t *testing.T) {
 	tests := []struct {
 		name            string
 		domainName      string
+		wantName        string
 		qtype           DNS_Type.Type
 		qclass          DNS_Class.Class
 		desireRecursion bool
@@ -44,10 +47,18 @@ func TestCreateDNSQuery(t *testing.T) {
 		{
 			name:            "Empty domain",
 			domainName:      "",
+			wantName:        ".",
 			qtype:           DNS_Type.A,
 			qclass:          DNS_Class.IN,
 			desireRecursion: true,
 		},
+		{
+			name:            "Root domain",
+			domainName:      ".",
+			qtype:           DNS_Type.NS,
+			qclass:          DNS_Class.IN,
+			desireRecursion: true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -71,9 +82,13 @@ func TestCreateDNSQuery(t *testing.T) {
 			if len(msg.Questions) != 1 {
 				t.Fatalf("Expected 1 question, got %d", len(msg.Questions))
 			}
+			wantName := tc.domainName
+			if tc.wantName != "" {
+				wantName = tc.wantName
+			}
 			q := msg.Questions[0]
-			if q.Name != tc.domainName {
-				t.Fatalf("Expected question name %s, got %s", tc.domainName, q.Name)
+			if q.Name != wantName {
+				t.Fatalf("Expected question name %s, got %s", wantName, q.Name)
 			}
 			if q.Type != tc.qtype {
 				t.Fatalf("Expected question type %d, got %d", tc.qtype, q.Type)
@@ -85,6 +100,33 @@ func TestCreateDNSQuery(t *testing.T) {
 	}
 }
 
+func TestCreateDNSQuery_RootRoundTrip(t *testing.T) {
+	for _, domainName := range []string{".", ""} {
+		msg, err := CreateDNSQuery(domainName, DNS_Type.NS, DNS_Class.IN, true)
+		if err != nil {
+			t.Fatalf("CreateDNSQuery(%q) returned error: %v", domainName, err)
+		}
+		data, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal query for %q: %v", domainName, err)
+		}
+
+		// The root name is a single zero byte, followed by the type and class.
+		expectedQuestion := []byte{0, 0, byte(DNS_Type.NS), 0, byte(DNS_Class.IN)}
+		if !bytes.Equal(data[12:], expectedQuestion) {
+			t.Fatalf("Expected question %v for %q, got %v", expectedQuestion, domainName, data[12:])
+		}
+
+		unmarshaled, err := New(data)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal query for %q: %v", domainName, err)
+		}
+		if len(unmarshaled.Questions) != 1 || unmarshaled.Questions[0].Name != "." {
+			t.Fatalf("Expected a question for \".\" from %q, got %v", domainName, unmarshaled.Questions)
+		}
+	}
+}
+
 func TestMarshalUnmarshal(t *testing.T) {
 	msg := Message{}
 
@@ -154,7 +196,9 @@ func TestCopy(t *testing.T) {
 		Type:  DNS_Type.A,
 		Class: DNS_Class.IN,
 	}
-	mockA.SetRDATAToARecord(net.IP{127, 0, 0, 1})
+	if err := mockA.SetRDATAToARecord(net.IP{127, 0, 0, 1}); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
 	original.Answers = append(original.Answers, mockA)
 
 	mockNS := RR.RR{
@@ -291,7 +335,9 @@ func TestMessageWithManyRecords(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to set TTL: %v", err)
 		}
-		rr.SetRDATAToARecord(net.IP{192, 168, 0, byte(i)})
+		if err := rr.SetRDATAToARecord(net.IP{192, 168, 0, byte(i)}); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
 		msg.Answers = append(msg.Answers, rr)
 	}
 
@@ -342,7 +388,9 @@ func TestRoundtripMessageWithCompression(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to set TTL: %v", err)
 	}
-	aRecord.SetRDATAToARecord(net.IP{192, 168, 0, 1})
+	if err := aRecord.SetRDATAToARecord(net.IP{192, 168, 0, 1}); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
 	msg.Answers = append(msg.Answers, aRecord)
 
 	nsRecord := RR.RR{}
@@ -556,3 +604,486 @@ func TestMessageEquality(t *testing.T) {
 		t.Fatalf("Binary representations of identical messages don't match")
 	}
 }
+
+func TestValidateResponse(t *testing.T) {
+	newPair := func(t *testing.T) (Message, Message) {
+		t.Helper()
+		query, err := CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+		if err != nil {
+			t.Fatalf("Failed to create query: %v", err)
+		}
+		response, err := Copy(&query)
+		if err != nil {
+			t.Fatalf("Failed to copy query: %v", err)
+		}
+		response.Questions = append([]question.Question(nil), query.Questions...)
+		response.Header.SetQRFlag(true)
+		answer := RR.RR{}
+		answer.SetName("example.com")
+		answer.SetClass(DNS_Class.IN)
+		if err := answer.SetRDATAToARecord(net.ParseIP("192.0.2.1")); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+		response.Answers = []RR.RR{answer}
+		if err := response.Header.SetANCOUNT(len(response.Answers)); err != nil {
+			t.Fatalf("Failed to set ANCOUNT: %v", err)
+		}
+		return query, response
+	}
+
+	tests := []struct {
+		mutate  func(response *Message)
+		wantErr error
+		name    string
+	}{
+		{name: "valid", mutate: func(*Message) {}},
+		{name: "valid NXDOMAIN", mutate: func(r *Message) { r.Header.SetRCODE(header.NameError) }},
+		{name: "valid with differently cased question", mutate: func(r *Message) { r.Questions[0].Name = "ExAmPlE.CoM." }},
+		{name: "ID mismatch", mutate: func(r *Message) { r.Header.ID[1]++ }, wantErr: ErrIDMismatch},
+		{name: "QR not set", mutate: func(r *Message) { r.Header.SetQRFlag(false) }, wantErr: ErrNotResponse},
+		{name: "question name mismatch", mutate: func(r *Message) { r.Questions[0].Name = "example.org" }, wantErr: ErrQuestionMismatch},
+		{name: "question type mismatch", mutate: func(r *Message) { r.Questions[0].Type = DNS_Type.MX }, wantErr: ErrQuestionMismatch},
+		{name: "question missing", mutate: func(r *Message) { r.Questions = nil }, wantErr: ErrQuestionMismatch},
+		{name: "ANCOUNT mismatch", mutate: func(r *Message) { r.Answers = nil }, wantErr: ErrCountMismatch},
+		{name: "ARCOUNT mismatch", mutate: func(r *Message) { _ = r.Header.SetARCOUNT(2) }, wantErr: ErrCountMismatch},
+		{name: "server failure", mutate: func(r *Message) { r.Header.SetRCODE(header.ServerFailure) }, wantErr: ErrUnexpectedRCODE},
+		{name: "refused", mutate: func(r *Message) { r.Header.SetRCODE(header.Refused) }, wantErr: ErrUnexpectedRCODE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, response := newPair(t)
+			tt.mutate(&response)
+
+			err := ValidateResponse(&query, &response)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Expected valid response, got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if err := ValidateResponse(nil, &Message{}); !errors.Is(err, ErrNilMessage) {
+		t.Fatalf("Expected ErrNilMessage, got %v", err)
+	}
+}
+
+// createResponseWithAnswers creates a response for example.com with the given number of A answers, one NS record in
+// the Authority section and one glue record in the Additional section.
+func createResponseWithAnswers(tb testing.TB, answers int) *Message {
+	tb.Helper()
+	msg, err := CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		tb.Fatalf("Failed to create query: %v", err)
+	}
+	msg.Header.SetQRFlag(true)
+
+	for i := 0; i < answers; i++ {
+		rr := RR.RR{Name: "example.com", Type: DNS_Type.A, Class: DNS_Class.IN, TTL: 300}
+		if err := rr.SetRDATAToARecord(net.IP{192, 0, 2, byte(i)}); err != nil {
+			tb.Errorf("failed to set A record: %v", err)
+		}
+		msg.Answers = append(msg.Answers, rr)
+	}
+	ns := RR.RR{Name: "example.com", Class: DNS_Class.IN, TTL: 300}
+	if err := ns.SetRDATAToNSRecord("ns.example.com"); err != nil {
+		tb.Fatalf("Failed to set NS record: %v", err)
+	}
+	msg.Authority = []RR.RR{ns}
+	glue := RR.RR{Name: "ns.example.com", Type: DNS_Type.A, Class: DNS_Class.IN, TTL: 300}
+	if err := glue.SetRDATAToARecord(net.IP{192, 0, 2, 53}); err != nil {
+		tb.Fatalf("failed to set A record: %v", err)
+	}
+	msg.Additional = []RR.RR{glue}
+
+	if err := msg.Header.SetANCOUNT(len(msg.Answers)); err != nil {
+		tb.Fatalf("Failed to set ANCOUNT: %v", err)
+	}
+	if err := msg.Header.SetNSCOUNT(len(msg.Authority)); err != nil {
+		tb.Fatalf("Failed to set NSCOUNT: %v", err)
+	}
+	if err := msg.Header.SetARCOUNT(len(msg.Additional)); err != nil {
+		tb.Fatalf("Failed to set ARCOUNT: %v", err)
+	}
+	return &msg
+}
+
+func TestWireSize(t *testing.T) {
+	for _, answers := range []int{0, 1, 15} {
+		msg := createResponseWithAnswers(t, answers)
+		data, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary returned error: %v", err)
+		}
+
+		// Compression replaces a name by a pointer of at least 2 bytes, so it saves at most the rest of the name.
+		const pointerSize int = 2
+		compressionMargin := 0
+		for _, section := range [][]RR.RR{msg.Answers, msg.Authority, msg.Additional} {
+			for _, rr := range section {
+				compressionMargin += utils.NameWireSize(rr.Name) - pointerSize
+			}
+		}
+
+		size := msg.WireSize()
+		if size < len(data) || size > len(data)+compressionMargin {
+			t.Errorf("%d answers: WireSize() = %d, want between %d and %d", answers, size, len(data),
+				len(data)+compressionMargin)
+		}
+	}
+
+	if got := (&Message{}).WireSize(); got != 12 {
+		t.Errorf("expected an empty message to be the 12 byte header, got %d", got)
+	}
+}
+
+func TestMarshalBinaryWithLimit(t *testing.T) {
+	t.Run("under limit", func(t *testing.T) {
+		msg := createResponseWithAnswers(t, 2)
+		full, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal message: %v", err)
+		}
+
+		data, err := msg.MarshalBinaryWithLimit(512)
+		if err != nil {
+			t.Fatalf("MarshalBinaryWithLimit returned error: %v", err)
+		}
+		if !bytes.Equal(data, full) {
+			t.Fatalf("Expected a message under the limit to be marshalled unchanged")
+		}
+		if msg.Header.IsTC() {
+			t.Fatalf("Expected TC flag to stay clear under the limit")
+		}
+	})
+
+	t.Run("over limit", func(t *testing.T) {
+		msg := createResponseWithAnswers(t, 40)
+
+		data, err := msg.MarshalBinaryWithLimit(512)
+		if err != nil {
+			t.Fatalf("MarshalBinaryWithLimit returned error: %v", err)
+		}
+		if len(data) > 512 {
+			t.Fatalf("Expected at most 512 bytes, got %d", len(data))
+		}
+
+		trimmed, err := New(data)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal trimmed message: %v", err)
+		}
+		if !trimmed.Header.IsTC() {
+			t.Fatalf("Expected TC flag on the trimmed message")
+		}
+		if len(trimmed.Additional) != 0 || len(trimmed.Authority) != 0 {
+			t.Fatalf("Expected Additional and Authority records to be dropped first, got %d and %d",
+				len(trimmed.Additional), len(trimmed.Authority))
+		}
+		if len(trimmed.Answers) == 0 || len(trimmed.Answers) >= 40 {
+			t.Fatalf("Expected some but not all answers to be kept, got %d", len(trimmed.Answers))
+		}
+		if int(trimmed.Header.GetANCOUNT()) != len(trimmed.Answers) {
+			t.Fatalf("Expected ANCOUNT %d, got %d", len(trimmed.Answers), trimmed.Header.GetANCOUNT())
+		}
+		if len(msg.Answers) != len(trimmed.Answers) || !msg.Header.IsTC() {
+			t.Fatalf("Expected the message to be updated to match the trimmed bytes")
+		}
+	})
+
+	t.Run("drops Additional records without TC", func(t *testing.T) {
+		msg := createResponseWithAnswers(t, 2)
+		full, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal message: %v", err)
+		}
+
+		data, err := msg.MarshalBinaryWithLimit(len(full) - 1)
+		if err != nil {
+			t.Fatalf("MarshalBinaryWithLimit returned error: %v", err)
+		}
+		trimmed, err := New(data)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal trimmed message: %v", err)
+		}
+		if len(trimmed.Answers) != 2 || len(trimmed.Authority) != 1 || len(trimmed.Additional) != 0 {
+			t.Fatalf("Expected only the Additional record to be dropped, got %d/%d/%d",
+				len(trimmed.Answers), len(trimmed.Authority), len(trimmed.Additional))
+		}
+		if trimmed.Header.IsTC() || msg.Header.IsTC() {
+			t.Fatalf("Expected TC flag to stay clear when only Additional records are dropped")
+		}
+	})
+
+	t.Run("dropping authority sets TC", func(t *testing.T) {
+		msg := createResponseWithAnswers(t, 2)
+		msg.Additional = nil
+		if err := msg.Header.SetARCOUNT(0); err != nil {
+			t.Fatalf("Failed to set ARCOUNT: %v", err)
+		}
+		full, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal message: %v", err)
+		}
+
+		data, err := msg.MarshalBinaryWithLimit(len(full) - 1)
+		if err != nil {
+			t.Fatalf("MarshalBinaryWithLimit returned error: %v", err)
+		}
+		trimmed, err := New(data)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal trimmed message: %v", err)
+		}
+		if len(trimmed.Answers) != 2 || len(trimmed.Authority) != 0 || !trimmed.Header.IsTC() {
+			t.Fatalf("Expected the Authority record to be dropped with TC, got %d/%d and TC %v",
+				len(trimmed.Answers), len(trimmed.Authority), trimmed.Header.IsTC())
+		}
+	})
+
+	t.Run("keeps the OPT record", func(t *testing.T) {
+		msg := createResponseWithAnswers(t, 40)
+		cookie := []byte{0, 10, 0, 8, 1, 2, 3, 4, 5, 6, 7, 8}
+		if err := msg.SetOPT(&OPTRecord{UDPSize: 1232, ExtendedRCODE: 1, Data: cookie}); err != nil {
+			t.Fatalf("Failed to set OPT record: %v", err)
+		}
+
+		data, err := msg.MarshalBinaryWithLimit(512)
+		if err != nil {
+			t.Fatalf("MarshalBinaryWithLimit returned error: %v", err)
+		}
+		if len(data) > 512 {
+			t.Fatalf("Expected at most 512 bytes including the OPT record, got %d", len(data))
+		}
+		trimmed, err := New(data)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal trimmed message: %v", err)
+		}
+		if !trimmed.Header.IsTC() || len(trimmed.Authority) != 0 || len(trimmed.Additional) != 1 {
+			t.Fatalf("Expected a truncated message with only the OPT record in Additional, got TC %v and %d/%d",
+				trimmed.Header.IsTC(), len(trimmed.Authority), len(trimmed.Additional))
+		}
+		opt, ok := trimmed.GetOPT()
+		if !ok || opt.UDPSize != 1232 || opt.ExtendedRCODE != 1 || !bytes.Equal(opt.Data, cookie) {
+			t.Fatalf("Expected the OPT record to be kept unchanged, got %+v", opt)
+		}
+	})
+
+	t.Run("question exceeds limit", func(t *testing.T) {
+		msg := createResponseWithAnswers(t, 1)
+		if _, err := msg.MarshalBinaryWithLimit(12); err == nil {
+			t.Fatalf("Expected error when the question alone exceeds the limit")
+		}
+	})
+}
+
+func BenchmarkMarshalBinaryWithLimit(b *testing.B) {
+	msg := createResponseWithAnswers(b, 4)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := msg.MarshalBinaryWithLimit(512); err != nil {
+			b.Fatalf("MarshalBinaryWithLimit returned error: %v", err)
+		}
+	}
+}
+
+func TestMarshalCompressesAnswerOwnerAgainstQuestion(t *testing.T) {
+	const headerSize int = 12
+	const questionFixedSize int = 4
+
+	msg := createResponseWithAnswers(t, 1)
+	msg.Authority = nil
+	msg.Additional = nil
+	if err := msg.Header.SetNSCOUNT(0); err != nil {
+		t.Fatalf("Failed to set NSCOUNT: %v", err)
+	}
+	if err := msg.Header.SetARCOUNT(0); err != nil {
+		t.Fatalf("Failed to set ARCOUNT: %v", err)
+	}
+
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+
+	answerStart := headerSize + len("\x07example\x03com\x00") + questionFixedSize
+	pointer := []byte{0b11000000, byte(headerSize)}
+	if !bytes.Equal(data[answerStart:answerStart+len(pointer)], pointer) {
+		t.Fatalf("Expected the answer owner to be a pointer to the question name, got % x",
+			data[answerStart:answerStart+len(pointer)])
+	}
+
+	unmarshaled, err := New(data)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	if unmarshaled.Answers[0].Name != "example.com" {
+		t.Fatalf("Expected answer owner example.com, got %q", unmarshaled.Answers[0].Name)
+	}
+}
+
+func TestMarshalCompressesRepeatedOwnerNames(t *testing.T) {
+	const answers int = 15
+	const headerSize int = 12
+
+	msg := createResponseWithAnswers(t, answers)
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+
+	naive := headerSize
+	for _, q := range msg.Questions {
+		encoded, err := q.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Failed to marshal question: %v", err)
+		}
+		naive += len(encoded)
+	}
+	for _, section := range [][]RR.RR{msg.Answers, msg.Authority, msg.Additional} {
+		for _, rr := range section {
+			encoded, err := rr.MarshalBinary()
+			if err != nil {
+				t.Fatalf("Failed to marshal record: %v", err)
+			}
+			naive += len(encoded)
+		}
+	}
+
+	// Every answer owner is a 2 byte pointer instead of the 13 bytes of example.com.
+	if saved := naive - len(data); saved < answers*(len("\x07example\x03com\x00")-2) {
+		t.Fatalf("Expected compression to save at least 11 bytes per answer, saved %d of %d bytes", saved, naive)
+	}
+
+	unmarshaled, err := New(data)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	if len(unmarshaled.Answers) != answers {
+		t.Fatalf("Expected %d answers, got %d", answers, len(unmarshaled.Answers))
+	}
+	for i, answer := range unmarshaled.Answers {
+		if answer.Name != "example.com" || !bytes.Equal(answer.RDATA, msg.Answers[i].RDATA) {
+			t.Fatalf("Answer %d did not round trip: %+v", i, answer)
+		}
+	}
+}
+
+func TestNewBestEffort_QuestionCountMismatch(t *testing.T) {
+	query, err := CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+	// Announce a second question which is not there.
+	data[5] = 2
+
+	if _, err := New(data); !errors.Is(err, ErrQuestionCount) {
+		t.Fatalf("expected New to fail with ErrQuestionCount, got %v", err)
+	}
+
+	msg, unparsed, err := NewBestEffort(data)
+	if err != nil {
+		t.Fatalf("NewBestEffort failed: %v", err)
+	}
+	if unparsed != 1 {
+		t.Fatalf("expected 1 unparsed question, got %d", unparsed)
+	}
+	if len(msg.Questions) != 1 || msg.Header.GetQDCOUNT() != 1 || msg.Questions[0].Name != "www.example.com" {
+		t.Fatalf("expected the parsable question with QDCOUNT 1, got %v with QDCOUNT %d", msg.Questions,
+			msg.Header.GetQDCOUNT())
+	}
+
+	// Without a single parsable question the message is rejected.
+	if _, _, err := NewBestEffort(data[:12]); !errors.Is(err, ErrQuestionCount) {
+		t.Fatalf("expected ErrQuestionCount without parsable questions, got %v", err)
+	}
+
+	// Well-formed messages are parsed as by New.
+	data[5] = 1
+	if _, unparsed, err := NewBestEffort(data); err != nil || unparsed != 0 {
+		t.Fatalf("expected a well-formed message to parse, got %d unparsed and %v", unparsed, err)
+	}
+}
+
+func TestMakeError(t *testing.T) {
+	query, err := CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	query.Header.ID = [2]byte{0x12, 0x34}
+	query.Additional = []RR.RR{{Name: "", Type: DNS_Type.OPT, Class: 1232}}
+	if err := query.Header.SetARCOUNT(len(query.Additional)); err != nil {
+		t.Fatalf("failed to set ARCOUNT: %v", err)
+	}
+
+	resp := MakeError(&query, header.ServerFailure)
+
+	if resp.Header.GetMessageID() != 0x1234 {
+		t.Errorf("expected ID 0x1234, got %#x", resp.Header.GetMessageID())
+	}
+	if !resp.Header.IsResponse() || resp.Header.GetRCODE() != header.ServerFailure {
+		t.Errorf("expected a SERVFAIL response, got QR %v and RCODE %s", resp.Header.IsResponse(), resp.Header.GetRCODE())
+	}
+	if !resp.Header.IsRD() {
+		t.Errorf("expected the RD flag to be echoed")
+	}
+	if len(resp.Questions) != 1 || resp.Questions[0].Name != "www.example.com" || resp.Header.GetQDCOUNT() != 1 {
+		t.Errorf("expected the question to be echoed, got %v with QDCOUNT %d", resp.Questions, resp.Header.GetQDCOUNT())
+	}
+	if len(resp.Answers)+len(resp.Authority)+len(resp.Additional) != 0 {
+		t.Errorf("expected empty record sections")
+	}
+	if resp.Header.GetANCOUNT() != 0 || resp.Header.GetNSCOUNT() != 0 || resp.Header.GetARCOUNT() != 0 {
+		t.Errorf("expected zero record counts, got AN %d NS %d AR %d", resp.Header.GetANCOUNT(),
+			resp.Header.GetNSCOUNT(), resp.Header.GetARCOUNT())
+	}
+	if query.Header.IsResponse() || query.Header.GetARCOUNT() != 1 {
+		t.Errorf("expected the query to be left unchanged")
+	}
+
+	empty := MakeError(nil, header.FormatError)
+	if empty.Header.GetRCODE() != header.FormatError || len(empty.Questions) != 0 || empty.Header.GetQDCOUNT() != 0 {
+		t.Errorf("expected a FORMERR response without questions for a nil query, got %+v", empty)
+	}
+}
+
+func TestCopy_RejectsMalformedARecord(t *testing.T) {
+	malformed := RR.RR{Name: "www.example.com", Type: DNS_Type.A, Class: DNS_Class.IN, TTL: 300}
+	malformed.SetRDATA(net.ParseIP("2001:db8::1"))
+	msg := &Message{Answers: []RR.RR{malformed}}
+
+	if _, err := Copy(msg); err == nil {
+		t.Fatalf("expected copying an A record with 16 bytes of RDATA to fail")
+	}
+}
+
+func BenchmarkMarshalBinary(b *testing.B) {
+	msg := createResponseWithAnswers(b, 4)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := msg.MarshalBinary(); err != nil {
+			b.Fatalf("MarshalBinary returned error: %v", err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	data, err := createResponseWithAnswers(b, 4).MarshalBinary()
+	if err != nil {
+		b.Fatalf("MarshalBinary returned error: %v", err)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		var msg Message
+		if err := msg.UnmarshalBinary(data); err != nil {
+			b.Fatalf("UnmarshalBinary returned error: %v", err)
+		}
+	}
+}
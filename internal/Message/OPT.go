@@ -0,0 +1,123 @@
+package Message
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+)
+
+/*
+The OPT pseudo record (https://datatracker.ietf.org/doc/html/rfc6891#section-6.1.2) lives in the Additional section
+but repurposes the fixed RR fields:
+
+Field		Carries
+Name		Always the root domain
+Class		The requestor's UDP payload size
+TTL			Extended RCODE (8 bits), version (8 bits), DO flag (1 bit) and Z (15 bits)
+RDATA		EDNS options, see the edns package
+*/
+
+// OPTRecord is the structured representation of the OPT pseudo record.
+type OPTRecord struct {
+	// Data is the OPT RDATA, the encoded EDNS options.
+	Data []byte
+	// UDPSize is the advertised UDP payload size.
+	UDPSize uint16
+	// Z holds the remaining, unassigned flags.
+	Z uint16
+	// ExtendedRCODE holds the upper 8 bits of the 12-bit RCODE.
+	ExtendedRCODE uint8
+	Version       uint8
+	// DO is the DNSSEC OK flag.
+	DO bool
+}
+
+const (
+	optDOFlag uint32 = 1 << 15
+	optZMask  uint32 = optDOFlag - 1
+)
+
+// GetOPT returns the OPT record of the Message.Additional section and whether there is one.
+func (msg *Message) GetOPT() (*OPTRecord, bool) {
+	for _, add := range msg.Additional {
+		if add.Type == DNS_Type.OPT {
+			ttl := add.GetTTL()
+			return &OPTRecord{
+				Data:          add.GetRDATA(),
+				UDPSize:       uint16(add.Class),
+				Z:             uint16(ttl & optZMask), //nolint:gosec
+				ExtendedRCODE: uint8(ttl >> 24),       //nolint:gosec
+				Version:       uint8(ttl >> 16),       //nolint:gosec
+				DO:            ttl&optDOFlag != 0,
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// SetOPT replaces the OPT record of the Message.Additional section with opt, or appends it if there is none.
+// A nil opt removes the OPT record. The Header.ARCOUNT is updated accordingly.
+func (msg *Message) SetOPT(opt *OPTRecord) error {
+	kept := make([]RR.RR, 0, len(msg.Additional)+1)
+	replaced := false
+	for _, add := range msg.Additional {
+		if add.Type != DNS_Type.OPT {
+			kept = append(kept, add)
+			continue
+		}
+		if opt != nil && !replaced {
+			kept = append(kept, opt.toRR())
+			replaced = true
+		}
+	}
+	if opt != nil && !replaced {
+		kept = append(kept, opt.toRR())
+	}
+	msg.Additional = kept
+	return msg.Header.SetARCOUNT(len(msg.Additional))
+}
+
+// toRR encodes the OPTRecord into its wire resource record form.
+func (opt *OPTRecord) toRR() RR.RR {
+	ttl := uint32(opt.ExtendedRCODE)<<24 | uint32(opt.Version)<<16 | uint32(opt.Z)&optZMask
+	if opt.DO {
+		ttl |= optDOFlag
+	}
+
+	rr := RR.RR{}
+	rr.SetName(".")
+	rr.SetType(DNS_Type.OPT)
+	rr.SetClass(DNS_Class.Class(opt.UDPSize))
+	rr.TTL = ttl
+	rr.SetRDATA(append([]byte{}, opt.Data...))
+	return rr
+}
+
+// EDNSUDPSize returns the UDP payload size advertised by the OPT record of the Message.
+// Messages without an OPT record, or advertising less than 512 bytes, are limited to 512 bytes as described in
+// https://datatracker.ietf.org/doc/html/rfc6891#section-6.2.5
+func (msg *Message) EDNSUDPSize() uint16 {
+	const minUDPPayloadSize uint16 = 512
+
+	opt, ok := msg.GetOPT()
+	if !ok || opt.UDPSize < minUDPPayloadSize {
+		return minUDPPayloadSize
+	}
+	return opt.UDPSize
+}
+
+// EDNSVersion returns the EDNS version advertised by the OPT record of the Message.
+// The second return value reports whether the Message has an OPT record.
+func (msg *Message) EDNSVersion() (uint8, bool) {
+	opt, ok := msg.GetOPT()
+	if !ok {
+		return 0, false
+	}
+	return opt.Version, true
+}
+
+// DNSSECOK reports whether the OPT record of the Message sets the DO flag, asking for DNSSEC records in the response.
+func (msg *Message) DNSSECOK() bool {
+	opt, ok := msg.GetOPT()
+	return ok && opt.DO
+}
@@ -0,0 +1,158 @@
+package Message
+
+import (
+	"bytes"
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"net"
+	"testing"
+)
+
+func TestEDNSUDPSize(t *testing.T) {
+	msg, err := CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	if size := msg.EDNSUDPSize(); size != 512 {
+		t.Fatalf("Expected 512 without an OPT record, got %d", size)
+	}
+
+	opt := RR.RR{}
+	opt.SetName(".")
+	opt.SetType(DNS_Type.OPT)
+	opt.SetClass(4096)
+	msg.Additional = []RR.RR{opt}
+	if size := msg.EDNSUDPSize(); size != 4096 {
+		t.Fatalf("Expected advertised size 4096, got %d", size)
+	}
+
+	msg.Additional[0].SetClass(100)
+	if size := msg.EDNSUDPSize(); size != 512 {
+		t.Fatalf("Expected sizes below 512 to be raised to 512, got %d", size)
+	}
+}
+
+func TestEDNSVersion(t *testing.T) {
+	msg, err := CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	if _, ok := msg.EDNSVersion(); ok {
+		t.Fatalf("Expected no EDNS version without an OPT record")
+	}
+
+	opt := RR.RR{}
+	opt.SetName(".")
+	opt.SetType(DNS_Type.OPT)
+	opt.TTL = 1 << 16
+	msg.Additional = []RR.RR{opt}
+	if version, ok := msg.EDNSVersion(); !ok || version != 1 {
+		t.Fatalf("Expected EDNS version 1, got %d (OPT present: %v)", version, ok)
+	}
+}
+
+func TestDNSSECOK(t *testing.T) {
+	msg, err := CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	if msg.DNSSECOK() {
+		t.Fatalf("Expected DO to be unset without an OPT record")
+	}
+
+	if err := msg.SetOPT(&OPTRecord{UDPSize: 1232, DO: true}); err != nil {
+		t.Fatalf("Failed to set OPT record: %v", err)
+	}
+	if !msg.DNSSECOK() {
+		t.Fatalf("Expected DO to be set")
+	}
+}
+
+func TestSetOPT(t *testing.T) {
+	glue := RR.RR{}
+	glue.SetName("ns1.example.com")
+	glue.SetClass(DNS_Class.IN)
+	if err := glue.SetRDATAToARecord(net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+
+	msg := Message{Additional: []RR.RR{glue}}
+	if err := msg.Header.SetARCOUNT(len(msg.Additional)); err != nil {
+		t.Fatalf("Failed to set ARCOUNT: %v", err)
+	}
+	if _, ok := msg.GetOPT(); ok {
+		t.Fatalf("Expected no OPT record")
+	}
+
+	added := &OPTRecord{UDPSize: 1232, Version: 0, DO: true, Z: 3, ExtendedRCODE: 1, Data: []byte{0, 10, 0, 0}}
+	if err := msg.SetOPT(added); err != nil {
+		t.Fatalf("SetOPT returned error: %v", err)
+	}
+	if msg.Header.GetARCOUNT() != 2 || len(msg.Additional) != 2 {
+		t.Fatalf("Expected the OPT record to be appended, got ARCOUNT %d", msg.Header.GetARCOUNT())
+	}
+
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	parsed, err := New(data)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	got, ok := parsed.GetOPT()
+	if !ok {
+		t.Fatalf("Expected the OPT record to survive the roundtrip")
+	}
+	if got.UDPSize != added.UDPSize || got.Version != added.Version || got.DO != added.DO || got.Z != added.Z ||
+		got.ExtendedRCODE != added.ExtendedRCODE || !bytes.Equal(got.Data, added.Data) {
+		t.Fatalf("Expected %+v, got %+v", added, got)
+	}
+
+	if err := msg.SetOPT(&OPTRecord{UDPSize: 4096}); err != nil {
+		t.Fatalf("SetOPT returned error: %v", err)
+	}
+	if msg.Header.GetARCOUNT() != 2 {
+		t.Fatalf("Expected the OPT record to be replaced, got ARCOUNT %d", msg.Header.GetARCOUNT())
+	}
+	if size := msg.EDNSUDPSize(); size != 4096 {
+		t.Fatalf("Expected the replaced OPT record to advertise 4096, got %d", size)
+	}
+	if msg.Additional[0].Type != DNS_Type.A {
+		t.Fatalf("Expected the other Additional records to be kept")
+	}
+
+	if err := msg.SetOPT(nil); err != nil {
+		t.Fatalf("SetOPT returned error: %v", err)
+	}
+	if _, ok := msg.GetOPT(); ok || msg.Header.GetARCOUNT() != 1 {
+		t.Fatalf("Expected the OPT record to be removed, got ARCOUNT %d", msg.Header.GetARCOUNT())
+	}
+}
+
+func TestUnmarshalRejectsMultipleOPT(t *testing.T) {
+	msg, err := CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	for _, size := range []DNS_Class.Class{1232, 4096} {
+		opt := RR.RR{}
+		opt.SetName(".")
+		opt.SetType(DNS_Type.OPT)
+		opt.SetClass(size)
+		msg.Additional = append(msg.Additional, opt)
+	}
+	if err := msg.Header.SetARCOUNT(len(msg.Additional)); err != nil {
+		t.Fatalf("Failed to set ARCOUNT: %v", err)
+	}
+
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	if _, err := New(data); !errors.Is(err, ErrMultipleOPT) {
+		t.Fatalf("Expected ErrMultipleOPT, got %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package Message
+
+import (
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"github.com/blazskufca/dns_server_in_go/internal/question"
+	"strings"
+)
+
+// QuestionMatches reports whether msg and other carry the same question section: the same number of questions, each
+// with the same type, class and name. Names are compared case-insensitively and with or without the trailing dot.
+func (msg *Message) QuestionMatches(other *Message) bool {
+	if msg == nil || other == nil || len(msg.Questions) != len(other.Questions) {
+		return false
+	}
+	for i, q := range msg.Questions {
+		o := other.Questions[i]
+		if q.Type != o.Type || q.Class != o.Class || !name.Equal(q.Name, o.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// questionsString formats questions for error messages, e.g. "[example.com A]".
+func questionsString(questions []question.Question) string {
+	parts := make([]string, 0, len(questions))
+	for _, q := range questions {
+		parts = append(parts, fmt.Sprintf("%s %s", q.Name, q.Type))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,47 @@
+package Message
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/question"
+	"testing"
+)
+
+func TestQuestionMatches(t *testing.T) {
+	query, err := CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		questions []question.Question
+		want      bool
+	}{
+		{name: "same question", questions: []question.Question{{Name: "example.com", Type: DNS_Type.A, Class: DNS_Class.IN}}, want: true},
+		{name: "differently cased fqdn", questions: []question.Question{{Name: "ExAmPlE.CoM.", Type: DNS_Type.A, Class: DNS_Class.IN}}, want: true},
+		{name: "other name", questions: []question.Question{{Name: "example.org", Type: DNS_Type.A, Class: DNS_Class.IN}}},
+		{name: "other type", questions: []question.Question{{Name: "example.com", Type: DNS_Type.AAAA, Class: DNS_Class.IN}}},
+		{name: "other class", questions: []question.Question{{Name: "example.com", Type: DNS_Type.A, Class: DNS_Class.CH}}},
+		{name: "no question"},
+		{name: "extra question", questions: []question.Question{
+			{Name: "example.com", Type: DNS_Type.A, Class: DNS_Class.IN},
+			{Name: "example.com", Type: DNS_Type.MX, Class: DNS_Class.IN},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := &Message{Questions: tt.questions}
+			if got := query.QuestionMatches(other); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			if got := other.QuestionMatches(&query); got != tt.want {
+				t.Fatalf("expected symmetric result %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if query.QuestionMatches(nil) {
+		t.Fatal("expected no match against a nil message")
+	}
+}
@@ -0,0 +1,145 @@
+package Message
+
+import (
+	"encoding/binary"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"math"
+)
+
+// Section identifies one of the record sections of a Message.
+type Section uint8
+
+const (
+	AnswerSection Section = iota
+	AuthoritySection
+	AdditionalSection
+)
+
+// RRSet groups the records sharing an owner name, type and class (https://datatracker.ietf.org/doc/html/rfc2181#section-5).
+// All records of an RRSet share a TTL, the lowest TTL of its records. RRSIG records covering the RRSet are kept
+// alongside it in Signatures rather than in an RRSet of their own.
+type RRSet struct {
+	Name       string
+	Records    []RR.RR
+	Signatures []RR.RR
+	TTL        uint32
+	Type       DNS_Type.Type
+	Class      DNS_Class.Class
+}
+
+// typeCovered returns the type an RRSIG record signs, the first field of its RDATA.
+func typeCovered(rrsig *RR.RR) (DNS_Type.Type, bool) {
+	const typeCoveredLength int = 2
+
+	if len(rrsig.RDATA) < typeCoveredLength {
+		return 0, false
+	}
+	return DNS_Type.Type(binary.BigEndian.Uint16(rrsig.RDATA[:typeCoveredLength])), true
+}
+
+// NewRRSets groups records into RRSets, in the order their first record appears. RRSIG records are attached to the
+// RRSet they cover, or form an RRSet of their own if it is not among records. OPT pseudo records are skipped.
+func NewRRSets(records []RR.RR) []RRSet {
+	var sets []RRSet
+	find := func(owner string, rrType DNS_Type.Type, class DNS_Class.Class) int {
+		for i := range sets {
+			if sets[i].Type == rrType && sets[i].Class == class && name.Equal(sets[i].Name, owner) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var signatures []RR.RR
+	for _, record := range records {
+		switch record.Type {
+		case DNS_Type.OPT:
+			continue
+		case DNS_Type.RRSIG:
+			signatures = append(signatures, record)
+			continue
+		}
+
+		i := find(record.GetName(), record.Type, record.Class)
+		if i == -1 {
+			sets = append(sets, RRSet{Name: record.GetName(), Type: record.Type, Class: record.Class, TTL: math.MaxUint32})
+			i = len(sets) - 1
+		}
+		sets[i].Records = append(sets[i].Records, record)
+		sets[i].TTL = min(sets[i].TTL, record.GetTTL())
+	}
+
+	for _, signature := range signatures {
+		covered, ok := typeCovered(&signature)
+		i := -1
+		if ok {
+			i = find(signature.GetName(), covered, signature.Class)
+		}
+		if i == -1 {
+			i = find(signature.GetName(), DNS_Type.RRSIG, signature.Class)
+		}
+		if i == -1 {
+			sets = append(sets, RRSet{Name: signature.GetName(), Type: DNS_Type.RRSIG, Class: signature.Class,
+				TTL: math.MaxUint32})
+			i = len(sets) - 1
+		}
+		if sets[i].Type == DNS_Type.RRSIG {
+			sets[i].Records = append(sets[i].Records, signature)
+			sets[i].TTL = min(sets[i].TTL, signature.GetTTL())
+			continue
+		}
+		sets[i].Signatures = append(sets[i].Signatures, signature)
+	}
+	return sets
+}
+
+// RRSets returns the records of a section of the Message grouped into RRSets.
+func (msg *Message) RRSets(section Section) []RRSet {
+	return NewRRSets(*msg.section(section))
+}
+
+// RecordsOfType returns the records of type t in a section of the Message, in the order of the section.
+func (msg *Message) RecordsOfType(section Section, t DNS_Type.Type) []RR.RR {
+	var records []RR.RR
+	for _, record := range *msg.section(section) {
+		if record.Type == t {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// AddRRSet appends the records and signatures of set to a section of the Message, all with the TTL of the RRSet, and
+// updates the section count in the header.
+func (msg *Message) AddRRSet(section Section, set RRSet) error {
+	records := msg.section(section)
+	for _, group := range [][]RR.RR{set.Records, set.Signatures} {
+		for _, record := range group {
+			record.TTL = set.TTL
+			*records = append(*records, record)
+		}
+	}
+
+	switch section {
+	case AnswerSection:
+		return msg.Header.SetANCOUNT(len(msg.Answers))
+	case AuthoritySection:
+		return msg.Header.SetNSCOUNT(len(msg.Authority))
+	default:
+		return msg.Header.SetARCOUNT(len(msg.Additional))
+	}
+}
+
+func (msg *Message) section(section Section) *[]RR.RR {
+	switch section {
+	case AnswerSection:
+		return &msg.Answers
+	case AuthoritySection:
+		return &msg.Authority
+	default:
+		return &msg.Additional
+	}
+}
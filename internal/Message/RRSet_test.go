@@ -0,0 +1,115 @@
+package Message
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"net"
+	"testing"
+)
+
+func TestNewRRSets(t *testing.T) {
+	a1 := RR.RR{Name: "example.com", Class: DNS_Class.IN, TTL: 300}
+	if err := a1.SetRDATAToARecord(net.IPv4(192, 0, 2, 1)); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	a2 := RR.RR{Name: "EXAMPLE.com.", Class: DNS_Class.IN, TTL: 60}
+	if err := a2.SetRDATAToARecord(net.IPv4(192, 0, 2, 2)); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	other := RR.RR{Name: "www.example.com", Class: DNS_Class.IN, TTL: 300}
+	if err := other.SetRDATAToARecord(net.IPv4(192, 0, 2, 3)); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	mx := RR.RR{Name: "example.com", Class: DNS_Class.IN, TTL: 300}
+	if err := mx.SetRDATAToMXRecord(10, "mail.example.com"); err != nil {
+		t.Fatalf("Failed to set MX record: %v", err)
+	}
+	rrsig := RR.RR{Name: "example.com", Type: DNS_Type.RRSIG, Class: DNS_Class.IN, TTL: 300}
+	rrsig.SetRDATA([]byte{0, byte(DNS_Type.A), 8, 2})
+	opt := RR.RR{Name: "", Type: DNS_Type.OPT, Class: 1232}
+
+	msg := Message{Answers: []RR.RR{a1, rrsig, other, a2, mx}, Additional: []RR.RR{opt}}
+
+	sets := msg.RRSets(AnswerSection)
+	if len(sets) != 3 {
+		t.Fatalf("Expected 3 RRSets, got %d: %+v", len(sets), sets)
+	}
+
+	aSet := sets[0]
+	if aSet.Type != DNS_Type.A || aSet.Name != "example.com" || len(aSet.Records) != 2 {
+		t.Fatalf("Expected both example.com A records in the first RRSet, got %+v", aSet)
+	}
+	if aSet.TTL != 60 {
+		t.Fatalf("Expected the RRSet TTL to be the lowest record TTL 60, got %d", aSet.TTL)
+	}
+	if len(aSet.Signatures) != 1 {
+		t.Fatalf("Expected the RRSIG covering A to be attached to the A RRSet, got %d signatures", len(aSet.Signatures))
+	}
+	if sets[1].Name != "www.example.com" || len(sets[1].Records) != 1 {
+		t.Fatalf("Expected www.example.com in its own RRSet, got %+v", sets[1])
+	}
+	if sets[2].Type != DNS_Type.MX || len(sets[2].Records) != 1 {
+		t.Fatalf("Expected the MX record in its own RRSet, got %+v", sets[2])
+	}
+
+	if additional := msg.RRSets(AdditionalSection); len(additional) != 0 {
+		t.Fatalf("Expected the OPT record to be skipped, got %+v", additional)
+	}
+}
+
+func TestAddRRSet(t *testing.T) {
+	a1 := RR.RR{Name: "example.com", Class: DNS_Class.IN, TTL: 300}
+	if err := a1.SetRDATAToARecord(net.IPv4(192, 0, 2, 1)); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	a2 := RR.RR{Name: "example.com", Class: DNS_Class.IN, TTL: 60}
+	if err := a2.SetRDATAToARecord(net.IPv4(192, 0, 2, 2)); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+
+	sets := NewRRSets([]RR.RR{a1, a2})
+	if len(sets) != 1 {
+		t.Fatalf("Expected 1 RRSet, got %d", len(sets))
+	}
+
+	var msg Message
+	if err := msg.AddRRSet(AuthoritySection, sets[0]); err != nil {
+		t.Fatalf("AddRRSet returned error: %v", err)
+	}
+	if len(msg.Authority) != 2 || msg.Header.GetNSCOUNT() != 2 {
+		t.Fatalf("Expected 2 Authority records and NSCOUNT 2, got %d and %d", len(msg.Authority), msg.Header.GetNSCOUNT())
+	}
+	for _, record := range msg.Authority {
+		if record.GetTTL() != 60 {
+			t.Fatalf("Expected every record to carry the RRSet TTL 60, got %d", record.GetTTL())
+		}
+	}
+	if sets[0].Records[0].GetTTL() != 300 {
+		t.Fatalf("Expected AddRRSet not to modify the RRSet records")
+	}
+}
+
+func TestRecordsOfType(t *testing.T) {
+	msg := createResponseWithAnswers(t, 2)
+	soa := RR.RR{Name: "example.com", Class: DNS_Class.IN, TTL: 300}
+	if err := soa.SetRDATAToSOARecord("ns.example.com", "hostmaster.example.com", 1, 3600, 600, 86400, 300); err != nil {
+		t.Fatalf("failed to set SOA record: %v", err)
+	}
+	msg.Authority = append([]RR.RR{soa}, msg.Authority...)
+
+	ns := msg.RecordsOfType(AuthoritySection, DNS_Type.NS)
+	if len(ns) != 1 || ns[0].Type != DNS_Type.NS {
+		t.Fatalf("expected the NS record of the Authority section, got %v", ns)
+	}
+	glue := msg.RecordsOfType(AdditionalSection, DNS_Type.A)
+	if len(glue) != 1 || glue[0].Name != "ns.example.com" {
+		t.Fatalf("expected the glue A record of the Additional section, got %v", glue)
+	}
+	if answers := msg.RecordsOfType(AnswerSection, DNS_Type.A); len(answers) != 2 {
+		t.Fatalf("expected the two A answers, got %v", answers)
+	}
+	if mx := msg.RecordsOfType(AnswerSection, DNS_Type.MX); mx != nil {
+		t.Fatalf("expected no MX records, got %v", mx)
+	}
+}
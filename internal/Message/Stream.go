@@ -0,0 +1,76 @@
+package Message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
+	"io"
+)
+
+// lengthPrefixSize is the size of the length prefix of messages sent over stream transports like TCP
+// (https://datatracker.ietf.org/doc/html/rfc1035#section-4.2.2).
+const lengthPrefixSize = 2
+
+var (
+	ErrEmptyFrame    = errors.New("length prefixed message is empty")
+	ErrFrameTooLarge = errors.New("message too large for its length prefix")
+)
+
+// ReadFrame reads a single length prefixed message from r and returns its body, without the prefix.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	lenBuf := make([]byte, lengthPrefixSize, lengthPrefixSize) //nolint:gosimple
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return nil, fmt.Errorf("failed to read message length: %w", err)
+	}
+
+	msgLen := binary.BigEndian.Uint16(lenBuf)
+	if msgLen == 0 {
+		return nil, ErrEmptyFrame
+	}
+
+	msgBuf := make([]byte, msgLen, msgLen) //nolint:gosimple
+	if _, err := io.ReadFull(r, msgBuf); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return msgBuf, nil
+}
+
+// ReadFrom reads a single length prefixed message from r, as sent over TCP, and unmarshalls it into msg.
+// Unlike most io.ReaderFrom implementations it stops after one message instead of reading r until EOF.
+// It returns the number of bytes read, including the length prefix.
+func (msg *Message) ReadFrom(r io.Reader) (int64, error) {
+	body, err := ReadFrame(r)
+	if err != nil {
+		return 0, err
+	}
+	n := int64(lengthPrefixSize + len(body))
+	if err := msg.UnmarshalBinary(body); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// WriteFrame writes body to w prefixed with its length, in a single write.
+// It returns the number of bytes written, including the length prefix.
+func WriteFrame(w io.Writer, body []byte) (int64, error) {
+	if utils.WouldOverflowUint16(len(body)) {
+		return 0, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(body))
+	}
+	framed := make([]byte, 0, lengthPrefixSize+len(body))
+	framed = binary.BigEndian.AppendUint16(framed, uint16(len(body)))
+	framed = append(framed, body...)
+
+	n, err := w.Write(framed)
+	return int64(n), err
+}
+
+// WriteTo marshals msg and writes it to w prefixed with its length, as sent over TCP. It implements io.WriterTo,
+// returning the number of bytes written, including the length prefix.
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	body, err := msg.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	return WriteFrame(w, body)
+}
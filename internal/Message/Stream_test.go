@@ -0,0 +1,106 @@
+package Message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestReadFrom_Pipe(t *testing.T) {
+	query, err := CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	body, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	go func() {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(body)))
+		// Write the message twice, in pieces, to check that exactly one message is consumed per read.
+		for i := 0; i < 2; i++ {
+			_, _ = client.Write(framed[:1])
+			_, _ = client.Write(append(framed[1:], body...))
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		var got Message
+		n, err := got.ReadFrom(server)
+		if err != nil {
+			t.Fatalf("ReadFrom returned error: %v", err)
+		}
+		if n != int64(len(body)+2) {
+			t.Fatalf("expected %d bytes read, got %d", len(body)+2, n)
+		}
+		if got.Header.ID != query.Header.ID || len(got.Questions) != 1 || got.Questions[0].Name != "www.example.com" {
+			t.Fatalf("expected the query to round trip, got ID %d with questions %v", got.Header.ID, got.Questions)
+		}
+	}
+}
+
+func TestReadFrame_Errors(t *testing.T) {
+	if _, err := ReadFrame(bytes.NewReader([]byte{0, 0})); !errors.Is(err, ErrEmptyFrame) {
+		t.Fatalf("expected ErrEmptyFrame for a zero length prefix, got %v", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader([]byte{0})); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a truncated prefix, got %v", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader([]byte{0, 12, 1, 2, 3})); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a truncated body, got %v", err)
+	}
+}
+
+func TestWriteTo_MatchesManualFraming(t *testing.T) {
+	query, err := CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	body, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal query: %v", err)
+	}
+	want := binary.BigEndian.AppendUint16(nil, uint16(len(body)))
+	want = append(want, body...)
+
+	var buf bytes.Buffer
+	n, err := query.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("expected %d bytes written, got %d", len(want), n)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("framed bytes mismatch:\n got %x\nwant %x", buf.Bytes(), want)
+	}
+
+	var got Message
+	if _, err := got.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom of written message failed: %v", err)
+	}
+	if got.Header.ID != query.Header.ID {
+		t.Errorf("expected ID %d after round trip, got %d", query.Header.ID, got.Header.ID)
+	}
+}
+
+func TestWriteFrame_TooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := WriteFrame(&buf, make([]byte, 1<<16))
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing written, got %d bytes", buf.Len())
+	}
+}
@@ -1,6 +1,7 @@
 package RR
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -12,6 +13,9 @@ import (
 	"strings"
 )
 
+// ErrNotIPv4 is returned when an address which is not an IPv4 address is set as the RDATA of an A record.
+var ErrNotIPv4 = errors.New("not an IPv4 address")
+
 // RR structure represents a structure of a DNS Resource Record
 /*
 The answer section contains a list of RRs (Resource Records), which are answers to the questions asked in the question section.
@@ -79,9 +83,15 @@ func (rr *RR) SetRDATA(data []byte) {
 
 // SetRDATAToARecord sets the RR.RDATA to 4-byte integer which represents the net.IP address (IPv4 address).
 // It also sets the RR.Type to DNS_Type.A and sets the RR.RDLEGNTH to appropriate value.
-func (rr *RR) SetRDATAToARecord(ip net.IP) {
+// It returns ErrNotIPv4 and leaves the record unchanged if ip is not an IPv4 address.
+func (rr *RR) SetRDATAToARecord(ip net.IP) error {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return fmt.Errorf("%w: %v", ErrNotIPv4, ip)
+	}
 	rr.Type = DNS_Type.A
-	rr.SetRDATA(ip.To4())
+	rr.SetRDATA(ipv4)
+	return nil
 }
 
 // GetRDATAAsARecord tries to interpret RR.RDATA byte slice as an A resource record.
@@ -117,7 +127,7 @@ func (rr *RR) SetRDATAToMXRecord(preference uint16, exchange string) error {
 
 	encodedExchange, err := utils.MarshalName(exchange, data, len(data))
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid MX exchange: %w", err)
 	}
 	data = append(data, encodedExchange...)
 
@@ -159,7 +169,7 @@ func (rr *RR) SetRDATAToCNAMERecord(canonicalName string) error {
 	rr.Type = DNS_Type.CNAME
 	encodedName, err := utils.MarshalName(canonicalName, nil, 0)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid CNAME target: %w", err)
 	}
 	rr.SetRDATA(encodedName)
 	return nil
@@ -189,7 +199,7 @@ func (rr *RR) SetRDATAToNSRecord(nameServer string) error {
 	rr.Type = DNS_Type.NS
 	encodedNS, err := utils.MarshalName(nameServer, nil, 0)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid NS name: %w", err)
 	}
 	rr.SetRDATA(encodedNS)
 	return nil
@@ -275,7 +285,7 @@ func (rr *RR) SetRDATAToPTRRecord(ptrDomain string) error {
 	rr.Type = DNS_Type.PTR
 	encodedPtr, err := utils.MarshalName(ptrDomain, nil, 0)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid PTR name: %w", err)
 	}
 	rr.SetRDATA(encodedPtr)
 	return nil
@@ -316,13 +326,13 @@ func (rr *RR) SetRDATAToSOARecord(
 
 	encodedMName, err := utils.MarshalName(mname, buf, 0)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid SOA MNAME: %w", err)
 	}
 	buf = append(buf, encodedMName...)
 
 	encodedRName, err := utils.MarshalName(rname, buf, len(buf))
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid SOA RNAME: %w", err)
 	}
 	buf = append(buf, encodedRName...)
 
@@ -392,6 +402,94 @@ func (rr *RR) GetRDATAAsSOARecord() (mname string, rname string, serial uint32,
 	return mname, rname, serial, refresh, retry, expire, minimum, nil
 }
 
+// SetRDATAToCAARecord sets the RR.RDATA to contain a certification authority authorization property
+// (https://datatracker.ietf.org/doc/html/rfc8659#section-4.1): the flags byte, the length prefixed tag and the value.
+func (rr *RR) SetRDATAToCAARecord(flags uint8, tag, value string) error {
+	if len(tag) == 0 || len(tag) > math.MaxUint8 {
+		return fmt.Errorf("invalid CAA tag length %d", len(tag))
+	}
+	rr.Type = DNS_Type.CAA
+
+	data := make([]byte, 0, 2+len(tag)+len(value))
+	data = append(data, flags, byte(len(tag)))
+	data = append(data, tag...)
+	data = append(data, value...)
+	rr.SetRDATA(data)
+	return nil
+}
+
+// GetRDATAAsCAARecord tries to interpret RR.RDATA byte slice as CAA resource record.
+func (rr *RR) GetRDATAAsCAARecord() (flags uint8, tag string, value string, err error) {
+	if rr.Type != DNS_Type.CAA {
+		return 0, "", "", fmt.Errorf("record type is %d, not CAA type", rr.Type)
+	}
+	if len(rr.RDATA) != int(rr.RDLENGTH) {
+		return 0, "", "", fmt.Errorf("invalid CAA record data length: got %d bytes, expected %d", len(rr.RDATA),
+			rr.RDLENGTH)
+	}
+	if len(rr.RDATA) < 2 {
+		return 0, "", "", fmt.Errorf("CAA record data too short: %d bytes", len(rr.RDATA))
+	}
+
+	tagLen := int(rr.RDATA[1])
+	if tagLen == 0 || 2+tagLen > len(rr.RDATA) {
+		return 0, "", "", fmt.Errorf("CAA tag length %d exceeds available data", tagLen)
+	}
+	return rr.RDATA[0], string(rr.RDATA[2 : 2+tagLen]), string(rr.RDATA[2+tagLen:]), nil
+}
+
+// decompressedRDATA returns the RDATA of a record with one of the name bearing types CopyRR knows the layout of, with
+// every name in it written out in full. A compression pointer is only valid in the packet the record was read from,
+// so RDATA holding one can not be marshalled into another packet as it is. RFC 1035 types may compress the names in
+// their RDATA, NAPTR must not (https://datatracker.ietf.org/doc/html/rfc3403#section-4.1) but a broken server could
+// still do it. Pointers of a record which was not unmarshalled from a packet can not be resolved and are an error.
+func (rr *RR) decompressedRDATA() ([]byte, error) {
+	// The RDATA starts with fixedBytes bytes, followed by characterStrings length prefixed strings and names names.
+	var fixedBytes, characterStrings, names int
+	switch rr.Type {
+	case DNS_Type.MD, DNS_Type.MF, DNS_Type.MB, DNS_Type.MG, DNS_Type.MR:
+		names = 1
+	case DNS_Type.MINFO:
+		names = 2
+	case DNS_Type.NAPTR:
+		fixedBytes, characterStrings, names = 4, 3, 1
+	default:
+		return nil, fmt.Errorf("RDATA layout of type %d is not known", rr.Type)
+	}
+
+	if len(rr.RDATA) < fixedBytes {
+		return nil, fmt.Errorf("RDATA too short: %d bytes", len(rr.RDATA))
+	}
+	offset := fixedBytes
+	for range characterStrings {
+		if offset >= len(rr.RDATA) || offset+1+int(rr.RDATA[offset]) > len(rr.RDATA) {
+			return nil, errors.New("character string exceeds available data")
+		}
+		offset += 1 + int(rr.RDATA[offset])
+	}
+
+	data := bytes.Clone(rr.RDATA[:offset])
+	for range names {
+		if offset >= len(rr.RDATA) {
+			return nil, errors.New("name exceeds available data")
+		}
+		name, bytesRead, err := utils.UnmarshalName(rr.RDATA, offset, rr.fullPacket)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name in RDATA: %w", err)
+		}
+		encoded, err := utils.EncodeDomainNameToLabel(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name in RDATA: %w", err)
+		}
+		data = append(data, encoded...)
+		offset += bytesRead
+	}
+	if offset != len(rr.RDATA) {
+		return nil, fmt.Errorf("%d trailing bytes after RDATA", len(rr.RDATA)-offset)
+	}
+	return data, nil
+}
+
 // GetRDATA just returns a raw (byte slice) RR.RDATA to the caller.
 func (rr *RR) GetRDATA() []byte {
 	return rr.RDATA
@@ -399,20 +497,25 @@ func (rr *RR) GetRDATA() []byte {
 
 // MarshalBinary serializes an RR into a byte slice according to DNS protocol
 func (rr *RR) MarshalBinary() ([]byte, error) {
+	return rr.AppendBinary(make([]byte, 0))
+}
+
+// AppendBinary appends the serialized RR to packet, which holds the message marshalled so far.
+// The owner name is compressed against names already in packet, RDATA is appended as is.
+// It fulfills the encoding.BinaryAppender interface.
+func (rr *RR) AppendBinary(packet []byte) ([]byte, error) {
 	const uint16ByteLength int = 2
 	const uint32ByteLength int = 4
 	const TypeClassTTLRDLENGTHSize int = 3*uint16ByteLength + uint32ByteLength
 
-	buf := make([]byte, 0)
-
-	nameBytes, err := utils.MarshalName(rr.Name, buf, 0)
+	nameBytes, err := utils.MarshalName(rr.Name, packet, len(packet))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid owner name: %w", err)
 	}
-	buf = append(buf, nameBytes...)
+	buf := append(packet, nameBytes...)
 
 	buf = append(buf, make([]byte, TypeClassTTLRDLENGTHSize)...)
-	offset := len(nameBytes)
+	offset := len(packet) + len(nameBytes)
 
 	binary.BigEndian.PutUint16(buf[offset:offset+uint16ByteLength], uint16(rr.Type))
 	offset += uint16ByteLength
@@ -430,6 +533,12 @@ func (rr *RR) MarshalBinary() ([]byte, error) {
 	return buf, nil
 }
 
+// WireSize returns the number of bytes the RR takes on the wire without owner name compression.
+func (rr *RR) WireSize() int {
+	const TypeClassTTLRDLENGTHSize int = 10
+	return utils.NameWireSize(rr.Name) + TypeClassTTLRDLENGTHSize + len(rr.RDATA)
+}
+
 // Unmarshal parses a DNS RR from binary data.
 func Unmarshal(data []byte, fullPacket []byte) (RR, int, error) {
 	const uint16ByteLength int = 2
@@ -493,7 +602,9 @@ func CopyRR(old RR) (RR, error) {
 		if err != nil {
 			return RR{}, fmt.Errorf("failed to get A record: %w", err)
 		}
-		newCopy.SetRDATAToARecord(ip)
+		if err := newCopy.SetRDATAToARecord(ip); err != nil {
+			return RR{}, fmt.Errorf("failed to set A record: %w", err)
+		}
 
 	case DNS_Type.NS:
 		ns, err := old.GetRDATAAsNSRecord()
@@ -552,16 +663,34 @@ func CopyRR(old RR) (RR, error) {
 			return RR{}, fmt.Errorf("failed to set PTR record: %w", err)
 		}
 
-	// For types without specific setters/getters (MD, MF, MB, MG, MR, NULL, WKS, HINFO, MINFO),
-	// we'll just copy the raw RDATA
-	case DNS_Type.MD, DNS_Type.MF, DNS_Type.MB, DNS_Type.MG, DNS_Type.MR,
-		DNS_Type.NULL, DNS_Type.WKS, DNS_Type.HINFO, DNS_Type.MINFO:
-		newCopy.SetType(old.Type)
-		newCopy.SetRDATA(old.GetRDATA())
+	case DNS_Type.CAA:
+		flags, tag, value, err := old.GetRDATAAsCAARecord()
+		if err != nil {
+			return RR{}, fmt.Errorf("failed to get CAA record: %w", err)
+		}
+		err = newCopy.SetRDATAToCAARecord(flags, tag, value)
+		if err != nil {
+			return RR{}, fmt.Errorf("failed to set CAA record: %w", err)
+		}
 
+	// The names in the RDATA of these types may be compressed, so they are written out in full.
+	case DNS_Type.MD, DNS_Type.MF, DNS_Type.MB, DNS_Type.MG, DNS_Type.MR, DNS_Type.MINFO, DNS_Type.NAPTR:
+		rdata, err := old.decompressedRDATA()
+		if err != nil {
+			return RR{}, fmt.Errorf("failed to copy record of type %d: %w", old.Type, err)
+		}
+		newCopy.SetType(old.Type)
+		newCopy.SetRDATA(rdata)
+
+	// For types without specific setters/getters (NULL, WKS, HINFO) and types this package does not know the RDATA
+	// layout of (A6, DNAME, SRV, experimental types), we'll just copy the raw RDATA. Names inside the RDATA of types
+	// defined after RFC 1035 are never compressed (https://datatracker.ietf.org/doc/html/rfc3597#section-4), so bytes
+	// which look like compression pointers are data and must be kept as they are. A server which compresses them
+	// anyway breaks such records for us: the pointers are copied as they are and point at unrelated bytes once the
+	// record is marshalled into another packet.
 	default:
 		newCopy.SetType(old.Type)
-		newCopy.SetRDATA(old.GetRDATA())
+		newCopy.SetRDATA(bytes.Clone(old.GetRDATA()))
 	}
 
 	return newCopy, nil
@@ -1,10 +1,14 @@
 package RR
 
 import (
+	"bytes"
+	"errors"
 	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
 	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
 	"math"
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -49,7 +53,9 @@ func TestARRecord(t *testing.T) {
 	record.SetName(testName)
 
 	testIP := net.ParseIP("192.168.1.1")
-	record.SetRDATAToARecord(testIP)
+	if err := record.SetRDATAToARecord(testIP); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
 
 	if record.Type != DNS_Type.A {
 		t.Fatalf("A record type was not set correctly. Got %d, expected %d", record.Type, DNS_Type.A)
@@ -75,6 +81,52 @@ func TestARRecord(t *testing.T) {
 	}
 }
 
+func TestARecord_RejectsIPv6(t *testing.T) {
+	record := RR{Name: "www.example.com", Class: DNS_Class.IN, TTL: 300}
+
+	err := record.SetRDATAToARecord(net.ParseIP("2001:db8::1"))
+	if !errors.Is(err, ErrNotIPv4) {
+		t.Fatalf("expected ErrNotIPv4, got %v", err)
+	}
+	if record.Type == DNS_Type.A || len(record.RDATA) != 0 || record.RDLENGTH != 0 {
+		t.Fatalf("expected the record to be left unchanged, got type %s with %d bytes of RDATA", record.Type,
+			len(record.RDATA))
+	}
+}
+
+func TestSetRDATAToARecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      net.IP
+		want    []byte
+		wantErr bool
+	}{
+		{name: "IPv4", ip: net.IP{192, 0, 2, 1}, want: []byte{192, 0, 2, 1}},
+		{name: "IPv4 in 16 byte form", ip: net.IPv4(192, 0, 2, 1), want: []byte{192, 0, 2, 1}},
+		{name: "IPv6", ip: net.ParseIP("2001:db8::1"), wantErr: true},
+		{name: "IPv4-compatible IPv6", ip: net.ParseIP("::c000:201"), wantErr: true},
+		{name: "nil", ip: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := RR{}
+			err := record.SetRDATAToARecord(tt.ip)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNotIPv4) {
+					t.Fatalf("expected ErrNotIPv4, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetRDATAToARecord failed: %v", err)
+			}
+			if record.Type != DNS_Type.A || !bytes.Equal(record.RDATA, tt.want) || record.RDLENGTH != 4 {
+				t.Fatalf("expected an A record with RDATA %v, got type %s with %v", tt.want, record.Type, record.RDATA)
+			}
+		})
+	}
+}
+
 func TestMXRecord(t *testing.T) {
 	record := RR{}
 	testName := "example.com."
@@ -158,6 +210,38 @@ func TestCNAMERecord(t *testing.T) {
 	}
 }
 
+func TestCNAMERecord_OverlongTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		wantErr error
+	}{
+		{name: "label too long", target: strings.Repeat("a", 64) + ".example.com", wantErr: utils.ErrLabelTooLong},
+		{name: "name too long", target: strings.Repeat(strings.Repeat("a", 63)+".", 4), wantErr: utils.ErrDomainNameTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := RR{Name: "alias.example.com", Class: DNS_Class.IN, TTL: 300}
+
+			err := record.SetRDATAToCNAMERecord(tt.target)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(err.Error(), "CNAME target") {
+				t.Fatalf("expected the error to name the CNAME target, got %q", err)
+			}
+		})
+	}
+}
+
+func TestMXRecord_OverlongExchange(t *testing.T) {
+	record := RR{}
+	err := record.SetRDATAToMXRecord(10, "mail."+strings.Repeat("a", 64)+".example.com")
+	if !errors.Is(err, utils.ErrLabelTooLong) {
+		t.Fatalf("expected ErrLabelTooLong, got %v", err)
+	}
+}
+
 func TestNSRecord(t *testing.T) {
 	record := RR{}
 	testName := "example.com."
@@ -268,6 +352,47 @@ func TestPTRRecord(t *testing.T) {
 	}
 }
 
+func TestCAARecord(t *testing.T) {
+	record := RR{}
+	record.SetName("example.com")
+
+	if err := record.SetRDATAToCAARecord(0, "issue", "letsencrypt.org"); err != nil {
+		t.Fatalf("Failed to set CAA record: %v", err)
+	}
+	if record.Type != DNS_Type.CAA {
+		t.Fatalf("CAA record type was not set correctly. Got %d, expected %d", record.Type, DNS_Type.CAA)
+	}
+	expected := append([]byte{0, 5}, "issueletsencrypt.org"...)
+	if !bytes.Equal(record.GetRDATA(), expected) {
+		t.Fatalf("CAA RDATA mismatch. Got %v, expected %v", record.GetRDATA(), expected)
+	}
+
+	copyRR, err := CopyRR(record)
+	if err != nil {
+		t.Fatalf("Failed to copy CAA record: %v", err)
+	}
+	flags, tag, value, err := copyRR.GetRDATAAsCAARecord()
+	if err != nil {
+		t.Fatalf("Failed to get CAA record: %v", err)
+	}
+	if flags != 0 || tag != "issue" || value != "letsencrypt.org" {
+		t.Fatalf("CAA mismatch. Got %d %s %q, expected 0 issue \"letsencrypt.org\"", flags, tag, value)
+	}
+
+	record.SetRDATA([]byte{0, 6, 'i', 's', 's', 'u', 'e'})
+	if _, _, _, err := record.GetRDATAAsCAARecord(); err == nil {
+		t.Fatal("GetRDATAAsCAARecord should fail when the tag runs past RDLENGTH")
+	}
+	if err := record.SetRDATAToCAARecord(0, "", "letsencrypt.org"); err == nil {
+		t.Fatal("SetRDATAToCAARecord should fail with an empty tag")
+	}
+
+	record.SetType(DNS_Type.A)
+	if _, _, _, err := record.GetRDATAAsCAARecord(); err == nil {
+		t.Fatal("GetRDATAAsCAARecord should fail with incorrect type")
+	}
+}
+
 func TestSOARecord(t *testing.T) {
 	record := RR{}
 	testName := "example.com."
@@ -348,7 +473,9 @@ func TestMarshalUnmarshal(t *testing.T) {
 	}
 
 	ip := net.ParseIP("192.168.1.1")
-	original.SetRDATAToARecord(ip)
+	if err := original.SetRDATAToARecord(ip); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
 
 	data, err := original.MarshalBinary()
 	if err != nil {
@@ -404,7 +531,9 @@ func TestCopyRR(t *testing.T) {
 	}
 
 	ip := net.ParseIP("192.168.1.1")
-	original.SetRDATAToARecord(ip)
+	if err := original.SetRDATAToARecord(ip); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
 
 	copyRR, err := CopyRR(original)
 	if err != nil {
@@ -497,7 +626,9 @@ func TestErrorHandlingUnmarshal(t *testing.T) {
 	}
 
 	ip := net.ParseIP("192.168.1.1")
-	record.SetRDATAToARecord(ip)
+	if err := record.SetRDATAToARecord(ip); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
 
 	data, err := record.MarshalBinary()
 	if err != nil {
@@ -586,3 +717,75 @@ func TestSpecialCases(t *testing.T) {
 			rname, "admin.example.com.")
 	}
 }
+
+func TestUnknownType_LosslessPassthrough(t *testing.T) {
+	const typeA6 DNS_Type.Type = 38
+
+	// The RDATA holds bytes which look like a compression pointer to offset 12, they must not be followed.
+	rdata := []byte{0x40, 0x20, 0x01, 0x0d, 0xb8, 0xc0, 0x0c, 0x03, 'f', 'o', 'o', 0x00}
+	original := RR{Name: "a6.example.com", Type: typeA6, Class: DNS_Class.IN, TTL: 300}
+	original.SetRDATA(rdata)
+
+	packet := make([]byte, 12) // A header, so that offset 12 exists
+	wire, err := original.AppendBinary(packet)
+	if err != nil {
+		t.Fatalf("AppendBinary returned error: %v", err)
+	}
+
+	parsed, n, err := Unmarshal(wire[12:], wire)
+	if err != nil {
+		t.Fatalf("Unmarshal returned error: %v", err)
+	}
+	if n != len(wire)-12 || parsed.Type != typeA6 || !bytes.Equal(parsed.RDATA, rdata) {
+		t.Fatalf("expected the record to be parsed unchanged, got %+v", parsed)
+	}
+
+	copied, err := CopyRR(parsed)
+	if err != nil {
+		t.Fatalf("CopyRR returned error: %v", err)
+	}
+	again, err := copied.AppendBinary(make([]byte, 12))
+	if err != nil {
+		t.Fatalf("AppendBinary returned error: %v", err)
+	}
+	if !bytes.Equal(again, wire) {
+		t.Fatalf("expected the copy to marshal byte-identically\nwant %x\ngot  %x", wire, again)
+	}
+
+	copied.RDATA[0] = 0xff
+	if parsed.RDATA[0] != rdata[0] {
+		t.Fatalf("expected CopyRR to copy the RDATA, not share it")
+	}
+}
+
+func TestCopyRR_DecompressesNAPTRReplacement(t *testing.T) {
+	// A header followed by the owner name, so that the replacement can point at it.
+	packet := append(make([]byte, 12), 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0)
+
+	prefix := []byte{0x00, 0x64, 0x00, 0x0a, 1, 's', 7, 'S', 'I', 'P', '+', 'D', '2', 'U', 0}
+	original := RR{Name: "example.com", Type: DNS_Type.NAPTR, Class: DNS_Class.IN, TTL: 300}
+	original.SetRDATA(append(append([]byte{}, prefix...), 0xc0, 0x0c)) // The replacement points at offset 12.
+
+	wire, err := original.AppendBinary(packet)
+	if err != nil {
+		t.Fatalf("AppendBinary returned error: %v", err)
+	}
+	parsed, _, err := Unmarshal(wire[len(packet):], wire)
+	if err != nil {
+		t.Fatalf("Unmarshal returned error: %v", err)
+	}
+
+	copied, err := CopyRR(parsed)
+	if err != nil {
+		t.Fatalf("CopyRR returned error: %v", err)
+	}
+	expected := append(append([]byte{}, prefix...), 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0)
+	if copied.Type != DNS_Type.NAPTR || !bytes.Equal(copied.GetRDATA(), expected) {
+		t.Fatalf("expected the replacement to be written out in full\nwant %x\ngot  %x", expected, copied.GetRDATA())
+	}
+
+	// Without the packet it was read from the pointer can not be resolved, so the record is refused.
+	if _, err := CopyRR(original); err == nil {
+		t.Fatal("expected CopyRR to refuse a compressed replacement it can not resolve")
+	}
+}
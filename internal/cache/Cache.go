@@ -1,31 +1,100 @@
 package cache
 
 import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
 	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/clock"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
 	"log/slog"
 	"math"
+	"net"
 	"sync"
 	"time"
 )
 
+// DefaultMaxTTL caps how long anything is cached unless configured with WithMaxTTL, to prevent excessively long cache
+// times
+const DefaultMaxTTL = 1 * time.Hour
+
 type cachedResponse struct {
 	message   *Message.Message
 	expiresAt time.Time
 }
 
+// rrsetKey identifies an RRset, the records sharing an owner name, type and class. Names are lowercase.
+type rrsetKey struct {
+	name   string
+	rrType DNS_Type.Type
+	class  DNS_Class.Class
+}
+
+func newRRSetKey(owner string, rrType DNS_Type.Type, class DNS_Class.Class) rrsetKey {
+	return rrsetKey{name: name.Canonicalize(owner), rrType: rrType, class: class}
+}
+
+type cachedRRSet struct {
+	expiresAt time.Time
+	set       Message.RRSet
+	// authoritative is set for RRsets from the Answer section, which are not replaced by Authority or Additional data.
+	authoritative bool
+}
+
+// cachedAddressName is the owner name of an A or AAAA record, indexed by its address.
+type cachedAddressName struct {
+	expiresAt time.Time
+	name      string
+}
+
 // DNSCache represents a simple cache for DNS records
 
 type DNSCache struct {
-	cache  map[string]cachedResponse
+	cache map[string]cachedResponse
+	// failures holds the expiry times of cached resolution failures (https://datatracker.ietf.org/doc/html/rfc9520)
+	failures map[string]time.Time
+	// rrsets holds individual RRsets, so records learned while resolving one query type can answer others
+	rrsets map[rrsetKey]cachedRRSet
+	// addressNames is the reverse index of cached A and AAAA answers, nil unless enabled with EnableReverseIndex.
+	addressNames map[string]cachedAddressName
+	// clock tells the time entries expire against.
+	clock clock.Clock
+	// maxTTL caps how long anything is cached, zero disables the cap.
+	maxTTL time.Duration
 	logger *slog.Logger
 	mu     sync.RWMutex
 }
 
+// Option configures optional behaviour of a DNSCache created with NewDNSCache.
+type Option func(*DNSCache)
+
+// WithClock makes the cache expire its entries against clk instead of the system clock.
+func WithClock(clk clock.Clock) Option {
+	return func(c *DNSCache) {
+		c.clock = clk
+	}
+}
+
+// WithMaxTTL caps how long anything is cached at maxTTL instead of DefaultMaxTTL. Zero disables the cap, so the full
+// TTL of every record is honored.
+func WithMaxTTL(maxTTL time.Duration) Option {
+	return func(c *DNSCache) {
+		c.maxTTL = max(maxTTL, 0)
+	}
+}
+
 // NewDNSCache creates a new DNS cache
-func NewDNSCache(logger *slog.Logger) *DNSCache {
+func NewDNSCache(logger *slog.Logger, opts ...Option) *DNSCache {
 	cache := &DNSCache{
-		cache:  make(map[string]cachedResponse),
-		logger: logger,
+		cache:    make(map[string]cachedResponse),
+		failures: make(map[string]time.Time),
+		rrsets:   make(map[rrsetKey]cachedRRSet),
+		clock:    clock.Real{},
+		maxTTL:   DefaultMaxTTL,
+		logger:   logger,
+	}
+	for _, opt := range opts {
+		opt(cache)
 	}
 
 	// Start cache cleanup goroutine
@@ -40,25 +109,45 @@ func (c *DNSCache) periodicallyCleanup() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		c.cleanup()
+		c.CleanupNow()
 	}
 }
 
-// cleanup removes expired cache entries
-func (c *DNSCache) cleanup() {
+// CleanupNow removes expired cache entries right away, instead of waiting for the periodic cleanup.
+// Expired entries are never returned either way, cleaning up only frees their memory.
+func (c *DNSCache) CleanupNow() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	now := time.Now()
+	now := c.clock.Now()
 	for key, entry := range c.cache {
 		if entry.expiresAt.Before(now) {
 			delete(c.cache, key)
 			c.logger.Debug("Removed expired cache entry", slog.String("key", key))
 		}
 	}
+	for key, expiresAt := range c.failures {
+		if expiresAt.Before(now) {
+			delete(c.failures, key)
+			c.logger.Debug("Removed expired failure cache entry", slog.String("key", key))
+		}
+	}
+	for key, entry := range c.rrsets {
+		if entry.expiresAt.Before(now) {
+			delete(c.rrsets, key)
+			c.logger.Debug("Removed expired RRset cache entry", slog.String("name", key.name), slog.Any("type", key.rrType))
+		}
+	}
+	for address, entry := range c.addressNames {
+		if entry.expiresAt.Before(now) {
+			delete(c.addressNames, address)
+			c.logger.Debug("Removed expired reverse index entry", slog.String("address", address))
+		}
+	}
 }
 
-// Get retrieves a cached DNS message if available and not expired
+// Get retrieves a copy of a cached DNS message if available and not expired. The copy can be modified without affecting
+// the cached message, so every caller may adapt it to its client.
 func (c *DNSCache) Get(key string) *Message.Message {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -68,11 +157,16 @@ func (c *DNSCache) Get(key string) *Message.Message {
 		return nil
 	}
 
-	if time.Now().After(entry.expiresAt) {
+	if c.clock.Now().After(entry.expiresAt) {
 		return nil
 	}
 
-	return entry.message
+	msg, err := Message.Copy(entry.message)
+	if err != nil {
+		c.logger.Warn("Failed to copy cached response", slog.String("key", key), slog.Any("error", err))
+		return nil
+	}
+	return &msg
 }
 
 // Put adds a DNS message to the cache with TTL from the record
@@ -94,22 +188,191 @@ func (c *DNSCache) Put(key string, msg *Message.Message) {
 		return
 	}
 
-	// Use minimum of actual TTL or 1 hour to prevent excessively long cache times
-	cacheTTL := time.Duration(minTTL) * time.Second
-	maxCacheTTL := 1 * time.Hour
-	if cacheTTL > maxCacheTTL {
-		cacheTTL = maxCacheTTL
-	}
+	// Use minimum of actual TTL or the cap to prevent excessively long cache times
+	cacheTTL := c.capTTL(time.Duration(minTTL) * time.Second)
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.cache[key] = cachedResponse{
 		message:   msg,
-		expiresAt: time.Now().Add(cacheTTL),
+		expiresAt: c.clock.Now().Add(cacheTTL),
 	}
 
 	c.logger.Debug("Added DNS response to cache",
 		slog.String("key", key),
 		slog.Duration("ttl", cacheTTL))
 }
+
+// capTTL returns ttl capped at the maximum TTL of the cache, if it has one.
+func (c *DNSCache) capTTL(ttl time.Duration) time.Duration {
+	if c.maxTTL == 0 {
+		return ttl
+	}
+	return min(ttl, c.maxTTL)
+}
+
+// PutNegative caches a negative response to key, NXDOMAIN or NOERROR without answers, for ttl. Get returns it like any
+// other cached response until it expires.
+func (c *DNSCache) PutNegative(key string, msg *Message.Message, ttl time.Duration) {
+	if msg == nil || msg.Header.GetQDCOUNT() == 0 || ttl <= 0 {
+		return
+	}
+	cacheTTL := c.capTTL(ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cache[key] = cachedResponse{
+		message:   msg,
+		expiresAt: c.clock.Now().Add(cacheTTL),
+	}
+
+	c.logger.Debug("Added negative DNS response to cache",
+		slog.String("key", key),
+		slog.Duration("ttl", cacheTTL))
+}
+
+// PutFailure remembers that resolving key failed, so that repeated queries can be answered with SERVFAIL for ttl
+// without resolving again.
+func (c *DNSCache) PutFailure(key string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures[key] = c.clock.Now().Add(ttl)
+
+	c.logger.Debug("Added resolution failure to cache",
+		slog.String("key", key),
+		slog.Duration("ttl", ttl))
+}
+
+// HasFailure reports whether a resolution failure for key is cached and not expired.
+func (c *DNSCache) HasFailure(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expiresAt, found := c.failures[key]
+	return found && c.clock.Now().Before(expiresAt)
+}
+
+// PutRRSets caches every RRset of msg individually, keyed by owner name, type and class, so that they can be reused by
+// queries of other types. RRsets from the Answer section replace cached ones, RRsets from the Authority and Additional
+// sections only fill in RRsets which are not cached yet. The OPT pseudo record is never cached.
+func (c *DNSCache) PutRRSets(msg *Message.Message) {
+	if msg == nil {
+		return
+	}
+
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.putRRSets(now, msg.Answers, true)
+	c.putRRSets(now, msg.Authority, false)
+	c.putRRSets(now, msg.Additional, false)
+}
+
+// putRRSets groups records into RRsets and caches them. c.mu must be held.
+func (c *DNSCache) putRRSets(now time.Time, records []RR.RR, authoritative bool) {
+	for _, set := range Message.NewRRSets(records) {
+		if set.TTL == 0 {
+			continue
+		}
+
+		key := newRRSetKey(set.Name, set.Type, set.Class)
+		existing, found := c.rrsets[key]
+		if found && existing.expiresAt.After(now) && existing.authoritative && !authoritative {
+			continue
+		}
+
+		cacheTTL := c.capTTL(time.Duration(set.TTL) * time.Second)
+		c.rrsets[key] = cachedRRSet{
+			set:           set,
+			expiresAt:     now.Add(cacheTTL),
+			authoritative: authoritative,
+		}
+
+		c.logger.Debug("Added RRset to cache",
+			slog.String("name", key.name),
+			slog.Any("type", key.rrType),
+			slog.Duration("ttl", cacheTTL))
+
+		if authoritative {
+			c.indexAddresses(set, now.Add(cacheTTL))
+		}
+	}
+}
+
+// EnableReverseIndex makes the cache index the addresses of A and AAAA records from the Answer section of messages
+// passed to PutRRSets, so that GetAddressName can map them back to their owner names.
+func (c *DNSCache) EnableReverseIndex() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.addressNames == nil {
+		c.addressNames = make(map[string]cachedAddressName)
+	}
+}
+
+// indexAddresses adds the addresses of an A or AAAA RRset to the reverse index, if it is enabled. c.mu must be held.
+func (c *DNSCache) indexAddresses(set Message.RRSet, expiresAt time.Time) {
+	if c.addressNames == nil || set.Class != DNS_Class.IN {
+		return
+	}
+	for _, rr := range set.Records {
+		switch {
+		case set.Type == DNS_Type.A && len(rr.RDATA) == net.IPv4len:
+		case set.Type == DNS_Type.AAAA && len(rr.RDATA) == net.IPv6len:
+		default:
+			continue
+		}
+		c.addressNames[net.IP(rr.RDATA).String()] = cachedAddressName{name: set.Name, expiresAt: expiresAt}
+	}
+}
+
+// GetAddressName returns the owner name of a cached A or AAAA record with address ip and the seconds the record has
+// left in the cache. It returns false if the reverse index is disabled or has no unexpired entry for ip.
+func (c *DNSCache) GetAddressName(ip net.IP) (string, uint32, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, found := c.addressNames[ip.String()]
+	if !found {
+		return "", 0, false
+	}
+	remaining := entry.expiresAt.Sub(c.clock.Now())
+	if remaining < time.Second {
+		return "", 0, false
+	}
+	return entry.name, uint32(remaining / time.Second), true //nolint:gosec
+}
+
+// GetRRSet returns a copy of the cached RRset of name, type and class followed by its signatures, with the TTLs
+// lowered to the time the RRset has left in the cache. It returns nil if the RRset is not cached or expired.
+func (c *DNSCache) GetRRSet(name string, rrType DNS_Type.Type, class DNS_Class.Class) []RR.RR {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, found := c.rrsets[newRRSetKey(name, rrType, class)]
+	if !found {
+		return nil
+	}
+
+	remaining := entry.expiresAt.Sub(c.clock.Now())
+	if remaining <= 0 {
+		return nil
+	}
+
+	set := entry.set
+	set.TTL = uint32(remaining / time.Second) //nolint:gosec
+	var rrset Message.Message
+	if err := rrset.AddRRSet(Message.AnswerSection, set); err != nil {
+		return nil
+	}
+	return rrset.Answers
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
 	"github.com/blazskufca/dns_server_in_go/internal/Message"
 	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/clock"
 	"github.com/blazskufca/dns_server_in_go/internal/header"
 	"github.com/blazskufca/dns_server_in_go/internal/question"
 	"log/slog"
@@ -34,7 +35,8 @@ func TestDNSCache_Get(t *testing.T) {
 
 func TestDNSCache_Expiration(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
-	cache := NewDNSCache(logger)
+	clk := clock.NewFake(time.Now())
+	cache := NewDNSCache(logger, WithClock(clk))
 
 	msg := createMessageWithTTL(t, 1)
 
@@ -46,7 +48,12 @@ func TestDNSCache_Expiration(t *testing.T) {
 		t.Fatalf("Expected cache hit before expiration, got nil")
 	}
 
-	time.Sleep(2 * time.Second)
+	clk.Advance(999 * time.Millisecond)
+	if result := cache.Get(key); result == nil {
+		t.Fatalf("Expected cache hit just before expiration, got nil")
+	}
+
+	clk.Advance(2 * time.Millisecond)
 
 	result = cache.Get(key)
 	if result != nil {
@@ -56,7 +63,8 @@ func TestDNSCache_Expiration(t *testing.T) {
 
 func TestDNSCache_Put(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
-	cache := NewDNSCache(logger)
+	clk := clock.NewFake(time.Now())
+	cache := NewDNSCache(logger, WithClock(clk))
 
 	tests := []struct { //nolint:govet
 		name     string
@@ -123,9 +131,8 @@ func TestDNSCache_Put(t *testing.T) {
 					return
 				}
 
-				expectedExpiration := time.Now().Add(tt.maxCache)
-				if expectedExpiration.Sub(entry.expiresAt) > 1*time.Second ||
-					entry.expiresAt.Sub(expectedExpiration) > 1*time.Second {
+				expectedExpiration := clk.Now().Add(tt.maxCache)
+				if !entry.expiresAt.Equal(expectedExpiration) {
 					t.Fatalf("Wrong expiration time. Expected around %v, got %v",
 						expectedExpiration, entry.expiresAt)
 				}
@@ -134,9 +141,10 @@ func TestDNSCache_Put(t *testing.T) {
 	}
 }
 
-func TestDNSCache_Cleanup(t *testing.T) {
+func TestDNSCache_CleanupNow(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
-	cache := NewDNSCache(logger)
+	clk := clock.NewFake(time.Now())
+	cache := NewDNSCache(logger, WithClock(clk))
 
 	msg1 := createMessageWithTTL(t, 1)
 	key1 := "expired.example.com"
@@ -146,9 +154,16 @@ func TestDNSCache_Cleanup(t *testing.T) {
 	key2 := "not-expired.example.com"
 	cache.Put(key2, msg2)
 
-	time.Sleep(2 * time.Second)
+	clk.Advance(2 * time.Second)
 
-	cache.cleanup()
+	cache.CleanupNow()
+
+	cache.mu.RLock()
+	_, stillStored := cache.cache[key1]
+	cache.mu.RUnlock()
+	if stillStored {
+		t.Fatalf("Expected cleanup to remove the expired entry")
+	}
 
 	if ce := cache.Get(key1); ce != nil {
 		t.Fatalf("Expected cache miss, got %v", ce)
@@ -197,7 +212,8 @@ func TestDNSCache_ConcurrentAccess(t *testing.T) {
 
 func TestDNSCache_MinimumTTL(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
-	cache := NewDNSCache(logger)
+	clk := clock.NewFake(time.Now())
+	cache := NewDNSCache(logger, WithClock(clk))
 
 	msg := createMessageWithTTL(t, 300)
 	msg.Answers = append(msg.Answers, RR.RR{TTL: 600})
@@ -218,9 +234,8 @@ func TestDNSCache_MinimumTTL(t *testing.T) {
 		return
 	}
 
-	expectedExpiration := time.Now().Add(200 * time.Second)
-	if expectedExpiration.Sub(entry.expiresAt) > 1*time.Second ||
-		entry.expiresAt.Sub(expectedExpiration) > 1*time.Second {
+	expectedExpiration := clk.Now().Add(200 * time.Second)
+	if !entry.expiresAt.Equal(expectedExpiration) {
 		t.Fatalf("Wrong expiration time. Expected around %v, got %v",
 			expectedExpiration, entry.expiresAt)
 	}
@@ -228,13 +243,14 @@ func TestDNSCache_MinimumTTL(t *testing.T) {
 
 func TestDNSCache_PeriodicallyCleanup(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
-	cache := NewDNSCache(logger)
+	clk := clock.NewFake(time.Now())
+	cache := NewDNSCache(logger, WithClock(clk))
 
 	// Override ticker for testing
 	ticker := time.NewTicker(50 * time.Millisecond)
 	go func() {
 		for range ticker.C {
-			cache.cleanup()
+			cache.CleanupNow()
 		}
 	}()
 
@@ -242,17 +258,27 @@ func TestDNSCache_PeriodicallyCleanup(t *testing.T) {
 	msg := createMessageWithTTL(t, 1)
 	cache.Put(key, msg)
 
-	time.Sleep(2 * time.Second)
+	clk.Advance(2 * time.Second)
 
-	// Check if entry was removed
-	if ce := cache.Get(key); ce != nil {
-		t.Fatalf("Expected cache miss, got %v", ce)
+	// Wait for the ticker to remove the entry
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cache.mu.RLock()
+		_, found := cache.cache[key]
+		cache.mu.RUnlock()
+		if !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the periodic cleanup to remove the expired entry")
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
 
 	ticker.Stop()
 }
 
-func createMessageWithTTL(t *testing.T, ttl uint32) *Message.Message {
+func createMessageWithTTL(t testing.TB, ttl uint32) *Message.Message {
 	t.Helper()
 	msg := &Message.Message{
 		Header: header.Header{},
@@ -275,3 +301,258 @@ func createMessageWithTTL(t *testing.T, ttl uint32) *Message.Message {
 
 	return msg
 }
+
+func TestDNSCache_Failure(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	clk := clock.NewFake(time.Now())
+	cache := NewDNSCache(logger, WithClock(clk))
+
+	key := "broken.example.com:1"
+	if cache.HasFailure(key) {
+		t.Fatalf("Expected no cached failure before PutFailure")
+	}
+
+	cache.PutFailure(key, time.Second)
+	if !cache.HasFailure(key) {
+		t.Fatalf("Expected cached failure after PutFailure")
+	}
+	if ce := cache.Get(key); ce != nil {
+		t.Fatalf("Expected a cached failure not to be returned as a response, got %v", ce)
+	}
+
+	clk.Advance(1100 * time.Millisecond)
+
+	if cache.HasFailure(key) {
+		t.Fatalf("Expected cached failure to expire")
+	}
+}
+
+func TestDNSCache_RRSets(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	cache := NewDNSCache(logger)
+
+	cname := RR.RR{Name: "www.example.com", Class: DNS_Class.IN, TTL: 300}
+	if err := cname.SetRDATAToCNAMERecord("web.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	target := RR.RR{Name: "web.example.com", Class: DNS_Class.IN, TTL: 60}
+	if err := target.SetRDATAToARecord([]byte{192, 0, 2, 1}); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	ns := RR.RR{Name: "example.com", Class: DNS_Class.IN, TTL: 3600}
+	if err := ns.SetRDATAToNSRecord("ns.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	opt := RR.RR{Name: "", Type: DNS_Type.OPT, Class: 1232}
+
+	msg := createMessageWithTTL(t, 300)
+	msg.Answers = []RR.RR{cname, target}
+	msg.Authority = []RR.RR{ns}
+	msg.Additional = []RR.RR{opt}
+	cache.PutRRSets(msg)
+
+	if got := cache.GetRRSet("WWW.example.com.", DNS_Type.CNAME, DNS_Class.IN); len(got) != 1 {
+		t.Fatalf("Expected the CNAME RRset to be cached, got %v", got)
+	}
+	if got := cache.GetRRSet("www.example.com", DNS_Type.AAAA, DNS_Class.IN); got != nil {
+		t.Fatalf("Expected no AAAA RRset, got %v", got)
+	}
+	if got := cache.GetRRSet("example.com", DNS_Type.NS, DNS_Class.IN); len(got) != 1 {
+		t.Fatalf("Expected the NS RRset from the Authority section to be cached, got %v", got)
+	}
+	if got := cache.GetRRSet("", DNS_Type.OPT, 1232); got != nil {
+		t.Fatalf("Expected the OPT record not to be cached, got %v", got)
+	}
+
+	got := cache.GetRRSet("web.example.com", DNS_Type.A, DNS_Class.IN)
+	if len(got) != 1 || got[0].GetTTL() > 60 {
+		t.Fatalf("Expected the A RRset with its TTL counting down from 60, got %v", got)
+	}
+	got[0].TTL = 0
+	if again := cache.GetRRSet("web.example.com", DNS_Type.A, DNS_Class.IN); again[0].GetTTL() == 0 {
+		t.Fatalf("Expected GetRRSet to return a copy")
+	}
+
+	stale := RR.RR{Name: "web.example.com", Class: DNS_Class.IN, TTL: 60}
+	if err := stale.SetRDATAToARecord([]byte{203, 0, 113, 1}); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	cache.PutRRSets(&Message.Message{Additional: []RR.RR{stale}})
+	got = cache.GetRRSet("web.example.com", DNS_Type.A, DNS_Class.IN)
+	if ip, err := got[0].GetRDATAAsARecord(); err != nil || ip.String() != "192.0.2.1" {
+		t.Fatalf("Expected additional data not to replace the cached answer, got %v", got)
+	}
+}
+
+func TestDNSCache_ReverseIndex(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	cache := NewDNSCache(logger)
+
+	answer := RR.RR{Name: "host.example.com", Class: DNS_Class.IN, TTL: 60}
+	if err := answer.SetRDATAToARecord([]byte{192, 0, 2, 1}); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	glue := RR.RR{Name: "ns.example.com", Class: DNS_Class.IN, TTL: 60}
+	if err := glue.SetRDATAToARecord([]byte{192, 0, 2, 53}); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	msg := &Message.Message{Answers: []RR.RR{answer}, Additional: []RR.RR{glue}}
+
+	cache.PutRRSets(msg)
+	if _, _, ok := cache.GetAddressName([]byte{192, 0, 2, 1}); ok {
+		t.Fatalf("Expected no reverse index entries before EnableReverseIndex")
+	}
+
+	cache.EnableReverseIndex()
+	cache.PutRRSets(msg)
+
+	name, ttl, ok := cache.GetAddressName([]byte{192, 0, 2, 1})
+	if !ok || name != "host.example.com" || ttl == 0 || ttl > 60 {
+		t.Fatalf("Expected host.example.com with a TTL counting down from 60, got %q %d %v", name, ttl, ok)
+	}
+	if _, _, ok := cache.GetAddressName([]byte{192, 0, 2, 53}); ok {
+		t.Fatalf("Expected addresses from the Additional section not to be indexed")
+	}
+}
+
+func TestDNSCache_RRSetExpiresWithClock(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	clk := clock.NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	cache := NewDNSCache(logger, WithClock(clk))
+	cache.EnableReverseIndex()
+
+	answer := RR.RR{Name: "host.example.com", Class: DNS_Class.IN, TTL: 60}
+	if err := answer.SetRDATAToARecord([]byte{192, 0, 2, 1}); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	cache.PutRRSets(&Message.Message{Answers: []RR.RR{answer}})
+
+	clk.Advance(45 * time.Second)
+	got := cache.GetRRSet("host.example.com", DNS_Type.A, DNS_Class.IN)
+	if len(got) != 1 || got[0].GetTTL() != 15 {
+		t.Fatalf("Expected the A RRset with 15 seconds left, got %v", got)
+	}
+	if _, ttl, ok := cache.GetAddressName([]byte{192, 0, 2, 1}); !ok || ttl != 15 {
+		t.Fatalf("Expected the reverse index entry with 15 seconds left, got %d %v", ttl, ok)
+	}
+
+	clk.Advance(15 * time.Second)
+	if got := cache.GetRRSet("host.example.com", DNS_Type.A, DNS_Class.IN); got != nil {
+		t.Fatalf("Expected the A RRset to expire, got %v", got)
+	}
+	if _, _, ok := cache.GetAddressName([]byte{192, 0, 2, 1}); ok {
+		t.Fatalf("Expected the reverse index entry to expire")
+	}
+}
+
+func TestDNSCache_PutNegative(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	clk := clock.NewFake(time.Now())
+	cache := NewDNSCache(logger, WithClock(clk))
+
+	msg := createMessageWithTTL(t, 0)
+	msg.Answers = nil
+	msg.Header.SetRCODE(header.NameError)
+
+	key := "missing.example.com:1"
+	cache.Put(key, msg)
+	if ce := cache.Get(key); ce != nil {
+		t.Fatalf("Expected Put not to cache a response without answers, got %v", ce)
+	}
+
+	cache.PutNegative(key, msg, 30*time.Second)
+	if ce := cache.Get(key); ce == nil || ce.Header.GetRCODE() != header.NameError {
+		t.Fatalf("Expected the negative response to be cached, got %v", ce)
+	}
+
+	clk.Advance(31 * time.Second)
+	if ce := cache.Get(key); ce != nil {
+		t.Fatalf("Expected the negative response to expire, got %v", ce)
+	}
+}
+
+func TestDNSCache_GetReturnsCopy(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	cache := NewDNSCache(logger)
+
+	msg := createMessageWithTTL(t, 300)
+	msg.Answers[0].Name = "example.com"
+	if err := msg.Answers[0].SetRDATAToARecord([]byte{192, 0, 2, 1}); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	cache.Put("copy.example.com", msg)
+
+	got := cache.Get("copy.example.com")
+	got.Header.ID = [2]byte{0xff, 0xff}
+	got.Header.SetTC(true)
+	got.Answers[0].TTL = 1
+	got.Answers[0].RDATA[0] = 10
+	got.Answers = got.Answers[:0]
+
+	again := cache.Get("copy.example.com")
+	if again.Header.GetMessageID() != 0 || again.Header.IsTC() {
+		t.Fatalf("Expected the cached header to be unaffected, got %+v", again.Header)
+	}
+	if len(again.Answers) != 1 || again.Answers[0].GetTTL() != 300 {
+		t.Fatalf("Expected the cached answers to be unaffected, got %v", again.Answers)
+	}
+	if ip, err := again.Answers[0].GetRDATAAsARecord(); err != nil || ip.String() != "192.0.2.1" {
+		t.Fatalf("Expected the cached RDATA to be unaffected, got %v (%v)", ip, err)
+	}
+}
+
+func TestDNSCache_MaxTTL(t *testing.T) {
+	const twoHours uint32 = 7200
+
+	tests := []struct { //nolint:govet
+		name       string
+		opts       []Option
+		wantExpiry time.Duration
+	}{
+		{name: "Default cap", wantExpiry: DefaultMaxTTL},
+		{name: "Custom cap", opts: []Option{WithMaxTTL(90 * time.Minute)}, wantExpiry: 90 * time.Minute},
+		{name: "Cap disabled", opts: []Option{WithMaxTTL(0)}, wantExpiry: 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
+			cache := NewDNSCache(slog.New(slog.DiscardHandler), append(tt.opts, WithClock(clk))...)
+
+			cache.Put("long-ttl.example.com", createMessageWithTTL(t, twoHours))
+
+			clk.Advance(tt.wantExpiry - time.Second)
+			if ce := cache.Get("long-ttl.example.com"); ce == nil {
+				t.Fatalf("Expected cache hit before %v, got miss", tt.wantExpiry)
+			}
+			clk.Advance(2 * time.Second)
+			if ce := cache.Get("long-ttl.example.com"); ce != nil {
+				t.Fatalf("Expected the entry to expire after %v, got hit", tt.wantExpiry)
+			}
+		})
+	}
+}
+
+func BenchmarkDNSCache_ConcurrentGetPut(b *testing.B) {
+	const keys int = 64
+
+	cache := NewDNSCache(slog.New(slog.DiscardHandler))
+	msg := createMessageWithTTL(b, 3600)
+	for i := range keys {
+		cache.Put(fmt.Sprintf("host%d.example.com", i), msg)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			key := fmt.Sprintf("host%d.example.com", i%keys)
+			if i%10 == 0 { // One write for every nine reads
+				cache.Put(key, msg)
+				continue
+			}
+			if cache.Get(key) == nil {
+				b.Errorf("expected a cache hit for %s", key)
+			}
+		}
+	})
+}
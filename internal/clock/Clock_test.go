@@ -0,0 +1,31 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	if got := c.Now(); !got.Equal(start) {
+		t.Fatalf("expected %v, got %v", start, got)
+	}
+	c.Advance(90 * time.Second)
+	if got := c.Now(); !got.Equal(start.Add(90 * time.Second)) {
+		t.Fatalf("expected the clock to advance by 90s, got %v", got)
+	}
+	c.Set(start)
+	if got := c.Now(); !got.Equal(start) {
+		t.Fatalf("expected the clock to be set back to %v, got %v", start, got)
+	}
+}
+
+func TestReal(t *testing.T) {
+	before := time.Now()
+	got := Real{}.Now()
+	if got.Before(before) || got.After(time.Now()) {
+		t.Fatalf("expected the system time, got %v", got)
+	}
+}
@@ -0,0 +1,78 @@
+package edns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+/*
+The data of an EDNS Client Subnet option (https://datatracker.ietf.org/doc/html/rfc7871#section-6) is:
+
+Field					Type				Description
+FAMILY					2-byte Integer		Address family of ADDRESS, 1 for IPv4 and 2 for IPv6.
+SOURCE PREFIX-LENGTH	1-byte Integer		Leftmost bits of ADDRESS the client asks to be used for the lookup.
+SCOPE PREFIX-LENGTH		1-byte Integer		Leftmost bits of ADDRESS the response covers, 0 in queries.
+ADDRESS					Variable			The address truncated to SOURCE PREFIX-LENGTH bits, padded to whole bytes.
+*/
+
+const (
+	familyIPv4 uint16 = 1
+	familyIPv6 uint16 = 2
+)
+
+var ErrMalformedClientSubnet = errors.New("malformed CLIENT-SUBNET option")
+
+// ParseClientSubnet parses the data of a CLIENT-SUBNET option into the source prefix and the scope prefix length.
+func ParseClientSubnet(data []byte) (prefix netip.Prefix, scope uint8, err error) {
+	const fixedSize int = 4
+
+	if len(data) < fixedSize {
+		return netip.Prefix{}, 0, fmt.Errorf("%w: invalid length %d", ErrMalformedClientSubnet, len(data))
+	}
+	family := binary.BigEndian.Uint16(data)
+	source, scope := int(data[2]), data[3]
+
+	var addr [16]byte
+	var addrLen int
+	switch family {
+	case familyIPv4:
+		addrLen = 4
+	case familyIPv6:
+		addrLen = 16
+	default:
+		return netip.Prefix{}, 0, fmt.Errorf("%w: unknown family %d", ErrMalformedClientSubnet, family)
+	}
+	address := data[fixedSize:]
+	if source > addrLen*8 || int(scope) > addrLen*8 || len(address) != (source+7)/8 {
+		return netip.Prefix{}, 0, fmt.Errorf("%w: address of %d bytes for source prefix length %d",
+			ErrMalformedClientSubnet, len(address), source)
+	}
+	copy(addr[:], address)
+
+	ip := netip.AddrFrom16(addr)
+	if family == familyIPv4 {
+		ip = netip.AddrFrom4([4]byte(addr[:4]))
+	}
+	prefix = netip.PrefixFrom(ip, source)
+	if prefix.Masked() != prefix {
+		return netip.Prefix{}, 0, fmt.Errorf("%w: address bits set beyond source prefix length",
+			ErrMalformedClientSubnet)
+	}
+	return prefix, scope, nil
+}
+
+// ClientSubnetOption builds a CLIENT-SUBNET option for prefix with the given scope prefix length. Address bits beyond
+// the prefix length are cleared.
+func ClientSubnetOption(prefix netip.Prefix, scope uint8) Option {
+	prefix = prefix.Masked()
+	family := familyIPv6
+	if prefix.Addr().Is4() {
+		family = familyIPv4
+	}
+	data := binary.BigEndian.AppendUint16(nil, family)
+	data = append(data, uint8(prefix.Bits()), scope) //nolint:gosec
+	data = append(data, prefix.Addr().AsSlice()[:(prefix.Bits()+7)/8]...)
+	return Option{Code: ClientSubnet, Data: data}
+}
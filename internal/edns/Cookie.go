@@ -0,0 +1,46 @@
+package edns
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+The data of a COOKIE option (https://datatracker.ietf.org/doc/html/rfc7873#section-4) is:
+
+Field			Type				Description
+Client Cookie	8 bytes				Chosen by the client, identifies the client and server pair.
+Server Cookie	8 to 32 bytes		Chosen by the server, absent in the first query of a client to a server.
+*/
+
+const (
+	// ClientCookieLen is the length of a client cookie.
+	ClientCookieLen = 8
+	// MinServerCookieLen and MaxServerCookieLen bound the length of a server cookie.
+	MinServerCookieLen = 8
+	MaxServerCookieLen = 32
+)
+
+var ErrMalformedCookie = errors.New("malformed COOKIE option")
+
+// ParseCookie splits the data of a COOKIE option into the client and the server cookie. The server cookie is nil if
+// the option only carries a client cookie. Neither aliases data.
+func ParseCookie(data []byte) (client []byte, server []byte, err error) {
+	serverLen := len(data) - ClientCookieLen
+	if serverLen < 0 || (serverLen > 0 && (serverLen < MinServerCookieLen || serverLen > MaxServerCookieLen)) {
+		return nil, nil, fmt.Errorf("%w: invalid length %d", ErrMalformedCookie, len(data))
+	}
+	client = append([]byte{}, data[:ClientCookieLen]...)
+	if serverLen > 0 {
+		server = append([]byte{}, data[ClientCookieLen:]...)
+	}
+	return client, server, nil
+}
+
+// CookieOption builds a COOKIE option carrying the client and the server cookie.
+func CookieOption(client, server []byte) Option {
+	data := make([]byte, 0, len(client)+len(server))
+	data = append(data, client...)
+	data = append(data, server...)
+	return Option{Code: Cookie, Data: data}
+}
@@ -0,0 +1,38 @@
+package edns
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseCookie(t *testing.T) {
+	client := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	server := []byte{9, 10, 11, 12, 13, 14, 15, 16}
+
+	gotClient, gotServer, err := ParseCookie(client)
+	if err != nil {
+		t.Fatalf("ParseCookie returned error for a client cookie: %v", err)
+	}
+	if !bytes.Equal(gotClient, client) || gotServer != nil {
+		t.Fatalf("expected client cookie %v without server cookie, got %v and %v", client, gotClient, gotServer)
+	}
+
+	opt := CookieOption(client, server)
+	if opt.Code != Cookie {
+		t.Fatalf("expected a COOKIE option, got %s", opt.Code)
+	}
+	gotClient, gotServer, err = ParseCookie(opt.Data)
+	if err != nil {
+		t.Fatalf("ParseCookie returned error: %v", err)
+	}
+	if !bytes.Equal(gotClient, client) || !bytes.Equal(gotServer, server) {
+		t.Fatalf("expected cookies %v and %v, got %v and %v", client, server, gotClient, gotServer)
+	}
+
+	for _, length := range []int{0, 7, 9, 15, 41} {
+		if _, _, err := ParseCookie(make([]byte, length)); !errors.Is(err, ErrMalformedCookie) {
+			t.Errorf("expected ErrMalformedCookie for length %d, got %v", length, err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package edns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
+	"sync"
+)
+
+/*
+The RDATA of an OPT pseudo record (https://datatracker.ietf.org/doc/html/rfc6891#section-6.1.2) is a sequence of
+options, each encoded as:
+
+Field			Type				Description
+OPTION-CODE		2-byte Integer		Identifies the option, full list https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml#dns-parameters-11
+OPTION-LENGTH	2-byte Integer		Length of OPTION-DATA in bytes.
+OPTION-DATA		Variable			Data specific to the option.
+*/
+
+// Version is the EDNS version implemented by this server.
+const Version uint8 = 0
+
+// BadVersion is the extended RCODE BADVERS, returned to requests with an unsupported EDNS version
+// (https://datatracker.ietf.org/doc/html/rfc6891#section-9).
+const BadVersion uint16 = 16
+
+// BadCookie is the extended RCODE BADCOOKIE, returned to requests with a server cookie the server did not hand out
+// (https://datatracker.ietf.org/doc/html/rfc7873#section-8).
+const BadCookie uint16 = 23
+
+// SetExtendedRCODE sets the 12-bit extendedRCODE of msg, the lower 4 bits in the header RCODE and the upper 8 bits
+// in the OPT record. An OPT record advertising udpSize is added if msg has none.
+func SetExtendedRCODE(msg *Message.Message, extendedRCODE uint16, udpSize uint16) error {
+	opt, ok := msg.GetOPT()
+	if !ok {
+		opt = &Message.OPTRecord{UDPSize: udpSize, Version: Version}
+	}
+	opt.ExtendedRCODE = uint8(extendedRCODE >> 4) //nolint:gosec
+	msg.Header.SetRCODE(header.ResponseCode(extendedRCODE & 0xF))
+	return msg.SetOPT(opt)
+}
+
+// ExtendedRCODE combines the header RCODE of msg with the upper bits carried in its OPT record into the 12-bit RCODE.
+func ExtendedRCODE(msg *Message.Message) uint16 {
+	rcode := uint16(msg.Header.GetRCODE()) //nolint:gosec
+	if opt, ok := msg.GetOPT(); ok {
+		rcode |= uint16(opt.ExtendedRCODE) << 4
+	}
+	return rcode
+}
+
+// OptionCode identifies an EDNS option.
+type OptionCode uint16
+
+const (
+	NSID         OptionCode = 3
+	ClientSubnet OptionCode = 8
+	Expire       OptionCode = 9
+	Cookie       OptionCode = 10
+	KeepAlive    OptionCode = 11
+	Padding      OptionCode = 12
+	// Chain asks for the DNSSEC chain from the closest trust point in its data down to the answer
+	// (https://datatracker.ietf.org/doc/html/rfc7901).
+	Chain OptionCode = 13
+	// ExtendedError carries additional information about the cause of an error (https://datatracker.ietf.org/doc/html/rfc8914).
+	ExtendedError OptionCode = 15
+)
+
+func (c OptionCode) String() string {
+	switch c {
+	case NSID:
+		return "NSID"
+	case ClientSubnet:
+		return "ClientSubnet"
+	case Expire:
+		return "Expire"
+	case Cookie:
+		return "Cookie"
+	case KeepAlive:
+		return "KeepAlive"
+	case Padding:
+		return "Padding"
+	case Chain:
+		return "Chain"
+	case ExtendedError:
+		return "ExtendedError"
+	default:
+		return fmt.Sprintf("Option%d", uint16(c))
+	}
+}
+
+// Option is a single EDNS option carried in the OPT record RDATA.
+type Option struct {
+	Data []byte
+	Code OptionCode
+}
+
+var ErrMalformedOption = errors.New("malformed EDNS option")
+
+// ParseOptions parses the RDATA of an OPT record into its options. Options are returned in wire order and their Data
+// does not alias rdata.
+func ParseOptions(rdata []byte) ([]Option, error) {
+	const optionHeaderSize int = 4
+
+	var opts []Option
+	for offset := 0; offset < len(rdata); {
+		if len(rdata)-offset < optionHeaderSize {
+			return nil, fmt.Errorf("%w: truncated option header at offset %d", ErrMalformedOption, offset)
+		}
+		code := OptionCode(binary.BigEndian.Uint16(rdata[offset:]))
+		length := int(binary.BigEndian.Uint16(rdata[offset+2:]))
+		offset += optionHeaderSize
+
+		if len(rdata)-offset < length {
+			return nil, fmt.Errorf("%w: option %s length %d exceeds RDATA", ErrMalformedOption, code, length)
+		}
+		data := make([]byte, length)
+		copy(data, rdata[offset:offset+length])
+		opts = append(opts, Option{Code: code, Data: data})
+		offset += length
+	}
+	return opts, nil
+}
+
+// PackOptions encodes opts into OPT record RDATA.
+func PackOptions(opts []Option) ([]byte, error) {
+	var rdata []byte
+	for _, opt := range opts {
+		if utils.WouldOverflowUint16(len(opt.Data)) {
+			return nil, fmt.Errorf("%w: option %s data too long", ErrMalformedOption, opt.Code)
+		}
+		rdata = binary.BigEndian.AppendUint16(rdata, uint16(opt.Code))
+		rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(opt.Data)))
+		rdata = append(rdata, opt.Data...)
+	}
+	if utils.WouldOverflowUint16(len(rdata)) {
+		return nil, fmt.Errorf("%w: options exceed maximum RDATA length", ErrMalformedOption)
+	}
+	return rdata, nil
+}
+
+// FindOption returns the first option in opts with the given code.
+func FindOption(opts []Option, code OptionCode) (Option, bool) {
+	for _, opt := range opts {
+		if opt.Code == code {
+			return opt, true
+		}
+	}
+	return Option{}, false
+}
+
+// SetOption returns opts with every option of the same code as opt removed and opt appended.
+func SetOption(opts []Option, opt Option) []Option {
+	kept := make([]Option, 0, len(opts)+1)
+	for _, option := range opts {
+		if option.Code != opt.Code {
+			kept = append(kept, option)
+		}
+	}
+	return append(kept, opt)
+}
+
+// Policy decides what happens to an EDNS option when a message is relayed.
+type Policy uint8
+
+const (
+	// Pass relays the option unchanged.
+	Pass Policy = iota
+	// Strip removes the option.
+	Strip
+)
+
+// Registry holds the per option Policy applied to relayed messages.
+// Options without a registered policy, including ones this server does not understand, are passed through.
+// A nil *Registry passes every option.
+type Registry struct {
+	policies map[OptionCode]Policy
+	mu       sync.RWMutex
+}
+
+// NewRegistry creates a Registry which passes every option.
+func NewRegistry() *Registry {
+	return &Registry{policies: make(map[OptionCode]Policy)}
+}
+
+// SetPolicy registers the Policy for options with code.
+func (r *Registry) SetPolicy(code OptionCode, p Policy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policies[code] = p
+}
+
+// Policy returns the Policy for options with code.
+func (r *Registry) Policy(code OptionCode) Policy {
+	if r == nil {
+		return Pass
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.policies[code]
+}
+
+// Filter returns the options of opts which should be relayed, preserving their order.
+func (r *Registry) Filter(opts []Option) []Option {
+	kept := make([]Option, 0, len(opts))
+	for _, opt := range opts {
+		if r.Policy(opt.Code) != Strip {
+			kept = append(kept, opt)
+		}
+	}
+	return kept
+}
+
+// Apply filters the options of the OPT record of msg in place.
+// The OPT record is only rewritten if an option was actually removed.
+func (r *Registry) Apply(msg *Message.Message) error {
+	if r == nil || msg == nil {
+		return nil
+	}
+	opt, ok := msg.GetOPT()
+	if !ok {
+		return nil
+	}
+	opts, err := ParseOptions(opt.Data)
+	if err != nil {
+		return err
+	}
+	kept := r.Filter(opts)
+	if len(kept) == len(opts) {
+		return nil
+	}
+	opt.Data, err = PackOptions(kept)
+	if err != nil {
+		return err
+	}
+	return msg.SetOPT(opt)
+}
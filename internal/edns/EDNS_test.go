@@ -0,0 +1,159 @@
+package edns
+
+import (
+	"bytes"
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"testing"
+)
+
+func TestPackParseRoundtrip(t *testing.T) {
+	opts := []Option{
+		{Code: Cookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Code: Padding, Data: []byte{}},
+		{Code: OptionCode(65001), Data: []byte("opaque")},
+	}
+
+	rdata, err := PackOptions(opts)
+	if err != nil {
+		t.Fatalf("PackOptions returned error: %v", err)
+	}
+	parsed, err := ParseOptions(rdata)
+	if err != nil {
+		t.Fatalf("ParseOptions returned error: %v", err)
+	}
+
+	if len(parsed) != len(opts) {
+		t.Fatalf("expected %d options, got %d", len(opts), len(parsed))
+	}
+	for i := range opts {
+		if parsed[i].Code != opts[i].Code || !bytes.Equal(parsed[i].Data, opts[i].Data) {
+			t.Errorf("option %d: expected %v, got %v", i, opts[i], parsed[i])
+		}
+	}
+}
+
+func TestParseOptionsMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		rdata []byte
+	}{
+		{"truncated header", []byte{0, 10, 0}},
+		{"length exceeds data", []byte{0, 10, 0, 8, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseOptions(tt.rdata); !errors.Is(err, ErrMalformedOption) {
+				t.Fatalf("expected ErrMalformedOption, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRegistryApply(t *testing.T) {
+	rdata, err := PackOptions([]Option{
+		{Code: Cookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{Code: OptionCode(65001), Data: []byte("opaque")},
+	})
+	if err != nil {
+		t.Fatalf("PackOptions returned error: %v", err)
+	}
+
+	opt := RR.RR{}
+	opt.SetName(".")
+	opt.SetType(DNS_Type.OPT)
+	opt.SetClass(DNS_Class.Class(1232))
+	opt.SetRDATA(rdata)
+	msg := Message.Message{Additional: []RR.RR{opt}}
+
+	r := NewRegistry()
+	r.SetPolicy(Cookie, Strip)
+	if err := r.Apply(&msg); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	parsed, err := ParseOptions(msg.Additional[0].RDATA)
+	if err != nil {
+		t.Fatalf("ParseOptions returned error: %v", err)
+	}
+	if len(parsed) != 1 || parsed[0].Code != OptionCode(65001) {
+		t.Fatalf("expected only the unknown option to be kept, got %v", parsed)
+	}
+	if int(msg.Additional[0].RDLENGTH) != len(msg.Additional[0].RDATA) {
+		t.Fatalf("RDLENGTH %d does not match RDATA length %d", msg.Additional[0].RDLENGTH, len(msg.Additional[0].RDATA))
+	}
+}
+
+func TestNilRegistryPassesEverything(t *testing.T) {
+	var r *Registry
+	if p := r.Policy(Cookie); p != Pass {
+		t.Fatalf("expected Pass from a nil registry, got %v", p)
+	}
+	if err := r.Apply(&Message.Message{}); err != nil {
+		t.Fatalf("Apply on nil registry returned error: %v", err)
+	}
+}
+
+func TestExtendedRCODE(t *testing.T) {
+	msg := Message.Message{}
+	if err := SetExtendedRCODE(&msg, BadVersion, 1232); err != nil {
+		t.Fatalf("SetExtendedRCODE returned error: %v", err)
+	}
+
+	if rcode := ExtendedRCODE(&msg); rcode != BadVersion {
+		t.Fatalf("expected extended RCODE %d, got %d", BadVersion, rcode)
+	}
+	if msg.Header.GetRCODE() != header.NoError {
+		t.Fatalf("expected the header to carry only the lower 4 bits of BADVERS, got %s", msg.Header.GetRCODE())
+	}
+	if size := msg.EDNSUDPSize(); size != 1232 {
+		t.Fatalf("expected advertised UDP size 1232, got %d", size)
+	}
+}
+
+func TestPackOptionsPreservesRDATA(t *testing.T) {
+	rdata := []byte{
+		0x00, 0x0A, 0x00, 0x08, 1, 2, 3, 4, 5, 6, 7, 8, // COOKIE with a client cookie
+		0x00, 0x0C, 0x00, 0x03, 0, 0, 0, // PADDING of 3 bytes
+	}
+
+	opts, err := ParseOptions(rdata)
+	if err != nil {
+		t.Fatalf("ParseOptions returned error: %v", err)
+	}
+	if len(opts) != 2 || opts[0].Code != Cookie || opts[1].Code != Padding {
+		t.Fatalf("expected COOKIE and PADDING options, got %v", opts)
+	}
+	packed, err := PackOptions(opts)
+	if err != nil {
+		t.Fatalf("PackOptions returned error: %v", err)
+	}
+	if !bytes.Equal(packed, rdata) {
+		t.Fatalf("expected RDATA %v, got %v", rdata, packed)
+	}
+}
+
+func TestFindAndSetOption(t *testing.T) {
+	opts := []Option{
+		{Code: Cookie, Data: []byte{1}},
+		{Code: Padding, Data: []byte{}},
+		{Code: Cookie, Data: []byte{2}},
+	}
+
+	if opt, ok := FindOption(opts, Cookie); !ok || !bytes.Equal(opt.Data, []byte{1}) {
+		t.Fatalf("expected the first COOKIE option, got %v, %v", opt, ok)
+	}
+	if _, ok := FindOption(opts, ExtendedError); ok {
+		t.Fatal("expected no EDE option")
+	}
+
+	opts = SetOption(opts, Option{Code: Cookie, Data: []byte{3}})
+	if len(opts) != 2 || opts[0].Code != Padding || opts[1].Code != Cookie || !bytes.Equal(opts[1].Data, []byte{3}) {
+		t.Fatalf("expected PADDING followed by the new COOKIE, got %v", opts)
+	}
+}
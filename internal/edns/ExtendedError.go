@@ -0,0 +1,52 @@
+package edns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+/*
+The data of an Extended DNS Error option (https://datatracker.ietf.org/doc/html/rfc8914#section-2) is:
+
+Field			Type				Description
+INFO-CODE		2-byte Integer		The cause of the error, full list https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml#extended-dns-error-codes
+EXTRA-TEXT		Variable			Optional UTF-8 text for humans, not NUL terminated.
+*/
+
+// Extended DNS Error info codes.
+const (
+	EDEOtherError       uint16 = 0
+	EDEStaleAnswer      uint16 = 3
+	EDEForgedAnswer     uint16 = 4
+	EDENotReady         uint16 = 14
+	EDEBlocked          uint16 = 15
+	EDECensored         uint16 = 16
+	EDEFiltered         uint16 = 17
+	EDEProhibited       uint16 = 18
+	EDENotAuthoritative uint16 = 20
+	EDENoReachableAuth  uint16 = 22
+	EDENetworkError     uint16 = 23
+	EDEInvalidData      uint16 = 24
+)
+
+var ErrMalformedExtendedError = errors.New("malformed EDE option")
+
+// ParseExtendedError parses the data of an Extended DNS Error option into its info code and extra text.
+func ParseExtendedError(data []byte) (infoCode uint16, extraText string, err error) {
+	if len(data) < 2 {
+		return 0, "", fmt.Errorf("%w: invalid length %d", ErrMalformedExtendedError, len(data))
+	}
+	text := data[2:]
+	if !utf8.Valid(text) {
+		return 0, "", fmt.Errorf("%w: extra text is not UTF-8", ErrMalformedExtendedError)
+	}
+	return binary.BigEndian.Uint16(data), string(text), nil
+}
+
+// ExtendedErrorOption builds an Extended DNS Error option with infoCode and extraText, which may be empty.
+func ExtendedErrorOption(infoCode uint16, extraText string) Option {
+	data := binary.BigEndian.AppendUint16(nil, infoCode)
+	return Option{Code: ExtendedError, Data: append(data, extraText...)}
+}
@@ -0,0 +1,40 @@
+package edns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+The data of an edns-tcp-keepalive option (https://datatracker.ietf.org/doc/html/rfc7828#section-3.1) is empty in
+queries, and in responses:
+
+Field			Type				Description
+TIMEOUT			2-byte Integer		Idle timeout of the TCP connection, in units of 100 milliseconds.
+*/
+
+// keepAliveUnit is the unit of the keepalive TIMEOUT field.
+const keepAliveUnit = 100 * time.Millisecond
+
+var ErrMalformedKeepAlive = errors.New("malformed KEEPALIVE option")
+
+// ParseKeepAlive parses the data of a KEEPALIVE option. hasTimeout is false for the empty option clients send.
+func ParseKeepAlive(data []byte) (timeout time.Duration, hasTimeout bool, err error) {
+	switch len(data) {
+	case 0:
+		return 0, false, nil
+	case 2:
+		return time.Duration(binary.BigEndian.Uint16(data)) * keepAliveUnit, true, nil
+	default:
+		return 0, false, fmt.Errorf("%w: invalid length %d", ErrMalformedKeepAlive, len(data))
+	}
+}
+
+// KeepAliveOption builds a KEEPALIVE option advertising timeout, rounded down to 100 milliseconds and capped at the
+// largest value the option can carry.
+func KeepAliveOption(timeout time.Duration) Option {
+	units := min(max(timeout/keepAliveUnit, 0), 0xFFFF)
+	return Option{Code: KeepAlive, Data: binary.BigEndian.AppendUint16(nil, uint16(units))}
+}
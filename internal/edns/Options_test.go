@@ -0,0 +1,92 @@
+package edns
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestClientSubnet(t *testing.T) {
+	tests := []struct {
+		prefix netip.Prefix
+		want   []byte
+	}{
+		{prefix: netip.MustParsePrefix("192.0.2.0/24"), want: []byte{0, 1, 24, 0, 192, 0, 2}},
+		{prefix: netip.MustParsePrefix("2001:db8::/32"), want: []byte{0, 2, 32, 0, 0x20, 0x01, 0x0d, 0xb8}},
+		{prefix: netip.MustParsePrefix("0.0.0.0/0"), want: []byte{0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		opt := ClientSubnetOption(tt.prefix, 0)
+		if opt.Code != ClientSubnet || string(opt.Data) != string(tt.want) {
+			t.Errorf("%s: expected data %v, got %s %v", tt.prefix, tt.want, opt.Code, opt.Data)
+			continue
+		}
+		prefix, scope, err := ParseClientSubnet(opt.Data)
+		if err != nil {
+			t.Errorf("%s: ParseClientSubnet returned error: %v", tt.prefix, err)
+			continue
+		}
+		if prefix != tt.prefix || scope != 0 {
+			t.Errorf("expected %s with scope 0, got %s with scope %d", tt.prefix, prefix, scope)
+		}
+	}
+
+	for _, data := range [][]byte{
+		{0, 1, 24},             // truncated
+		{0, 3, 0, 0},           // unknown family
+		{0, 1, 24, 0, 192, 0},  // address shorter than the source prefix
+		{0, 1, 33, 0, 1, 2, 3}, // source prefix longer than IPv4
+		{0, 1, 8, 0, 192, 1},   // address longer than the source prefix
+		{0, 1, 7, 0, 193},      // bits set beyond the source prefix
+	} {
+		if _, _, err := ParseClientSubnet(data); !errors.Is(err, ErrMalformedClientSubnet) {
+			t.Errorf("expected ErrMalformedClientSubnet for %v, got %v", data, err)
+		}
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	if _, hasTimeout, err := ParseKeepAlive(nil); err != nil || hasTimeout {
+		t.Fatalf("expected an empty option without timeout, got %v, %v", hasTimeout, err)
+	}
+
+	opt := KeepAliveOption(12*time.Second + 50*time.Millisecond)
+	timeout, hasTimeout, err := ParseKeepAlive(opt.Data)
+	if err != nil || !hasTimeout || timeout != 12*time.Second {
+		t.Fatalf("expected a timeout of 12s, got %s, %v, %v", timeout, hasTimeout, err)
+	}
+	if opt := KeepAliveOption(24 * time.Hour); string(opt.Data) != "\xff\xff" {
+		t.Fatalf("expected the timeout to be capped, got %v", opt.Data)
+	}
+	if _, _, err := ParseKeepAlive([]byte{1}); !errors.Is(err, ErrMalformedKeepAlive) {
+		t.Fatalf("expected ErrMalformedKeepAlive, got %v", err)
+	}
+}
+
+func TestPaddingOption(t *testing.T) {
+	opt := PaddingOption(5)
+	if opt.Code != Padding || string(opt.Data) != "\x00\x00\x00\x00\x00" {
+		t.Fatalf("expected 5 zero bytes of padding, got %s %v", opt.Code, opt.Data)
+	}
+	if opt := PaddingOption(-1); len(opt.Data) != 0 {
+		t.Fatalf("expected no padding for a negative length, got %v", opt.Data)
+	}
+}
+
+func TestExtendedError(t *testing.T) {
+	opt := ExtendedErrorOption(EDEBlocked, "blocked by policy")
+	infoCode, text, err := ParseExtendedError(opt.Data)
+	if err != nil {
+		t.Fatalf("ParseExtendedError returned error: %v", err)
+	}
+	if opt.Code != ExtendedError || infoCode != EDEBlocked || text != "blocked by policy" {
+		t.Fatalf("expected Blocked with its text, got %s %d %q", opt.Code, infoCode, text)
+	}
+
+	for _, data := range [][]byte{{0}, {0, 15, 0xff}} {
+		if _, _, err := ParseExtendedError(data); !errors.Is(err, ErrMalformedExtendedError) {
+			t.Errorf("expected ErrMalformedExtendedError for %v, got %v", data, err)
+		}
+	}
+}
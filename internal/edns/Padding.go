@@ -0,0 +1,7 @@
+package edns
+
+// PaddingOption builds a PADDING option of n zero bytes (https://datatracker.ietf.org/doc/html/rfc7830).
+// The padding option itself adds 4 more bytes of option header to the message.
+func PaddingOption(n int) Option {
+	return Option{Code: Padding, Data: make([]byte, max(n, 0))}
+}
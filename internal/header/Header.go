@@ -89,7 +89,10 @@ const (
 	Query  Opcode = iota // Standard query (QUERY)
 	IQuery               // Inverse query (IQUERY)
 	Status               // Server status request (STATUS)
-	// 3-15 reserved for future use
+	_                    // 3 is unassigned
+	Notify               // Zone change notification (NOTIFY), RFC 1996
+	Update               // Dynamic update (UPDATE), RFC 2136
+	// 6-15 reserved for future use
 )
 
 // ResponseCode represents a DNS response code (4 bits)
@@ -102,7 +105,12 @@ const (
 	NameError                          // Name error (domain doesn't exist)
 	NotImplemented                     // Not implemented
 	Refused                            // Operation refused
-	// 6-15 reserved for future use
+	YXDomain                           // Name exists when it should not (RFC 2136)
+	YXRRSet                            // RR set exists when it should not (RFC 2136)
+	NXRRSet                            // RR set that should exist does not (RFC 2136)
+	NotAuth                            // Server not authoritative for zone (RFC 2136)
+	NotZone                            // Name not contained in zone (RFC 2136)
+	// 11-15 reserved for future use
 )
 
 func (code ResponseCode) String() string {
@@ -119,7 +127,17 @@ func (code ResponseCode) String() string {
 		return "NotImplemented"
 	case Refused:
 		return "Refused"
-	case 6, 7, 8, 9, 10, 11, 12, 13, 14, 15:
+	case YXDomain:
+		return "YXDomain"
+	case YXRRSet:
+		return "YXRRSet"
+	case NXRRSet:
+		return "NXRRSet"
+	case NotAuth:
+		return "NotAuth"
+	case NotZone:
+		return "NotZone"
+	case 11, 12, 13, 14, 15:
 		return "ReservedForFutureUse"
 	default:
 		return "Unknown"
@@ -144,6 +162,12 @@ func (h *Header) GetMessageID() uint16 {
 	return binary.BigEndian.Uint16(h.ID[:])
 }
 
+// Clone returns a copy of the Header. The Header only holds arrays, so the copy shares no memory with h and can be
+// modified without affecting it.
+func (h *Header) Clone() Header {
+	return *h
+}
+
 // IsQuery returns true if the header represents a query
 func (h *Header) IsQuery() bool {
 	const QR_Mask byte = 0b10000000 // Mask for the QR bit
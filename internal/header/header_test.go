@@ -321,7 +321,12 @@ func TestResponseCode(t *testing.T) {
 		t.Fatalf("RCODE.String() should be 'Refused', got '%s'", h.GetRCODE().String())
 	}
 
-	h.SetRCODE(6)
+	h.SetRCODE(YXDomain)
+	if h.GetRCODE().String() != "YXDomain" {
+		t.Fatalf("RCODE.String() should be 'YXDomain', got '%s'", h.GetRCODE().String())
+	}
+
+	h.SetRCODE(11)
 	if h.GetRCODE().String() != "ReservedForFutureUse" {
 		t.Fatalf("RCODE.String() for reserved value should be 'ReservedForFutureUse', got '%s'", h.GetRCODE().String())
 	}
@@ -619,3 +624,26 @@ func TestCompleteHeaderWorkflow(t *testing.T) {
 		t.Fatal("QR bit not set in response data")
 	}
 }
+
+func TestClone(t *testing.T) {
+	h := Header{ID: [2]byte{0x12, 0x34}}
+	h.SetQRFlag(true)
+	h.SetRCODE(NameError)
+	if err := h.SetANCOUNT(2); err != nil {
+		t.Fatalf("SetANCOUNT returned error: %v", err)
+	}
+
+	clone := h.Clone()
+	if clone != h {
+		t.Fatalf("expected the clone to equal the original, got %+v", clone)
+	}
+
+	clone.ID = [2]byte{0xab, 0xcd}
+	clone.SetTC(true)
+	if err := clone.SetANCOUNT(0); err != nil {
+		t.Fatalf("SetANCOUNT returned error: %v", err)
+	}
+	if h.GetMessageID() != 0x1234 || h.IsTC() || h.GetANCOUNT() != 2 {
+		t.Fatalf("expected modifying the clone to leave the original unchanged, got %+v", h)
+	}
+}
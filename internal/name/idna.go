@@ -0,0 +1,228 @@
+package name
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Internationalized domain names are carried on the wire as A-labels, the ASCII compatible encoding of their Unicode
+labels with Punycode (https://datatracker.ietf.org/doc/html/rfc3492) behind the "xn--" prefix
+(https://datatracker.ietf.org/doc/html/rfc5890#section-2.3.2.1). ToASCII and ToUnicode convert between the two forms.
+Unicode labels are only lowercased before encoding, the full IDNA2008 mapping and validity rules are not applied.
+*/
+
+// ACEPrefix starts every A-label.
+const ACEPrefix = "xn--"
+
+// Punycode parameters (https://datatracker.ietf.org/doc/html/rfc3492#section-5).
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+	punycodeDelimiter   = '-'
+)
+
+var ErrInvalidPunycode = errors.New("invalid punycode")
+
+// ToASCII converts every label of name containing non-ASCII characters to its A-label. ASCII labels are left as they
+// are, so ToASCII can be applied to names which are already A-labels.
+func ToASCII(name string) (string, error) {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if !utf8.ValidString(label) {
+			return "", fmt.Errorf("label %q is not valid UTF-8", label)
+		}
+		labels[i] = ACEPrefix + encodePunycode([]rune(strings.ToLower(label)))
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// ToUnicode converts every A-label of name back to its Unicode label. Other labels are left as they are.
+func ToUnicode(name string) (string, error) {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if len(label) < len(ACEPrefix) || !strings.EqualFold(label[:len(ACEPrefix)], ACEPrefix) {
+			continue
+		}
+		decoded, err := decodePunycode(label[len(ACEPrefix):])
+		if err != nil {
+			return "", fmt.Errorf("label %q: %w", label, err)
+		}
+		labels[i] = decoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// encodePunycode encodes a label with the Punycode algorithm (https://datatracker.ietf.org/doc/html/rfc3492#section-6.3).
+func encodePunycode(input []rune) string {
+	var output strings.Builder
+	for _, r := range input {
+		if r < utf8.RuneSelf {
+			output.WriteRune(r)
+		}
+	}
+	basic := output.Len()
+	handled := basic
+	if basic > 0 {
+		output.WriteByte(punycodeDelimiter)
+	}
+
+	n, delta, bias := rune(punycodeInitialN), 0, punycodeInitialBias
+	for handled < len(input) {
+		next := rune(unicode.MaxRune)
+		for _, r := range input {
+			if r >= n && r < next {
+				next = r
+			}
+		}
+		delta += int(next-n) * (handled + 1)
+		n = next
+
+		for _, r := range input {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := punycodeThreshold(k, bias)
+				if q < t {
+					break
+				}
+				output.WriteByte(punycodeDigit(t + (q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			output.WriteByte(punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return output.String()
+}
+
+// decodePunycode decodes a label with the Punycode algorithm (https://datatracker.ietf.org/doc/html/rfc3492#section-6.2).
+func decodePunycode(input string) (string, error) {
+	var output []rune
+	pos := 0
+	if delimiter := strings.LastIndexByte(input, punycodeDelimiter); delimiter >= 0 {
+		for i := 0; i < delimiter; i++ {
+			if input[i] >= utf8.RuneSelf {
+				return "", ErrInvalidPunycode
+			}
+			output = append(output, rune(input[i]))
+		}
+		pos = delimiter + 1
+	}
+
+	n, i, bias := punycodeInitialN, 0, punycodeInitialBias
+	for pos < len(input) {
+		oldI, w := i, 1
+		for k := punycodeBase; ; k += punycodeBase {
+			if pos >= len(input) {
+				return "", ErrInvalidPunycode
+			}
+			digit, ok := punycodeDigitValue(input[pos])
+			pos++
+			if !ok || digit > (math.MaxInt32-i)/w {
+				return "", ErrInvalidPunycode
+			}
+			i += digit * w
+			t := punycodeThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			if w > math.MaxInt32/(punycodeBase-t) {
+				return "", ErrInvalidPunycode
+			}
+			w *= punycodeBase - t
+		}
+		length := len(output) + 1
+		bias = punycodeAdapt(i-oldI, length, oldI == 0)
+		n += i / length
+		i %= length
+		if n > unicode.MaxRune || n < punycodeInitialN {
+			return "", ErrInvalidPunycode
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return string(output), nil
+}
+
+// punycodeThreshold returns the threshold t for the digit at position k.
+func punycodeThreshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return punycodeTMin
+	case k >= bias+punycodeTMax:
+		return punycodeTMax
+	default:
+		return k - bias
+	}
+}
+
+// punycodeAdapt is the bias adaptation function (https://datatracker.ietf.org/doc/html/rfc3492#section-6.1).
+func punycodeAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+// punycodeDigit returns the lowercase character of a digit from 0 to 35.
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// punycodeDigitValue returns the value of a digit character, case-insensitively.
+func punycodeDigitValue(c byte) (int, bool) {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A'), true
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,55 @@
+package name
+
+import "strings"
+
+// Canonicalize returns name lowercased and without the trailing dot. The root domain, "." or "", becomes "".
+func Canonicalize(name string) string {
+	return ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Equal reports whether a and b are the same domain name. Only ASCII letters compare case-insensitively
+// (https://datatracker.ietf.org/doc/html/rfc4343#section-3), so the Kelvin sign does not match "k".
+func Equal(a, b string) bool {
+	a, b = strings.TrimSuffix(a, "."), strings.TrimSuffix(b, ".")
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if lowerASCII(a[i]) != lowerASCII(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ToLower returns name with its ASCII letters lowercased. Other bytes are left as they are, as DNS names are only
+// case-insensitive for ASCII (https://datatracker.ietf.org/doc/html/rfc4343#section-3).
+func ToLower(name string) string {
+	for i := 0; i < len(name); i++ {
+		if name[i] != lowerASCII(name[i]) {
+			lowered := []byte(name)
+			for j := i; j < len(lowered); j++ {
+				lowered[j] = lowerASCII(lowered[j])
+			}
+			return string(lowered)
+		}
+	}
+	return name
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// IsSubdomain reports whether child is parent or a name below it. Every name is a subdomain of the root.
+// Only whole labels match, "badexample.com" is not a subdomain of "example.com".
+func IsSubdomain(child, parent string) bool {
+	child, parent = Canonicalize(child), Canonicalize(parent)
+	if parent == "" {
+		return true
+	}
+	return child == parent || strings.HasSuffix(child, "."+parent)
+}
@@ -0,0 +1,132 @@
+package name
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "example.com", want: "example.com"},
+		{in: "Example.COM.", want: "example.com"},
+		{in: ".", want: ""},
+		{in: "", want: ""},
+		{in: "WWW.example.com", want: "www.example.com"},
+		{in: "\u212Aelvin.example", want: "\u212Aelvin.example"},
+		{in: "\u00C9COLE.example", want: "\u00C9cole.example"},
+	}
+	for _, tt := range tests {
+		if got := Canonicalize(tt.in); got != tt.want {
+			t.Errorf("Canonicalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "example.com", b: "example.com.", want: true},
+		{a: "Example.Com", b: "eXAMPLE.cOM.", want: true},
+		{a: ".", b: "", want: true},
+		{a: ".", b: ".", want: true},
+		{a: "example.com", b: "www.example.com", want: false},
+		{a: "example.com", b: "example.org", want: false},
+		{a: "example.com", b: ".", want: false},
+		{a: "\u212A.example", b: "k.example", want: false},
+		{a: "\u00E9.example", b: "\u00C9.example", want: false},
+	}
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.want {
+			t.Errorf("Equal(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIsSubdomain(t *testing.T) {
+	tests := []struct {
+		child, parent string
+		want          bool
+	}{
+		{child: "www.example.com", parent: "example.com", want: true},
+		{child: "example.com", parent: "example.com.", want: true},
+		{child: "WWW.Example.COM.", parent: "example.com", want: true},
+		{child: "a.b.example.com", parent: "Example.Com.", want: true},
+		{child: "example.com", parent: ".", want: true},
+		{child: ".", parent: "", want: true},
+		{child: "badexample.com", parent: "example.com", want: false},
+		{child: "example.com", parent: "www.example.com", want: false},
+		{child: ".", parent: "com", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsSubdomain(tt.child, tt.parent); got != tt.want {
+			t.Errorf("IsSubdomain(%q, %q) = %v, want %v", tt.child, tt.parent, got, tt.want)
+		}
+	}
+}
+
+func TestReverseAddress(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{in: "1.2.0.192.in-addr.arpa", want: "192.0.2.1", wantOK: true},
+		{in: "1.2.0.192.IN-ADDR.ARPA.", want: "192.0.2.1", wantOK: true},
+		{in: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", want: "2001:db8::1", wantOK: true},
+		{in: "2.0.192.in-addr.arpa"},
+		{in: "256.2.0.192.in-addr.arpa"},
+		{in: "01.2.0.192.in-addr.arpa"},
+		{in: "10.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+		{in: "g.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+		{in: "www.example.com"},
+	}
+	for _, tt := range tests {
+		got, ok := ReverseAddress(tt.in)
+		if ok != tt.wantOK {
+			t.Errorf("ReverseAddress(%q) ok = %v, want %v", tt.in, ok, tt.wantOK)
+			continue
+		}
+		if ok && !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("ReverseAddress(%q) = %v, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIDNA(t *testing.T) {
+	tests := []struct {
+		unicode string
+		ascii   string
+	}{
+		{unicode: "café.example", ascii: "xn--caf-dma.example"},
+		{unicode: "münchen.de", ascii: "xn--mnchen-3ya.de"},
+		{unicode: "bücher.example", ascii: "xn--bcher-kva.example"},
+		{unicode: "例え.テスト", ascii: "xn--r8jz45g.xn--zckzah"},
+		{unicode: "www.example.com", ascii: "www.example.com"},
+	}
+	for _, tt := range tests {
+		ascii, err := ToASCII(tt.unicode)
+		if err != nil || ascii != tt.ascii {
+			t.Errorf("ToASCII(%q) = %q, %v, want %q", tt.unicode, ascii, err, tt.ascii)
+		}
+		unicode, err := ToUnicode(tt.ascii)
+		if err != nil || unicode != tt.unicode {
+			t.Errorf("ToUnicode(%q) = %q, %v, want %q", tt.ascii, unicode, err, tt.unicode)
+		}
+	}
+
+	if ascii, err := ToASCII("CAFÉ.Example"); err != nil || ascii != "xn--caf-dma.Example" {
+		t.Errorf("expected Unicode labels to be lowercased before encoding, got %q, %v", ascii, err)
+	}
+	if _, err := ToUnicode("xn--caf-dm!.example"); !errors.Is(err, ErrInvalidPunycode) {
+		t.Errorf("expected ErrInvalidPunycode, got %v", err)
+	}
+	if _, err := ToUnicode("xn--99999999999.example"); !errors.Is(err, ErrInvalidPunycode) {
+		t.Errorf("expected ErrInvalidPunycode for an out of range code point, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package name
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	ipv4ReverseZone = "in-addr.arpa"
+	ipv6ReverseZone = "ip6.arpa"
+)
+
+// ReverseAddress returns the address a reverse lookup name under in-addr.arpa
+// (https://datatracker.ietf.org/doc/html/rfc1035#section-3.5) or ip6.arpa
+// (https://datatracker.ietf.org/doc/html/rfc3596#section-2.5) points to. It returns false for other names and for
+// names which don't spell out a complete address.
+func ReverseAddress(owner string) (net.IP, bool) {
+	owner = Canonicalize(owner)
+	if labels, found := strings.CutSuffix(owner, "."+ipv4ReverseZone); found {
+		return reverseIPv4(strings.Split(labels, "."))
+	}
+	if labels, found := strings.CutSuffix(owner, "."+ipv6ReverseZone); found {
+		return reverseIPv6(strings.Split(labels, "."))
+	}
+	return nil, false
+}
+
+// reverseIPv4 parses the four decimal octets of an in-addr.arpa name, least significant first.
+func reverseIPv4(labels []string) (net.IP, bool) {
+	if len(labels) != net.IPv4len {
+		return nil, false
+	}
+	ip := make(net.IP, net.IPv4len)
+	for i, label := range labels {
+		if len(label) > 1 && label[0] == '0' {
+			return nil, false
+		}
+		octet, err := strconv.ParseUint(label, 10, 8)
+		if err != nil {
+			return nil, false
+		}
+		ip[net.IPv4len-1-i] = byte(octet)
+	}
+	return ip.To16(), true
+}
+
+// reverseIPv6 parses the 32 hexadecimal nibbles of an ip6.arpa name, least significant first.
+func reverseIPv6(labels []string) (net.IP, bool) {
+	const nibblesPerByte = 2
+
+	if len(labels) != net.IPv6len*nibblesPerByte {
+		return nil, false
+	}
+	ip := make(net.IP, net.IPv6len)
+	for i, label := range labels {
+		if len(label) != 1 {
+			return nil, false
+		}
+		nibble, err := strconv.ParseUint(label, 16, 4)
+		if err != nil {
+			return nil, false
+		}
+		pos := len(labels) - 1 - i
+		if pos%nibblesPerByte == 0 {
+			nibble <<= 4
+		}
+		ip[pos/nibblesPerByte] |= byte(nibble)
+	}
+	return ip, true
+}
@@ -58,19 +58,24 @@ func (q *Question) SetClass(class DNS_Class.Class) {
 
 // MarshalBinary the Question into a byte slice.
 func (q *Question) MarshalBinary() ([]byte, error) {
+	return q.AppendBinary(make([]byte, 0))
+}
+
+// AppendBinary appends the marshalled Question to packet, which holds the message marshalled so far.
+// The name is compressed against names already in packet.
+// It fulfills the encoding.BinaryAppender interface.
+func (q *Question) AppendBinary(packet []byte) ([]byte, error) {
 	const byteSizeUintSixteen int = 2
 	const twoUintSixteens int = byteSizeUintSixteen * 2
 
-	buf := make([]byte, 0)
-
-	nameBytes, err := utils.MarshalName(q.Name, buf, 0)
+	nameBytes, err := utils.MarshalName(q.Name, packet, len(packet))
 	if err != nil {
 		return nil, err
 	}
-	buf = append(buf, nameBytes...)
+	buf := append(packet, nameBytes...)
 
 	buf = append(buf, make([]byte, twoUintSixteens)...)
-	nbl := len(nameBytes)
+	nbl := len(packet) + len(nameBytes)
 
 	binary.BigEndian.PutUint16(buf[nbl:nbl+byteSizeUintSixteen], uint16(q.Type))
 	binary.BigEndian.PutUint16(buf[nbl+byteSizeUintSixteen:nbl+twoUintSixteens], uint16(q.Class))
@@ -78,6 +83,12 @@ func (q *Question) MarshalBinary() ([]byte, error) {
 	return buf, nil
 }
 
+// WireSize returns the number of bytes the Question takes on the wire without name compression.
+func (q *Question) WireSize() int {
+	const typeClassSize int = 4
+	return utils.NameWireSize(q.Name) + typeClassSize
+}
+
 // Unmarshal parses a DNS question from raw binary data
 func Unmarshal(data []byte, fullPacket []byte) (Question, int, error) {
 	const typeAndClassBytes int = 4
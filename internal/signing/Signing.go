@@ -0,0 +1,164 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Response signing lets clients inside a trusted deployment verify that a response was produced by a server holding
+a shared secret. It is a lightweight, TSIG-like scheme (https://datatracker.ietf.org/doc/html/rfc2845):
+
+  - The response is marshalled without the signature record.
+  - An HMAC-SHA256 is computed over the marshalled bytes followed by the 8-byte big-endian signing time.
+  - The signing time and MAC are attached as the last record of the Additional section, a TXT record owned by
+    SignatureRecordName with the text "t=<unix seconds>;mac=<base64 MAC>".
+
+Verification strips the signature record, re-marshals the message and compares MACs in constant time.
+*/
+
+// SignatureRecordName is the owner name of the TXT record carrying the response signature.
+const SignatureRecordName = "_signature.dns-server"
+
+// DefaultFudge is the default allowed difference between the signing time and the verifier's clock.
+const DefaultFudge = 5 * time.Minute
+
+var (
+	ErrEmptyKey         = errors.New("signing key cannot be empty")
+	ErrMissingSignature = errors.New("message carries no signature record")
+	ErrBadSignature     = errors.New("message signature does not match")
+	ErrSignatureExpired = errors.New("message signature time is outside the allowed window")
+)
+
+// Sign computes a signature over msg with key and appends it as the last record of the Additional section.
+// The Header.ARCOUNT is updated accordingly.
+func Sign(msg *Message.Message, key []byte, now time.Time) error {
+	if msg == nil {
+		return errors.New("sign got nil message")
+	}
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+
+	signedAt := now.Unix()
+	mac, err := computeMAC(msg, key, signedAt)
+	if err != nil {
+		return err
+	}
+
+	sig := RR.RR{}
+	sig.SetName(SignatureRecordName)
+	sig.SetClass(DNS_Class.IN)
+	sig.SetRDATAToTXTRecord(fmt.Sprintf("t=%d;mac=%s", signedAt, base64.StdEncoding.EncodeToString(mac)))
+
+	msg.Additional = append(msg.Additional, sig)
+	return msg.Header.SetARCOUNT(len(msg.Additional))
+}
+
+// Verify checks the signature attached to msg by Sign using key.
+// The signing time must be within fudge of now. The msg itself is not modified.
+func Verify(msg *Message.Message, key []byte, now time.Time, fudge time.Duration) error {
+	if msg == nil {
+		return errors.New("verify got nil message")
+	}
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if len(msg.Additional) == 0 {
+		return ErrMissingSignature
+	}
+
+	sig := msg.Additional[len(msg.Additional)-1]
+	if sig.Type != DNS_Type.TXT || sig.GetName() != SignatureRecordName {
+		return ErrMissingSignature
+	}
+
+	text, err := sig.GetRDATAAsTXTRecord()
+	if err != nil {
+		return fmt.Errorf("failed to read signature record: %w", err)
+	}
+	signedAt, mac, err := parseSignature(text)
+	if err != nil {
+		return err
+	}
+
+	delta := now.Sub(time.Unix(signedAt, 0))
+	if delta > fudge || delta < -fudge {
+		return ErrSignatureExpired
+	}
+
+	unsigned := *msg
+	unsigned.Additional = msg.Additional[:len(msg.Additional)-1]
+	if err := unsigned.Header.SetARCOUNT(len(unsigned.Additional)); err != nil {
+		return err
+	}
+
+	expected, err := computeMAC(&unsigned, key, signedAt)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, expected) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// computeMAC computes the HMAC-SHA256 over the marshalled msg followed by the signing time.
+func computeMAC(msg *Message.Message, key []byte, signedAt int64) ([]byte, error) {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message for signing: %w", err)
+	}
+
+	var timeBytes [8]byte
+	binary.BigEndian.PutUint64(timeBytes[:], uint64(signedAt)) //nolint:gosec
+
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	h.Write(timeBytes[:])
+	return h.Sum(nil), nil
+}
+
+// parseSignature parses the "t=<unix seconds>;mac=<base64 MAC>" text of a signature record.
+func parseSignature(text string) (int64, []byte, error) {
+	var signedAt int64
+	var mac []byte
+	var haveTime, haveMAC bool
+
+	for _, field := range strings.Split(text, ";") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return 0, nil, fmt.Errorf("malformed signature field %q", field)
+		}
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, nil, fmt.Errorf("malformed signature time: %w", err)
+			}
+			signedAt, haveTime = t, true
+		case "mac":
+			m, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return 0, nil, fmt.Errorf("malformed signature MAC: %w", err)
+			}
+			mac, haveMAC = m, true
+		}
+	}
+
+	if !haveTime || !haveMAC {
+		return 0, nil, ErrMissingSignature
+	}
+	return signedAt, mac, nil
+}
@@ -0,0 +1,118 @@
+package signing
+
+import (
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"net"
+	"testing"
+	"time"
+)
+
+func createSignedResponse(t *testing.T, key []byte, now time.Time) Message.Message {
+	t.Helper()
+	msg, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	msg.Header.SetQRFlag(true)
+
+	answer := RR.RR{}
+	answer.SetName("example.com")
+	answer.SetClass(DNS_Class.IN)
+	if err := answer.SetTTL(300); err != nil {
+		t.Fatalf("failed to set TTL: %v", err)
+	}
+	if err := answer.SetRDATAToARecord(net.ParseIP("93.184.216.34")); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	msg.Answers = append(msg.Answers, answer)
+	if err := msg.Header.SetANCOUNT(len(msg.Answers)); err != nil {
+		t.Fatalf("failed to set ANCOUNT: %v", err)
+	}
+
+	if err := Sign(&msg, key, now); err != nil {
+		t.Fatalf("Sign returned error: %v", err)
+	}
+	return msg
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key := []byte("shared-secret")
+	now := time.Unix(1700000000, 0)
+
+	msg := createSignedResponse(t, key, now)
+
+	if msg.Header.GetARCOUNT() != 1 || len(msg.Additional) != 1 {
+		t.Fatalf("expected signature record in Additional, ARCOUNT %d, records %d",
+			msg.Header.GetARCOUNT(), len(msg.Additional))
+	}
+	if msg.Additional[0].GetName() != SignatureRecordName || msg.Additional[0].Type != DNS_Type.TXT {
+		t.Fatalf("unexpected signature record: %+v", msg.Additional[0])
+	}
+
+	// Verify the message as a client would see it, after a trip over the wire.
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal signed message: %v", err)
+	}
+	received, err := Message.New(data)
+	if err != nil {
+		t.Fatalf("failed to unmarshal signed message: %v", err)
+	}
+
+	if err := Verify(&received, key, now.Add(time.Second), DefaultFudge); err != nil {
+		t.Fatalf("Verify returned error for valid signature: %v", err)
+	}
+	if received.Header.GetARCOUNT() != 1 {
+		t.Fatalf("Verify must not modify the message, ARCOUNT is %d", received.Header.GetARCOUNT())
+	}
+}
+
+func TestVerifyFailures(t *testing.T) {
+	key := []byte("shared-secret")
+	now := time.Unix(1700000000, 0)
+
+	t.Run("Wrong key", func(t *testing.T) {
+		msg := createSignedResponse(t, key, now)
+		if err := Verify(&msg, []byte("other-secret"), now, DefaultFudge); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("expected ErrBadSignature, got %v", err)
+		}
+	})
+
+	t.Run("Tampered answer", func(t *testing.T) {
+		msg := createSignedResponse(t, key, now)
+		if err := msg.Answers[0].SetRDATAToARecord(net.ParseIP("10.0.0.1")); err != nil {
+			t.Errorf("failed to set A record: %v", err)
+		}
+		if err := Verify(&msg, key, now, DefaultFudge); !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("expected ErrBadSignature, got %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		msg := createSignedResponse(t, key, now)
+		if err := Verify(&msg, key, now.Add(DefaultFudge+time.Second), DefaultFudge); !errors.Is(err, ErrSignatureExpired) {
+			t.Fatalf("expected ErrSignatureExpired, got %v", err)
+		}
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		msg, err := Message.CreateDNSQuery("example.com", DNS_Type.A, DNS_Class.IN, true)
+		if err != nil {
+			t.Fatalf("failed to create query: %v", err)
+		}
+		if err := Verify(&msg, key, now, DefaultFudge); !errors.Is(err, ErrMissingSignature) {
+			t.Fatalf("expected ErrMissingSignature, got %v", err)
+		}
+	})
+
+	t.Run("Empty key", func(t *testing.T) {
+		msg := createSignedResponse(t, key, now)
+		if err := Sign(&msg, nil, now); !errors.Is(err, ErrEmptyKey) {
+			t.Fatalf("expected ErrEmptyKey, got %v", err)
+		}
+	})
+}
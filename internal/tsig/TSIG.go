@@ -0,0 +1,350 @@
+package tsig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"github.com/blazskufca/dns_server_in_go/internal/question"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
+	"hash"
+	"time"
+)
+
+/*
+TSIG (https://datatracker.ietf.org/doc/html/rfc2845) authenticates a DNS message with a shared secret.
+The signature is carried in a TSIG meta record which MUST be the last record of the Additional section.
+
+TSIG RDATA has the following structure:
+
+Field				Type					Description
+Algorithm Name		Domain name				Name of the MAC algorithm, e.g. hmac-sha256
+Time Signed			6-byte Integer			Seconds since the epoch when the message was signed
+Fudge				2-byte Integer			Seconds of error permitted in Time Signed
+MAC Size			2-byte Integer			Number of bytes in MAC
+MAC					Variable				The MAC itself
+Original ID			2-byte Integer			Message ID before any forwarding changed it
+Error				2-byte Integer			Extended RCODE covering TSIG processing
+Other Len			2-byte Integer			Length of Other Data
+Other Data			Variable				Empty unless Error == BADTIME
+
+The MAC is computed over (the request MAC for responses), the message without the TSIG record (with ARCOUNT
+decremented and the original ID restored) and the TSIG variables (key name, class, TTL, algorithm name, time signed,
+fudge, error, other len, other data), as described in https://datatracker.ietf.org/doc/html/rfc2845#section-3.4
+*/
+
+const (
+	// HmacSHA256 is the algorithm name of HMAC-SHA256
+	HmacSHA256 = "hmac-sha256"
+	// HmacSHA512 is the algorithm name of HMAC-SHA512
+	HmacSHA512 = "hmac-sha512"
+
+	// DefaultFudge is the fudge, in seconds, put into signatures created by Sign
+	DefaultFudge uint16 = 300
+)
+
+// Extended TSIG error codes carried in the TSIG Error field
+const (
+	BadSig  uint16 = 16 // TSIG signature failure
+	BadKey  uint16 = 17 // Key not recognized
+	BadTime uint16 = 18 // Signature out of time window
+)
+
+var (
+	ErrNoTSIG     = errors.New("message does not carry a TSIG record")
+	ErrBadKey     = errors.New("TSIG key not recognized")
+	ErrBadSig     = errors.New("TSIG signature failure")
+	ErrBadTime    = errors.New("TSIG signature out of time window")
+	ErrBadMessage = errors.New("message is malformed")
+)
+
+// Key is a named shared secret used to sign and verify messages.
+type Key struct {
+	// Name is the key name, which becomes the owner name of the TSIG record
+	Name string
+	// Algorithm is one of HmacSHA256 or HmacSHA512
+	Algorithm string
+	Secret    []byte
+}
+
+// record is the parsed content of a TSIG record.
+type record struct {
+	keyName    string
+	algorithm  string
+	mac        []byte
+	other      []byte
+	timeSigned uint64
+	fudge      uint16
+	originalID uint16
+	errorCode  uint16
+}
+
+// Sign appends a TSIG record to the wire format DNS message data and returns the signed message and its MAC.
+// When signing a response, requestMAC must be the MAC of the request, otherwise it should be nil.
+func Sign(data []byte, key Key, requestMAC []byte, now time.Time) ([]byte, []byte, error) {
+	const headerSize int = 12
+
+	if len(data) < headerSize {
+		return nil, nil, ErrBadMessage
+	}
+	newHash, err := hashFor(key.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rec := record{
+		keyName:    key.Name,
+		algorithm:  key.Algorithm,
+		timeSigned: uint64(now.Unix()), //nolint:gosec
+		fudge:      DefaultFudge,
+		originalID: binary.BigEndian.Uint16(data[0:2]),
+	}
+
+	mac, err := computeMAC(newHash, key.Secret, requestMAC, data, rec)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec.mac = mac
+
+	rr, err := rec.marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	arcount := int(binary.BigEndian.Uint16(data[10:12])) + 1
+	if utils.WouldOverflowUint16(arcount) {
+		return nil, nil, fmt.Errorf("arcount with value %d would overflow uint16", arcount)
+	}
+
+	signed := make([]byte, 0, len(data)+len(rr))
+	signed = append(signed, data...)
+	signed = append(signed, rr...)
+	binary.BigEndian.PutUint16(signed[10:12], uint16(arcount))
+
+	return signed, mac, nil
+}
+
+// Verify checks the TSIG record at the end of the wire format DNS message data against key.
+// When verifying a response, requestMAC must be the MAC of the request that was sent, otherwise it should be nil.
+// On success the MAC of the verified message is returned, which is needed to sign the response to it.
+func Verify(data []byte, key Key, requestMAC []byte, now time.Time) ([]byte, error) {
+	offset, err := tsigOffset(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := parseRecord(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if !name.Equal(rec.keyName, key.Name) || !name.Equal(rec.algorithm, key.Algorithm) {
+		return nil, ErrBadKey
+	}
+	newHash, err := hashFor(key.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	unsigned := make([]byte, offset)
+	copy(unsigned, data[:offset])
+	binary.BigEndian.PutUint16(unsigned[0:2], rec.originalID)
+	binary.BigEndian.PutUint16(unsigned[10:12], binary.BigEndian.Uint16(unsigned[10:12])-1)
+
+	expected, err := computeMAC(newHash, key.Secret, requestMAC, unsigned, rec)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(expected, rec.mac) {
+		return nil, ErrBadSig
+	}
+
+	signedAt := int64(rec.timeSigned) //nolint:gosec
+	delta := now.Unix() - signedAt
+	if delta > int64(rec.fudge) || delta < -int64(rec.fudge) {
+		return nil, ErrBadTime
+	}
+
+	return rec.mac, nil
+}
+
+// HasTSIG reports whether the last record of the wire format DNS message data is a TSIG record.
+func HasTSIG(data []byte) bool {
+	offset, err := tsigOffset(data)
+	if err != nil {
+		return false
+	}
+	_, err = parseRecord(data, offset)
+	return err == nil
+}
+
+// tsigOffset walks the message and returns the offset of its last record, where the TSIG record must be.
+func tsigOffset(data []byte) (int, error) {
+	const headerSize int = 12
+
+	if len(data) < headerSize {
+		return 0, ErrBadMessage
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(data[4:6]))
+	rrcount := int(binary.BigEndian.Uint16(data[6:8])) +
+		int(binary.BigEndian.Uint16(data[8:10])) +
+		int(binary.BigEndian.Uint16(data[10:12]))
+	if binary.BigEndian.Uint16(data[10:12]) == 0 {
+		return 0, ErrNoTSIG
+	}
+
+	offset := headerSize
+	for i := 0; i < qdcount; i++ {
+		_, n, err := question.Unmarshal(data[offset:], data)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrBadMessage, err)
+		}
+		offset += n
+	}
+	for i := 0; i < rrcount-1; i++ {
+		_, n, err := RR.Unmarshal(data[offset:], data)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrBadMessage, err)
+		}
+		offset += n
+	}
+	return offset, nil
+}
+
+// parseRecord parses the TSIG record which starts at offset and must end the message.
+func parseRecord(data []byte, offset int) (record, error) {
+	rr, n, err := RR.Unmarshal(data[offset:], data)
+	if err != nil {
+		return record{}, fmt.Errorf("%w: %w", ErrBadMessage, err)
+	}
+	if rr.Type != DNS_Type.TSIG {
+		return record{}, ErrNoTSIG
+	}
+	if offset+n != len(data) {
+		return record{}, fmt.Errorf("%w: TSIG record is not the last record", ErrBadMessage)
+	}
+
+	rdata := rr.GetRDATA()
+	rec := record{keyName: rr.GetName()}
+
+	alg, read, err := utils.UnmarshalName(rdata, 0, rdata)
+	if err != nil {
+		return record{}, fmt.Errorf("%w: bad algorithm name: %w", ErrBadMessage, err)
+	}
+	rec.algorithm = alg
+	rest := rdata[read:]
+
+	const fixedBeforeMAC int = 6 + 2 + 2 // time signed + fudge + MAC size
+	if len(rest) < fixedBeforeMAC {
+		return record{}, fmt.Errorf("%w: TSIG record too short", ErrBadMessage)
+	}
+	rec.timeSigned = uint64(binary.BigEndian.Uint16(rest[0:2]))<<32 | uint64(binary.BigEndian.Uint32(rest[2:6]))
+	rec.fudge = binary.BigEndian.Uint16(rest[6:8])
+	macSize := int(binary.BigEndian.Uint16(rest[8:10]))
+	rest = rest[fixedBeforeMAC:]
+
+	const fixedAfterMAC int = 2 + 2 + 2 // original ID + error + other len
+	if len(rest) < macSize+fixedAfterMAC {
+		return record{}, fmt.Errorf("%w: TSIG MAC exceeds record", ErrBadMessage)
+	}
+	rec.mac = rest[:macSize]
+	rest = rest[macSize:]
+
+	rec.originalID = binary.BigEndian.Uint16(rest[0:2])
+	rec.errorCode = binary.BigEndian.Uint16(rest[2:4])
+	otherLen := int(binary.BigEndian.Uint16(rest[4:6]))
+	rest = rest[fixedAfterMAC:]
+	if len(rest) != otherLen {
+		return record{}, fmt.Errorf("%w: TSIG other data length mismatch", ErrBadMessage)
+	}
+	rec.other = rest
+
+	return rec, nil
+}
+
+// marshal encodes the record into a complete TSIG RR in wire format.
+func (rec record) marshal() ([]byte, error) {
+	alg, err := utils.EncodeDomainNameToLabel(name.Canonicalize(rec.algorithm))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG algorithm name: %w", err)
+	}
+
+	rdata := append([]byte{}, alg...)
+	rdata = appendUint48(rdata, rec.timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, rec.fudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(rec.mac))) //nolint:gosec
+	rdata = append(rdata, rec.mac...)
+	rdata = binary.BigEndian.AppendUint16(rdata, rec.originalID)
+	rdata = binary.BigEndian.AppendUint16(rdata, rec.errorCode)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(rec.other))) //nolint:gosec
+	rdata = append(rdata, rec.other...)
+
+	rr := RR.RR{}
+	rr.SetName(name.Canonicalize(rec.keyName))
+	rr.SetType(DNS_Type.TSIG)
+	rr.SetClass(DNS_Class.ANY)
+	rr.SetRDATA(rdata)
+	return rr.MarshalBinary()
+}
+
+// computeMAC computes the TSIG MAC over the unsigned message and the TSIG variables of rec.
+func computeMAC(newHash func() hash.Hash, secret, requestMAC, unsigned []byte, rec record) ([]byte, error) {
+	keyName, err := utils.EncodeDomainNameToLabel(name.Canonicalize(rec.keyName))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG key name: %w", err)
+	}
+	alg, err := utils.EncodeDomainNameToLabel(name.Canonicalize(rec.algorithm))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG algorithm name: %w", err)
+	}
+
+	h := hmac.New(newHash, secret)
+	if requestMAC != nil {
+		h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC)))) //nolint:gosec
+		h.Write(requestMAC)
+	}
+	h.Write(unsigned)
+
+	vars := append([]byte{}, keyName...)
+	vars = binary.BigEndian.AppendUint16(vars, uint16(DNS_Class.ANY))
+	vars = binary.BigEndian.AppendUint32(vars, 0) // TTL
+	vars = append(vars, alg...)
+	vars = appendUint48(vars, rec.timeSigned)
+	vars = binary.BigEndian.AppendUint16(vars, rec.fudge)
+	vars = binary.BigEndian.AppendUint16(vars, rec.errorCode)
+	vars = binary.BigEndian.AppendUint16(vars, uint16(len(rec.other))) //nolint:gosec
+	vars = append(vars, rec.other...)
+	h.Write(vars)
+
+	return h.Sum(nil), nil
+}
+
+// hashFor returns the hash constructor of the named algorithm.
+func hashFor(algorithm string) (func() hash.Hash, error) {
+	switch name.Canonicalize(algorithm) {
+	case HmacSHA256:
+		return sha256.New, nil
+	case HmacSHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrBadKey, algorithm)
+	}
+}
+
+// appendUint48 appends the lower 48 bits of value in network byte order.
+func appendUint48(data []byte, value uint64) []byte {
+	return append(data,
+		byte(value>>40),
+		byte(value>>32),
+		byte(value>>24),
+		byte(value>>16),
+		byte(value>>8),
+		byte(value))
+}
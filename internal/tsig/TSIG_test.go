@@ -0,0 +1,121 @@
+package tsig
+
+import (
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"testing"
+	"time"
+)
+
+func createAXFRRequest(t *testing.T) []byte {
+	t.Helper()
+	query, err := Message.CreateDNSQuery("example.com", DNS_Type.AXFR, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create AXFR query: %v", err)
+	}
+	data, err := query.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal AXFR query: %v", err)
+	}
+	return data
+}
+
+func TestSignedAXFRRequest(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	key := Key{Name: "transfer-key.", Algorithm: HmacSHA256, Secret: []byte("super-secret")}
+
+	signed, requestMAC, err := Sign(createAXFRRequest(t), key, nil, now)
+	if err != nil {
+		t.Fatalf("Sign returned error: %v", err)
+	}
+	if !HasTSIG(signed) {
+		t.Fatalf("signed message does not carry a TSIG record")
+	}
+
+	msg, err := Message.New(signed)
+	if err != nil {
+		t.Fatalf("signed message does not unmarshal: %v", err)
+	}
+	if msg.Header.GetARCOUNT() != 1 || msg.Additional[0].Type != DNS_Type.TSIG {
+		t.Fatalf("expected TSIG record in Additional, got %+v", msg.Additional)
+	}
+
+	t.Run("Valid key is accepted", func(t *testing.T) {
+		mac, err := Verify(signed, key, nil, now.Add(10*time.Second))
+		if err != nil {
+			t.Fatalf("Verify returned error for valid key: %v", err)
+		}
+		if string(mac) != string(requestMAC) {
+			t.Fatalf("Verify returned a different MAC than Sign")
+		}
+	})
+
+	t.Run("Wrong secret is rejected", func(t *testing.T) {
+		wrong := key
+		wrong.Secret = []byte("wrong-secret")
+		if _, err := Verify(signed, wrong, nil, now); !errors.Is(err, ErrBadSig) {
+			t.Fatalf("expected ErrBadSig, got %v", err)
+		}
+	})
+
+	t.Run("Unknown key name is rejected", func(t *testing.T) {
+		wrong := key
+		wrong.Name = "other-key"
+		if _, err := Verify(signed, wrong, nil, now); !errors.Is(err, ErrBadKey) {
+			t.Fatalf("expected ErrBadKey, got %v", err)
+		}
+	})
+
+	t.Run("Outside of time window is rejected", func(t *testing.T) {
+		late := now.Add(time.Duration(DefaultFudge+1) * time.Second)
+		if _, err := Verify(signed, key, nil, late); !errors.Is(err, ErrBadTime) {
+			t.Fatalf("expected ErrBadTime, got %v", err)
+		}
+	})
+
+	t.Run("Tampered message is rejected", func(t *testing.T) {
+		tampered := append([]byte{}, signed...)
+		tampered[2] ^= 0b00000001 // Flip RD
+		if _, err := Verify(tampered, key, nil, now); !errors.Is(err, ErrBadSig) {
+			t.Fatalf("expected ErrBadSig, got %v", err)
+		}
+	})
+
+	t.Run("Unsigned message is rejected", func(t *testing.T) {
+		if _, err := Verify(createAXFRRequest(t), key, nil, now); !errors.Is(err, ErrNoTSIG) {
+			t.Fatalf("expected ErrNoTSIG, got %v", err)
+		}
+	})
+
+	t.Run("Response is signed with the request MAC", func(t *testing.T) {
+		msg.Header.SetQRFlag(true)
+		msg.Additional = nil
+		if err := msg.Header.SetARCOUNT(0); err != nil {
+			t.Fatalf("failed to set ARCOUNT: %v", err)
+		}
+		respData, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal response: %v", err)
+		}
+
+		signedResp, _, err := Sign(respData, key, requestMAC, now)
+		if err != nil {
+			t.Fatalf("Sign returned error for response: %v", err)
+		}
+		if _, err := Verify(signedResp, key, requestMAC, now); err != nil {
+			t.Fatalf("Verify returned error for response: %v", err)
+		}
+		if _, err := Verify(signedResp, key, nil, now); !errors.Is(err, ErrBadSig) {
+			t.Fatalf("expected ErrBadSig when the request MAC is missing, got %v", err)
+		}
+	})
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	key := Key{Name: "key", Algorithm: "hmac-md5", Secret: []byte("secret")}
+	if _, _, err := Sign(createAXFRRequest(t), key, nil, time.Now()); !errors.Is(err, ErrBadKey) {
+		t.Fatalf("expected ErrBadKey for unsupported algorithm, got %v", err)
+	}
+}
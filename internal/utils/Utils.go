@@ -20,8 +20,27 @@ var (
 	ErrLabelTooLong      = errors.New("label exceeds maximum length of 63 bytes")
 	ErrDomainNameTooLong = errors.New("domain name exceeds maximum length of 255 bytes")
 	ErrEmptyDomainName   = errors.New("domain name cannot be empty")
+	ErrForwardPointer    = errors.New("compression pointer does not point backward")
 )
 
+// ParseError is an error in a configuration or zone file, with the position it was found at.
+type ParseError struct {
+	Err  error
+	File string
+	Line int
+}
+
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // EncodeDomainNameToLabel encodes names to a Label.
 func EncodeDomainNameToLabel(name string) ([]byte, error) {
 	if err := ValidateName(name); err != nil {
@@ -68,7 +87,7 @@ func MarshalName(name string, fullPacket []byte, offset int) ([]byte, error) {
 		}
 
 		remainingName := strings.Join(labels[i:], ".")
-		if matchOffset := findNameMatch(remainingName, fullPacket); matchOffset != -1 {
+		if matchOffset := findNameMatch(remainingName, fullPacket); matchOffset != -1 && matchOffset <= maxPointerOffset {
 			pointer := createPointer(matchOffset)
 			result = append(result, pointer...)
 			return result, nil
@@ -83,6 +102,18 @@ func MarshalName(name string, fullPacket []byte, offset int) ([]byte, error) {
 	return result, nil
 }
 
+// NameWireSize returns the length of the uncompressed wire encoding of name, which is the most bytes marshalling it
+// can take.
+func NameWireSize(name string) int {
+	size := 1
+	for _, label := range strings.Split(name, ".") {
+		if trimmedLabel := strings.TrimSpace(label); len(trimmedLabel) > 0 {
+			size += 1 + len(trimmedLabel)
+		}
+	}
+	return size
+}
+
 // findNameMatch looks for a match of the given name in the full packet
 func findNameMatch(name string, fullPacket []byte) int {
 	if len(name) == 0 {
@@ -103,9 +134,12 @@ func findNameMatch(name string, fullPacket []byte) int {
 	return -1
 }
 
+// maxPointerOffset is the largest offset a 14-bit compression pointer can point to.
+const maxPointerOffset int = 0b0011111111111111
+
 // createPointer creates a DNS pointer (2 bytes) pointing to the given offset
 func createPointer(offset int) []byte {
-	if offset > 0b0011111111111111 { // 14-bit max
+	if offset > maxPointerOffset {
 		return nil
 	}
 
@@ -117,29 +151,49 @@ func createPointer(offset int) []byte {
 	return pointer
 }
 
-// ValidateName validates that names are valid Labels.
+// ValidateName validates that names are valid Labels. The length limits apply to the wire encoding of the name, where
+// every label is prefixed with its length and the name ends with the zero length root label.
 func ValidateName(name string) error {
 	if len(name) == 0 {
 		return ErrEmptyDomainName
 	}
 
-	if len(name) > MaxDomainNameLength {
-		return ErrDomainNameTooLong
-	}
-
-	labels := strings.Split(name, ".")
-	for _, label := range labels {
+	wireLength := 1
+	for _, label := range strings.Split(name, ".") {
 		trimmedLabel := strings.TrimSpace(label)
 		if len(trimmedLabel) > MaxLabelLength {
-			return ErrLabelTooLong
+			return fmt.Errorf("%w: %q is %d bytes", ErrLabelTooLong, trimmedLabel, len(trimmedLabel))
+		}
+		if len(trimmedLabel) > 0 {
+			wireLength += 1 + len(trimmedLabel)
 		}
 	}
+	if wireLength > MaxDomainNameLength {
+		return fmt.Errorf("%w: %d bytes encoded", ErrDomainNameTooLong, wireLength)
+	}
 
 	return nil
 }
 
+// NameOption configures how UnmarshalName parses a name.
+type NameOption func(*nameOptions)
+
+type nameOptions struct {
+	strictPointers bool
+}
+
+// StrictPointers makes UnmarshalName reject compression pointers which do not point strictly backward, to an offset
+// before the pointer itself, with ErrForwardPointer. Encoders only ever point back at names they already wrote, so a
+// forward pointer is a sign of a crafted packet. Pointers are only checked where their position in the full packet is
+// known, that is when buffer is a slice of it or after a pointer has been followed.
+func StrictPointers() NameOption {
+	return func(o *nameOptions) {
+		o.strictPointers = true
+	}
+}
+
 // UnmarshalName unmarshal Names/labels with pointer compression.
-func UnmarshalName(buffer []byte, offset int, fullPacket []byte) (string, int, error) {
+func UnmarshalName(buffer []byte, offset int, fullPacket []byte, opts ...NameOption) (string, int, error) {
 	const (
 		pointerMarker byte   = 0b11000000
 		pointerMask   uint16 = 0b00111111
@@ -150,6 +204,12 @@ func UnmarshalName(buffer []byte, offset int, fullPacket []byte) (string, int, e
 		return "", 0, fmt.Errorf("initial offset %d out of bounds for buffer length %d", offset, len(buffer))
 	}
 
+	var options nameOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	bufferPosition, bufferInPacket := sliceOffset(buffer, fullPacket)
+
 	var name strings.Builder
 	startOffset := offset
 	bytesConsumed := 0
@@ -183,6 +243,17 @@ func UnmarshalName(buffer []byte, offset int, fullPacket []byte) (string, int, e
 				return "", 0, fmt.Errorf("pointer offset %d out of bounds (full packet length %d)", pointerOffset, len(fullPacket))
 			}
 
+			if options.strictPointers {
+				// Once a pointer was followed the current buffer is the full packet itself.
+				position, known := offset, pointersFollowed > 0
+				if !known && bufferInPacket {
+					position, known = bufferPosition+offset, true
+				}
+				if known && pointerOffset >= position {
+					return "", 0, fmt.Errorf("%w: pointer at offset %d points to offset %d", ErrForwardPointer, position, pointerOffset)
+				}
+			}
+
 			// Follow the pointer by updating the buffer and offset
 			currentBuffer = fullPacket // Always use the full packet when following pointers
 			offset = pointerOffset
@@ -233,6 +304,15 @@ func UnmarshalName(buffer []byte, offset int, fullPacket []byte) (string, int, e
 	return name.String(), bytesConsumed, nil
 }
 
+// sliceOffset returns the offset of buffer within packet, if buffer is a slice of packet sharing its memory.
+func sliceOffset(buffer, packet []byte) (int, bool) {
+	start := cap(packet) - cap(buffer)
+	if len(buffer) == 0 || start < 0 || start >= len(packet) || &packet[start] != &buffer[0] {
+		return 0, false
+	}
+	return start, true
+}
+
 // SplitStringIntoChunks is a helper function to split a string into chunks
 func SplitStringIntoChunks(s string, chunkSize int) []string {
 	var chunks []string
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"slices"
 
@@ -22,6 +23,8 @@ func TestValidateName(t *testing.T) {
 		{"Empty domain", "", true},
 		{"Label too long", strings.Repeat("a", 64) + ".com", true},
 		{"Domain too long", strings.Repeat("a.", 130), true},
+		{"Valid with max encoded length", strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 61), false},
+		{"Encoded name too long", strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 62), true},
 		{"Valid with trailing dot", "example.com.", false},
 		{"Valid with leading spaces", "  example.com", false},
 		{"Valid with trailing spaces", "example.com  ", false},
@@ -37,6 +40,18 @@ func TestValidateName(t *testing.T) {
 	}
 }
 
+func TestNameWireSize(t *testing.T) {
+	for _, name := range []string{".", "com", "example.com", "example.com.", "a.b.c.d.example.com"} {
+		encoded, err := EncodeDomainNameToLabel(name)
+		if err != nil {
+			t.Fatalf("EncodeDomainNameToLabel(%q) returned error: %v", name, err)
+		}
+		if got := NameWireSize(name); got != len(encoded) {
+			t.Errorf("NameWireSize(%q) = %d, want %d", name, got, len(encoded))
+		}
+	}
+}
+
 func TestEncodeDomainNameToLabel(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -427,3 +442,38 @@ func TestOverflowChecks(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshalName_StrictPointers(t *testing.T) {
+	// A pointer at offset 0 pointing forward to "com" at offset 2.
+	forwardPacket := []byte{0xC0, 2, 3, 'c', 'o', 'm', 0}
+
+	name, _, err := UnmarshalName(forwardPacket, 0, forwardPacket)
+	if err != nil || name != "com" {
+		t.Fatalf("expected forward pointers to be followed by default, got %q, %v", name, err)
+	}
+	if _, _, err := UnmarshalName(forwardPacket, 0, forwardPacket, StrictPointers()); !errors.Is(err, ErrForwardPointer) {
+		t.Fatalf("expected ErrForwardPointer in strict mode, got %v", err)
+	}
+
+	// The same forward pointer inside a slice of the packet is still detected.
+	embedded := append([]byte{1, 'x', 0}, 0xC0, 5, 3, 'c', 'o', 'm', 0)
+	if _, _, err := UnmarshalName(embedded[3:], 0, embedded, StrictPointers()); !errors.Is(err, ErrForwardPointer) {
+		t.Fatalf("expected ErrForwardPointer for a slice of the packet, got %v", err)
+	}
+
+	// A pointer to itself is not strictly backward either.
+	selfPointer := []byte{0xC0, 0}
+	if _, _, err := UnmarshalName(selfPointer, 0, selfPointer, StrictPointers()); !errors.Is(err, ErrForwardPointer) {
+		t.Fatalf("expected ErrForwardPointer for a pointer to itself, got %v", err)
+	}
+
+	compressedPacket := []byte{
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		3, 's', 'u', 'b', 0xC0, 0,
+		5, 'o', 't', 'h', 'e', 'r', 0xC0, 13,
+	}
+	name, read, err := UnmarshalName(compressedPacket[19:], 0, compressedPacket, StrictPointers())
+	if err != nil || name != "other.sub.example.com" || read != 8 {
+		t.Fatalf("expected backward pointers to be followed in strict mode, got %q, %d, %v", name, read, err)
+	}
+}
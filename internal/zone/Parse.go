@@ -0,0 +1,228 @@
+package zone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultRecordTTL is the TTL of records without one when the file has no $TTL directive.
+const defaultRecordTTL uint32 = 3600
+
+var recordTypes = map[string]DNS_Type.Type{
+	"A":     DNS_Type.A,
+	"AAAA":  DNS_Type.AAAA,
+	"NS":    DNS_Type.NS,
+	"CNAME": DNS_Type.CNAME,
+	"PTR":   DNS_Type.PTR,
+	"MX":    DNS_Type.MX,
+	"TXT":   DNS_Type.TXT,
+	"SOA":   DNS_Type.SOA,
+}
+
+// zoneParser holds the state carried between the lines of a zone file.
+type zoneParser struct {
+	origin    string
+	lastOwner string
+	ttl       uint32
+}
+
+/*
+Parse reads a zone file for origin from r, in a subset of the master file format (https://datatracker.ietf.org/doc/html/rfc1035#section-5):
+
+  - One record per line, "owner [TTL] [class] type RDATA", where TTL and class may come in either order.
+  - A line starting with whitespace reuses the owner of the previous record, "@" stands for the origin.
+  - Names without a trailing dot are relative to the origin.
+  - The $ORIGIN and $TTL directives and ";" comments are supported, parentheses spanning lines are not.
+  - Supported types are A, AAAA, NS, CNAME, PTR, MX, TXT and SOA, in class IN only.
+
+Every malformed line is reported as a *utils.ParseError with its line number; if there are any, they are all returned
+joined together and no zone is returned.
+*/
+func Parse(r io.Reader, origin string) (*Zone, error) {
+	z := New(origin)
+	p := &zoneParser{origin: name.Canonicalize(origin), ttl: defaultRecordTTL}
+
+	var errs []error
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		rr, ok, err := p.parseLine(scanner.Text())
+		if err == nil && ok {
+			err = z.Add(rr)
+		}
+		if err != nil {
+			errs = append(errs, &utils.ParseError{Line: line, Err: err})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to read zone file: %w", err))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return z, nil
+}
+
+// ParseRecord parses a single record in the zone file syntax of Parse, "owner [TTL] [class] type RDATA", with relative
+// names resolved against origin. Records without a TTL get the default of 3600 seconds.
+func ParseRecord(line, origin string) (RR.RR, error) {
+	p := &zoneParser{origin: name.Canonicalize(origin), ttl: defaultRecordTTL}
+	if strings.TrimSpace(line) == "" || unicode.IsSpace(rune(line[0])) {
+		return RR.RR{}, errors.New("record without an owner name")
+	}
+	rr, ok, err := p.parseLine(line)
+	if err != nil {
+		return RR.RR{}, err
+	}
+	if !ok {
+		return RR.RR{}, errors.New("expected a record")
+	}
+	return rr, nil
+}
+
+// parseLine parses one line of a zone file. It returns false for lines without a record.
+func (p *zoneParser) parseLine(line string) (RR.RR, bool, error) {
+	if i := strings.IndexByte(line, ';'); i >= 0 && !strings.Contains(line[:i], `"`) {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return RR.RR{}, false, nil
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "$ORIGIN":
+		if len(fields) != 2 {
+			return RR.RR{}, false, errors.New("$ORIGIN takes exactly one name")
+		}
+		p.origin = p.absolute(fields[1])
+		return RR.RR{}, false, nil
+	case "$TTL":
+		if len(fields) != 2 {
+			return RR.RR{}, false, errors.New("$TTL takes exactly one value")
+		}
+		ttl, err := strconv.ParseUint(fields[1], 10, 31)
+		if err != nil {
+			return RR.RR{}, false, fmt.Errorf("invalid $TTL %q", fields[1])
+		}
+		p.ttl = uint32(ttl)
+		return RR.RR{}, false, nil
+	}
+
+	owner := p.lastOwner
+	if !unicode.IsSpace(rune(line[0])) {
+		owner = p.absolute(fields[0])
+		fields = fields[1:]
+	}
+	if owner == "" {
+		return RR.RR{}, false, errors.New("record without an owner name")
+	}
+	p.lastOwner = owner
+
+	rr := RR.RR{Name: owner, Class: DNS_Class.IN, TTL: p.ttl}
+	for len(fields) > 0 {
+		if ttl, err := strconv.ParseUint(fields[0], 10, 31); err == nil {
+			rr.TTL = uint32(ttl)
+		} else if strings.EqualFold(fields[0], "IN") {
+			rr.Class = DNS_Class.IN
+		} else {
+			break
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return RR.RR{}, false, errors.New("missing record type")
+	}
+
+	rrType, known := recordTypes[strings.ToUpper(fields[0])]
+	if !known {
+		return RR.RR{}, false, fmt.Errorf("unsupported record type %q", fields[0])
+	}
+	if err := p.setRDATA(&rr, rrType, fields[1:]); err != nil {
+		return RR.RR{}, false, fmt.Errorf("invalid %s record: %w", fields[0], err)
+	}
+	return rr, true, nil
+}
+
+// setRDATA sets the RDATA of rr from the RDATA fields of a record of type rrType.
+func (p *zoneParser) setRDATA(rr *RR.RR, rrType DNS_Type.Type, rdata []string) error {
+	want := map[DNS_Type.Type]int{
+		DNS_Type.A: 1, DNS_Type.AAAA: 1, DNS_Type.NS: 1, DNS_Type.CNAME: 1, DNS_Type.PTR: 1, DNS_Type.MX: 2,
+		DNS_Type.SOA: 7,
+	}
+	if n, fixed := want[rrType]; fixed && len(rdata) != n {
+		return fmt.Errorf("expected %d RDATA fields, got %d", n, len(rdata))
+	}
+
+	switch rrType {
+	case DNS_Type.A:
+		ip := net.ParseIP(rdata[0]).To4()
+		if ip == nil {
+			return fmt.Errorf("invalid IPv4 address %q", rdata[0])
+		}
+		if err := rr.SetRDATAToARecord(ip); err != nil {
+			return err
+		}
+	case DNS_Type.AAAA:
+		ip := net.ParseIP(rdata[0])
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %q", rdata[0])
+		}
+		rr.SetType(DNS_Type.AAAA)
+		rr.SetRDATA(ip.To16())
+	case DNS_Type.NS:
+		return rr.SetRDATAToNSRecord(p.absolute(rdata[0]))
+	case DNS_Type.CNAME:
+		return rr.SetRDATAToCNAMERecord(p.absolute(rdata[0]))
+	case DNS_Type.PTR:
+		return rr.SetRDATAToPTRRecord(p.absolute(rdata[0]))
+	case DNS_Type.MX:
+		preference, err := strconv.ParseUint(rdata[0], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid preference %q", rdata[0])
+		}
+		return rr.SetRDATAToMXRecord(uint16(preference), p.absolute(rdata[1]))
+	case DNS_Type.TXT:
+		if len(rdata) == 0 {
+			return errors.New("missing text")
+		}
+		rr.SetRDATAToTXTRecord(strings.Trim(strings.Join(rdata, " "), `"`))
+	case DNS_Type.SOA:
+		var timers [5]uint32
+		for i, field := range rdata[2:] {
+			value, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid SOA timer %q", field)
+			}
+			timers[i] = uint32(value)
+		}
+		return rr.SetRDATAToSOARecord(p.absolute(rdata[0]), p.absolute(rdata[1]),
+			timers[0], timers[1], timers[2], timers[3], timers[4])
+	}
+	return nil
+}
+
+// absolute returns owner as an absolute name without the trailing dot, resolving "@" and relative names against the
+// current origin.
+func (p *zoneParser) absolute(owner string) string {
+	switch {
+	case owner == "@":
+		return p.origin
+	case strings.HasSuffix(owner, "."):
+		return name.Canonicalize(owner)
+	case p.origin == "":
+		return name.Canonicalize(owner)
+	default:
+		return name.Canonicalize(owner + "." + p.origin)
+	}
+}
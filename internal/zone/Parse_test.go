@@ -0,0 +1,93 @@
+package zone
+
+import (
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/utils"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const file = `$TTL 600
+@       IN SOA ns1 admin 1 3600 600 86400 300
+        IN NS  ns1 ; the owner of the previous record
+ns1     300 A  192.0.2.1
+www.example.com. IN 60 CNAME ns1
+`
+	z, err := Parse(strings.NewReader(file), "example.com.")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	ns, ok := z.Lookup("example.com", DNS_Type.NS)
+	if !ok || len(ns) != 1 {
+		t.Fatalf("expected one NS record at the apex, got %v", ns)
+	}
+	if ns[0].TTL != 600 {
+		t.Errorf("expected the $TTL of 600, got %d", ns[0].TTL)
+	}
+
+	a, ok := z.Lookup("ns1.example.com", DNS_Type.A)
+	if !ok || len(a) != 1 {
+		t.Fatalf("expected one A record for ns1.example.com, got %v", a)
+	}
+	if a[0].TTL != 300 {
+		t.Errorf("expected TTL 300, got %d", a[0].TTL)
+	}
+
+	cname, ok := z.Lookup("www.example.com", DNS_Type.CNAME)
+	if !ok || len(cname) != 1 {
+		t.Fatalf("expected one CNAME record for www.example.com, got %v", cname)
+	}
+	if cname[0].TTL != 60 {
+		t.Errorf("expected TTL 60, got %d", cname[0].TTL)
+	}
+}
+
+func TestParse_ReportsMalformedLines(t *testing.T) {
+	const file = `@    IN SOA ns1 admin 1 3600 600 86400 300
+ns1  IN A   192.0.2.1
+www  IN A   192.0.2.300
+mail IN MX  ten mail
+`
+	z, err := Parse(strings.NewReader(file), "example.com.")
+	if err == nil {
+		t.Fatal("expected an error for the malformed zone")
+	}
+	if z != nil {
+		t.Error("expected no zone when parsing fails")
+	}
+
+	var parseErr *utils.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a *utils.ParseError, got %T", err)
+	}
+	if parseErr.Line != 3 {
+		t.Errorf("expected the first error on line 3, got line %d", parseErr.Line)
+	}
+	for _, want := range []string{"line 3:", "192.0.2.300", "line 4:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error %q to contain %q", err, want)
+		}
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	rr, err := ParseRecord("mail 60 MX 10 mx1", "example.com.")
+	if err != nil {
+		t.Fatalf("ParseRecord failed: %v", err)
+	}
+	if rr.Name != "mail.example.com" || rr.Type != DNS_Type.MX || rr.TTL != 60 {
+		t.Fatalf("expected mail.example.com 60 MX, got %s %d %s", rr.Name, rr.TTL, rr.Type)
+	}
+	if _, exchange, err := rr.GetRDATAAsMXRecord(); err != nil || exchange != "mx1.example.com" {
+		t.Errorf("expected exchange mx1.example.com, got %q (%v)", exchange, err)
+	}
+
+	for _, line := range []string{"", "  A 192.0.2.1", "$TTL 60", "www A 192.0.2.300"} {
+		if _, err := ParseRecord(line, "example.com."); err == nil {
+			t.Errorf("expected an error for %q", line)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package zone
+
+import (
+	"bytes"
+	"errors"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"slices"
+)
+
+/*
+Dynamic updates (https://datatracker.ietf.org/doc/html/rfc2136) reuse the DNS message format with renamed sections:
+
+Section			Message field			Purpose
+Zone			Questions				The zone being updated, a single SOA question for the zone apex
+Prerequisite	Answers					RRs or RRsets which must (or must not) exist for the update to be applied
+Update			Authority				RRs or RRsets to be added or deleted
+Additional		Additional				Data related to the update, or the TSIG record
+
+The meaning of a prerequisite or an update record depends on its class:
+
+Prerequisites (RFC 2136 section 2.4)			Updates (RFC 2136 section 2.5)
+ANY  ANY  	Name is in use						ANY  ANY	Delete all RRsets from a name
+ANY  type	RRset exists (value independent)	ANY  type	Delete an RRset
+NONE ANY 	Name is not in use					NONE type	Delete an RR from an RRset
+NONE type	RRset does not exist				zone type	Add to an RRset
+zone type	RRset exists (value dependent)
+
+Either all updates are applied or none of them are.
+*/
+
+var errFormat = errors.New("malformed update")
+
+type rrsetKey struct {
+	name string
+	t    DNS_Type.Type
+}
+
+// ApplyUpdate checks the prerequisites of the dynamic update msg and, if they hold, applies its updates to the zone.
+// It returns the RCODE with which the update should be answered.
+func (z *Zone) ApplyUpdate(msg *Message.Message) header.ResponseCode {
+	const firstQuestion uint8 = 0
+
+	if msg == nil || msg.Header.GetOpcode() != header.Update {
+		return header.FormatError
+	}
+	if len(msg.Questions) != 1 || msg.Questions[firstQuestion].Type != DNS_Type.SOA {
+		return header.FormatError
+	}
+	if name.Canonicalize(msg.Questions[firstQuestion].Name) != z.origin {
+		return header.NotAuth
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if rcode := z.checkPrerequisites(msg.Answers); rcode != header.NoError {
+		return rcode
+	}
+	if rcode := z.prescanUpdates(msg.Authority); rcode != header.NoError {
+		return rcode
+	}
+
+	// The updates are applied to a copy of the records, which only replaces them once every update succeeded.
+	committed := z.records
+	z.records = make(map[string][]RR.RR, len(committed))
+	for owner, rrs := range committed {
+		z.records[owner] = slices.Clone(rrs)
+	}
+	for _, rr := range msg.Authority {
+		if err := z.applyUpdate(rr); err != nil {
+			z.records = committed
+			return header.ServerFailure
+		}
+	}
+	return header.NoError
+}
+
+// checkPrerequisites implements RFC 2136 section 3.2, the caller must hold the write lock.
+func (z *Zone) checkPrerequisites(prerequisites []RR.RR) header.ResponseCode {
+	valueDependent := make(map[rrsetKey][]RR.RR)
+
+	for _, rr := range prerequisites {
+		if rr.GetTTL() != 0 {
+			return header.FormatError
+		}
+		if !z.Contains(rr.GetName()) {
+			return header.NotZone
+		}
+		owner := name.Canonicalize(rr.GetName())
+
+		switch {
+		case rr.Class == DNS_Class.ANY:
+			if rr.RDLENGTH != 0 {
+				return header.FormatError
+			}
+			if rr.Type == DNS_Type.ANY {
+				if _, inUse := z.records[owner]; !inUse {
+					return header.NameError
+				}
+			} else if len(z.rrset(owner, rr.Type)) == 0 {
+				return header.NXRRSet
+			}
+		case rr.Class == DNS_Class.NONE:
+			if rr.RDLENGTH != 0 {
+				return header.FormatError
+			}
+			if rr.Type == DNS_Type.ANY {
+				if _, inUse := z.records[owner]; inUse {
+					return header.YXDomain
+				}
+			} else if len(z.rrset(owner, rr.Type)) != 0 {
+				return header.YXRRSet
+			}
+		case isZoneClass(rr.Class):
+			normalized, err := RR.CopyRR(rr)
+			if err != nil {
+				return header.FormatError
+			}
+			key := rrsetKey{name: owner, t: rr.Type}
+			valueDependent[key] = append(valueDependent[key], normalized)
+		default:
+			return header.FormatError
+		}
+	}
+
+	for key, expected := range valueDependent {
+		if !sameRDATA(z.rrset(key.name, key.t), expected) {
+			return header.NXRRSet
+		}
+	}
+	return header.NoError
+}
+
+// prescanUpdates implements RFC 2136 section 3.4.1, the caller must hold the write lock.
+func (z *Zone) prescanUpdates(updates []RR.RR) header.ResponseCode {
+	for _, rr := range updates {
+		if !z.Contains(rr.GetName()) {
+			return header.NotZone
+		}
+		switch {
+		case isZoneClass(rr.Class):
+			if rr.Type == DNS_Type.ANY || rr.Type == DNS_Type.AXFR || rr.Type == DNS_Type.TSIG ||
+				rr.Type == DNS_Type.OPT {
+				return header.FormatError
+			}
+		case rr.Class == DNS_Class.ANY:
+			if rr.GetTTL() != 0 || rr.RDLENGTH != 0 || rr.Type == DNS_Type.AXFR {
+				return header.FormatError
+			}
+		case rr.Class == DNS_Class.NONE:
+			if rr.GetTTL() != 0 || rr.Type == DNS_Type.ANY || rr.Type == DNS_Type.AXFR {
+				return header.FormatError
+			}
+		default:
+			return header.FormatError
+		}
+	}
+	return header.NoError
+}
+
+// applyUpdate applies a single, already prescanned, update record. The caller must hold the write lock.
+func (z *Zone) applyUpdate(rr RR.RR) error {
+	switch {
+	case isZoneClass(rr.Class):
+		return z.add(rr)
+	case rr.Class == DNS_Class.ANY:
+		z.deleteRRset(rr.GetName(), rr.Type)
+		return nil
+	case rr.Class == DNS_Class.NONE:
+		target := rr
+		target.SetClass(DNS_Class.IN)
+		return z.deleteRR(target)
+	default:
+		return errFormat
+	}
+}
+
+// sameRDATA reports whether both RRsets contain the same set of RDATA.
+func sameRDATA(have, want []RR.RR) bool {
+	contains := func(set []RR.RR, rr RR.RR) bool {
+		for _, candidate := range set {
+			if bytes.Equal(candidate.RDATA, rr.RDATA) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, rr := range want {
+		if !contains(have, rr) {
+			return false
+		}
+	}
+	for _, rr := range have {
+		if !contains(want, rr) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package zone
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"testing"
+)
+
+func createUpdate(t *testing.T, zoneName string, prerequisites []RR.RR, updates []RR.RR) *Message.Message {
+	t.Helper()
+	msg, err := Message.CreateDNSQuery(zoneName, DNS_Type.SOA, DNS_Class.IN, false)
+	if err != nil {
+		t.Fatalf("failed to create update: %v", err)
+	}
+	msg.Header.SetOpcode(header.Update)
+	msg.Answers = prerequisites
+	msg.Authority = updates
+	if err := msg.Header.SetANCOUNT(len(prerequisites)); err != nil {
+		t.Fatalf("failed to set ANCOUNT: %v", err)
+	}
+	if err := msg.Header.SetNSCOUNT(len(updates)); err != nil {
+		t.Fatalf("failed to set NSCOUNT: %v", err)
+	}
+
+	// Send it through the wire format, the way it would reach the zone.
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal update: %v", err)
+	}
+	parsed, err := Message.New(data)
+	if err != nil {
+		t.Fatalf("failed to unmarshal update: %v", err)
+	}
+	return &parsed
+}
+
+func createMetaRecord(name string, t DNS_Type.Type, class DNS_Class.Class) RR.RR {
+	rr := RR.RR{}
+	rr.SetName(name)
+	rr.SetType(t)
+	rr.SetClass(class)
+	return rr
+}
+
+func TestApplyUpdate_AddARecord(t *testing.T) {
+	z := createTestZone(t)
+
+	update := createUpdate(t, "example.com", nil, []RR.RR{createARecord(t, "new.example.com", "192.0.2.10")})
+	if rcode := z.ApplyUpdate(update); rcode != header.NoError {
+		t.Fatalf("expected NoError, got %s", rcode)
+	}
+
+	rrs, _ := z.Lookup("new.example.com", DNS_Type.A)
+	if len(rrs) != 1 {
+		t.Fatalf("expected the added A record, got %d records", len(rrs))
+	}
+	ip, err := rrs[0].GetRDATAAsARecord()
+	if err != nil || ip.String() != "192.0.2.10" {
+		t.Fatalf("unexpected A record %v (err: %v)", ip, err)
+	}
+}
+
+func TestApplyUpdate_DeleteRRset(t *testing.T) {
+	z := createTestZone(t)
+	if err := z.Add(createARecord(t, "www.example.com", "192.0.2.2")); err != nil {
+		t.Fatalf("failed to add record: %v", err)
+	}
+
+	update := createUpdate(t, "example.com", nil,
+		[]RR.RR{createMetaRecord("www.example.com", DNS_Type.A, DNS_Class.ANY)})
+	if rcode := z.ApplyUpdate(update); rcode != header.NoError {
+		t.Fatalf("expected NoError, got %s", rcode)
+	}
+
+	if rrs, exists := z.Lookup("www.example.com", DNS_Type.A); len(rrs) != 0 || exists {
+		t.Fatalf("expected the RRset and name to be gone, got %d records (exists %v)", len(rrs), exists)
+	}
+}
+
+func TestApplyUpdate_DeleteSingleRR(t *testing.T) {
+	z := createTestZone(t)
+	if err := z.Add(createARecord(t, "www.example.com", "192.0.2.2")); err != nil {
+		t.Fatalf("failed to add record: %v", err)
+	}
+
+	del := createARecord(t, "www.example.com", "192.0.2.1")
+	del.SetClass(DNS_Class.NONE)
+	if err := del.SetTTL(0); err != nil {
+		t.Fatalf("failed to set TTL: %v", err)
+	}
+	if rcode := z.ApplyUpdate(createUpdate(t, "example.com", nil, []RR.RR{del})); rcode != header.NoError {
+		t.Fatalf("expected NoError, got %s", rcode)
+	}
+
+	rrs, _ := z.Lookup("www.example.com", DNS_Type.A)
+	if len(rrs) != 1 {
+		t.Fatalf("expected one remaining A record, got %d", len(rrs))
+	}
+}
+
+func TestApplyUpdate_FailedUpdateLeavesZoneUnchanged(t *testing.T) {
+	z := createTestZone(t)
+	if err := z.Add(createARecord(t, "www.example.com", "192.0.2.2")); err != nil {
+		t.Fatalf("failed to add record: %v", err)
+	}
+	before, _ := z.Lookup("www.example.com", DNS_Type.A)
+
+	// The last update passes the prescan, but an A record with 5 bytes of RDATA can not be added.
+	malformed := RR.RR{Name: "bad.example.com", Type: DNS_Type.A, Class: DNS_Class.IN, TTL: 300}
+	malformed.SetRDATA([]byte{192, 0, 2, 1, 0})
+	update := createUpdate(t, "example.com", nil, []RR.RR{
+		createMetaRecord("www.example.com", DNS_Type.A, DNS_Class.ANY),
+		createARecord(t, "new.example.com", "192.0.2.10"),
+		malformed,
+	})
+	if rcode := z.ApplyUpdate(update); rcode != header.ServerFailure {
+		t.Fatalf("expected ServerFailure, got %s", rcode)
+	}
+
+	if rrs, _ := z.Lookup("www.example.com", DNS_Type.A); len(rrs) != len(before) {
+		t.Fatalf("expected the deleted RRset of %d records to be restored, got %d records", len(before), len(rrs))
+	}
+	if rrs, exists := z.Lookup("new.example.com", DNS_Type.A); len(rrs) != 0 || exists {
+		t.Fatalf("expected the added record to be rolled back, got %d records (exists %v)", len(rrs), exists)
+	}
+}
+
+func TestApplyUpdate_Prerequisites(t *testing.T) {
+	add := func(t *testing.T) []RR.RR {
+		return []RR.RR{createARecord(t, "new.example.com", "192.0.2.10")}
+	}
+
+	tests := []struct {
+		name          string
+		zoneName      string
+		prerequisites []RR.RR
+		want          header.ResponseCode
+	}{
+		{
+			name:          "Name in use, but it is not",
+			zoneName:      "example.com",
+			prerequisites: []RR.RR{createMetaRecord("nothing.example.com", DNS_Type.ANY, DNS_Class.ANY)},
+			want:          header.NameError,
+		},
+		{
+			name:          "RRset exists, but it does not",
+			zoneName:      "example.com",
+			prerequisites: []RR.RR{createMetaRecord("www.example.com", DNS_Type.MX, DNS_Class.ANY)},
+			want:          header.NXRRSet,
+		},
+		{
+			name:          "Name not in use, but it is",
+			zoneName:      "example.com",
+			prerequisites: []RR.RR{createMetaRecord("www.example.com", DNS_Type.ANY, DNS_Class.NONE)},
+			want:          header.YXDomain,
+		},
+		{
+			name:          "RRset does not exist, but it does",
+			zoneName:      "example.com",
+			prerequisites: []RR.RR{createMetaRecord("www.example.com", DNS_Type.A, DNS_Class.NONE)},
+			want:          header.YXRRSet,
+		},
+		{
+			name:          "Prerequisite outside of zone",
+			zoneName:      "example.com",
+			prerequisites: []RR.RR{createMetaRecord("www.example.org", DNS_Type.A, DNS_Class.ANY)},
+			want:          header.NotZone,
+		},
+		{
+			name:     "Wrong zone",
+			zoneName: "example.org",
+			want:     header.NotAuth,
+		},
+		{
+			name:          "All prerequisites hold",
+			zoneName:      "example.com",
+			prerequisites: []RR.RR{createMetaRecord("www.example.com", DNS_Type.A, DNS_Class.ANY)},
+			want:          header.NoError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := createTestZone(t)
+			rcode := z.ApplyUpdate(createUpdate(t, tt.zoneName, tt.prerequisites, add(t)))
+			if rcode != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, rcode)
+			}
+
+			_, added := z.Lookup("new.example.com", DNS_Type.A)
+			if added != (tt.want == header.NoError) {
+				t.Fatalf("update applied = %v, but RCODE was %s", added, rcode)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package zone
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"github.com/blazskufca/dns_server_in_go/internal/name"
+	"sync"
+)
+
+// Zone is an in-memory authoritative zone.
+// Records are stored per owner name, with owner names compared case-insensitively.
+type Zone struct {
+	records map[string][]RR.RR
+	origin  string
+	mu      sync.RWMutex
+}
+
+// New creates a new empty Zone for origin.
+func New(origin string) *Zone {
+	return &Zone{
+		origin:  name.Canonicalize(origin),
+		records: make(map[string][]RR.RR),
+	}
+}
+
+// Origin returns the name of the zone apex.
+func (z *Zone) Origin() string {
+	return z.origin
+}
+
+// Contains reports whether owner is the zone apex or a name below it.
+func (z *Zone) Contains(owner string) bool {
+	return name.IsSubdomain(owner, z.origin)
+}
+
+// Add adds a copy of rr to the zone. Adding a record which is already present is a no-op.
+func (z *Zone) Add(rr RR.RR) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.add(rr)
+}
+
+// Lookup returns copies of the records of type t owned by owner and whether owner has any records at all.
+func (z *Zone) Lookup(owner string, t DNS_Type.Type) ([]RR.RR, bool) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	owned, exists := z.records[name.Canonicalize(owner)]
+	var rrs []RR.RR
+	for _, rr := range owned {
+		if rr.Type == t || t == DNS_Type.ANY {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, exists
+}
+
+// Answer builds an authoritative response to query from the zone contents.
+// It returns false if the queried name does not belong to the zone or is not of the zone's class.
+func (z *Zone) Answer(query *Message.Message) (*Message.Message, bool) {
+	const firstQuestion uint8 = 0
+
+	if query == nil || len(query.Questions) == 0 {
+		return nil, false
+	}
+	q := query.Questions[firstQuestion]
+	if !isZoneClass(q.Class) || !z.Contains(q.Name) {
+		return nil, false
+	}
+
+	response := &Message.Message{
+		Header:    query.Header,
+		Questions: query.Questions,
+	}
+	response.Header.SetQRFlag(true)
+	response.Header.SetAA(true)
+	response.Header.SetRA(false)
+
+	answers, exists := z.Lookup(q.Name, q.Type)
+	if len(answers) == 0 && q.Type != DNS_Type.CNAME {
+		answers, _ = z.Lookup(q.Name, DNS_Type.CNAME)
+	}
+	response.Answers = answers
+
+	if !exists && !z.isEmptyNonTerminal(q.Name) {
+		response.Header.SetRCODE(header.NameError)
+	} else {
+		response.Header.SetRCODE(header.NoError)
+	}
+	if len(response.Answers) == 0 {
+		response.Authority, _ = z.Lookup(z.origin, DNS_Type.SOA)
+	}
+
+	if err := response.Header.SetANCOUNT(len(response.Answers)); err != nil {
+		return nil, false
+	}
+	if err := response.Header.SetNSCOUNT(len(response.Authority)); err != nil {
+		return nil, false
+	}
+	if err := response.Header.SetARCOUNT(0); err != nil {
+		return nil, false
+	}
+	return response, true
+}
+
+// add adds rr to the zone, the caller must hold the write lock.
+func (z *Zone) add(rr RR.RR) error {
+	if !z.Contains(rr.GetName()) {
+		return fmt.Errorf("record %s is not contained in zone %s", rr.GetName(), z.origin)
+	}
+
+	stored, err := RR.CopyRR(rr)
+	if err != nil {
+		return fmt.Errorf("failed to copy record: %w", err)
+	}
+	owner := name.Canonicalize(rr.GetName())
+	stored.SetName(owner)
+
+	for i, existing := range z.records[owner] {
+		if existing.Type != stored.Type {
+			continue
+		}
+		if stored.Type == DNS_Type.SOA { // There is only ever one SOA, a new one replaces it
+			z.records[owner][i] = stored
+			return nil
+		}
+		if existing.Class == stored.Class && bytes.Equal(existing.RDATA, stored.RDATA) {
+			return nil
+		}
+	}
+	z.records[owner] = append(z.records[owner], stored)
+	return nil
+}
+
+// deleteRRset deletes all records of type t at owner (all types if t is DNS_Type.ANY).
+// SOA and NS records at the apex are never deleted. The caller must hold the write lock.
+func (z *Zone) deleteRRset(owner string, t DNS_Type.Type) {
+	owner = name.Canonicalize(owner)
+	kept := z.records[owner][:0]
+	for _, rr := range z.records[owner] {
+		protected := owner == z.origin && (rr.Type == DNS_Type.SOA || rr.Type == DNS_Type.NS)
+		if protected || (t != DNS_Type.ANY && rr.Type != t) {
+			kept = append(kept, rr)
+		}
+	}
+	z.setRecords(owner, kept)
+}
+
+// deleteRR deletes the record matching type and RDATA of rr. SOA records and the last NS record at the apex are
+// never deleted. The caller must hold the write lock.
+func (z *Zone) deleteRR(rr RR.RR) error {
+	owner := name.Canonicalize(rr.GetName())
+	target, err := RR.CopyRR(rr)
+	if err != nil {
+		return fmt.Errorf("failed to copy record: %w", err)
+	}
+
+	if owner == z.origin && rr.Type == DNS_Type.SOA {
+		return nil
+	}
+	if owner == z.origin && rr.Type == DNS_Type.NS {
+		nsRecords := 0
+		for _, existing := range z.records[owner] {
+			if existing.Type == DNS_Type.NS {
+				nsRecords++
+			}
+		}
+		if nsRecords <= 1 {
+			return nil
+		}
+	}
+
+	kept := z.records[owner][:0]
+	for _, existing := range z.records[owner] {
+		if existing.Type != target.Type || !bytes.Equal(existing.RDATA, target.RDATA) {
+			kept = append(kept, existing)
+		}
+	}
+	z.setRecords(owner, kept)
+	return nil
+}
+
+// setRecords replaces the records of owner, removing the name entirely if no records are left.
+func (z *Zone) setRecords(owner string, rrs []RR.RR) {
+	if len(rrs) == 0 {
+		delete(z.records, owner)
+		return
+	}
+	z.records[owner] = rrs
+}
+
+// isEmptyNonTerminal reports whether owner has no records but names below it do, so it exists in the tree and has to be
+// answered with NODATA instead of NXDOMAIN (https://datatracker.ietf.org/doc/html/rfc8020).
+func (z *Zone) isEmptyNonTerminal(owner string) bool {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	owner = name.Canonicalize(owner)
+	for other := range z.records {
+		if other != owner && name.IsSubdomain(other, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// rrset returns the records of type t owned by owner, the caller must hold the lock.
+func (z *Zone) rrset(owner string, t DNS_Type.Type) []RR.RR {
+	var rrs []RR.RR
+	for _, rr := range z.records[name.Canonicalize(owner)] {
+		if rr.Type == t {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// isZoneClass reports whether c is the class of records stored in the zone.
+func isZoneClass(c DNS_Class.Class) bool {
+	return c == DNS_Class.IN
+}
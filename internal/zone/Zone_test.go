@@ -0,0 +1,149 @@
+package zone
+
+import (
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Class"
+	"github.com/blazskufca/dns_server_in_go/internal/DNS_Type"
+	"github.com/blazskufca/dns_server_in_go/internal/Message"
+	"github.com/blazskufca/dns_server_in_go/internal/RR"
+	"github.com/blazskufca/dns_server_in_go/internal/header"
+	"net"
+	"testing"
+)
+
+func createARecord(t *testing.T, name string, ip string) RR.RR {
+	t.Helper()
+	rr := RR.RR{}
+	rr.SetName(name)
+	rr.SetClass(DNS_Class.IN)
+	if err := rr.SetTTL(300); err != nil {
+		t.Fatalf("failed to set TTL: %v", err)
+	}
+	if err := rr.SetRDATAToARecord(net.ParseIP(ip)); err != nil {
+		t.Fatalf("failed to set A record: %v", err)
+	}
+	return rr
+}
+
+func createTestZone(t *testing.T) *Zone {
+	t.Helper()
+	z := New("example.com.")
+
+	soa := RR.RR{}
+	soa.SetName("example.com")
+	soa.SetClass(DNS_Class.IN)
+	if err := soa.SetRDATAToSOARecord("ns1.example.com", "admin.example.com", 1, 3600, 600, 86400, 300); err != nil {
+		t.Fatalf("failed to set SOA: %v", err)
+	}
+	ns := RR.RR{}
+	ns.SetName("example.com")
+	ns.SetClass(DNS_Class.IN)
+	if err := ns.SetRDATAToNSRecord("ns1.example.com"); err != nil {
+		t.Fatalf("failed to set NS: %v", err)
+	}
+
+	for _, rr := range []RR.RR{soa, ns, createARecord(t, "www.example.com", "192.0.2.1")} {
+		if err := z.Add(rr); err != nil {
+			t.Fatalf("failed to add record: %v", err)
+		}
+	}
+	return z
+}
+
+func TestZone_Contains(t *testing.T) {
+	z := New("Example.COM.")
+	tests := map[string]bool{
+		"example.com":      true,
+		"www.example.com.": true,
+		"WWW.EXAMPLE.COM":  true,
+		"notexample.com":   false,
+		"example.org":      false,
+	}
+	for name, want := range tests {
+		if got := z.Contains(name); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestZone_AddAndLookup(t *testing.T) {
+	z := createTestZone(t)
+
+	if err := z.Add(createARecord(t, "www.example.com", "192.0.2.1")); err != nil {
+		t.Fatalf("failed to add duplicate record: %v", err)
+	}
+	rrs, exists := z.Lookup("WWW.example.com.", DNS_Type.A)
+	if !exists || len(rrs) != 1 {
+		t.Fatalf("expected a single A record, got %d (exists %v)", len(rrs), exists)
+	}
+
+	if err := z.Add(createARecord(t, "www.example.org", "192.0.2.1")); err == nil {
+		t.Fatalf("expected an error adding an out of zone record")
+	}
+}
+
+func TestZone_Answer(t *testing.T) {
+	z := createTestZone(t)
+
+	query, err := Message.CreateDNSQuery("www.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp, ok := z.Answer(&query)
+	if !ok {
+		t.Fatalf("expected the zone to answer")
+	}
+	if !resp.Header.IsAA() || resp.Header.GetRCODE() != header.NoError || resp.Header.GetANCOUNT() != 1 {
+		t.Fatalf("unexpected response: AA %v, RCODE %s, ANCOUNT %d",
+			resp.Header.IsAA(), resp.Header.GetRCODE(), resp.Header.GetANCOUNT())
+	}
+
+	query, err = Message.CreateDNSQuery("missing.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp, ok = z.Answer(&query)
+	if !ok || resp.Header.GetRCODE() != header.NameError || resp.Header.GetNSCOUNT() != 1 {
+		t.Fatalf("expected NXDOMAIN with SOA, got ok %v", ok)
+	}
+
+	query, err = Message.CreateDNSQuery("example.org", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	if _, ok = z.Answer(&query); ok {
+		t.Fatalf("expected the zone not to answer for an out of zone name")
+	}
+}
+
+func TestZone_AnswerEmptyNonTerminal(t *testing.T) {
+	z := createTestZone(t)
+	if err := z.Add(createARecord(t, "a.b.example.com", "192.0.2.2")); err != nil {
+		t.Fatalf("failed to add record: %v", err)
+	}
+
+	query, err := Message.CreateDNSQuery("b.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	resp, ok := z.Answer(&query)
+	if !ok {
+		t.Fatalf("expected the zone to answer")
+	}
+	if resp.Header.GetRCODE() != header.NoError {
+		t.Fatalf("expected NODATA for the empty non-terminal, got RCODE %s", resp.Header.GetRCODE())
+	}
+	if resp.Header.GetANCOUNT() != 0 || len(resp.Answers) != 0 {
+		t.Fatalf("expected no answers, got %d", len(resp.Answers))
+	}
+	if len(resp.Authority) != 1 || resp.Authority[0].Type != DNS_Type.SOA {
+		t.Fatalf("expected the SOA in the Authority section, got %v", resp.Authority)
+	}
+
+	query, err = Message.CreateDNSQuery("c.b.example.com", DNS_Type.A, DNS_Class.IN, true)
+	if err != nil {
+		t.Fatalf("failed to create query: %v", err)
+	}
+	if resp, _ = z.Answer(&query); resp.Header.GetRCODE() != header.NameError {
+		t.Fatalf("expected NXDOMAIN for a sibling of the existing name, got RCODE %s", resp.Header.GetRCODE())
+	}
+}